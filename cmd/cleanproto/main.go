@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"go/token"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/jptrs93/cleanproto/internal/generate"
 	gogen "github.com/jptrs93/cleanproto/internal/generate/go"
@@ -26,6 +32,14 @@ func (s *stringList) Set(value string) error {
 }
 
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stderr))
+}
+
+// run is the CLI entry point, taking its arguments, stdin and stderr
+// explicitly so it can be exercised in tests. It returns the exit code.
+func run(args []string, stdinReader io.Reader, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cleanproto", flag.ContinueOnError)
+	fs.SetOutput(stderr)
 	var importPaths stringList
 	var goOut string
 	var jsOut string
@@ -35,51 +49,247 @@ func main() {
 	var goClient bool
 	var goClientService string
 	var goServer bool = true
+	var goSamples bool
+	var goSingleFile bool
+	var goSplitModel bool
+	var goNolint string
+	var goBinaryMarshaler bool
+	var goWriterTo bool
+	var goSetters bool
+	var goNonNilSlices bool
+	var goEmptyBytes bool
+	var goHash bool
+	var goCanonical bool
+	var goArena bool
+	var goStrictRepeated bool
+	var goEnumStringer bool
+	var goEnumValues bool
+	var goStringer bool
+	var goToMap bool
+	var goApplyMask bool
+	var goPopulatedFields bool
+	var goUnknownEnums bool
+	var goSortedRange bool
+	var goFieldNames bool
+	var goVisitor bool
+	var goDiff bool
+	var goTextFormat bool
+	var goReadDelimited bool
+	var goDecodeFields bool
+	var goDecoder bool
+	var goUnpackAny bool
+	var goMinimal bool
+	var goUtilPrefix string
+	var goPackageMap stringList
+	var jsRuntime string
+	var jsMap string
+	var jsValidate bool
+	var jsJSONNames bool
+	var jsIndent string
+	var stdin bool
+	var header string
+	var headerFile string
+	var quiet bool
+	var verbose bool
 
-	flag.Var(&importPaths, "proto_path", "proto import path (repeatable)")
-	flag.StringVar(&goOut, "go.out", "", "output directory for Go")
-	flag.StringVar(&jsOut, "js.out", "", "output directory for JS")
-	flag.StringVar(&tsOut, "ts.out", "", "output directory for TS")
-	flag.StringVar(&goJSONTags, "go.jsontags", "", "Go JSON tags style (snake)")
-	flag.StringVar(&goCtxType, "go.ctxtype", "", "Go server auth context type override")
-	flag.BoolVar(&goClient, "go.client", false, "generate Go client stubs")
-	flag.StringVar(&goClientService, "go.client.service", "", "only generate Go client stubs for this service (empty = all)")
-	flag.BoolVar(&goServer, "go.server", true, "generate Go server mux stubs")
-	flag.Parse()
-
-	if len(flag.Args()) == 0 {
-		fmt.Fprintln(os.Stderr, "no proto files provided")
-		os.Exit(1)
+	fs.Var(&importPaths, "proto_path", "proto import path (repeatable)")
+	fs.BoolVar(&quiet, "quiet", false, "suppress warnings; only errors are printed")
+	fs.BoolVar(&verbose, "verbose", false, "log import paths, parsed files and written outputs to stderr")
+	fs.BoolVar(&stdin, "stdin", false, "read a proto file from stdin (as "+parser.StdinPath+") in addition to any positional files")
+	fs.StringVar(&header, "header", "", "license header text prepended as a comment to every generated file")
+	fs.StringVar(&headerFile, "header-file", "", "file whose contents are prepended as a comment to every generated file, like -header")
+	fs.StringVar(&goOut, "go.out", "", "output directory for Go")
+	fs.StringVar(&jsOut, "js.out", "", "output directory for JS")
+	fs.StringVar(&jsRuntime, "js.runtime", "import", "JS Writer/Reader runtime: import (from runtime.js), inline (into model.js) or protobufjs (from protobufjs/minimal)")
+	fs.StringVar(&jsMap, "js.map", "object", "JS representation of proto maps: object (plain object) or map (Map, preserves wire order)")
+	fs.BoolVar(&jsValidate, "js.validate", false, "generate JS validate<Msg>(message) functions that throw on missing required fields and mistyped values")
+	fs.BoolVar(&jsJSONNames, "js.jsonnames", false, "name JS properties by each field's proto3 JSON name (json_name)")
+	fs.StringVar(&jsIndent, "js.indent", "", "reindent generated JS with tab or a number of spaces (1-8) per level; empty keeps the generated indentation")
+	fs.StringVar(&tsOut, "ts.out", "", "output directory for TS")
+	fs.StringVar(&goJSONTags, "go.jsontags", "", "Go JSON tags style (snake, protojson)")
+	fs.StringVar(&goCtxType, "go.ctxtype", "", "Go server auth context type override")
+	fs.BoolVar(&goClient, "go.client", false, "generate Go client stubs")
+	fs.StringVar(&goClientService, "go.client.service", "", "only generate Go client stubs for this service (empty = all)")
+	fs.BoolVar(&goServer, "go.server", true, "generate Go server mux stubs")
+	fs.BoolVar(&goSingleFile, "go.singlefile", false, "merge all generated Go files into a single model.gen.go")
+	fs.BoolVar(&goSplitModel, "go.splitmodel", false, "write Go models as model_types.gen.go, model_encode.gen.go and model_decode.gen.go")
+	fs.BoolVar(&goBinaryMarshaler, "go.binarymarshaler", false, "generate MarshalBinary/UnmarshalBinary methods implementing encoding.BinaryMarshaler/Unmarshaler")
+	fs.BoolVar(&goWriterTo, "go.writerto", false, "generate WriteTo(w io.Writer) methods implementing io.WriterTo")
+	fs.BoolVar(&goSetters, "go.setters", false, "generate fluent Set<Field>/Add<Field> methods returning the message, for builder-style construction")
+	fs.BoolVar(&goHash, "go.hash", false, "generate Go Hash() uint64 methods over a deterministic encoding (map entries sorted)")
+	fs.BoolVar(&goCanonical, "go.canonical", false, "generate Go CanonicalEncode() methods writing fields in number order and map entries sorted, for signing")
+	fs.BoolVar(&goArena, "go.arena", false, "generate Go Decode<Msg>WithArena functions allocating messages from a reusable Arena")
+	fs.BoolVar(&goStrictRepeated, "go.strictrepeated", false, "reject nil elements of Go repeated message fields on encode (Encode panics, EncodeErr returns an error) instead of skipping them")
+	fs.BoolVar(&goEnumStringer, "go.enumstringer", true, "generate String() on Go enums returning the value name")
+	fs.BoolVar(&goEnumValues, "go.enumvalues", false, "generate <Enum>Values() functions listing each Go enum's values")
+	fs.BoolVar(&goStringer, "go.stringer", false, "generate String() on Go messages printing their fields")
+	fs.BoolVar(&goToMap, "go.tomap", false, "generate Go ToMap() map[string]any methods converting nested messages to maps and enums to names")
+	fs.BoolVar(&goApplyMask, "go.applymask", false, "generate Go ApplyMask(paths) methods zeroing the fields a FieldMask leaves out")
+	fs.BoolVar(&goPopulatedFields, "go.populatedfields", false, "generate Go PopulatedFields() []int methods returning the numbers of the fields Encode writes")
+	fs.BoolVar(&goUnknownEnums, "go.unknownenums", false, "generate Go UnknownEnums() methods reporting enum field values the generated enums do not declare")
+	fs.BoolVar(&goSortedRange, "go.sortedrange", false, "generate Go Range<Field>Sorted(fn) methods iterating map fields in key order")
+	fs.BoolVar(&goFieldNames, "go.fieldnames", false, "generate Go FieldName(number) methods returning the proto name of a field number")
+	fs.BoolVar(&goVisitor, "go.visitor", false, "generate Go VisitFields(v Visitor) methods passing each field to v and recursing into nested messages")
+	fs.BoolVar(&goDiff, "go.diff", false, "generate Go Diff<Msg>(a, b) functions listing the fields that differ between two messages")
+	fs.BoolVar(&goTextFormat, "go.textformat", false, "generate Go TextString() methods and Parse<Msg>Text functions for the protobuf text format")
+	fs.BoolVar(&goReadDelimited, "go.readdelimited", false, "generate Go ReadDelimited<Msg>(ctx, r) functions reading one length-prefixed message, aborting when ctx is done")
+	fs.BoolVar(&goDecodeFields, "go.decodefields", false, "generate Go Decode<Msg>Fields(b, fields...) functions decoding only the given field numbers")
+	fs.BoolVar(&goDecoder, "go.decoder", false, "generate a Go Decoder type holding DecodeOptions, with Decode<Msg>(b) methods decoding under them")
+	fs.BoolVar(&goUnpackAny, "go.unpackany", false, "generate a Go UnpackAny(a *Any) function decoding an Any into the generated message its type URL names")
+	fs.BoolVar(&goNonNilSlices, "go.nonnilslices", false, "decode absent Go repeated and map fields as empty, non-nil slices and maps")
+	fs.BoolVar(&goEmptyBytes, "go.emptybytes", false, "decode absent Go singular bytes fields as an empty, non-nil slice")
+	fs.BoolVar(&goMinimal, "go.minimal", false, "generate only Go models and util.gen.go, importing just small standard packages TinyGo supports")
+	fs.StringVar(&goNolint, "go.nolint", "all", "linters for the //nolint directive added to generated Go files (empty = no directive)")
+	fs.Var(&goPackageMap, "go.packagemap", "override a file's Go package as file.proto=pkg, ahead of its go_package option (repeatable)")
+	fs.StringVar(&goUtilPrefix, "go.utilprefix", "", "prefix for identifiers in the generated util.gen.go (e.g. cp_), to avoid clashes with the package's own names")
+	fs.BoolVar(&goSamples, "go.samples", false, "generate Go sample<Msg>(seed) test helpers in model_sample_test.go")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if quiet && verbose {
+		fmt.Fprintln(stderr, "-quiet and -verbose are mutually exclusive")
+		return 1
+	}
+	logger := log.New(stderr, "", log.LstdFlags)
+	if quiet {
+		logger.SetOutput(io.Discard)
+	}
+	verbosef := func(format string, args ...any) {
+		if verbose {
+			fmt.Fprintf(stderr, "cleanproto: "+format+"\n", args...)
+		}
 	}
+
 	if len(importPaths) == 0 {
 		importPaths = append(importPaths, ".")
 	}
+	protoFiles, err := expandProtoArgs(fs.Args(), importPaths)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if stdin {
+		protoFiles = append(protoFiles, parser.StdinPath)
+	}
+	if len(protoFiles) == 0 {
+		fmt.Fprintln(stderr, "no proto files provided")
+		return 1
+	}
 	if goOut == "" && jsOut == "" && tsOut == "" {
-		fmt.Fprintln(os.Stderr, "at least one of -go.out, -js.out, or -ts.out is required")
-		os.Exit(1)
+		fmt.Fprintln(stderr, "at least one of -go.out, -js.out, or -ts.out is required")
+		return 1
+	}
+	if goJSONTags != "" && goJSONTags != "snake" && goJSONTags != "protojson" {
+		fmt.Fprintln(stderr, "-go.jsontags must be empty or one of: snake, protojson")
+		return 1
 	}
-	if goJSONTags != "" && goJSONTags != "snake" {
-		fmt.Fprintln(os.Stderr, "-go.jsontags must be empty or: snake")
-		os.Exit(1)
+
+	if jsRuntime != "import" && jsRuntime != "inline" && jsRuntime != "protobufjs" {
+		fmt.Fprintln(stderr, "-js.runtime must be one of: import, inline, protobufjs")
+		return 1
+	}
+
+	if jsMap != "object" && jsMap != "map" {
+		fmt.Fprintln(stderr, "-js.map must be one of: object, map")
+		return 1
+	}
+
+	switch jsIndent {
+	case "", "tab", "1", "2", "3", "4", "5", "6", "7", "8":
+	default:
+		fmt.Fprintln(stderr, "-js.indent must be tab or a number of spaces from 1 to 8")
+		return 1
+	}
+
+	goPackages, err := parseGoPackageMap(goPackageMap)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if headerFile != "" {
+		if header != "" {
+			fmt.Fprintln(stderr, "-header and -header-file cannot both be set")
+			return 1
+		}
+		content, err := os.ReadFile(headerFile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		header = string(content)
 	}
 
 	ctx := context.Background()
-	p := parser.Parser{ImportPaths: importPaths}
-	files, err := p.Parse(ctx, flag.Args())
+	for _, path := range importPaths {
+		if abs, err := filepath.Abs(path); err == nil && abs != path {
+			verbosef("import path: %s (%s)", path, abs)
+		} else {
+			verbosef("import path: %s", path)
+		}
+	}
+	p := parser.Parser{ImportPaths: importPaths, Logger: logger}
+	if stdin {
+		p.Stdin = stdinReader
+	}
+	files, err := p.Parse(ctx, protoFiles)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for _, file := range files {
+		verbosef("parsed %s", file.Path)
 	}
 
 	options := generate.Options{
-		GoOut:           cleanPath(goOut),
-		JsOut:           cleanPath(jsOut),
-		TsOut:           cleanPath(tsOut),
-		GoJSONTags:      goJSONTags,
-		GoCtxType:       goCtxType,
-		GoClient:        goClient,
-		GoClientService: goClientService,
-		GoServer:        goServer,
+		GoOut:             cleanPath(goOut),
+		JsOut:             cleanPath(jsOut),
+		TsOut:             cleanPath(tsOut),
+		GoJSONTags:        goJSONTags,
+		GoCtxType:         goCtxType,
+		GoClient:          goClient,
+		GoClientService:   goClientService,
+		GoServer:          goServer,
+		GoEmitSamples:     goSamples,
+		GoSingleFile:      goSingleFile,
+		GoSplitModel:      goSplitModel,
+		GoBinaryMarshaler: goBinaryMarshaler,
+		GoWriterTo:        goWriterTo,
+		GoSetters:         goSetters,
+		GoNonNilSlices:    goNonNilSlices,
+		GoEmptyBytes:      goEmptyBytes,
+		GoHash:            goHash,
+		GoCanonical:       goCanonical,
+		GoArena:           goArena,
+		GoStrictRepeated:  goStrictRepeated,
+		GoEnumStringer:    goEnumStringer,
+		GoEnumValues:      goEnumValues,
+		GoStringer:        goStringer,
+		GoToMap:           goToMap,
+		GoApplyMask:       goApplyMask,
+		GoPopulatedFields: goPopulatedFields,
+		GoUnknownEnums:    goUnknownEnums,
+		GoSortedRange:     goSortedRange,
+		GoFieldNames:      goFieldNames,
+		GoVisitor:         goVisitor,
+		GoDiff:            goDiff,
+		GoTextFormat:      goTextFormat,
+		GoReadDelimited:   goReadDelimited,
+		GoDecodeFields:    goDecodeFields,
+		GoDecoder:         goDecoder,
+		GoUnpackAny:       goUnpackAny,
+		GoMinimal:         goMinimal,
+		GoNolint:          goNolint,
+		GoUtilPrefix:      goUtilPrefix,
+		GoPackageMap:      goPackages,
+		JsRuntime:         jsRuntime,
+		JsMap:             jsMap,
+		JsValidate:        jsValidate,
+		JsJSONNames:       jsJSONNames,
+		JsIndent:          jsIndent,
 	}
 
 	generators := []generate.Generator{
@@ -88,19 +298,142 @@ func main() {
 		tsg.Generator{},
 	}
 
-	for _, gen := range generators {
-		outputs, err := gen.Generate(files, options)
+	outputs, err := generate.Generate(files, options, generators...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if header != "" {
+		generate.PrependHeader(outputs, header)
+	}
+	if err := generate.WriteFiles(outputs); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for _, output := range outputs {
+		verbosef("wrote %s", output.Path)
+	}
+	return 0
+}
+
+// parseGoPackageMap parses -go.packagemap file.proto=pkg arguments into a map
+// from proto path to Go package name.
+func parseGoPackageMap(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	packages := make(map[string]string, len(args))
+	for _, arg := range args {
+		path, pkg, ok := strings.Cut(arg, "=")
+		if !ok || path == "" || !token.IsIdentifier(pkg) {
+			return nil, fmt.Errorf("-go.packagemap must be file.proto=pkg with pkg a Go identifier, got %q", arg)
+		}
+		packages[filepath.ToSlash(filepath.Clean(path))] = pkg
+	}
+	return packages, nil
+}
+
+// expandProtoArgs expands positional arguments naming directories or glob
+// patterns into the .proto files they contain. Like plain file arguments they
+// are resolved against the import paths, and the results stay relative to the
+// import path they were found under. A directory contributes its own .proto
+// files, dir/... walks the whole tree, and patterns use filepath.Match syntax.
+// Other arguments pass through unchanged. Files are sorted within each
+// argument and deduplicated, keeping the first occurrence.
+func expandProtoArgs(args []string, importPaths []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	for _, arg := range args {
+		matches, err := expandProtoArg(arg, importPaths)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			return nil, err
+		}
+		if matches == nil {
+			add(arg)
+			continue
 		}
-		if err := generate.WriteFiles(outputs); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no .proto files match %s", arg)
 		}
+		for _, match := range matches {
+			add(match)
+		}
+	}
+	return files, nil
+}
+
+// expandProtoArg returns the sorted .proto files arg names under the first
+// import path where it matches, or nil when arg is not a directory or pattern.
+func expandProtoArg(arg string, importPaths []string) ([]string, error) {
+	dir, recursive := strings.CutSuffix(filepath.ToSlash(arg), "/...")
+	dir = filepath.FromSlash(dir)
+	isPattern := strings.ContainsAny(arg, "*?[")
+	expandable := isPattern || recursive
+	for _, importPath := range importPaths {
+		root := importPath
+		if filepath.IsAbs(arg) {
+			root = ""
+		}
+		var matches []string
+		if isPattern {
+			paths, err := filepath.Glob(filepath.Join(root, arg))
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", arg, err)
+			}
+			for _, path := range paths {
+				if filepath.Ext(path) == ".proto" {
+					matches = append(matches, path)
+				}
+			}
+		} else {
+			top := filepath.Join(root, dir)
+			if info, err := os.Stat(top); err != nil || !info.IsDir() {
+				continue
+			}
+			expandable = true
+			err := filepath.WalkDir(top, func(path string, d os.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() && path != top && !recursive {
+					return filepath.SkipDir
+				}
+				if !d.IsDir() && filepath.Ext(path) == ".proto" {
+					matches = append(matches, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		// Compile relative to the import path, as with file arguments.
+		for i, match := range matches {
+			if rel, err := filepath.Rel(root, match); root != "" && err == nil {
+				matches[i] = rel
+			}
+		}
+		sort.Strings(matches)
+		return matches, nil
+	}
+	if expandable {
+		return []string{}, nil
 	}
+	return nil, nil
 }
 
+// cleanPath cleans an output directory flag. A relative path stays relative,
+// so it resolves against the working directory, not an import path.
 func cleanPath(path string) string {
 	if path == "" {
 		return ""
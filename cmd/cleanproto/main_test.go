@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const warningProto = `syntax = "proto3";
+package example;
+option go_package = "example";
+
+import "buf/validate/validate.proto";
+
+message Host {
+  string name = 1 [(buf.validate.field).string.hostname = true];
+}
+`
+
+func writeProto(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "host.proto"), []byte(warningProto), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	return dir
+}
+
+func TestRunVerboseLogsParsedFilesAndOutputs(t *testing.T) {
+	dir := writeProto(t)
+	goOut := filepath.Join(dir, "go")
+	var stderr bytes.Buffer
+	code := run([]string{"-verbose", "-proto_path", dir, "-go.out", goOut, "host.proto"}, nil, &stderr)
+	if code != 0 {
+		t.Fatalf("run exited %d:\n%s", code, stderr.String())
+	}
+	logged := stderr.String()
+	for _, want := range []string{
+		"cleanproto: import path: " + dir + "\n",
+		"cleanproto: parsed host.proto\n",
+		"cleanproto: wrote " + filepath.Join(goOut, "model.gen.go") + "\n",
+		"cleanproto: wrote " + filepath.Join(goOut, "util.gen.go") + "\n",
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("expected verbose output to contain %q, got:\n%s", want, logged)
+		}
+	}
+}
+
+func TestRunQuietSuppressesWarnings(t *testing.T) {
+	dir := writeProto(t)
+	var stderr bytes.Buffer
+	if code := run([]string{"-proto_path", dir, "-go.out", filepath.Join(dir, "go"), "host.proto"}, nil, &stderr); code != 0 {
+		t.Fatalf("run exited %d:\n%s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "string.hostname not supported") {
+		t.Fatalf("expected a skipped-option warning by default, got:\n%s", stderr.String())
+	}
+
+	stderr.Reset()
+	if code := run([]string{"-quiet", "-proto_path", dir, "-go.out", filepath.Join(dir, "go"), "host.proto"}, nil, &stderr); code != 0 {
+		t.Fatalf("run exited %d:\n%s", code, stderr.String())
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("expected no output with -quiet, got:\n%s", stderr.String())
+	}
+}
+
+func TestRunQuietStillReportsErrors(t *testing.T) {
+	var stderr bytes.Buffer
+	if code := run([]string{"-quiet", "-go.out", t.TempDir(), "missing.proto"}, nil, &stderr); code == 0 {
+		t.Fatalf("expected a non-zero exit for a missing proto file")
+	}
+	if stderr.Len() == 0 {
+		t.Fatalf("expected -quiet to still print errors")
+	}
+}
+
+func TestRunExpandsDirectoriesAndPatterns(t *testing.T) {
+	root := t.TempDir()
+	protos := map[string]string{
+		"api/a.proto":          "syntax = \"proto3\";\npackage api;\noption go_package = \"api\";\nmessage A { string id = 1; }\n",
+		"api/v1/b.proto":       "syntax = \"proto3\";\npackage api.v1;\noption go_package = \"api\";\nmessage B { string id = 1; }\n",
+		"api/v1/inner/c.proto": "syntax = \"proto3\";\npackage api.v1.inner;\noption go_package = \"api\";\nmessage C { string id = 1; }\n",
+		"api/README.md":        "not a proto",
+	}
+	for name, content := range protos {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"api/..."}, []string{"api/a.proto", "api/v1/b.proto", "api/v1/inner/c.proto"}},
+		{[]string{"api"}, []string{"api/a.proto"}},
+		{[]string{"api/v1/*.proto", "api/..."}, []string{"api/v1/b.proto", "api/a.proto", "api/v1/inner/c.proto"}},
+	} {
+		var stderr bytes.Buffer
+		args := append([]string{"-verbose", "-proto_path", root, "-go.out", filepath.Join(t.TempDir(), "go")}, tc.args...)
+		if code := run(args, nil, &stderr); code != 0 {
+			t.Fatalf("%v: run exited %d:\n%s", tc.args, code, stderr.String())
+		}
+		var parsed []string
+		for _, line := range strings.Split(stderr.String(), "\n") {
+			if name, ok := strings.CutPrefix(line, "cleanproto: parsed "); ok {
+				parsed = append(parsed, filepath.ToSlash(name))
+			}
+		}
+		if strings.Join(parsed, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%v: parsed %v, want %v", tc.args, parsed, tc.want)
+		}
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"-proto_path", root, "-go.out", t.TempDir(), "api/v1/*.txt"}, nil, &stderr); code == 0 {
+		t.Fatalf("expected a pattern matching no protos to fail")
+	}
+	if !strings.Contains(stderr.String(), "no .proto files match api/v1/*.txt") {
+		t.Fatalf("unexpected error output:\n%s", stderr.String())
+	}
+}
+
+func TestRunResolvesRelativeOutputAgainstWorkingDir(t *testing.T) {
+	root := t.TempDir()
+	protoDir := filepath.Join(root, "api", "v1")
+	if err := os.MkdirAll(protoDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(protoDir, "host.proto"), []byte(warningProto), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	var stderr bytes.Buffer
+	if code := run([]string{"-quiet", "-proto_path", root, "-go.out", "gen/go", "-js.out", "gen/nested/js", "api/v1/host.proto"}, nil, &stderr); code != 0 {
+		t.Fatalf("run exited %d:\n%s", code, stderr.String())
+	}
+	for _, path := range []string{"gen/go/model.gen.go", "gen/go/util.gen.go", "gen/nested/js/model.js"} {
+		if _, err := os.Stat(filepath.Join(cwd, path)); err != nil {
+			t.Errorf("expected %s under the working directory: %v", path, err)
+		}
+	}
+	for _, dir := range []string{filepath.Join(root, "gen"), filepath.Join(protoDir, "gen")} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("expected nothing written to %s, got %v", dir, err)
+		}
+	}
+}
+
+func TestRunGoPackageMapOverridesGoPackage(t *testing.T) {
+	dir := writeProto(t)
+	goOut := filepath.Join(dir, "go")
+	var stderr bytes.Buffer
+	if code := run([]string{"-quiet", "-proto_path", dir, "-go.out", goOut, "-go.packagemap", "host.proto=hostpb", "host.proto"}, nil, &stderr); code != 0 {
+		t.Fatalf("run exited %d:\n%s", code, stderr.String())
+	}
+	for _, name := range []string{"model.gen.go", "util.gen.go"} {
+		content, err := os.ReadFile(filepath.Join(goOut, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if !strings.Contains(string(content), "\npackage hostpb\n") {
+			t.Errorf("expected %s to use package hostpb, got:\n%s", name, content)
+		}
+	}
+
+	for _, tc := range []struct {
+		arg  string
+		want string
+	}{
+		{"host.proto", "-go.packagemap must be file.proto=pkg"},
+		{"host.proto=host-pb", "-go.packagemap must be file.proto=pkg"},
+		{"other.proto=otherpb", "go package map names files that were not parsed: other.proto"},
+	} {
+		stderr.Reset()
+		if code := run([]string{"-quiet", "-proto_path", dir, "-go.out", goOut, "-go.packagemap", tc.arg, "host.proto"}, nil, &stderr); code == 0 {
+			t.Fatalf("%s: expected a non-zero exit", tc.arg)
+		}
+		if !strings.Contains(stderr.String(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got:\n%s", tc.arg, tc.want, stderr.String())
+		}
+	}
+}
+
+func TestRunHeaderFile(t *testing.T) {
+	dir := writeProto(t)
+	goOut := filepath.Join(dir, "go")
+	headerPath := filepath.Join(dir, "LICENSE.txt")
+	if err := os.WriteFile(headerPath, []byte("Copyright Example Corp.\n"), 0o644); err != nil {
+		t.Fatalf("write header: %v", err)
+	}
+	var stderr bytes.Buffer
+	if code := run([]string{"-quiet", "-proto_path", dir, "-go.out", goOut, "-header-file", headerPath, "host.proto"}, nil, &stderr); code != 0 {
+		t.Fatalf("run exited %d:\n%s", code, stderr.String())
+	}
+	content, err := os.ReadFile(filepath.Join(goOut, "model.gen.go"))
+	if err != nil {
+		t.Fatalf("read model.gen.go: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "// Copyright Example Corp.\n") {
+		t.Errorf("expected the header file's contents first, got:\n%s", content)
+	}
+
+	for _, args := range [][]string{
+		{"-header-file", filepath.Join(dir, "missing.txt")},
+		{"-header-file", headerPath, "-header", "Copyright"},
+	} {
+		stderr.Reset()
+		args = append([]string{"-quiet", "-proto_path", dir, "-go.out", goOut}, append(args, "host.proto")...)
+		if code := run(args, nil, &stderr); code == 0 {
+			t.Errorf("%v: expected a non-zero exit", args)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package cp
+
+import _ "embed"
+
+const MoneyProtoPath = "google/type/money.proto"
+
+//go:embed google/type/money.proto
+var MoneyProtoSource string
@@ -75,6 +75,60 @@ var E_GoValue = &protoimpl.ExtensionInfo{
 	Filename:      OptionsProtoPath,
 }
 
+var E_GoMapSizeHint = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+	ExtensionType: (*int32)(nil),
+	Field:         50023,
+	Name:          "cp.go_map_size_hint",
+	Tag:           "varint,50023,opt,name=go_map_size_hint",
+	Filename:      OptionsProtoPath,
+}
+
+var E_SortBy = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+	ExtensionType: (*string)(nil),
+	Field:         50024,
+	Name:          "cp.sort_by",
+	Tag:           "bytes,50024,opt,name=sort_by",
+	Filename:      OptionsProtoPath,
+}
+
+var E_Nullable = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+	ExtensionType: (*bool)(nil),
+	Field:         50025,
+	Name:          "cp.nullable",
+	Tag:           "varint,50025,opt,name=nullable",
+	Filename:      OptionsProtoPath,
+}
+
+var E_Lazy = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+	ExtensionType: (*bool)(nil),
+	Field:         50026,
+	Name:          "cp.lazy",
+	Tag:           "varint,50026,opt,name=lazy",
+	Filename:      OptionsProtoPath,
+}
+
+var E_AlwaysEmit = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+	ExtensionType: (*bool)(nil),
+	Field:         50027,
+	Name:          "cp.always_emit",
+	Tag:           "varint,50027,opt,name=always_emit",
+	Filename:      OptionsProtoPath,
+}
+
+var E_GoTag = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+	ExtensionType: (*string)(nil),
+	Field:         50028,
+	Name:          "cp.go_tag",
+	Tag:           "bytes,50028,opt,name=go_tag",
+	Filename:      OptionsProtoPath,
+}
+
 var E_JsIgnore = &protoimpl.ExtensionInfo{
 	ExtendedType:  (*descriptorpb.FieldOptions)(nil),
 	ExtensionType: (*bool)(nil),
@@ -129,6 +183,33 @@ var E_AuditIgnore = &protoimpl.ExtensionInfo{
 	Filename:      OptionsProtoPath,
 }
 
+var E_GoImmutable = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+	ExtensionType: (*bool)(nil),
+	Field:         50040,
+	Name:          "cp.go_immutable",
+	Tag:           "varint,50040,opt,name=go_immutable",
+	Filename:      OptionsProtoPath,
+}
+
+var E_GoJson = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+	ExtensionType: (*bool)(nil),
+	Field:         50041,
+	Name:          "cp.go_json",
+	Tag:           "varint,50041,opt,name=go_json",
+	Filename:      OptionsProtoPath,
+}
+
+var E_JsName = &protoimpl.ExtensionInfo{
+	ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+	ExtensionType: (*string)(nil),
+	Field:         50042,
+	Name:          "cp.js_name",
+	Tag:           "bytes,50042,opt,name=js_name",
+	Filename:      OptionsProtoPath,
+}
+
 var E_GoCustom = &protoimpl.ExtensionInfo{
 	ExtendedType:  (*descriptorpb.MethodOptions)(nil),
 	ExtensionType: (*bool)(nil),
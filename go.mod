@@ -4,9 +4,8 @@ go 1.26.0
 
 require (
 	github.com/bufbuild/protocompile v0.10.0
+	github.com/google/uuid v1.6.0
 	google.golang.org/protobuf v1.33.1-0.20240319125436-3039476726e4
 )
 
-require (
-	golang.org/x/sync v0.6.0 // indirect
-)
+require golang.org/x/sync v0.6.0 // indirect
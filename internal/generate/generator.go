@@ -16,6 +16,120 @@ type Options struct {
 	GoClient        bool
 	GoClientService string
 	GoServer        bool
+	GoEmitSamples   bool
+	GoSingleFile    bool
+	// GoSplitModel writes each model.gen.go as model_types.gen.go,
+	// model_encode.gen.go and model_decode.gen.go in the same package.
+	GoSplitModel bool
+	// GoBinaryMarshaler adds MarshalBinary/UnmarshalBinary methods so
+	// generated messages implement encoding.BinaryMarshaler/Unmarshaler.
+	GoBinaryMarshaler bool
+	// GoWriterTo adds a WriteTo(w io.Writer) method so generated messages
+	// implement io.WriterTo.
+	GoWriterTo bool
+	// GoSetters adds fluent Set<Field>/Add<Field> methods that return the
+	// message, for builder-style construction.
+	GoSetters bool
+	// GoNonNilSlices makes decoding leave absent repeated and map fields as
+	// empty, non-nil values instead of nil.
+	GoNonNilSlices bool
+	// GoEmptyBytes makes decoding leave absent singular bytes fields without
+	// presence as a shared empty, non-nil slice instead of nil.
+	GoEmptyBytes bool
+	// GoHash adds a Hash() uint64 method to every message and makes Encode
+	// write map entries in sorted order, so equal messages hash equally.
+	GoHash bool
+	// GoCanonical adds a CanonicalEncode() []byte method to every message
+	// writing fields in number order and map entries sorted, for signing.
+	GoCanonical bool
+	// GoArena adds Decode<Msg>WithArena functions that allocate nested
+	// messages from a reusable Arena instead of individually.
+	GoArena bool
+	// GoStrictRepeated makes Encode panic, and adds EncodeErr returning an
+	// error, when a repeated message field has a nil element instead of
+	// skipping it.
+	GoStrictRepeated bool
+	// GoEnumStringer adds a String() method to enums returning the value's
+	// name. It is independent of GoStringer.
+	GoEnumStringer bool
+	// GoEnumValues adds a <Enum>Values() function to enums returning their
+	// values in declaration order, without aliases.
+	GoEnumValues bool
+	// GoStringer adds a String() method to messages printing their fields.
+	GoStringer bool
+	// GoToMap adds a ToMap() map[string]any method to messages, converting
+	// nested messages to maps and enums to their names.
+	GoToMap bool
+	// GoApplyMask adds an ApplyMask(paths []string) error method to
+	// messages, zeroing the fields a FieldMask's paths leave out.
+	GoApplyMask bool
+	// GoPopulatedFields adds a PopulatedFields() []int method to messages
+	// returning the numbers of the fields Encode would write.
+	GoPopulatedFields bool
+	// GoUnknownEnums adds an UnknownEnums() []UnknownEnum method to messages
+	// reporting enum field values their generated enum does not declare.
+	GoUnknownEnums bool
+	// GoSortedRange adds a Range<Field>Sorted(fn) method per map field
+	// calling fn for each entry in key order.
+	GoSortedRange bool
+	// GoFieldNames adds a FieldName(number int) string method to messages
+	// returning the proto name of a field number.
+	GoFieldNames bool
+	// GoVisitor adds a VisitFields(v Visitor) method to messages passing each
+	// field to v and recursing into nested messages.
+	GoVisitor bool
+	// GoDiff adds a Diff<Msg>(a, b *Msg) []FieldDiff function per message
+	// listing the fields that differ, recursing into nested messages.
+	GoDiff bool
+	// GoTextFormat adds a TextString() string method to messages returning
+	// them in the protobuf text format, and Parse<Msg>Text functions reading
+	// it back.
+	GoTextFormat bool
+	// GoReadDelimited adds ReadDelimited<Msg>(ctx, r) functions reading one
+	// length-prefixed message from an io.Reader, returning early when ctx is
+	// done.
+	GoReadDelimited bool
+	// GoDecodeFields adds Decode<Msg>Fields(b, fields...) functions decoding
+	// only the listed field numbers and skipping the rest.
+	GoDecodeFields bool
+	// GoDecoder adds a Decoder type holding DecodeOptions, with a
+	// Decode<Msg>(b) method per message decoding under those options.
+	GoDecoder bool
+	// GoUnpackAny adds UnpackAny(a *Any) (any, error), decoding a
+	// google.protobuf.Any into the generated message its type URL names.
+	GoUnpackAny bool
+	// GoMinimal restricts Go output to models and util.gen.go using only
+	// small standard packages TinyGo supports, for embedded targets.
+	GoMinimal bool
+	// GoPackageMap overrides the Go package of the files it names, keyed by
+	// their path relative to the import path, ahead of their go_package
+	// option.
+	GoPackageMap map[string]string
+	// GoNolint, when set, adds a //nolint:<GoNolint> directive to every
+	// generated Go file.
+	GoNolint string
+	// GoUtilPrefix is prepended to every identifier declared in util.gen.go,
+	// and to the generated code's references to them, so the helpers cannot
+	// clash with the user's own declarations in the package.
+	GoUtilPrefix string
+	// JsRuntime selects how model.js gets its Writer/Reader: "import" (the
+	// default) imports them from a sibling runtime.js, "inline" embeds them
+	// and "protobufjs" imports protobufjs/minimal, holding 64-bit integers
+	// as its Long.
+	JsRuntime string
+	// JsMap selects the JS representation of proto map fields: "object" (the
+	// default) decodes into plain objects, "map" into Map, which preserves
+	// wire order for integer-like keys.
+	JsMap string
+	// JsValidate adds validate<Msg>(message) functions that throw on unset
+	// required fields and on values of the wrong type.
+	JsValidate bool
+	// JsJSONNames names JS properties by each field's proto3 JSON name
+	// (json_name) instead of the camelCased proto name.
+	JsJSONNames bool
+	// JsIndent reindents generated JS with "tab" or a number of spaces per
+	// level. Empty keeps the generated indentation.
+	JsIndent string
 }
 
 type Generator interface {
@@ -0,0 +1,52 @@
+package gogen
+
+import "regexp"
+
+var goNewMessagePattern = regexp.MustCompile(`new\((\w+)\)`)
+
+// useGoArenaAlloc routes the nested message allocations of decode<Msg>Into
+// through allocMessage for -go.arena, so they come from the decode's Arena
+// when there is one.
+func useGoArenaAlloc(data *goFileData) {
+	for i := range data.Messages {
+		for j := range data.Messages[i].DecodeCases {
+			lines := data.Messages[i].DecodeCases[j].Lines
+			for k, line := range lines {
+				lines[k] = goNewMessagePattern.ReplaceAllString(line, "allocMessage[$1](budget)")
+			}
+		}
+	}
+}
+
+const arenaUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+import "math"
+
+// Arena supplies the messages decoded by Decode<Message>WithArena from chunks
+// that Reset recycles, so decoding many messages stops allocating once the
+// chunks have grown. Messages decoded with an arena, and anything they point
+// to, must not be used after Reset. Slices, maps, strings and bytes are still
+// allocated individually. The zero Arena is ready to use; an Arena is not
+// safe for concurrent use.
+type Arena struct {
+	decode decodeBudget
+}
+
+// Reset zeroes every message handed out so far and makes the memory
+// available to the next decode.
+func (a *Arena) Reset() {
+	for _, s := range a.decode.slabs {
+		s.reset()
+	}
+}
+
+func (a *Arena) budget() *decodeBudget {
+	if a.decode.slabs == nil {
+		a.decode.slabs = make(map[any]messageSlab)
+	}
+	a.decode.remaining = math.MaxInt
+	return &a.decode
+}
+`
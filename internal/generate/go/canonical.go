@@ -0,0 +1,51 @@
+package gogen
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// buildGoCanonicalLines returns the body of a message's CanonicalEncode
+// method: Encode's, with fields in ascending number order rather than
+// declaration order, map entries sorted as -go.hash sorts them, and nested
+// messages, map values included, canonically encoded in turn.
+func buildGoCanonicalLines(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum) ([]string, error) {
+	sorted := msg
+	sorted.Fields = slices.Clone(msg.Fields)
+	slices.SortStableFunc(sorted.Fields, func(a, b ir.Field) int {
+		return cmp.Compare(a.Number, b.Number)
+	})
+	lines, err := buildGoEncodeLines(sorted, msgIndex, enumIndex)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range lines {
+		line = strings.Replace(line, "AppendMap(", "AppendSortedMap(", 1)
+		line = strings.Replace(line, "AppendMessageFieldDecorator[", "AppendCanonicalMessageFieldDecorator[", 1)
+		line = strings.Replace(line, ".Encode())", ".CanonicalEncode())", 1)
+		lines[i] = strings.ReplaceAll(line, "protowire.", "")
+	}
+	return lines, nil
+}
+
+const canonicalUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+// CanonicalEncodable is a message with a CanonicalEncode method.
+type CanonicalEncodable interface {
+	CanonicalEncode() []byte
+}
+
+// AppendCanonicalMessageFieldDecorator is AppendMessageFieldDecorator for
+// CanonicalEncode, writing message map values canonically encoded.
+func AppendCanonicalMessageFieldDecorator[T CanonicalEncodable](num Number) func([]byte, T) []byte {
+	return func(b []byte, value T) []byte {
+		b = AppendTag(b, num, BytesType)
+		return AppendBytes(b, value.CanonicalEncode())
+	}
+}
+`
@@ -0,0 +1,43 @@
+package gogen
+
+import "github.com/jptrs93/cleanproto/internal/ir"
+
+// computeGoAliasedDecodes returns the messages whose decoded form can alias
+// the bytes decoded: those with a ctype = CORD bytes field, and any message
+// nesting one of them.
+func computeGoAliasedDecodes(msgIndex map[string]ir.Message) map[string]bool {
+	aliased := map[string]bool{}
+	for name, msg := range msgIndex {
+		for _, field := range goVisibleFields(msg.Fields) {
+			if field.AliasBytes {
+				aliased[name] = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, msg := range msgIndex {
+			if aliased[name] {
+				continue
+			}
+			for _, field := range goVisibleFields(msg.Fields) {
+				if aliased[field.MessageFullName] || field.IsMap && aliased[field.MapValueMessage] {
+					aliased[name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return aliased
+}
+
+// goStreamPayload returns the expression generated stream readers decode a
+// frame from. StreamReader reuses the payload's memory for the next frame,
+// so messages that would alias it decode a copy.
+func goStreamPayload(aliased bool) string {
+	if aliased {
+		return "append([]byte(nil), payload...)"
+	}
+	return "payload"
+}
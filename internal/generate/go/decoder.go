@@ -0,0 +1,21 @@
+package gogen
+
+const decoderUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+// Decoder decodes messages with the same DecodeOptions, set once rather than
+// passed to each Decode<Message>WithOptions call. The zero Decoder, like a
+// nil one, has no limits and decodes as Decode<Message> does. Each decode
+// gets its own budget, so a Decoder can be shared between goroutines.
+type Decoder struct {
+	Options DecodeOptions
+}
+
+func (d *Decoder) options() DecodeOptions {
+	if d == nil {
+		return DecodeOptions{}
+	}
+	return d.Options
+}
+`
@@ -0,0 +1,126 @@
+package gogen
+
+const delimitedUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+var errDelimitedTooLarge = errors.New("delimited frame length overflows int")
+
+// ReadDelimited reads one uvarint length-prefixed frame from r, the framing
+// Decode<Message>N and streaming RPCs use, and returns its payload. It
+// returns io.EOF when r ends before a frame starts and io.ErrUnexpectedEOF
+// when r ends inside one. Bytes past the frame are left in r.
+//
+// If ctx is done first, ReadDelimited returns ctx.Err(), checking it again
+// between the length and the payload. A Read blocked on r is interrupted by
+// a read deadline in the past when r has SetReadDeadline, as a net.Conn
+// does; the deadline is cleared again before returning. Any other r is read
+// on a separate goroutine when ctx can be done, and that Read carries on in
+// the background until r returns. Either way the frame being read is lost,
+// so r should be closed and not read again.
+func ReadDelimited(ctx context.Context, r io.Reader) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		return readDelimitedFrame(ctx, r)
+	}
+	if d, ok := r.(readDeadliner); ok {
+		return readDelimitedWithDeadline(ctx, r, d)
+	}
+	type result struct {
+		payload []byte
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		payload, err := readDelimitedFrame(ctx, r)
+		done <- result{payload, err}
+	}()
+	select {
+	case res := <-done:
+		return res.payload, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readDeadliner is a reader whose blocked Reads a deadline can interrupt.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// aLongTimeAgo is the past deadline that interrupts a blocked Read.
+var aLongTimeAgo = time.Unix(1, 0)
+
+func readDelimitedWithDeadline(ctx context.Context, r io.Reader, d readDeadliner) ([]byte, error) {
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		d.SetReadDeadline(aLongTimeAgo)
+		close(interrupted)
+	})
+	payload, err := readDelimitedFrame(ctx, r)
+	if stop() {
+		return payload, err
+	}
+	<-interrupted
+	d.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, ctx.Err()
+	}
+	// The frame was read in full before the deadline took effect.
+	return payload, nil
+}
+
+func readDelimitedFrame(ctx context.Context, r io.Reader) ([]byte, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = delimitedByteReader{r}
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if size > math.MaxInt {
+		return nil, errDelimitedTooLarge
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// The payload grows as bytes arrive rather than being allocated from
+	// the length up front, so a corrupt length cannot allocate more than r
+	// supplies.
+	var payload bytes.Buffer
+	if _, err := io.CopyN(&payload, r, int64(size)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
+
+// delimitedByteReader reads the length of a frame one byte at a time, so
+// nothing past it is taken from a reader without ReadByte.
+type delimitedByteReader struct {
+	r io.Reader
+}
+
+func (d delimitedByteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+`
@@ -0,0 +1,88 @@
+package gogen
+
+import (
+	"fmt"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// goEncodeCheckLines returns the checks Encode runs on field before encoding
+// anything, panicking through panicEncodeField when its value cannot be
+// represented on the wire: go_type conversions that narrow (time.Time and
+// time.Duration into int32 seconds) and cp.go_type "string" enums holding a
+// name their enum does not declare.
+func goEncodeCheckLines(msg ir.Message, field ir.Field, name string, enumIndex map[string]ir.Enum) []string {
+	check := goEncodeCheckFunc(field)
+	if check == "" {
+		return nil
+	}
+	call := func(value string) string {
+		if goEnumString(field) {
+			return fmt.Sprintf("%s(%q, %d, %s, %s_value)", check, msg.Name, field.Number, value, enumIndex[field.EnumFullName].Name)
+		}
+		return fmt.Sprintf("%s(%q, %d, %s)", check, msg.Name, field.Number, value)
+	}
+	switch {
+	case field.IsRepeated:
+		return []string{fmt.Sprintf("for _, item := range %s {", name), call("item"), "}"}
+	case field.IsOptional:
+		return []string{fmt.Sprintf("if %s != nil {", name), call("*" + name), "}"}
+	}
+	return []string{call(name)}
+}
+
+func goEncodeCheckFunc(field ir.Field) string {
+	if !field.GoEncode || field.GoIgnore {
+		return ""
+	}
+	if goEnumString(field) {
+		return "checkEnumName"
+	}
+	if field.Kind != ir.KindInt32 {
+		return ""
+	}
+	switch field.GoType {
+	case "time.Time":
+		return "checkTimeInt32"
+	case "time.Duration":
+		return "checkDurationInt32"
+	}
+	return ""
+}
+
+// computeGoFallibleEncodes returns the messages whose Encode can fail, and so
+// get EncodeErr: those with a field needing an encode check, those with a
+// repeated message field under -go.strictrepeated, and any message nesting
+// one of them.
+func computeGoFallibleEncodes(msgIndex map[string]ir.Message, strictRepeated bool) map[string]bool {
+	fallible := map[string]bool{}
+	for name, msg := range msgIndex {
+		for _, field := range msg.Fields {
+			if goEncodeCheckFunc(field) != "" {
+				fallible[name] = true
+			}
+			if strictRepeated && field.GoEncode && !field.GoIgnore && field.IsRepeated && !field.IsMap && field.Kind == ir.KindMessage && !goRepeatedValueSlice(field) {
+				fallible[name] = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, msg := range msgIndex {
+			if fallible[name] {
+				continue
+			}
+			for _, field := range msg.Fields {
+				if !field.GoEncode || field.GoIgnore {
+					continue
+				}
+				if fallible[field.MessageFullName] || field.IsMap && fallible[field.MapValueMessage] {
+					fallible[name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return fallible
+}
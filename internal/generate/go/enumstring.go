@@ -0,0 +1,53 @@
+package gogen
+
+import (
+	"fmt"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// goEnumString reports whether field is an enum held in Go as its value name
+// (cp.go_type = "string") rather than as the enum type. The wire form is
+// still the enum number.
+func goEnumString(field ir.Field) bool {
+	return field.Kind == ir.KindEnum && field.GoType == "string"
+}
+
+// goEncodeEnumString returns the encode lines for a cp.go_type "string" enum
+// field, which writes the number enumType_value gives each name.
+func goEncodeEnumString(fieldName string, field ir.Field, enumType string) ([]string, error) {
+	number := func(expr string) string {
+		return fmt.Sprintf("EnumNumber(%s, %s_value)", expr, enumType)
+	}
+	switch {
+	case field.IsRepeated && field.IsPacked:
+		return goEncodePackedLines(fieldName, field.Number, "AppendInt32Compact", number("item")), nil
+	case field.IsRepeated:
+		return goEncodeElementLines(fieldName, number("item"), field)
+	case field.IsOptional:
+		return goEncodeSetLines(fieldName, number("*"+fieldName), field)
+	}
+	return []string{fmt.Sprintf("b = AppendInt32Field(b, %s, %d)", number(fieldName), field.Number)}, nil
+}
+
+// goDecodeEnumString returns the decode lines for a cp.go_type "string" enum
+// field, which stores the name enumType_name gives each number.
+func goDecodeEnumString(fieldName string, field ir.Field, enumType string) []string {
+	return goDecodeEnumValue(fieldName, field, fmt.Sprintf("EnumName(raw, %s_name)", enumType))
+}
+
+// goStringEnums returns the enums that cp.go_type "string" fields of files
+// refer to, which get a name to number map.
+func goStringEnums(files []ir.File) map[string]bool {
+	out := make(map[string]bool)
+	for _, file := range files {
+		for _, msg := range file.Messages {
+			for _, field := range goVisibleFields(msg.Fields) {
+				if goEnumString(field) {
+					out[field.EnumFullName] = true
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,21 @@
+package gogen
+
+import "github.com/jptrs93/cleanproto/internal/ir"
+
+// goFieldName pairs a field number with its proto field name, for the
+// <Msg>_fieldName map behind FieldName.
+type goFieldName struct {
+	Number int
+	Name   string
+}
+
+// buildGoFieldNames returns the number and proto name of each of msg's
+// fields, cp.go_ignore ones included, as they still have a number on the
+// wire.
+func buildGoFieldNames(msg ir.Message) []goFieldName {
+	out := make([]goFieldName, 0, len(msg.Fields))
+	for _, field := range msg.Fields {
+		out = append(out, goFieldName{Number: field.Number, Name: fieldProtoName(field)})
+	}
+	return out
+}
@@ -3,8 +3,14 @@ package gogen
 import (
 	"bytes"
 	"fmt"
+	"go/ast"
+	"go/format"
+	goparser "go/parser"
 	"go/token"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -26,10 +32,42 @@ func (g Generator) Generate(files []ir.File, options generate.Options) ([]genera
 	if err != nil {
 		return nil, err
 	}
+	files, err = applyGoPackageMap(files, options.GoPackageMap)
+	if err != nil {
+		return nil, err
+	}
+	if options.GoSplitModel && options.GoSingleFile {
+		return nil, fmt.Errorf("-go.splitmodel is not supported with -go.singlefile")
+	}
+	if options.GoMinimal {
+		options, err = goMinimalOptions(files, options)
+		if err != nil {
+			return nil, err
+		}
+	}
 	msgIndex := indexMessages(files)
 	enumIndex := indexEnums(files)
 	validateNeeds := computeValidateNeeds(msgIndex)
+	fallibleEncodes := computeGoFallibleEncodes(msgIndex, options.GoStrictRepeated)
 	keepMsgs, keepEnums := computeGoKeepTypes(files, msgIndex, enumIndex, options)
+	jsonMsgs, jsonEnums := computeGoJSONNeeds(msgIndex, options.GoJSONTags == "protojson")
+	stringEnums := goStringEnums(files)
+	if err := checkGoLazyFields(files, jsonMsgs, validateNeeds, options); err != nil {
+		return nil, err
+	}
+	if options.GoOut != "" {
+		if err := checkGoTypeNameCollisions(files, keepMsgs, keepEnums); err != nil {
+			return nil, err
+		}
+		if err := checkGoPackageNames(files, keepMsgs, keepEnums, options, len(jsonMsgs) > 0 || options.GoJSONTags == "protojson"); err != nil {
+			return nil, err
+		}
+	}
+	// -go.minimal leaves out validate.gen.go and the Validate methods in it.
+	validated := validateNeeds
+	if options.GoMinimal {
+		validated = nil
+	}
 	var outputs []generate.OutputFile
 	var utilPkg string
 	var utilDir string
@@ -52,15 +90,172 @@ func (g Generator) Generate(files []ir.File, options generate.Options) ([]genera
 		if err != nil {
 			return nil, err
 		}
+		data.BinaryMarshaler = options.GoBinaryMarshaler
+		if options.GoWriterTo {
+			for i := range data.Messages {
+				msg := &data.Messages[i]
+				msg.WriteToLines, err = buildGoWriteToLines(msgIndex[msg.FullName], msgIndex, enumIndex)
+				if err != nil {
+					return nil, err
+				}
+			}
+			data.WriterTo = true
+			if len(data.Messages) > 0 {
+				data.Imports = append(data.Imports, "io")
+			}
+		}
+		data.ProtoJSON = options.GoJSONTags == "protojson"
+		for i := range data.Enums {
+			data.Enums[i].JSON = data.ProtoJSON || jsonEnums[data.Enums[i].FullName]
+			data.Enums[i].ValueMap = stringEnums[data.Enums[i].FullName] || options.GoTextFormat || data.Enums[i].JSON
+		}
+		for i := range data.Messages {
+			msg := &data.Messages[i]
+			msg.MarshalJSON = goMarshalsJSON(msgIndex[msg.FullName], data.ProtoJSON)
+			msg.AppendJSON = jsonMsgs[msg.FullName]
+			if !msg.AppendJSON {
+				continue
+			}
+			msg.JSONLines, err = buildGoJSONLines(msgIndex[msg.FullName])
+			if err != nil {
+				return nil, err
+			}
+			msg.JSONParseLines, err = buildGoJSONParseLines(msg.Fields, goVisibleFields(msgIndex[msg.FullName].Fields), enumIndex)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if options.GoSetters {
+			data.Setters = true
+		}
+		data.NonNilSlices = options.GoNonNilSlices
+		data.EmptyBytes = options.GoEmptyBytes
+		data.StrictRepeated = options.GoStrictRepeated
+		data.EnumStringer = options.GoEnumStringer
+		if data.EnumStringer && len(data.Enums) > 0 {
+			data.Imports = append(data.Imports, "strconv")
+		}
+		data.EnumValues = options.GoEnumValues
+		if options.GoStringer {
+			data.Stringer = true
+			if len(data.Messages) > 0 {
+				data.Imports = append(data.Imports, "strings")
+			}
+			// Messages without fields write only their name.
+			if slices.ContainsFunc(data.Messages, func(msg goMessage) bool { return len(msg.StringLines) > 0 }) {
+				data.Imports = append(data.Imports, "fmt")
+			}
+		}
+		if options.GoToMap {
+			data.ToMap = true
+		}
+		if options.GoApplyMask {
+			data.ApplyMask = true
+		}
+		if options.GoPopulatedFields {
+			for i := range data.Messages {
+				msg := &data.Messages[i]
+				msg.PopulatedLines, err = buildGoPopulatedLines(msgIndex[msg.FullName], msgIndex, enumIndex)
+				if err != nil {
+					return nil, err
+				}
+			}
+			data.PopulatedFields = true
+		}
+		if options.GoUnknownEnums {
+			for i := range data.Messages {
+				msg := &data.Messages[i]
+				msg.UnknownEnumLines, err = buildGoUnknownEnumLines(msgIndex[msg.FullName], enumIndex)
+				if err != nil {
+					return nil, err
+				}
+			}
+			data.UnknownEnums = true
+		}
+		if options.GoSortedRange {
+			data.SortedRange = true
+		}
+		if options.GoFieldNames {
+			data.FieldNames = true
+		}
+		if options.GoVisitor {
+			data.Visitor = true
+		}
+		if options.GoDiff {
+			for i := range data.Messages {
+				msg := &data.Messages[i]
+				msg.DiffLines = buildGoDiffLines(msg.Fields, goVisibleFields(msgIndex[msg.FullName].Fields))
+			}
+			data.Diff = true
+		}
+		if options.GoTextFormat {
+			for i := range data.Messages {
+				msg := &data.Messages[i]
+				msg.TextLines, err = buildGoTextLines(msgIndex[msg.FullName])
+				if err != nil {
+					return nil, err
+				}
+				msg.TextParseLines, err = buildGoTextParseLines(msg.Fields, goVisibleFields(msgIndex[msg.FullName].Fields), enumIndex)
+				if err != nil {
+					return nil, err
+				}
+			}
+			data.TextFormat = true
+		}
+		if options.GoReadDelimited {
+			data.ReadDelimited = true
+			if len(data.Messages) > 0 {
+				data.Imports = append(data.Imports, "context", "io")
+			}
+		}
+		data.Decoder = options.GoDecoder
+		if options.GoDecodeFields {
+			data.DecodeFields = true
+			if len(data.Messages) > 0 {
+				data.Imports = append(data.Imports, "slices")
+			}
+		}
+		for i := range data.Messages {
+			data.Messages[i].EncodeErr = fallibleEncodes[data.Messages[i].FullName]
+		}
+		if options.GoArena {
+			useGoArenaAlloc(&data)
+			data.Arena = true
+		}
+		if options.GoCanonical {
+			for i := range data.Messages {
+				msg := &data.Messages[i]
+				msg.CanonicalLines, err = buildGoCanonicalLines(msgIndex[msg.FullName], msgIndex, enumIndex)
+				if err != nil {
+					return nil, err
+				}
+			}
+			data.Canonical = true
+		}
+		if options.GoHash {
+			useGoSortedMaps(&data)
+			data.Hash = true
+		}
+		if err := checkGoMessageNames(data, validated, computeAuditMessages(file, msgIndex)); err != nil {
+			return nil, err
+		}
 		var buf bytes.Buffer
 		if err := tmpl.Execute(&buf, data); err != nil {
 			return nil, err
 		}
 		outPath := filepath.Join(goOut, "model.gen.go")
-		outputs = append(outputs, generate.OutputFile{
-			Path:    outPath,
-			Content: buf.Bytes(),
-		})
+		if options.GoSplitModel {
+			split, err := splitGoModel(outPath, buf.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, split...)
+		} else {
+			outputs = append(outputs, generate.OutputFile{
+				Path:    outPath,
+				Content: buf.Bytes(),
+			})
+		}
 		auditContent, err := buildGoAuditFile(file, msgIndex, enumIndex, pkg, options.GoJSONTags, keepMsgs)
 		if err != nil {
 			return nil, err
@@ -75,12 +270,24 @@ func (g Generator) Generate(files []ir.File, options generate.Options) ([]genera
 		if err != nil {
 			return nil, err
 		}
-		if len(validateContent) > 0 {
+		if len(validateContent) > 0 && !options.GoMinimal {
 			outputs = append(outputs, generate.OutputFile{
 				Path:    filepath.Join(goOut, "validate.gen.go"),
 				Content: validateContent,
 			})
 		}
+		if options.GoEmitSamples {
+			sampleContent, err := buildGoSampleFile(file, msgIndex, enumIndex, pkg, keepMsgs)
+			if err != nil {
+				return nil, err
+			}
+			if len(sampleContent) > 0 {
+				outputs = append(outputs, generate.OutputFile{
+					Path:    filepath.Join(goOut, "model_sample_test.go"),
+					Content: sampleContent,
+				})
+			}
+		}
 		if len(file.Services) > 0 && options.GoServer {
 			needMuxUtil = true
 			if muxUtilDir == "" {
@@ -119,6 +326,12 @@ func (g Generator) Generate(files []ir.File, options generate.Options) ([]genera
 	if err != nil {
 		return nil, err
 	}
+	if options.GoMinimal {
+		utilContent, err = stripGoUtilUUID(utilContent)
+		if err != nil {
+			return nil, err
+		}
+	}
 	outputs = append(outputs, generate.OutputFile{
 		Path:    filepath.Join(utilDir, "util.gen.go"),
 		Content: utilContent,
@@ -130,9 +343,215 @@ func (g Generator) Generate(files []ir.File, options generate.Options) ([]genera
 			Content: muxUtilContent,
 		})
 	}
+	if options.GoArena {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "arena.gen.go"),
+			Content: []byte(strings.ReplaceAll(arenaUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoApplyMask {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "mask.gen.go"),
+			Content: []byte(strings.ReplaceAll(maskUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoUnknownEnums {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "unknownenums.gen.go"),
+			Content: []byte(strings.ReplaceAll(unknownEnumsUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoSortedRange {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "sortedrange.gen.go"),
+			Content: []byte(strings.ReplaceAll(sortedRangeUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoDecoder {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "decoder.gen.go"),
+			Content: []byte(strings.ReplaceAll(decoderUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoVisitor {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "visitor.gen.go"),
+			Content: []byte(strings.ReplaceAll(visitorUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoCanonical {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "canonical.gen.go"),
+			Content: []byte(strings.ReplaceAll(canonicalUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoDiff {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "diff.gen.go"),
+			Content: []byte(strings.ReplaceAll(diffUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoTextFormat {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "textformat.gen.go"),
+			Content: []byte(strings.ReplaceAll(textFormatUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoUnpackAny && utilDir != "" {
+		if content, ok := buildGoAnyRegistry(files, keepMsgs, utilPkg); ok {
+			outputs = append(outputs, generate.OutputFile{
+				Path:    filepath.Join(utilDir, "anyregistry.gen.go"),
+				Content: []byte(content),
+			})
+		}
+	}
+	if options.GoReadDelimited {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "delimited.gen.go"),
+			Content: []byte(strings.ReplaceAll(delimitedUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if len(jsonMsgs) > 0 || options.GoJSONTags == "protojson" {
+		outputs = append(outputs, generate.OutputFile{
+			Path:    filepath.Join(utilDir, "json_util.gen.go"),
+			Content: []byte(strings.ReplaceAll(jsonUtilSource, "__PACKAGE__", utilPkg)),
+		})
+	}
+	if options.GoUtilPrefix != "" {
+		if err := applyGoUtilPrefix(outputs, options.GoUtilPrefix); err != nil {
+			return nil, err
+		}
+	}
+	if options.GoSingleFile {
+		outputs, err = mergeGoOutputs(outputs, filepath.Join(utilDir, "model.gen.go"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.GoNolint != "" {
+		addGoNolintDirective(outputs, options.GoNolint)
+	}
 	return outputs, nil
 }
 
+// applyGoPackageMap returns files with the Go package of each file named in
+// packageMap replaced. files itself is left alone, since the other generators
+// share it.
+func applyGoPackageMap(files []ir.File, packageMap map[string]string) ([]ir.File, error) {
+	if len(packageMap) == 0 {
+		return files, nil
+	}
+	out := append([]ir.File(nil), files...)
+	used := make(map[string]bool, len(packageMap))
+	for i := range out {
+		if pkg, ok := packageMap[out[i].Path]; ok {
+			out[i].GoPackage = pkg
+			used[out[i].Path] = true
+		}
+	}
+	var unknown []string
+	for path := range packageMap {
+		if !used[path] {
+			unknown = append(unknown, path)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("go package map names files that were not parsed: %s", strings.Join(unknown, ", "))
+	}
+	return out, nil
+}
+
+// addGoNolintDirective inserts a file-level //nolint:<linters> directive
+// above the package clause of every generated Go file, so strict linters in
+// CI skip generated code.
+func addGoNolintDirective(outputs []generate.OutputFile, linters string) {
+	directive := "//nolint:" + linters + "\n"
+	for i, out := range outputs {
+		if !strings.HasSuffix(out.Path, ".go") {
+			continue
+		}
+		content := string(out.Content)
+		idx := strings.Index(content, "\npackage ")
+		if idx < 0 {
+			continue
+		}
+		outputs[i].Content = []byte(content[:idx+1] + directive + content[idx+1:])
+	}
+}
+
+// mergeGoOutputs folds every generated .gen.go output into a single file at
+// path: one package clause, one deduplicated import block, then each file's
+// declarations in output order. Other outputs (e.g. test helpers) pass through.
+func mergeGoOutputs(outputs []generate.OutputFile, path string) ([]generate.OutputFile, error) {
+	var merged []generate.OutputFile
+	var rest []generate.OutputFile
+	for _, out := range outputs {
+		if strings.HasSuffix(out.Path, ".gen.go") {
+			merged = append(merged, out)
+		} else {
+			rest = append(rest, out)
+		}
+	}
+	if len(merged) == 0 {
+		return outputs, nil
+	}
+	var pkg string
+	var imports []string
+	seenImports := map[string]bool{}
+	var bodies strings.Builder
+	fset := token.NewFileSet()
+	for _, out := range merged {
+		f, err := goparser.ParseFile(fset, out.Path, out.Content, goparser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("merge %s: %w", out.Path, err)
+		}
+		pkg = f.Name.Name
+		for _, imp := range f.Imports {
+			spec := imp.Path.Value
+			if imp.Name != nil {
+				spec = imp.Name.Name + " " + spec
+			}
+			if !seenImports[spec] {
+				seenImports[spec] = true
+				imports = append(imports, spec)
+			}
+		}
+		// Declarations start after the last import declaration, or right
+		// after the package clause when the file has none.
+		start := f.Name.End()
+		for _, decl := range f.Decls {
+			if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+				start = gen.End()
+			}
+		}
+		bodies.WriteString("\n")
+		bodies.Write(bytes.TrimSpace(out.Content[fset.Position(start).Offset:]))
+		bodies.WriteString("\n")
+	}
+	sort.Strings(imports)
+	var b strings.Builder
+	b.WriteString("// Code generated by cleanproto. DO NOT EDIT.\n\n")
+	b.WriteString("package ")
+	b.WriteString(pkg)
+	b.WriteString("\n\n")
+	if len(imports) > 0 {
+		b.WriteString("import (\n")
+		for _, imp := range imports {
+			b.WriteString("\t")
+			b.WriteString(imp)
+			b.WriteString("\n")
+		}
+		b.WriteString(")\n")
+	}
+	b.WriteString(bodies.String())
+	content, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("merge %s: %w", path, err)
+	}
+	return append([]generate.OutputFile{{Path: path, Content: content}}, rest...), nil
+}
+
 func buildGoClientFile(file ir.File, msgIndex map[string]ir.Message, pkg string, serviceFilter string) (string, error) {
 	type clientMethod struct {
 		Name            string
@@ -144,6 +563,7 @@ func buildGoClientFile(file ir.File, msgIndex map[string]ir.Message, pkg string,
 		OutputEmpty     bool
 		ClientStreaming bool
 		ServerStreaming bool
+		OutputAliased   bool
 	}
 	type clientService struct {
 		Name    string
@@ -155,6 +575,7 @@ func buildGoClientFile(file ir.File, msgIndex map[string]ir.Message, pkg string,
 	needsIter := false
 	needsBytes := false
 	needsClientStream := false
+	aliased := computeGoAliasedDecodes(msgIndex)
 	for _, svc := range file.Services {
 		if serviceFilter != "" && svc.Name != serviceFilter {
 			continue
@@ -208,6 +629,7 @@ func buildGoClientFile(file ir.File, msgIndex map[string]ir.Message, pkg string,
 				OutputEmpty:     outType == "Empty",
 				ClientStreaming: m.IsStreamingClient,
 				ServerStreaming: m.IsStreamingServer,
+				OutputAliased:   aliased[m.OutputFullName],
 			})
 		}
 		if len(cs.Methods) > 0 {
@@ -274,7 +696,7 @@ func buildGoClientFile(file ir.File, msgIndex map[string]ir.Message, pkg string,
 	for _, svc := range services {
 		writeGoClientService(&b, svc.Name)
 		for _, method := range svc.Methods {
-			writeGoClientMethod(&b, svc.Name, method.Name, method.HTTPMethod, method.Path, method.Input, method.Output, method.InputEmpty, method.OutputEmpty, method.ClientStreaming, method.ServerStreaming)
+			writeGoClientMethod(&b, svc.Name, method.Name, method.HTTPMethod, method.Path, method.Input, method.Output, method.InputEmpty, method.OutputEmpty, method.ClientStreaming, method.ServerStreaming, method.OutputAliased)
 		}
 	}
 	return b.String(), nil
@@ -385,9 +807,9 @@ func writeGoClientService(b *strings.Builder, name string) {
 	b.WriteString("}\n\n")
 }
 
-func writeGoClientMethod(b *strings.Builder, receiver string, name string, httpMethod string, path string, input string, output string, inputEmpty bool, outputEmpty bool, clientStreaming bool, serverStreaming bool) {
+func writeGoClientMethod(b *strings.Builder, receiver string, name string, httpMethod string, path string, input string, output string, inputEmpty bool, outputEmpty bool, clientStreaming bool, serverStreaming bool, outputAliased bool) {
 	if serverStreaming {
-		writeGoClientServerStreamingMethod(b, receiver, name, httpMethod, path, input, output, inputEmpty, clientStreaming)
+		writeGoClientServerStreamingMethod(b, receiver, name, httpMethod, path, input, output, inputEmpty, clientStreaming, outputAliased)
 		return
 	}
 	if clientStreaming {
@@ -454,7 +876,7 @@ func writeGoClientClientStreamingMethod(b *strings.Builder, receiver string, nam
 	writeGoClientUnaryResponse(b, output, outputEmpty)
 }
 
-func writeGoClientServerStreamingMethod(b *strings.Builder, receiver string, name string, httpMethod string, path string, input string, output string, inputEmpty bool, clientStreaming bool) {
+func writeGoClientServerStreamingMethod(b *strings.Builder, receiver string, name string, httpMethod string, path string, input string, output string, inputEmpty bool, clientStreaming bool, outputAliased bool) {
 	b.WriteString("func (c *")
 	b.WriteString(receiver)
 	b.WriteString(") ")
@@ -515,7 +937,7 @@ func writeGoClientServerStreamingMethod(b *strings.Builder, receiver string, nam
 	b.WriteString("\t\t\t}\n")
 	b.WriteString("\t\t\titem, err := Decode")
 	b.WriteString(output)
-	b.WriteString("(payload)\n")
+	b.WriteString("(" + goStreamPayload(outputAliased) + ")\n")
 	b.WriteString("\t\t\tif err != nil {\n")
 	b.WriteString("\t\t\t\tyield(nil, err)\n")
 	b.WriteString("\t\t\t\treturn\n")
@@ -613,6 +1035,7 @@ func buildGoMuxFile(file ir.File, msgIndex map[string]ir.Message, validateNeeds
 		PolicyType       int32
 		Compression      int32
 		InputValidatable bool
+		InputAliased     bool
 	}
 	type muxService struct {
 		HandlerName string
@@ -622,7 +1045,9 @@ func buildGoMuxFile(file ir.File, msgIndex map[string]ir.Message, validateNeeds
 	methods := make([]muxMethod, 0)
 	services := make([]muxService, 0, len(file.Services))
 	hasStream := false
+	hasServerStream := false
 	auditNeeds := computeAuditMessages(file, msgIndex)
+	aliased := computeGoAliasedDecodes(msgIndex)
 	for _, svc := range file.Services {
 		svcMethods := make([]muxMethod, 0, len(svc.Methods))
 		for _, m := range svc.Methods {
@@ -690,9 +1115,13 @@ func buildGoMuxFile(file ir.File, msgIndex map[string]ir.Message, validateNeeds
 				PolicyType:       m.PolicyType,
 				Compression:      m.CompressionMode,
 				InputValidatable: validateNeeds[m.InputFullName],
+				InputAliased:     aliased[m.InputFullName],
 			}
 			methods = append(methods, method)
 			svcMethods = append(svcMethods, method)
+			if m.IsStreamingServer {
+				hasServerStream = true
+			}
 			if m.IsStreamingServer || m.IsStreamingClient {
 				hasStream = true
 			}
@@ -735,8 +1164,11 @@ func buildGoMuxFile(file ir.File, msgIndex map[string]ir.Message, validateNeeds
 	b.WriteString("\n\n")
 	b.WriteString("import (\n")
 	b.WriteString("\t\"context\"\n")
-	if hasStream {
+	// Only server-streaming handlers wrap errors; client streams just need iter.
+	if hasServerStream {
 		b.WriteString("\t\"fmt\"\n")
+	}
+	if hasStream {
 		b.WriteString("\t\"iter\"\n")
 	}
 	b.WriteString("\t\"net/http\"\n")
@@ -859,7 +1291,7 @@ func buildGoMuxFile(file ir.File, msgIndex map[string]ir.Message, validateNeeds
 			b.WriteString("\t\t\t\t\t}\n")
 			b.WriteString("\t\t\t\t\treq, err := Decode")
 			b.WriteString(method.Input)
-			b.WriteString("(payload)\n")
+			b.WriteString("(" + goStreamPayload(method.InputAliased) + ")\n")
 			b.WriteString("\t\t\t\t\tif err != nil {\n")
 			b.WriteString("\t\t\t\t\t\tyield(nil, err)\n")
 			b.WriteString("\t\t\t\t\t\treturn\n")
@@ -992,7 +1424,7 @@ func buildGoMuxFile(file ir.File, msgIndex map[string]ir.Message, validateNeeds
 			b.WriteString("\t\t\t\t\t}\n")
 			b.WriteString("\t\t\t\t\treq, err := Decode")
 			b.WriteString(method.Input)
-			b.WriteString("(payload)\n")
+			b.WriteString("(" + goStreamPayload(method.InputAliased) + ")\n")
 			b.WriteString("\t\t\t\t\tif err != nil {\n")
 			b.WriteString("\t\t\t\t\t\tyield(nil, err)\n")
 			b.WriteString("\t\t\t\t\t\treturn\n")
@@ -1437,38 +1869,134 @@ func normalizeGoMethodName(name string) string {
 }
 
 type goFileData struct {
-	Package  string
-	Imports  []string
-	Enums    []goEnum
-	Messages []goMessage
+	Package         string
+	Imports         []string
+	Enums           []goEnum
+	Messages        []goMessage
+	BinaryMarshaler bool
+	WriterTo        bool
+	ProtoJSON       bool
+	Setters         bool
+	NonNilSlices    bool
+	EmptyBytes      bool
+	Hash            bool
+	Arena           bool
+	StrictRepeated  bool
+	EnumStringer    bool
+	EnumValues      bool
+	Stringer        bool
+	ToMap           bool
+	ApplyMask       bool
+	PopulatedFields bool
+	UnknownEnums    bool
+	SortedRange     bool
+	FieldNames      bool
+	Visitor         bool
+	Diff            bool
+	Canonical       bool
+	TextFormat      bool
+	ReadDelimited   bool
+	DecodeFields    bool
+	Decoder         bool
 }
 
 type goEnum struct {
-	Name   string
-	Values []goEnumValue
+	Name     string
+	FullName string
+	Values   []goEnumValue
+	// Canonical holds one value per number, the first declared, so aliased
+	// values (allow_alias) share a single String() name.
+	Canonical []goEnumValue
+	// JSON is set when the enum gets its JSON methods, for -go.jsontags
+	// protojson or a cp.go_json message using it.
+	JSON bool
+	// ValueMap is set when a cp.go_type "string" field holds the enum, whose
+	// encoding looks its names up in <Enum>_value, and for -go.textformat
+	// and JSON, whose parsing does.
+	ValueMap bool
 }
 
 type goEnumValue struct {
-	Name   string
-	Number int32
+	Name      string
+	ProtoName string
+	Number    int32
 }
 
 type goMessage struct {
 	Name          string
+	FullName      string
 	Fields        []goField
+	Immutable     bool
+	Lazy          []goLazyField
 	HasIsZero     bool
 	IsZeroExpr    string
 	EncodeLines   []string
+	SizeLines     []string
 	DecodeCases   []goDecodeCase
 	NeedsMsgBytes bool
-	NeedsTmpBytes bool
+	// MarshalJSON is set for messages that get a MarshalJSON method, and
+	// AppendJSON for those whose appendJSON other MarshalJSON methods reach.
+	// JSONParseLines are the body of parseJSON, which every message with
+	// appendJSON gets and UnmarshalJSON calls.
+	MarshalJSON    bool
+	AppendJSON     bool
+	JSONLines      []string
+	JSONParseLines []string
+	Setters        []goSetter
+	// NonNilLines replace nil repeated and map fields with empty ones after
+	// decoding, for -go.nonnilslices.
+	NonNilLines []string
+	// EmptyBytesLines replace nil singular bytes fields without presence
+	// with the shared emptyBytes after decoding, for -go.emptybytes.
+	EmptyBytesLines []string
+	// NilElementLines panic on nil elements of repeated message fields
+	// before encoding, for -go.strictrepeated.
+	NilElementLines []string
+	// CheckLines panic on field values Encode cannot represent, such as a
+	// time.Time beyond the range of int32 seconds.
+	CheckLines []string
+	// EncodeErr is set for messages whose Encode can fail, directly or
+	// through a nested message; they get an EncodeErr method.
+	EncodeErr bool
+	// StringLines write the fields for String, for -go.stringer.
+	StringLines []string
+	// ToMapLines fill the map returned by ToMap, for -go.tomap.
+	ToMapLines []string
+	// MaskCheckLines and MaskApplyLines are the bodies of the FieldMask
+	// helpers behind ApplyMask, for -go.applymask.
+	MaskCheckLines []string
+	MaskApplyLines []string
+	// PopulatedLines collect the numbers of the fields Encode would write,
+	// for -go.populatedfields.
+	PopulatedLines []string
+	// WriteToLines are the body of WriteTo, for -go.writerto.
+	WriteToLines []string
+	// UnknownEnumLines append the UnknownEnums of the message's enum fields
+	// to out, for -go.unknownenums.
+	UnknownEnumLines []string
+	// SortedRanges are the Range<Field>Sorted methods of the message's map
+	// fields, for -go.sortedrange.
+	SortedRanges []goSortedRange
+	// FieldNames back the FieldName method, for -go.fieldnames.
+	FieldNames []goFieldName
+	// VisitLines are the body of VisitFields, for -go.visitor.
+	VisitLines []string
+	// DiffLines are the body of append<Msg>Diff, for -go.diff.
+	DiffLines []string
+	// CanonicalLines are the body of CanonicalEncode, for -go.canonical.
+	CanonicalLines []string
+	// TextLines and TextParseLines are the bodies of appendText and
+	// parseText, for -go.textformat.
+	TextLines      []string
+	TextParseLines []string
 }
 
 type goField struct {
-	Name       string
-	Type       string
-	JSONTag    string
-	HasJSONTag bool
+	Name   string
+	Getter string
+	Type   string
+	// Tag is the struct tag content: the json tag, then any cp.go_tag.
+	Tag string
 }
 
 type goDecodeCase struct {
@@ -1482,12 +2010,19 @@ func buildGoFileData(file ir.File, msgIndex map[string]ir.Message, enumIndex map
 		if keepEnums != nil && !keepEnums[enum.FullName] {
 			continue
 		}
-		goEnum := goEnum{Name: enum.Name}
+		goEnum := goEnum{Name: enum.Name, FullName: enum.FullName}
+		seen := map[int32]bool{}
 		for _, value := range enum.Values {
-			goEnum.Values = append(goEnum.Values, goEnumValue{
-				Name:   enum.Name + "_" + value.Name,
-				Number: value.Number,
-			})
+			v := goEnumValue{
+				Name:      enum.Name + "_" + value.Name,
+				ProtoName: value.Name,
+				Number:    value.Number,
+			}
+			goEnum.Values = append(goEnum.Values, v)
+			if !seen[value.Number] {
+				seen[value.Number] = true
+				goEnum.Canonical = append(goEnum.Canonical, v)
+			}
 		}
 		data.Enums = append(data.Enums, goEnum)
 	}
@@ -1536,7 +2071,7 @@ func normalizeLocalProtowireSymbols(data *goFileData) {
 }
 
 func buildGoMessage(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, goJSONTags string, needsIsZero bool) (goMessage, bool, bool, error) {
-	out := goMessage{Name: msg.Name, HasIsZero: needsIsZero}
+	out := goMessage{Name: msg.Name, FullName: msg.FullName, HasIsZero: needsIsZero, Immutable: msg.GoImmutable}
 	var usesTime bool
 	var usesUUID bool
 	visibleFields := goVisibleFields(msg.Fields)
@@ -1551,25 +2086,59 @@ func buildGoMessage(msg ir.Message, msgIndex map[string]ir.Message, enumIndex ma
 		if field.GoType == "github.com/google/uuid.UUID" {
 			usesUUID = true
 		}
-		jsonTag := ""
-		if field.JSONIgnore {
-			jsonTag = "-"
-		} else if goJSONTags == "snake" {
-			jsonTag = toSnakeCase(field.Name)
-			if goJSONTagOmitEmpty(field) {
-				jsonTag += ",omitempty"
-			}
+		jsonTag := goJSONTag(field, goJSONTags)
+		getter := ""
+		if msg.GoImmutable {
+			// encoding/json ignores unexported fields, so tags would only
+			// trip go vet.
+			getter = ir.GoName(field.Name)
+			jsonTag = ""
+		}
+		tag, err := goStructTag(field, jsonTag)
+		if err != nil {
+			return goMessage{}, false, false, fmt.Errorf("%w: %s.%s", err, msg.FullName, fieldProtoName(field))
 		}
 		out.Fields = append(out.Fields, goField{
-			Name:       ir.GoName(field.Name),
-			Type:       goType,
-			JSONTag:    jsonTag,
-			HasJSONTag: jsonTag != "",
+			Name:   goStructFieldName(msg, field),
+			Getter: getter,
+			Type:   goType,
+			Tag:    tag,
 		})
 	}
+	out.Lazy = buildGoLazyFields(msg, msgIndex)
 	if needsIsZero {
 		out.IsZeroExpr = buildGoIsZeroExpr(msg)
 	}
+	if !msg.GoImmutable {
+		out.Setters = buildGoSetters(out.Fields, visibleFields)
+	}
+	out.StringLines = buildGoStringLines(out.Fields, visibleFields)
+	out.ToMapLines = buildGoToMapLines(out.Fields, visibleFields)
+	out.SortedRanges = buildGoSortedRanges(out.Fields, visibleFields)
+	out.FieldNames = buildGoFieldNames(msg)
+	out.VisitLines = buildGoVisitLines(out.Fields, visibleFields)
+	if !msg.GoImmutable {
+		out.MaskCheckLines, out.MaskApplyLines = buildGoMaskLines(msg, out.Fields, visibleFields, msgIndex)
+	}
+	for i, field := range visibleFields {
+		if field.IsRepeated || field.IsMap {
+			name := out.Fields[i].Name
+			out.NonNilLines = append(out.NonNilLines, fmt.Sprintf("if m.%s == nil {", name), fmt.Sprintf("m.%s = %s{}", name, out.Fields[i].Type), "}")
+		}
+		if goEmptyBytesField(field) && out.Fields[i].Type == "[]byte" {
+			name := out.Fields[i].Name
+			out.EmptyBytesLines = append(out.EmptyBytesLines, fmt.Sprintf("if m.%s == nil {", name), fmt.Sprintf("m.%s = emptyBytes", name), "}")
+		}
+		if field.GoEncode && field.IsRepeated && !field.IsMap && field.Kind == ir.KindMessage && !goRepeatedValueSlice(field) {
+			out.NilElementLines = append(out.NilElementLines,
+				fmt.Sprintf("for _, item := range m.%s {", out.Fields[i].Name),
+				"if item == nil {",
+				fmt.Sprintf("panicEncodeField(%q, %d, ErrNilElement)", msg.Name, field.Number),
+				"}",
+				"}")
+		}
+		out.CheckLines = append(out.CheckLines, goEncodeCheckLines(msg, field, "m."+out.Fields[i].Name, enumIndex)...)
+	}
 
 	encodeLines, err := buildGoEncodeLines(msg, msgIndex, enumIndex)
 	if err != nil {
@@ -1577,17 +2146,61 @@ func buildGoMessage(msg ir.Message, msgIndex map[string]ir.Message, enumIndex ma
 	}
 	out.EncodeLines = encodeLines
 
-	decodeCases, needsMsgBytes, needsTmpBytes, err := buildGoDecodeCases(msg, msgIndex, enumIndex)
+	sizeLines, err := buildGoSizeBoundLines(msg)
+	if err != nil {
+		return goMessage{}, false, false, err
+	}
+	out.SizeLines = sizeLines
+
+	decodeCases, needsMsgBytes, err := buildGoDecodeCases(msg, msgIndex, enumIndex)
 	if err != nil {
 		return goMessage{}, false, false, err
 	}
 	out.DecodeCases = decodeCases
 	out.NeedsMsgBytes = needsMsgBytes
-	out.NeedsTmpBytes = needsTmpBytes
 
 	return out, usesUUID, usesTime, nil
 }
 
+// goStructFieldName returns the struct field name generated for field. Fields
+// of cp.go_immutable messages are unexported so that only the generated
+// getters and constructor can reach them.
+func goStructFieldName(msg ir.Message, field ir.Field) string {
+	name := ir.GoName(field.Name)
+	if !msg.GoImmutable {
+		return name
+	}
+	if name == strings.ToUpper(name) {
+		name = strings.ToLower(name)
+	} else {
+		name = lowerFirst(name)
+	}
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	return name
+}
+
+// goSortByLess returns the less func literal ordering a cp.sort_by field's
+// elements by the named key. Nil elements, which Encode skips, sort first.
+func goSortByLess(field ir.Field, msgIndex map[string]ir.Message) (string, error) {
+	elem, ok := msgIndex[field.MessageFullName]
+	if !ok {
+		return "", fmt.Errorf("unknown message type: %s", field.MessageFullName)
+	}
+	for _, key := range elem.Fields {
+		if key.ProtoName != field.SortBy || key.GoIgnore {
+			continue
+		}
+		keyName := goStructFieldName(elem, key)
+		if goRepeatedValueSlice(field) {
+			return fmt.Sprintf("func(a, b %s) bool { return a.%s < b.%s }", elem.Name, keyName, keyName), nil
+		}
+		return fmt.Sprintf("func(a, b *%s) bool { return a == nil && b != nil || a != nil && b != nil && a.%s < b.%s }", elem.Name, keyName, keyName), nil
+	}
+	return "", fmt.Errorf("cp.sort_by field %q not found in %s", field.SortBy, elem.FullName)
+}
+
 // goRepeatedValueSlice reports whether a repeated message field should be
 // generated as []T instead of the default []*T, based on cp.go_slice_ptr=false.
 func goRepeatedValueSlice(field ir.Field) bool {
@@ -1600,6 +2213,28 @@ func goRepeatedValueSlice(field ir.Field) bool {
 	return field.GoSlicePtr != nil && !*field.GoSlicePtr
 }
 
+// goEmptyBytesField reports whether field is a singular bytes field without
+// presence, which -go.emptybytes decodes as emptyBytes when absent.
+func goEmptyBytesField(field ir.Field) bool {
+	return field.Kind == ir.KindBytes && !field.IsRepeated && !field.IsMap && !field.IsOptional && !field.IsWrapper && field.GoType == ""
+}
+
+// goStructTag returns the struct tag content of field: its json tag, if
+// any, followed by its cp.go_tag, which may not set json as well.
+func goStructTag(field ir.Field, jsonTag string) (string, error) {
+	var parts []string
+	if jsonTag != "" {
+		if _, ok := reflect.StructTag(field.GoTag).Lookup("json"); ok {
+			return "", fmt.Errorf("cp.go_tag sets a json key, which -go.jsontags or cp.json_ignore already writes")
+		}
+		parts = append(parts, `json:"`+jsonTag+`"`)
+	}
+	if field.GoTag != "" {
+		parts = append(parts, field.GoTag)
+	}
+	return strings.Join(parts, " "), nil
+}
+
 func goVisibleFields(fields []ir.Field) []ir.Field {
 	visible := make([]ir.Field, 0, len(fields))
 	for _, field := range fields {
@@ -1673,7 +2308,10 @@ func goJSONTagOmitEmpty(field ir.Field) bool {
 func buildGoIsZeroExpr(msg ir.Message) string {
 	var conditions []string
 	for _, field := range goVisibleFields(msg.Fields) {
-		conditions = append(conditions, goIsZeroCondition("m."+ir.GoName(field.Name), field))
+		conditions = append(conditions, goIsZeroCondition("m."+goStructFieldName(msg, field), field))
+		if field.Lazy {
+			conditions = append(conditions, "m."+goLazyRawName(goStructFieldName(msg, field))+" == nil")
+		}
 	}
 	if len(conditions) == 0 {
 		return "true"
@@ -1688,6 +2326,9 @@ func goIsZeroCondition(fieldName string, field ir.Field) string {
 	if field.IsOptional {
 		return fieldName + " == nil"
 	}
+	if goEnumString(field) {
+		return fieldName + ` == ""`
+	}
 	if field.GoType != "" {
 		switch field.GoType {
 		case "time.Time":
@@ -1778,8 +2419,13 @@ func goFieldType(field ir.Field, msgIndex map[string]ir.Message, enumIndex map[s
 			}
 			return "[]*" + msg.Name, false, nil
 		}
+		elem := "[]"
+		if field.IsWrapper {
+			// A nil element is a wrapper without a value.
+			elem = "[]*"
+		}
 		if field.Kind == ir.KindBytes {
-			return "[][]byte", false, nil
+			return elem + "[]byte", false, nil
 		}
 		t, mathNeeded, err := goScalarType(field.Kind, false)
 		if err != nil {
@@ -1792,7 +2438,7 @@ func goFieldType(field ir.Field, msgIndex map[string]ir.Message, enumIndex map[s
 			}
 			t = enum.Name
 		}
-		return "[]" + t, mathNeeded, nil
+		return elem + t, mathNeeded, nil
 	}
 
 	if field.Kind == ir.KindMessage {
@@ -1927,8 +2573,18 @@ func buildGoEncodeLines(msg ir.Message, msgIndex map[string]ir.Message, enumInde
 		if field.GoIgnore || !field.GoEncode {
 			continue
 		}
-		fieldName := "m." + ir.GoName(field.Name)
+		fieldName := "m." + goStructFieldName(msg, field)
 		switch {
+		case goEnumString(field):
+			enumType, err := goEnumTypeName(field, enumIndex)
+			if err != nil {
+				return nil, err
+			}
+			enumLines, err := goEncodeEnumString(fieldName, field, enumType)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, enumLines...)
 		case field.GoType != "":
 			nativeLines, err := goEncodeNative(fieldName, field)
 			if err != nil {
@@ -1947,6 +2603,12 @@ func buildGoEncodeLines(msg ir.Message, msgIndex map[string]ir.Message, enumInde
 				return nil, err
 			}
 			lines = append(lines, durLines...)
+		case field.IsWrapper:
+			wrapperLines, err := goEncodeWrapper(fieldName, field)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, wrapperLines...)
 		case field.IsRepeated && field.Kind == ir.KindEnum:
 			enumLines := goEncodeRepeatedEnum(fieldName, field)
 			lines = append(lines, enumLines...)
@@ -1957,13 +2619,11 @@ func buildGoEncodeLines(msg ir.Message, msgIndex map[string]ir.Message, enumInde
 			}
 			lines = append(lines, mapLines...)
 		case field.IsRepeated && field.Kind == ir.KindMessage:
-			lines = append(lines, fmt.Sprintf("for _, item := range %s {", fieldName))
-			if !goRepeatedValueSlice(field) {
-				lines = append(lines, "if item == nil {", "continue", "}")
+			messageLines, err := goEncodeRepeatedMessage(fieldName, field, msgIndex, nil)
+			if err != nil {
+				return nil, err
 			}
-			lines = append(lines, fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.BytesType)", field.Number))
-			lines = append(lines, "b = protowire.AppendBytes(b, item.Encode())")
-			lines = append(lines, "}")
+			lines = append(lines, messageLines...)
 		case field.IsRepeated:
 			if field.IsPacked && isGoPackable(field.Kind) {
 				packedLines, err := goEncodePacked(fieldName, field)
@@ -1986,7 +2646,20 @@ func buildGoEncodeLines(msg ir.Message, msgIndex map[string]ir.Message, enumInde
 			}
 			lines = append(lines, fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.BytesType)", field.Number))
 			lines = append(lines, fmt.Sprintf("b = protowire.AppendBytes(b, %s.Encode())", fieldName))
+			if field.Lazy {
+				// Bytes still undecoded are written back as they were read.
+				raw := "m." + goLazyRawName(goStructFieldName(msg, field))
+				lines = append(lines, fmt.Sprintf("} else if %s != nil {", raw))
+				lines = append(lines, fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.BytesType)", field.Number))
+				lines = append(lines, fmt.Sprintf("b = protowire.AppendBytes(b, %s)", raw))
+			}
 			lines = append(lines, "}")
+		case field.AlwaysEmit:
+			encodeLines, err := goEncodeAlwaysField(fieldName, field)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, encodeLines...)
 		case field.IsOptional:
 			encodeLines, err := goEncodeOptionalField(fieldName, field)
 			if err != nil {
@@ -2004,6 +2677,27 @@ func buildGoEncodeLines(msg ir.Message, msgIndex map[string]ir.Message, enumInde
 	return lines, nil
 }
 
+// goEncodeRepeatedMessage writes each element of a repeated message field,
+// following it with after.
+func goEncodeRepeatedMessage(fieldName string, field ir.Field, msgIndex map[string]ir.Message, after []string) ([]string, error) {
+	items := fieldName
+	if field.SortBy != "" {
+		less, err := goSortByLess(field, msgIndex)
+		if err != nil {
+			return nil, err
+		}
+		items = fmt.Sprintf("SortedCopy(%s, %s)", fieldName, less)
+	}
+	lines := []string{fmt.Sprintf("for _, item := range %s {", items)}
+	if !goRepeatedValueSlice(field) {
+		lines = append(lines, "if item == nil {", "continue", "}")
+	}
+	lines = append(lines, fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.BytesType)", field.Number))
+	lines = append(lines, "b = protowire.AppendBytes(b, item.Encode())")
+	lines = append(lines, after...)
+	return append(lines, "}"), nil
+}
+
 func goEncodeField(name string, field ir.Field) ([]string, error) {
 	if field.Kind == ir.KindEnum {
 		return []string{fmt.Sprintf("b = AppendInt32Field(b, int32(%s), %d)", name, field.Number)}, nil
@@ -2015,32 +2709,38 @@ func goEncodeField(name string, field ir.Field) ([]string, error) {
 	return []string{fmt.Sprintf("b = %s(b, %s, %d)", helper, name, field.Number)}, nil
 }
 
-func goEncodeRepeated(fieldName string, field ir.Field) ([]string, error) {
-	if field.Kind == ir.KindEnum {
-		return goEncodeRepeatedEnum(fieldName, field), nil
+// goEncodeAlwaysField writes a cp.always_emit field's tag and value without
+// the zero check the Append<Kind>Field helpers make.
+func goEncodeAlwaysField(name string, field ir.Field) ([]string, error) {
+	tag := fmt.Sprintf("b = protowire.AppendTag(b, %d, %s)", field.Number, goWireType(field.Kind))
+	switch field.Kind {
+	case ir.KindString:
+		return []string{tag, fmt.Sprintf("b = protowire.AppendString(b, %s)", name)}, nil
+	case ir.KindBytes:
+		return []string{tag, fmt.Sprintf("b = protowire.AppendBytes(b, %s)", name)}, nil
+	case ir.KindEnum:
+		name = "int32(" + name + ")"
 	}
-	helper, err := goAppendHelperName(field.Kind, false)
+	helper, err := goAppendCompactHelperName(field.Kind)
 	if err != nil {
 		return nil, err
 	}
-	lines := []string{fmt.Sprintf("b = AppendRepeated(b, %s, AppendFieldDecorator(%s, %d))", fieldName, helper, field.Number)}
-	return lines, nil
+	return []string{tag, fmt.Sprintf("b = %s(b, %s)", helper, name)}, nil
+}
+
+func goEncodeRepeated(fieldName string, field ir.Field) ([]string, error) {
+	if field.Kind == ir.KindEnum {
+		return goEncodeRepeatedEnum(fieldName, field), nil
+	}
+	return goEncodeElementLines(fieldName, "item", field)
 }
 
 func goEncodeOptionalField(name string, field ir.Field) ([]string, error) {
 	if field.Kind == ir.KindEnum {
-		return []string{
-			fmt.Sprintf("if %s != nil {", name),
-			fmt.Sprintf("b = AppendInt32Field(b, int32(*%s), %d)", name, field.Number),
-			"}",
-		}, nil
+		return goEncodeSetLines(name, "int32(*"+name+")", field)
 	}
 	if field.Kind == ir.KindBytes {
-		return []string{
-			fmt.Sprintf("if %s != nil {", name),
-			fmt.Sprintf("b = AppendBytesField(b, *%s, %d)", name, field.Number),
-			"}",
-		}, nil
+		return goEncodeSetLines(name, "*"+name, field)
 	}
 	helper, err := goAppendHelperName(field.Kind, true)
 	if err != nil {
@@ -2049,6 +2749,51 @@ func goEncodeOptionalField(name string, field ir.Field) ([]string, error) {
 	return []string{fmt.Sprintf("b = %s(b, %s, %d)", helper, name, field.Number)}, nil
 }
 
+// goEncodeSetLines encodes an optional field whenever ptr is set, including
+// to its zero value, so explicit presence survives the round trip. The Field
+// append helpers skip zero values, so only the value is appended through
+// them.
+func goEncodeSetLines(ptr, rawExpr string, field ir.Field) ([]string, error) {
+	valueHelper, err := goAppendValueHelperName(field.Kind)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		fmt.Sprintf("if %s != nil {", ptr),
+		fmt.Sprintf("b = protowire.AppendTag(b, %d, %s)", field.Number, goWireType(field.Kind)),
+		fmt.Sprintf("b = %s(b, %s)", valueHelper, rawExpr),
+		"}",
+	}, nil
+}
+
+// goEncodeElementLines encodes each element of an unpacked repeated field
+// with its own tag. Unlike the singular Append<Kind>Field helpers it writes
+// zero values too, since every element of a list is present.
+func goEncodeElementLines(fieldName, rawExpr string, field ir.Field) ([]string, error) {
+	valueHelper, err := goAppendValueHelperName(field.Kind)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		fmt.Sprintf("for _, item := range %s {", fieldName),
+		fmt.Sprintf("b = protowire.AppendTag(b, %d, %s)", field.Number, goWireType(field.Kind)),
+		fmt.Sprintf("b = %s(b, %s)", valueHelper, rawExpr),
+		"}",
+	}, nil
+}
+
+// goAppendValueHelperName returns the helper appending a bare value of kind,
+// without a tag.
+func goAppendValueHelperName(kind ir.Kind) (string, error) {
+	switch kind {
+	case ir.KindString:
+		return "protowire.AppendString", nil
+	case ir.KindBytes:
+		return "protowire.AppendBytes", nil
+	}
+	return goAppendCompactHelperName(kind)
+}
+
 func goEncodeNative(fieldName string, field ir.Field) ([]string, error) {
 	if !goUsesBuiltinTypeConversion(field) {
 		return goEncodeCustomType(fieldName, field)
@@ -2065,15 +2810,7 @@ func goEncodeNative(fieldName string, field ir.Field) ([]string, error) {
 			if field.Kind == ir.KindInt64 {
 				compact = "AppendInt64Compact"
 			}
-			lines = append(lines, "var packed []byte")
-			lines = append(lines, fmt.Sprintf("for _, item := range %s {", fieldName))
-			lines = append(lines, fmt.Sprintf("packed = %s(packed, %s)", compact, expr))
-			lines = append(lines, "}")
-			lines = append(lines, "if len(packed) > 0 {")
-			lines = append(lines, fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.BytesType)", field.Number))
-			lines = append(lines, "b = protowire.AppendBytes(b, packed)")
-			lines = append(lines, "}")
-			return lines, nil
+			return goEncodePackedLines(fieldName, field.Number, compact, expr), nil
 		}
 		lines = append(lines, fmt.Sprintf("for _, item := range %s {", fieldName))
 		lines = append(lines, fmt.Sprintf("b = %s(b, item, %d)", appendFunc, field.Number))
@@ -2106,34 +2843,20 @@ func goEncodeCustomType(fieldName string, field ir.Field) ([]string, error) {
 			if err != nil {
 				return nil, err
 			}
-			lines = append(lines, "var packed []byte")
-			lines = append(lines, fmt.Sprintf("for _, item := range %s {", fieldName))
-			lines = append(lines, fmt.Sprintf("packed = %s(packed, %s)", compactHelper, rawExpr))
-			lines = append(lines, "}")
-			lines = append(lines, "if len(packed) > 0 {")
-			lines = append(lines, fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.BytesType)", field.Number))
-			lines = append(lines, "b = protowire.AppendBytes(b, packed)")
-			lines = append(lines, "}")
-			return lines, nil
+			return goEncodePackedLines(fieldName, field.Number, compactHelper, rawExpr), nil
 		}
 		rawExpr, err := goCustomRawValueExpr(field, "item")
 		if err != nil {
 			return nil, err
 		}
-		lines = append(lines, fmt.Sprintf("for _, item := range %s {", fieldName))
-		lines = append(lines, fmt.Sprintf("b = %s(b, %s, %d)", appendFunc, rawExpr, field.Number))
-		lines = append(lines, "}")
-		return lines, nil
+		return goEncodeElementLines(fieldName, rawExpr, field)
 	}
 	if field.IsOptional {
 		rawExpr, err := goCustomRawValueExpr(field, "*"+fieldName)
 		if err != nil {
 			return nil, err
 		}
-		lines = append(lines, fmt.Sprintf("if %s != nil {", fieldName))
-		lines = append(lines, fmt.Sprintf("b = %s(b, %s, %d)", appendFunc, rawExpr, field.Number))
-		lines = append(lines, "}")
-		return lines, nil
+		return goEncodeSetLines(fieldName, rawExpr, field)
 	}
 	rawExpr, err := goCustomRawValueExpr(field, fieldName)
 	if err != nil {
@@ -2337,22 +3060,72 @@ func goNativeConsumeFunc(field ir.Field, optional bool) (string, error) {
 	return consumeFunc, nil
 }
 
-func goEncodeRepeatedEnum(fieldName string, field ir.Field) []string {
-	if field.IsPacked {
+// goEncodeWrapper encodes a google.protobuf wrapper field as a message
+// holding the value in field 1. A singular wrapper leaves a zero value out,
+// as every protobuf implementation does. A repeated element writes its value
+// even when zero, since an element without one is a nil (null) element.
+func goEncodeWrapper(fieldName string, field ir.Field) ([]string, error) {
+	if !field.IsRepeated {
+		helper, err := goAppendHelperName(field.Kind, false)
+		if err != nil {
+			return nil, err
+		}
 		return []string{
-			"var packed []byte",
-			fmt.Sprintf("for _, item := range %s {", fieldName),
-			"packed = AppendInt32Compact(packed, int32(item))",
-			"}",
-			"if len(packed) > 0 {",
-			fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.BytesType)", field.Number),
-			"b = protowire.AppendBytes(b, packed)",
+			fmt.Sprintf("if %s != nil {", fieldName),
+			fmt.Sprintf("b = AppendWrapper(b, %s(nil, *%s, 1), %d)", helper, fieldName, field.Number),
 			"}",
+		}, nil
+	}
+	valueHelper, err := goAppendValueHelperName(field.Kind)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		fmt.Sprintf("for _, item := range %s {", fieldName),
+		"var value []byte",
+		"if item != nil {",
+		fmt.Sprintf("value = protowire.AppendTag(nil, 1, %s)", goWireType(field.Kind)),
+		fmt.Sprintf("value = %s(value, *item)", valueHelper),
+		"}",
+		fmt.Sprintf("b = AppendWrapper(b, value, %d)", field.Number),
+		"}",
+	}, nil
+}
+
+// goDecodeWrapper decodes a google.protobuf wrapper field. A repeated element
+// without a value decodes as nil.
+func goDecodeWrapper(fieldName string, field ir.Field) ([]string, error) {
+	consumeCall, err := goConsumeFunc(field)
+	if err != nil {
+		return nil, err
+	}
+	if !field.IsRepeated {
+		return []string{fmt.Sprintf("b, %s, err = ConsumeWrapper(b, typ, %s)", fieldName, consumeCall)}, nil
+	}
+	elemType := "[]byte"
+	if field.Kind != ir.KindBytes {
+		elemType, _, err = goScalarType(field.Kind, false)
+		if err != nil {
+			return nil, err
 		}
 	}
+	return []string{
+		fmt.Sprintf("var item *%s", elemType),
+		fmt.Sprintf("b, item, err = ConsumeWrapperElement(b, typ, %s)", consumeCall),
+		"if err == nil {",
+		fmt.Sprintf("%s = append(%s, item)", fieldName, fieldName),
+		"}",
+	}, nil
+}
+
+func goEncodeRepeatedEnum(fieldName string, field ir.Field) []string {
+	if field.IsPacked {
+		return goEncodePackedLines(fieldName, field.Number, "AppendInt32Compact", "int32(item)")
+	}
 	return []string{
 		fmt.Sprintf("for _, item := range %s {", fieldName),
-		fmt.Sprintf("b = AppendInt32Field(b, int32(item), %d)", field.Number),
+		fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.VarintType)", field.Number),
+		"b = AppendInt32Compact(b, int32(item))",
 		"}",
 	}
 }
@@ -2512,54 +3285,24 @@ func goEncodeMap(fieldName string, field ir.Field, msgIndex map[string]ir.Messag
 	return lines, nil
 }
 
-func goDecodeMap(fieldName string, field ir.Field, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum) ([]string, bool, error) {
+func goDecodeMap(fieldName string, field ir.Field, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum) ([]string, error) {
 	var lines []string
 	keyConsume, err := goConsumeFunc(ir.Field{Kind: field.MapKeyKind})
 	if err != nil {
-		return nil, false, err
-	}
-	valConsume, err := goConsumeMapValueFunc(field, msgIndex, enumIndex)
-	if err != nil {
-		return nil, false, err
+		return nil, err
 	}
-	lines = append(lines, fmt.Sprintf("if %s == nil {", fieldName))
-	lines = append(lines, fmt.Sprintf("%s = make(map[%s]%s)", fieldName, mustGoMapKeyType(field.MapKeyKind), mustGoMapValueType(field, msgIndex, enumIndex)))
-	lines = append(lines, "}")
-	lines = append(lines, fmt.Sprintf("b, err = ConsumeMapEntry(b, typ, %s, %s, %s)", fieldName, keyConsume, valConsume))
-	return lines, false, nil
-}
-
-func goDecodeMapScalar(kind ir.Kind, target string, bufName string) ([]string, error) {
-	var lines []string
-	switch kind {
-	case ir.KindString:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeString(%s, typ2)", bufName, target, bufName))
-	case ir.KindBool:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeBool(%s, typ2)", bufName, target, bufName))
-	case ir.KindInt32, ir.KindEnum:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeVarInt32(%s, typ2)", bufName, target, bufName))
-	case ir.KindSint32:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeSint32(%s, typ2)", bufName, target, bufName))
-	case ir.KindUint32:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeVarUint32(%s, typ2)", bufName, target, bufName))
-	case ir.KindInt64:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeVarInt64(%s, typ2)", bufName, target, bufName))
-	case ir.KindSint64:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeSint64(%s, typ2)", bufName, target, bufName))
-	case ir.KindUint64:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeVarUint64(%s, typ2)", bufName, target, bufName))
-	case ir.KindFixed32:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeFixedUint32(%s, typ2)", bufName, target, bufName))
-	case ir.KindFixed64:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeFixedUint64(%s, typ2)", bufName, target, bufName))
-	case ir.KindSfixed32:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeSfixed32(%s, typ2)", bufName, target, bufName))
-	case ir.KindSfixed64:
-		lines = append(lines, fmt.Sprintf("%s, %s, err2 = ConsumeSfixed64(%s, typ2)", bufName, target, bufName))
-	default:
-		return nil, fmt.Errorf("unsupported map scalar: %v", kind)
+	valConsume, err := goConsumeMapValueFunc(field, msgIndex, enumIndex)
+	if err != nil {
+		return nil, err
+	}
+	lines = append(lines, fmt.Sprintf("if %s == nil {", fieldName))
+	if field.GoMapSizeHint > 0 {
+		lines = append(lines, fmt.Sprintf("%s = make(map[%s]%s, %d)", fieldName, mustGoMapKeyType(field.MapKeyKind), mustGoMapValueType(field, msgIndex, enumIndex), field.GoMapSizeHint))
+	} else {
+		lines = append(lines, fmt.Sprintf("%s = make(map[%s]%s)", fieldName, mustGoMapKeyType(field.MapKeyKind), mustGoMapValueType(field, msgIndex, enumIndex)))
 	}
-	lines = append(lines, "if err2 != nil {", "return nil, err2", "}")
+	lines = append(lines, "}")
+	lines = append(lines, fmt.Sprintf("b, err = ConsumeMapEntry(b, typ, %s, %s, %s)", fieldName, keyConsume, valConsume))
 	return lines, nil
 }
 
@@ -2593,9 +3336,29 @@ func goEncodePacked(fieldName string, field ir.Field) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	var lines []string
-	lines = append(lines, fmt.Sprintf("b = AppendRepeatedCompact(b, %s, %d, AppendCompactDecorator(%s))", fieldName, field.Number, compactHelper))
-	return lines, nil
+	// Inlined rather than AppendRepeatedCompact so no per-field closure is
+	// built.
+	return goEncodePackedLines(fieldName, field.Number, compactHelper, "item"), nil
+}
+
+// goEncodePackedLines builds a packed field in a pooled scratch buffer, so
+// encoding many messages reuses one backing array instead of allocating a
+// fresh one per field. The block is scoped so several packed fields can each
+// declare packed.
+func goEncodePackedLines(fieldName string, number int, compactHelper, itemExpr string) []string {
+	return []string{
+		"{",
+		"packed := GetPackedBuffer()",
+		fmt.Sprintf("for _, item := range %s {", fieldName),
+		fmt.Sprintf("*packed = %s(*packed, %s)", compactHelper, itemExpr),
+		"}",
+		"if len(*packed) > 0 {",
+		fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.BytesType)", number),
+		"b = protowire.AppendBytes(b, *packed)",
+		"}",
+		"PutPackedBuffer(packed)",
+		"}",
+	}
 }
 
 func goAppendCompactHelperName(kind ir.Kind) (string, error) {
@@ -2633,256 +3396,146 @@ func goAppendCompactHelperName(kind ir.Kind) (string, error) {
 	}
 }
 
-func goDecodePacked(fieldName string, field ir.Field) ([]string, error) {
-	var lines []string
-	lines = append(lines, "if typ == protowire.BytesType {")
-	lines = append(lines, "var packed []byte")
-	lines = append(lines, "b, packed, err = ConsumeBytes(b, typ)")
-	lines = append(lines, "if err != nil {", "return nil, err", "}")
-	lines = append(lines, "for len(packed) > 0 {")
-	itemLines, err := goDecodePackedItem("packed", field)
-	if err != nil {
-		return nil, err
-	}
-	lines = append(lines, itemLines...)
-	lines = append(lines, fmt.Sprintf("%s = append(%s, item)", fieldName, fieldName))
-	lines = append(lines, "}")
-	lines = append(lines, "} else {")
-	decodeLines, _, err := goDecodeScalar(field, "item")
-	if err != nil {
-		return nil, err
-	}
-	lines = append(lines, decodeLines...)
-	lines = append(lines, fmt.Sprintf("%s = append(%s, item)", fieldName, fieldName))
-	lines = append(lines, "}")
-	return lines, nil
-}
-
-func goDecodePackedItem(bufName string, field ir.Field) ([]string, error) {
-	var lines []string
-	switch field.Kind {
-	case ir.KindBool:
-		lines = append(lines, "var v uint64")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeVarint(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := v != 0")
-	case ir.KindFloat:
-		lines = append(lines, "var v uint32")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeFixed32(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := math.Float32frombits(v)")
-	case ir.KindDouble:
-		lines = append(lines, "var v uint64")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeFixed64(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := math.Float64frombits(v)")
-	case ir.KindInt32, ir.KindEnum:
-		lines = append(lines, "var v uint64")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeVarint(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := int32(v)")
-	case ir.KindUint32:
-		lines = append(lines, "var v uint64")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeVarint(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := uint32(v)")
-	case ir.KindSint32:
-		lines = append(lines, "var v uint64")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeVarint(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := int32(protowire.DecodeZigZag(v))")
-	case ir.KindInt64:
-		lines = append(lines, "var v uint64")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeVarint(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := int64(v)")
-	case ir.KindUint64:
-		lines = append(lines, "var v uint64")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeVarint(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := v")
-	case ir.KindSint64:
-		lines = append(lines, "var v uint64")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeVarint(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := int64(protowire.DecodeZigZag(v))")
-	case ir.KindFixed32, ir.KindSfixed32:
-		lines = append(lines, "var v uint32")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeFixed32(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := v")
-	case ir.KindFixed64, ir.KindSfixed64:
-		lines = append(lines, "var v uint64")
-		lines = append(lines, "var n int")
-		lines = append(lines, fmt.Sprintf("v, n = protowire.ConsumeFixed64(%s)", bufName))
-		lines = append(lines, "if err := protowire.ParseError(n); err != nil {", "return nil, err", "}")
-		lines = append(lines, fmt.Sprintf("%s = %s[n:]", bufName, bufName))
-		lines = append(lines, "item := v")
-	default:
-		return nil, fmt.Errorf("unsupported packed decode kind: %v", field.Kind)
-	}
-	return lines, nil
-}
-
-func buildGoDecodeCases(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum) ([]goDecodeCase, bool, bool, error) {
+func buildGoDecodeCases(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum) ([]goDecodeCase, bool, error) {
 	var cases []goDecodeCase
 	needsMsgBytes := false
-	needsTmpBytes := false
 	for _, field := range msg.Fields {
 		if field.GoIgnore {
 			continue
 		}
 		c := goDecodeCase{Number: field.Number}
-		fieldName := "m." + ir.GoName(field.Name)
+		fieldName := "m." + goStructFieldName(msg, field)
 		switch {
+		case goEnumString(field):
+			enumType, err := goEnumTypeName(field, enumIndex)
+			if err != nil {
+				return nil, false, err
+			}
+			c.Lines = append(c.Lines, goDecodeEnumString(fieldName, field, enumType)...)
 		case field.GoType != "":
 			lines, err := goDecodeNative(fieldName, field)
 			if err != nil {
-				return nil, false, false, err
+				return nil, false, err
 			}
 			c.Lines = append(c.Lines, lines...)
 		case field.IsTimestamp:
-			lines, needsMsg, err := goDecodeTimestamp(fieldName, field)
+			lines, err := goDecodeTimestamp(fieldName, field)
 			if err != nil {
-				return nil, false, false, err
-			}
-			if needsMsg {
-				needsMsgBytes = true
+				return nil, false, err
 			}
 			c.Lines = append(c.Lines, lines...)
 		case field.IsDuration:
 			lines, err := goDecodeDuration(fieldName, field)
 			if err != nil {
-				return nil, false, false, err
+				return nil, false, err
+			}
+			c.Lines = append(c.Lines, lines...)
+		case field.IsWrapper:
+			lines, err := goDecodeWrapper(fieldName, field)
+			if err != nil {
+				return nil, false, err
 			}
 			c.Lines = append(c.Lines, lines...)
 		case field.IsRepeated && field.Kind == ir.KindEnum:
 			enumType, err := goEnumTypeName(field, enumIndex)
 			if err != nil {
-				return nil, false, false, err
+				return nil, false, err
 			}
 			c.Lines = append(c.Lines, goDecodeEnum(fieldName, field, enumType)...)
 		case field.IsMap:
-			lines, msgBytesNeeded, err := goDecodeMap(fieldName, field, msgIndex, enumIndex)
+			lines, err := goDecodeMap(fieldName, field, msgIndex, enumIndex)
 			if err != nil {
-				return nil, false, false, err
-			}
-			if msgBytesNeeded {
-				needsMsgBytes = true
+				return nil, false, err
 			}
 			c.Lines = append(c.Lines, lines...)
 		case field.IsRepeated && field.Kind == ir.KindMessage:
 			needsMsgBytes = true
 			msgType := msgIndex[field.MessageFullName].Name
 			c.Lines = append(c.Lines, "b, msgBytes, err = ConsumeMessage(b, typ)")
-			c.Lines = append(c.Lines, "if err == nil {")
-			c.Lines = append(c.Lines, fmt.Sprintf("var item *%s", msgType))
-			c.Lines = append(c.Lines, fmt.Sprintf("item, err = Decode%s(msgBytes)", msgType))
+			c.Lines = append(c.Lines, "if err == nil {", "err = budget.consume(len(msgBytes))", "}")
 			c.Lines = append(c.Lines, "if err == nil {")
 			if goRepeatedValueSlice(field) {
-				c.Lines = append(c.Lines, fmt.Sprintf("%s = append(%s, *item)", fieldName, fieldName))
+				// Grow the slice first and decode straight into the new element.
+				c.Lines = append(c.Lines, fmt.Sprintf("%s = append(%s, %s{})", fieldName, fieldName, msgType))
+				c.Lines = append(c.Lines, fmt.Sprintf("_, err = decode%sInto(&%s[len(%s)-1], msgBytes, budget)", msgType, fieldName, fieldName))
 			} else {
+				c.Lines = append(c.Lines, fmt.Sprintf("item := new(%s)", msgType))
+				c.Lines = append(c.Lines, fmt.Sprintf("_, err = decode%sInto(item, msgBytes, budget)", msgType))
+				c.Lines = append(c.Lines, "if err == nil {")
 				c.Lines = append(c.Lines, fmt.Sprintf("%s = append(%s, item)", fieldName, fieldName))
+				c.Lines = append(c.Lines, "}")
 			}
 			c.Lines = append(c.Lines, "}")
-			c.Lines = append(c.Lines, "}")
 		case field.IsRepeated:
-			if field.Kind == ir.KindMessage {
-				decodeLines, tmpBytes, err := goDecodeScalar(field, "item")
-				if err != nil {
-					return nil, false, false, err
-				}
-				if tmpBytes {
-					needsTmpBytes = true
-				}
-				c.Lines = append(c.Lines, decodeLines...)
-				c.Lines = append(c.Lines, fmt.Sprintf("%s = append(%s, item)", fieldName, fieldName))
+			consumeCall, err := goConsumeFunc(field)
+			if err != nil {
+				return nil, false, err
+			}
+			if field.IsPacked && isGoPackable(field.Kind) {
+				elemTyp := goWireType(field.Kind)
+				c.Lines = append(c.Lines, fmt.Sprintf("b, %s, err = ConsumeRepeatedCompact(b, typ, %s, %s, %s)", fieldName, elemTyp, fieldName, consumeCall))
 			} else {
-				consumeCall, err := goConsumeFunc(field)
-				if err != nil {
-					return nil, false, false, err
-				}
-				if field.IsPacked && isGoPackable(field.Kind) {
-					elemTyp := goWireType(field.Kind)
-					c.Lines = append(c.Lines, fmt.Sprintf("b, %s, err = ConsumeRepeatedCompact(b, typ, %s, %s)", fieldName, elemTyp, consumeCall))
-				} else {
-					c.Lines = append(c.Lines, fmt.Sprintf("var item %s", mustGoSliceElemType(field, msgIndex)))
-					c.Lines = append(c.Lines, fmt.Sprintf("b, item, err = ConsumeRepeatedElement(b, typ, %s)", consumeCall))
-					c.Lines = append(c.Lines, "if err == nil {")
-					c.Lines = append(c.Lines, fmt.Sprintf("%s = append(%s, item)", fieldName, fieldName))
-					c.Lines = append(c.Lines, "}")
-				}
+				c.Lines = append(c.Lines, fmt.Sprintf("var item %s", mustGoSliceElemType(field, msgIndex)))
+				c.Lines = append(c.Lines, fmt.Sprintf("b, item, err = ConsumeRepeatedElement(b, typ, %s)", consumeCall))
+				c.Lines = append(c.Lines, "if err == nil {")
+				c.Lines = append(c.Lines, fmt.Sprintf("%s = append(%s, item)", fieldName, fieldName))
+				c.Lines = append(c.Lines, "}")
 			}
+		case field.Lazy:
+			needsMsgBytes = true
+			raw := "m." + goLazyRawName(goStructFieldName(msg, field))
+			c.Lines = append(c.Lines, "b, msgBytes, err = ConsumeMessage(b, typ)")
+			c.Lines = append(c.Lines, "if err == nil {", "err = budget.consume(len(msgBytes))", "}")
+			c.Lines = append(c.Lines, "if err == nil {")
+			c.Lines = append(c.Lines, fmt.Sprintf("%s = AppendLazy(%s, msgBytes)", raw, raw))
+			c.Lines = append(c.Lines, "}")
 		case field.Kind == ir.KindMessage:
 			needsMsgBytes = true
 			msgType := msgIndex[field.MessageFullName].Name
 			c.Lines = append(c.Lines, "b, msgBytes, err = ConsumeMessage(b, typ)")
-			c.Lines = append(c.Lines, "if err == nil {")
-			c.Lines = append(c.Lines, fmt.Sprintf("var item *%s", msgType))
-			c.Lines = append(c.Lines, fmt.Sprintf("item, err = Decode%s(msgBytes)", msgType))
+			c.Lines = append(c.Lines, "if err == nil {", "err = budget.consume(len(msgBytes))", "}")
 			c.Lines = append(c.Lines, "if err == nil {")
 			if field.GoValue {
-				c.Lines = append(c.Lines, fmt.Sprintf("%s = *item", fieldName))
+				c.Lines = append(c.Lines, fmt.Sprintf("_, err = decode%sInto(&%s, msgBytes, budget)", msgType, fieldName))
 			} else {
-				c.Lines = append(c.Lines, fmt.Sprintf("%s = item", fieldName))
+				c.Lines = append(c.Lines, fmt.Sprintf("if %s == nil {", fieldName))
+				c.Lines = append(c.Lines, fmt.Sprintf("%s = new(%s)", fieldName, msgType))
+				c.Lines = append(c.Lines, "}")
+				c.Lines = append(c.Lines, fmt.Sprintf("_, err = decode%sInto(%s, msgBytes, budget)", msgType, fieldName))
 			}
 			c.Lines = append(c.Lines, "}")
-			c.Lines = append(c.Lines, "}")
 		case field.IsOptional:
 			if field.Kind == ir.KindEnum {
 				enumType, err := goEnumTypeName(field, enumIndex)
 				if err != nil {
-					return nil, false, false, err
+					return nil, false, err
 				}
 				c.Lines = append(c.Lines, goDecodeEnum(fieldName, field, enumType)...)
 				break
 			}
 			decodeLines, err := goDecodeOptionalScalar(field, fieldName)
 			if err != nil {
-				return nil, false, false, err
+				return nil, false, err
 			}
 			c.Lines = append(c.Lines, decodeLines...)
 		default:
 			if field.Kind == ir.KindEnum {
 				enumType, err := goEnumTypeName(field, enumIndex)
 				if err != nil {
-					return nil, false, false, err
+					return nil, false, err
 				}
 				c.Lines = append(c.Lines, goDecodeEnum(fieldName, field, enumType)...)
 				break
 			}
-			decodeLines, tmpBytes, err := goDecodeScalar(field, fieldName)
+			decodeLines, err := goDecodeScalar(field, fieldName)
 			if err != nil {
-				return nil, false, false, err
-			}
-			if tmpBytes {
-				needsTmpBytes = true
+				return nil, false, err
 			}
 			c.Lines = append(c.Lines, decodeLines...)
 		}
 		cases = append(cases, c)
 	}
-	return cases, needsMsgBytes, needsTmpBytes, nil
+	return cases, needsMsgBytes, nil
 }
 
 func goEnumTypeName(field ir.Field, enumIndex map[string]ir.Enum) (string, error) {
@@ -2894,6 +3547,12 @@ func goEnumTypeName(field ir.Field, enumIndex map[string]ir.Enum) (string, error
 }
 
 func goDecodeEnum(fieldName string, field ir.Field, enumType string) []string {
+	return goDecodeEnumValue(fieldName, field, enumType+"(raw)")
+}
+
+// goDecodeEnumValue returns the decode lines for an enum field, storing value,
+// an expression of the int32 raw, for each number read.
+func goDecodeEnumValue(fieldName string, field ir.Field, value string) []string {
 	if field.IsRepeated {
 		if field.IsPacked {
 			return []string{
@@ -2904,7 +3563,7 @@ func goDecodeEnum(fieldName string, field ir.Field, enumType string) []string {
 				"var raw int32",
 				"packed, raw, err = ConsumeVarInt32(packed, protowire.VarintType)",
 				"if err != nil {", "return nil, err", "}",
-				fmt.Sprintf("%s = append(%s, %s(raw))", fieldName, fieldName, enumType),
+				fmt.Sprintf("%s = append(%s, %s)", fieldName, fieldName, value),
 				"}",
 			}
 		}
@@ -2912,7 +3571,7 @@ func goDecodeEnum(fieldName string, field ir.Field, enumType string) []string {
 			"var raw int32",
 			"b, raw, err = ConsumeVarInt32(b, typ)",
 			"if err == nil {",
-			fmt.Sprintf("%s = append(%s, %s(raw))", fieldName, fieldName, enumType),
+			fmt.Sprintf("%s = append(%s, %s)", fieldName, fieldName, value),
 			"}",
 		}
 	}
@@ -2921,7 +3580,7 @@ func goDecodeEnum(fieldName string, field ir.Field, enumType string) []string {
 			"var raw int32",
 			"b, raw, err = ConsumeVarInt32(b, typ)",
 			"if err == nil {",
-			fmt.Sprintf("tmp := %s(raw)", enumType),
+			"tmp := " + value,
 			fmt.Sprintf("%s = &tmp", fieldName),
 			"}",
 		}
@@ -2930,94 +3589,84 @@ func goDecodeEnum(fieldName string, field ir.Field, enumType string) []string {
 		"var raw int32",
 		"b, raw, err = ConsumeVarInt32(b, typ)",
 		"if err == nil {",
-		fmt.Sprintf("%s = %s(raw)", fieldName, enumType),
+		fmt.Sprintf("%s = %s", fieldName, value),
 		"}",
 	}
 }
 
-func goOptionalVarType(field ir.Field) (string, error) {
-	switch field.Kind {
-	case ir.KindBytes:
-		return "[]byte", nil
-	case ir.KindString:
-		return "string", nil
-	case ir.KindBool:
-		return "bool", nil
-	default:
-		t, _, err := goScalarType(field.Kind, false)
-		return t, err
-	}
-}
-
-func goDecodeScalar(field ir.Field, name string) ([]string, bool, error) {
+func goDecodeScalar(field ir.Field, name string) ([]string, error) {
 	switch field.Kind {
 	case ir.KindString:
 		return []string{
-			fmt.Sprintf("b, %s, err = ConsumeString(b, typ)", name),
-		}, false, nil
+			fmt.Sprintf("b, %s, err = budget.consumeString(b, typ)", name),
+		}, nil
 	case ir.KindBytes:
+		consumeFunc := "ConsumeBytesCopy"
+		if field.AliasBytes {
+			consumeFunc = "ConsumeBytes"
+		}
 		lines := []string{
-			fmt.Sprintf("b, %s, err = ConsumeBytesCopy(b, typ)", name),
+			fmt.Sprintf("b, %s, err = %s(b, typ)", name, consumeFunc),
 		}
-		return lines, false, nil
+		return lines, nil
 	case ir.KindBool:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeBool(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindFloat:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeFloat32(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindDouble:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeFloat64(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindInt32, ir.KindEnum:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeVarInt32(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindSint32:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeSint32(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindUint32:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeVarUint32(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindInt64:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeVarInt64(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindSint64:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeSint64(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindUint64:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeVarUint64(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindFixed32:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeFixedUint32(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindFixed64:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeFixedUint64(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindSfixed32:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeSfixed32(b, typ)", name),
-		}, false, nil
+		}, nil
 	case ir.KindSfixed64:
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeSfixed64(b, typ)", name),
-		}, false, nil
+		}, nil
 	default:
-		return nil, false, fmt.Errorf("unsupported decode kind: %v", field.Kind)
+		return nil, fmt.Errorf("unsupported decode kind: %v", field.Kind)
 	}
 }
 
-func goDecodeTimestamp(fieldName string, field ir.Field) ([]string, bool, error) {
+func goDecodeTimestamp(fieldName string, field ir.Field) ([]string, error) {
 	var lines []string
 	if field.IsRepeated {
 		lines = append(lines, "var item time.Time")
@@ -3025,14 +3674,14 @@ func goDecodeTimestamp(fieldName string, field ir.Field) ([]string, bool, error)
 		lines = append(lines, "if err == nil {")
 		lines = append(lines, fmt.Sprintf("%s = append(%s, item)", fieldName, fieldName))
 		lines = append(lines, "}")
-		return lines, false, nil
+		return lines, nil
 	}
 	consumeFunc := "ConsumeTimeFromTimestamp"
 	if field.IsOptional {
 		consumeFunc = "ConsumeTimeFromTimestampOpt"
 	}
 	lines = append(lines, fmt.Sprintf("b, %s, err = %s(b, typ)", fieldName, consumeFunc))
-	return lines, false, nil
+	return lines, nil
 }
 
 func goDecodeDuration(fieldName string, field ir.Field) ([]string, error) {
@@ -3057,9 +3706,14 @@ func goDecodeOptionalScalar(field ir.Field, fieldName string) ([]string, error)
 	switch field.Kind {
 	case ir.KindString:
 		return []string{
-			fmt.Sprintf("b, %s, err = ConsumeStringOpt(b, typ)", fieldName),
+			fmt.Sprintf("b, %s, err = budget.consumeStringOpt(b, typ)", fieldName),
 		}, nil
 	case ir.KindBytes:
+		if field.AliasBytes {
+			return []string{
+				fmt.Sprintf("b, %s, err = ConsumeBytesAliasOpt(b, typ)", fieldName),
+			}, nil
+		}
 		return []string{
 			fmt.Sprintf("b, %s, err = ConsumeBytesOpt(b, typ)", fieldName),
 		}, nil
@@ -3123,8 +3777,11 @@ func goDecodeOptionalScalar(field ir.Field, fieldName string) ([]string, error)
 func goConsumeFunc(field ir.Field) (string, error) {
 	switch field.Kind {
 	case ir.KindString:
-		return "ConsumeString", nil
+		return "budget.consumeString", nil
 	case ir.KindBytes:
+		if field.AliasBytes {
+			return "ConsumeBytes", nil
+		}
 		return "ConsumeBytesCopy", nil
 	case ir.KindBool:
 		return "ConsumeBool", nil
@@ -3170,7 +3827,7 @@ func goConsumeMapValueFunc(field ir.Field, msgIndex map[string]ir.Message, enumI
 		if !ok {
 			return "", fmt.Errorf("unknown map value message: %s", field.MapValueMessage)
 		}
-		return "ConsumeMessageDecorator(Decode" + msg.Name + ")", nil
+		return "ConsumeMessageWithBudget(decode" + msg.Name + "Into, budget)", nil
 	case ir.KindEnum:
 		enum, ok := enumIndex[field.MapValueEnum]
 		if !ok {
@@ -3178,7 +3835,7 @@ func goConsumeMapValueFunc(field ir.Field, msgIndex map[string]ir.Message, enumI
 		}
 		return "func(b []byte, typ protowire.Type) ([]byte, " + enum.Name + ", error) { var raw int32; var err error; b, raw, err = ConsumeVarInt32(b, typ); if err != nil { return nil, 0, err }; return b, " + enum.Name + "(raw), nil }", nil
 	case ir.KindBytes:
-		return "ConsumeBytes", nil
+		return "ConsumeBytesCopy", nil
 	default:
 		return goConsumeFunc(ir.Field{Kind: field.MapValueKind})
 	}
@@ -3452,8 +4109,9 @@ func goAuditFieldType(field ir.Field, msgIndex map[string]ir.Message, enumIndex
 	return t, err
 }
 
-func buildToAuditLines(field ir.Field, msgIndex map[string]ir.Message, needs map[string]bool) ([]string, error) {
+func buildToAuditLines(msg ir.Message, field ir.Field, msgIndex map[string]ir.Message, needs map[string]bool) ([]string, error) {
 	name := ir.GoName(field.Name)
+	src := "m." + goStructFieldName(msg, field)
 	if field.IsMap && field.MapValueKind == ir.KindMessage && field.MapValueMessage != "" && needs[field.MapValueMessage] {
 		keyType, err := goMapKeyType(field.MapKeyKind)
 		if err != nil {
@@ -3464,9 +4122,9 @@ func buildToAuditLines(field ir.Field, msgIndex map[string]ir.Message, needs map
 			return nil, fmt.Errorf("unknown map value message: %s", field.MapValueMessage)
 		}
 		return []string{
-			fmt.Sprintf("if m.%s != nil {", name),
-			fmt.Sprintf("\tout.%s = make(map[%s]*Audit%s, len(m.%s))", name, keyType, valMsg.Name, name),
-			fmt.Sprintf("\tfor k, v := range m.%s {", name),
+			fmt.Sprintf("if %s != nil {", src),
+			fmt.Sprintf("\tout.%s = make(map[%s]*Audit%s, len(%s))", name, keyType, valMsg.Name, src),
+			fmt.Sprintf("\tfor k, v := range %s {", src),
 			fmt.Sprintf("\t\tout.%s[k] = v.ToAudit()", name),
 			"\t}",
 			"}",
@@ -3475,14 +4133,14 @@ func buildToAuditLines(field ir.Field, msgIndex map[string]ir.Message, needs map
 	if field.Kind == ir.KindMessage && !field.IsTimestamp && !field.IsDuration && field.GoType == "" && needs[field.MessageFullName] {
 		if field.IsRepeated {
 			return []string{
-				fmt.Sprintf("for _, item := range m.%s {", name),
+				fmt.Sprintf("for _, item := range %s {", src),
 				fmt.Sprintf("\tout.%s = append(out.%s, item.ToAudit())", name, name),
 				"}",
 			}, nil
 		}
-		return []string{fmt.Sprintf("out.%s = m.%s.ToAudit()", name, name)}, nil
+		return []string{fmt.Sprintf("out.%s = %s.ToAudit()", name, src)}, nil
 	}
-	return []string{fmt.Sprintf("out.%s = m.%s", name, name)}, nil
+	return []string{fmt.Sprintf("out.%s = %s", name, src)}, nil
 }
 
 func buildGoAuditFile(file ir.File, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, pkg string, goJSONTags string, keepMsgs map[string]bool) ([]byte, error) {
@@ -3544,15 +4202,7 @@ func buildGoAuditFile(file ir.File, msgIndex map[string]ir.Message, enumIndex ma
 			if err != nil {
 				return nil, err
 			}
-			jsonTag := ""
-			if field.JSONIgnore {
-				jsonTag = "-"
-			} else if goJSONTags == "snake" {
-				jsonTag = toSnakeCase(field.Name)
-				if goJSONTagOmitEmpty(field) {
-					jsonTag += ",omitempty"
-				}
-			}
+			jsonTag := goJSONTag(field, goJSONTags)
 			b.WriteString("\t")
 			b.WriteString(ir.GoName(field.Name))
 			b.WriteString(" ")
@@ -3580,7 +4230,7 @@ func buildGoAuditFile(file ir.File, msgIndex map[string]ir.Message, enumIndex ma
 			if field.AuditIgnore {
 				continue
 			}
-			lines, err := buildToAuditLines(field, msgIndex, needs)
+			lines, err := buildToAuditLines(msg, field, msgIndex, needs)
 			if err != nil {
 				return nil, err
 			}
@@ -3606,6 +4256,52 @@ func indexMessages(files []ir.File) map[string]ir.Message {
 	return index
 }
 
+// checkGoTypeNameCollisions rejects messages and enums that flatten to the
+// same Go type name within a package, e.g. enum Outer.Color and message
+// Outer_Color both becoming OuterColor.
+func checkGoTypeNameCollisions(files []ir.File, keepMsgs, keepEnums map[string]bool) error {
+	type declared struct {
+		kind     string
+		fullName string
+	}
+	byPkg := make(map[string]map[string]declared)
+	declare := func(pkg, name string, d declared) error {
+		names := byPkg[pkg]
+		if names == nil {
+			names = make(map[string]declared)
+			byPkg[pkg] = names
+		}
+		prev, ok := names[name]
+		if !ok {
+			names[name] = d
+			return nil
+		}
+		if prev.fullName == d.fullName {
+			return nil
+		}
+		return fmt.Errorf("go type name %s in package %s is generated for both %s %s and %s %s", name, pkg, prev.kind, prev.fullName, d.kind, d.fullName)
+	}
+	for _, file := range files {
+		for _, msg := range file.Messages {
+			if keepMsgs != nil && !keepMsgs[msg.FullName] {
+				continue
+			}
+			if err := declare(file.GoPackage, msg.Name, declared{kind: "message", fullName: msg.FullName}); err != nil {
+				return err
+			}
+		}
+		for _, enum := range file.Enums {
+			if keepEnums != nil && !keepEnums[enum.FullName] {
+				continue
+			}
+			if err := declare(file.GoPackage, enum.Name, declared{kind: "enum", fullName: enum.FullName}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func indexEnums(files []ir.File) map[string]ir.Enum {
 	index := make(map[string]ir.Enum)
 	for _, file := range files {
@@ -3646,6 +4342,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 )
 
@@ -3739,6 +4436,9 @@ func decodeWithMaxBodySize[T any](r *http.Request, maxRequestBodySize int, decod
 type StreamReader struct {
 	r            *bufio.Reader
 	maxFrameSize int
+	// buf holds the payload Next returned last, grown to the largest frame
+	// so far rather than allocated per frame.
+	buf []byte
 }
 
 // NewStreamReader wraps r with a buffered reader for length-prefixed frames.
@@ -3750,6 +4450,11 @@ func NewStreamReader(r io.Reader, maxFrameSize int) *StreamReader {
 
 // Next returns the payload bytes of the next frame. At end-of-stream returns
 // (nil, false, nil). Framing or size errors return (nil, false, err).
+//
+// The payload is only valid until the following call to Next, which reuses
+// its memory. Decoding it copies what the message keeps, except ctype = CORD
+// bytes fields, which alias it; generated handlers and clients decode such
+// messages from a copy. Copy the payload to keep it.
 func (s *StreamReader) Next() ([]byte, bool, error) {
 	size, err := binary.ReadUvarint(s.r)
 	if err != nil {
@@ -3761,13 +4466,18 @@ func (s *StreamReader) Next() ([]byte, bool, error) {
 		}
 		return nil, false, err
 	}
-	if s.maxFrameSize > 0 && size > uint64(s.maxFrameSize) {
+	// A size beyond math.MaxInt cannot be allocated, and on 32-bit platforms
+	// would wrap negative if converted to int.
+	if size > math.MaxInt || s.maxFrameSize > 0 && size > uint64(s.maxFrameSize) {
 		return nil, false, ApiErr{DisplayErr: "Request frame too large", InternalErr: "request frame exceeds max size", Code: http.StatusRequestEntityTooLarge}
 	}
 	if size == 0 {
 		return nil, true, nil
 	}
-	payload := make([]byte, size)
+	if uint64(cap(s.buf)) < size {
+		s.buf = make([]byte, size)
+	}
+	payload := s.buf[:size]
 	if _, err := io.ReadFull(s.r, payload); err != nil {
 		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
 			return nil, false, fmt.Errorf("stream truncated mid-frame body: %w", err)
@@ -3984,6 +4694,51 @@ func AppendInt32FromTime(b []byte, v time.Time, num protowire.Number) []byte {
 	return AppendInt32Field(b, int32(v.Unix()), num)
 }
 
+// ErrOutOfRange is the error EncodeErr reports for a go_type value that does
+// not fit its wire type, such as a time.Time beyond int32 Unix seconds.
+var ErrOutOfRange = errors.New("value out of range for its wire type")
+
+func checkTimeInt32(msg string, num protowire.Number, v time.Time) {
+	if !v.IsZero() && (v.Unix() < math.MinInt32 || v.Unix() > math.MaxInt32) {
+		panicEncodeField(msg, num, ErrOutOfRange)
+	}
+}
+
+func checkDurationInt32(msg string, num protowire.Number, v time.Duration) {
+	if s := v / time.Second; s < math.MinInt32 || s > math.MaxInt32 {
+		panicEncodeField(msg, num, ErrOutOfRange)
+	}
+}
+
+// ErrUnknownEnumName is the error EncodeErr reports for a cp.go_type "string"
+// enum field holding a name its enum does not declare.
+var ErrUnknownEnumName = errors.New("unknown enum value name")
+
+// writeToChunk is how many bytes WriteTo buffers before writing them out.
+const writeToChunk = 4096
+
+// flushWriteTo writes b to w once it holds at least threshold bytes, adding
+// what was written to *n, and returns b emptied for reuse.
+func flushWriteTo(w io.Writer, b []byte, n *int64, threshold int) ([]byte, error) {
+	if len(b) == 0 || len(b) < threshold {
+		return b, nil
+	}
+	k, err := w.Write(b)
+	*n += int64(k)
+	return b[:0], err
+}
+
+// checkEnumName accepts the names values declares, the decimal numbers
+// EnumName writes for values without one, and "" for the zero value.
+func checkEnumName(msg string, num protowire.Number, name string, values map[string]int32) {
+	if _, ok := values[name]; ok || name == "" {
+		return
+	}
+	if _, err := strconv.ParseInt(name, 10, 32); err != nil {
+		panicEncodeField(msg, num, ErrUnknownEnumName)
+	}
+}
+
 func AppendInt64FromTime(b []byte, v time.Time, num protowire.Number) []byte {
 	if v.IsZero() {
 		return b
@@ -4267,6 +5022,25 @@ func ConsumeMapEntry[K comparable, V any](b []byte, typ protowire.Type, m map[K]
 	return b, nil
 }
 
+// ConsumeMessageWithBudget returns a map value consumer decoding nested
+// messages with decodeInto, charging their bytes to budget.
+func ConsumeMessageWithBudget[T any](decodeInto func(*T, []byte, *decodeBudget) (*T, error), budget *decodeBudget) func(b []byte, typ protowire.Type) ([]byte, *T, error) {
+	return func(b []byte, typ protowire.Type) ([]byte, *T, error) {
+		b, msgBytes, err := ConsumeMessage(b, typ)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := budget.consume(len(msgBytes)); err != nil {
+			return nil, nil, err
+		}
+		msg, err := decodeInto(allocMessage[T](budget), msgBytes, budget)
+		if err != nil {
+			return nil, nil, err
+		}
+		return b, msg, nil
+	}
+}
+
 func ConsumeMessageDecorator[T any](decodeFunc func([]byte) (T, error)) func(b []byte, typ protowire.Type) ([]byte, T, error) {
 	return func(b []byte, typ protowire.Type) ([]byte, T, error) {
 		var zeroV T
@@ -4294,7 +5068,9 @@ func ConsumeRepeatedElement[T any](b []byte, typ protowire.Type, consume func([]
 	return b, item, nil
 }
 
-func ConsumeRepeatedCompact[T any](b []byte, typ protowire.Type, elemTyp protowire.Type, consume func([]byte, protowire.Type) ([]byte, T, error)) ([]byte, []T, error) {
+// ConsumeRepeatedCompact decodes one packed chunk and appends its elements to
+// items, so a packed field split across several chunks accumulates.
+func ConsumeRepeatedCompact[T any](b []byte, typ protowire.Type, elemTyp protowire.Type, items []T, consume func([]byte, protowire.Type) ([]byte, T, error)) ([]byte, []T, error) {
 	if typ != protowire.BytesType || elemTyp == protowire.BytesType {
 		return nil, nil, errInvalidWireType
 	}
@@ -4304,7 +5080,6 @@ func ConsumeRepeatedCompact[T any](b []byte, typ protowire.Type, elemTyp protowi
 	if err != nil {
 		return nil, nil, err
 	}
-	var items []T
 	for len(packed) > 0 {
 		var v T
 		packed, v, err = consume(packed, elemTyp)
@@ -4456,6 +5231,22 @@ func ConsumeStringOpt(b []byte, typ protowire.Type) ([]byte, *string, error) {
 	return b, &v, nil
 }
 
+// consumeStringOpt is ConsumeStringOpt validating UTF-8 as consumeString
+// does.
+func (d *decodeBudget) consumeStringOpt(b []byte, typ protowire.Type) ([]byte, *string, error) {
+	var v string
+	var err error
+	b, v, err = d.consumeString(b, typ)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, &v, nil
+}
+
+// ConsumeBytesOpt consumes an optional bytes field into a copy that is
+// non-nil even when empty, so a field set to []byte{} decodes as it was
+// encoded rather than as a nil slice. An empty value costs one allocation,
+// for the pointer: a zero-length make does not allocate.
 func ConsumeBytesOpt(b []byte, typ protowire.Type) ([]byte, *[]byte, error) {
 	var v []byte
 	var err error
@@ -4463,10 +5254,132 @@ func ConsumeBytesOpt(b []byte, typ protowire.Type) ([]byte, *[]byte, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	copyBytes := append([]byte(nil), v...)
+	copyBytes := make([]byte, len(v))
+	copy(copyBytes, v)
 	return b, &copyBytes, nil
 }
 
+// ConsumeBytesAliasOpt is ConsumeBytesOpt for ctype = CORD fields: the value
+// is a slice of b rather than a copy, and non-nil even when empty.
+func ConsumeBytesAliasOpt(b []byte, typ protowire.Type) ([]byte, *[]byte, error) {
+	var v []byte
+	var err error
+	b, v, err = ConsumeBytes(b, typ)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, &v, nil
+}
+
+// AppendWrapper appends a google.protobuf wrapper field (StringValue,
+// Int32Value, ...) holding the encoded value field. The wrapper is written
+// even when value is empty, which is what tells a zero value from an absent
+// one.
+func AppendWrapper(b []byte, value []byte, num protowire.Number) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+// ConsumeWrapper consumes a google.protobuf wrapper field, reading its value
+// with consume. A wrapper without a value holds the zero value.
+func ConsumeWrapper[T any](b []byte, typ protowire.Type, consume func([]byte, protowire.Type) ([]byte, T, error)) ([]byte, *T, error) {
+	b, v, _, err := consumeWrapper(b, typ, consume)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, &v, nil
+}
+
+// ConsumeWrapperElement consumes an element of a repeated wrapper field. An
+// element without a value is nil.
+func ConsumeWrapperElement[T any](b []byte, typ protowire.Type, consume func([]byte, protowire.Type) ([]byte, T, error)) ([]byte, *T, error) {
+	b, v, ok, err := consumeWrapper(b, typ, consume)
+	if err != nil || !ok {
+		return b, nil, err
+	}
+	return b, &v, nil
+}
+
+func consumeWrapper[T any](b []byte, typ protowire.Type, consume func([]byte, protowire.Type) ([]byte, T, error)) ([]byte, T, bool, error) {
+	var v T
+	b, msgBytes, err := ConsumeMessage(b, typ)
+	if err != nil {
+		return nil, v, false, err
+	}
+	ok := false
+	for len(msgBytes) > 0 {
+		var num protowire.Number
+		var fieldTyp protowire.Type
+		msgBytes, num, fieldTyp, err = ConsumeTag(msgBytes)
+		if err != nil {
+			return nil, v, false, err
+		}
+		if num == 1 {
+			msgBytes, v, err = consume(msgBytes, fieldTyp)
+			ok = true
+		} else {
+			msgBytes, err = SkipFieldValue(msgBytes, num, fieldTyp)
+		}
+		if err != nil {
+			return nil, v, false, err
+		}
+	}
+	return b, v, ok, nil
+}
+
+// AppendLazy appends one occurrence of a cp.lazy message field to the bytes
+// held for it, copying them out of the decoded buffer. Occurrences are
+// concatenated, which protobuf decodes as merging them, and an empty
+// occurrence still leaves raw non-nil.
+func AppendLazy(raw, msg []byte) []byte {
+	if raw == nil {
+		raw = make([]byte, 0, len(msg))
+	}
+	return append(raw, msg...)
+}
+
+// MergeEncoded appends src to dst, both encodings of the same message type,
+// and returns the result as append does. Protobuf decodes concatenated
+// encodings as a merge: singular fields src sets replace dst's, repeated
+// fields append, map entries in src replace those with the same key, and
+// nested messages merge the same way. Fields src leaves at zero are not
+// encoded, so they keep dst's value.
+func MergeEncoded(dst, src []byte) []byte {
+	return append(dst, src...)
+}
+
+// EnumName returns the name names gives number, or number in decimal when it
+// has none, for enum fields held as strings (cp.go_type = "string").
+func EnumName(number int32, names map[int32]string) string {
+	if name, ok := names[number]; ok {
+		return name
+	}
+	return strconv.Itoa(int(number))
+}
+
+// EnumNumber returns the number values gives name, reading a decimal number
+// as EnumName writes it for a value without a name. Any other name is 0;
+// Encode rejects those first through checkEnumName.
+func EnumNumber(name string, values map[string]int32) int32 {
+	if number, ok := values[name]; ok {
+		return number
+	}
+	number, err := strconv.ParseInt(name, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(number)
+}
+
+// wrapperLen returns the length of a repeated string or bytes wrapper
+// element, which may be nil.
+func wrapperLen[T ~string | ~[]byte](v *T) int {
+	if v == nil {
+		return 0
+	}
+	return len(*v)
+}
+
 func ConsumeBytesCopy(b []byte, typ protowire.Type) ([]byte, []byte, error) {
 	var v []byte
 	var err error
@@ -4486,7 +5399,7 @@ func AppendVarIntField(b []byte, v uint64, num protowire.Number) []byte {
 }
 
 func AppendVarIntFieldOpt(b []byte, v *uint64, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.VarintType)
@@ -4502,7 +5415,7 @@ func AppendStringField(b []byte, v string, num protowire.Number) []byte {
 }
 
 func AppendStringFieldOpt(b []byte, v *string, num protowire.Number) []byte {
-	if v == nil || *v == "" {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.BytesType)
@@ -4526,11 +5439,11 @@ func AppendBoolField(b []byte, v bool, num protowire.Number) []byte {
 }
 
 func AppendBoolFieldOpt(b []byte, v *bool, num protowire.Number) []byte {
-	if v == nil || !*v {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.VarintType)
-	return protowire.AppendVarint(b, 1)
+	return AppendBoolCompact(b, *v)
 }
 
 func AppendFloat32Field(b []byte, v float32, num protowire.Number) []byte {
@@ -4542,7 +5455,7 @@ func AppendFloat32Field(b []byte, v float32, num protowire.Number) []byte {
 }
 
 func AppendFloat32FieldOpt(b []byte, v *float32, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
@@ -4558,7 +5471,7 @@ func AppendFloat64Field(b []byte, v float64, num protowire.Number) []byte {
 }
 
 func AppendFloat64FieldOpt(b []byte, v *float64, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
@@ -4570,15 +5483,15 @@ func AppendInt32Field(b []byte, v int32, num protowire.Number) []byte {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.VarintType)
-	return protowire.AppendVarint(b, uint64(uint32(v)))
+	return protowire.AppendVarint(b, uint64(int64(v)))
 }
 
 func AppendInt32FieldOpt(b []byte, v *int32, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.VarintType)
-	return protowire.AppendVarint(b, uint64(uint32(*v)))
+	return protowire.AppendVarint(b, uint64(int64(*v)))
 }
 
 func AppendUint32Field(b []byte, v uint32, num protowire.Number) []byte {
@@ -4590,7 +5503,7 @@ func AppendUint32Field(b []byte, v uint32, num protowire.Number) []byte {
 }
 
 func AppendUint32FieldOpt(b []byte, v *uint32, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.VarintType)
@@ -4606,7 +5519,7 @@ func AppendSint32Field(b []byte, v int32, num protowire.Number) []byte {
 }
 
 func AppendSint32FieldOpt(b []byte, v *int32, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.VarintType)
@@ -4622,7 +5535,7 @@ func AppendInt64Field(b []byte, v int64, num protowire.Number) []byte {
 }
 
 func AppendInt64FieldOpt(b []byte, v *int64, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.VarintType)
@@ -4638,7 +5551,7 @@ func AppendUint64Field(b []byte, v uint64, num protowire.Number) []byte {
 }
 
 func AppendUint64FieldOpt(b []byte, v *uint64, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.VarintType)
@@ -4654,7 +5567,7 @@ func AppendSint64Field(b []byte, v int64, num protowire.Number) []byte {
 }
 
 func AppendSint64FieldOpt(b []byte, v *int64, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.VarintType)
@@ -4670,7 +5583,7 @@ func AppendFixed32Field(b []byte, v uint32, num protowire.Number) []byte {
 }
 
 func AppendFixed32FieldOpt(b []byte, v *uint32, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
@@ -4686,7 +5599,7 @@ func AppendFixed64Field(b []byte, v uint64, num protowire.Number) []byte {
 }
 
 func AppendFixed64FieldOpt(b []byte, v *uint64, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
@@ -4702,7 +5615,7 @@ func AppendSfixed32Field(b []byte, v int32, num protowire.Number) []byte {
 }
 
 func AppendSfixed32FieldOpt(b []byte, v *int32, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
@@ -4718,7 +5631,7 @@ func AppendSfixed64Field(b []byte, v int64, num protowire.Number) []byte {
 }
 
 func AppendSfixed64FieldOpt(b []byte, v *int64, num protowire.Number) []byte {
-	if v == nil || *v == 0 {
+	if v == nil {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
@@ -4753,7 +5666,7 @@ func AppendFloat64Compact(b []byte, v float64) []byte {
 }
 
 func AppendInt32Compact(b []byte, v int32) []byte {
-	return protowire.AppendVarint(b, uint64(uint32(v)))
+	return protowire.AppendVarint(b, uint64(int64(v)))
 }
 
 func AppendUint32Compact(b []byte, v uint32) []byte {
@@ -4796,9 +5709,14 @@ type Encodable interface {
 	Encode() []byte
 }
 
+// AppendMessageFieldDecorator writes a message-valued field even when it
+// encodes to no bytes, so an empty message keeps its presence. A nil map
+// value is written as an empty message, as protobuf-go does, since the
+// generated Encode methods return no bytes for a nil receiver.
 func AppendMessageFieldDecorator[T Encodable](num protowire.Number) func([]byte, T) []byte {
 	return func(b []byte, value T) []byte {
-		return AppendBytesField(b, value.Encode(), num)
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, value.Encode())
 	}
 }
 
@@ -4809,16 +5727,42 @@ func AppendRepeated[T any](b []byte, values []T, appendValue func([]byte, T) []b
 	return b
 }
 
+// maxPackedBufferCap bounds the scratch buffers kept for reuse, so one huge
+// packed field does not pin its memory in the pool.
+const maxPackedBufferCap = 64 << 10
+
+var packedBufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
+// GetPackedBuffer returns an empty scratch buffer for building a packed field.
+// Return it with PutPackedBuffer once its bytes have been copied out.
+func GetPackedBuffer() *[]byte {
+	return packedBufferPool.Get().(*[]byte)
+}
+
+func PutPackedBuffer(b *[]byte) {
+	if cap(*b) > maxPackedBufferCap {
+		return
+	}
+	*b = (*b)[:0]
+	packedBufferPool.Put(b)
+}
+
 func AppendRepeatedCompact[T any](b []byte, values []T, num protowire.Number, appendValue func([]byte, T) []byte) []byte {
-	var packed []byte
+	packed := GetPackedBuffer()
+	defer PutPackedBuffer(packed)
 	for _, value := range values {
-		packed = appendValue(packed, value)
+		*packed = appendValue(*packed, value)
 	}
-	if len(packed) == 0 {
+	if len(*packed) == 0 {
 		return b
 	}
 	b = protowire.AppendTag(b, num, protowire.BytesType)
-	return protowire.AppendBytes(b, packed)
+	return protowire.AppendBytes(b, *packed)
 }
 
 func AppendMap[K comparable, V any](
@@ -4837,4 +5781,39 @@ func AppendMap[K comparable, V any](
 	}
 	return b
 }
+
+// AppendSortedMap is AppendMap with entries written in the order of their
+// encoded bytes rather than map iteration order, so the encoding of a map is
+// deterministic. Keys are unique and their encodings prefix-free, so no two
+// entries tie.
+func AppendSortedMap[K comparable, V any](
+	b []byte,
+	m map[K]V,
+	num protowire.Number,
+	appendKey func([]byte, K) []byte,
+	appendValue func([]byte, V) []byte,
+) []byte {
+	entries := make([][]byte, 0, len(m))
+	for key, value := range m {
+		entry := appendKey(nil, key)
+		entry = appendValue(entry, value)
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, bytes.Compare)
+	for _, entry := range entries {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+// HashBytes returns the 64-bit FNV-1a hash of b.
+func HashBytes(b []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
 `
@@ -1,6 +1,7 @@
 package gogen
 
 import (
+	"go/ast"
 	"go/parser"
 	"go/token"
 	"strings"
@@ -146,11 +147,11 @@ func TestBuildGoFileDataGoValueMessageField(t *testing.T) {
 	for _, c := range parent.DecodeCases {
 		decode.WriteString(strings.Join(c.Lines, "\n"))
 	}
-	if !strings.Contains(decode.String(), "m.ValueChild = *item") {
-		t.Fatalf("expected value message decode to assign decoded value, got:\n%s", decode.String())
+	if !strings.Contains(decode.String(), "_, err = decodeChildInto(&m.ValueChild, msgBytes, budget)") {
+		t.Fatalf("expected value message decode to decode in place, got:\n%s", decode.String())
 	}
-	if !strings.Contains(decode.String(), "m.PointerChild = item") {
-		t.Fatalf("expected default message decode to keep pointer assignment, got:\n%s", decode.String())
+	if !strings.Contains(decode.String(), "m.PointerChild = new(Child)") || !strings.Contains(decode.String(), "_, err = decodeChildInto(m.PointerChild, msgBytes, budget)") {
+		t.Fatalf("expected default message decode to keep pointer field, got:\n%s", decode.String())
 	}
 }
 
@@ -198,8 +199,8 @@ func TestBuildGoFileDataPackageLocalCustomGoType(t *testing.T) {
 	encodeChecks := []string{
 		"b = AppendInt32Field(b, int32(m.Status), 1)",
 		"if m.StatusOpt != nil {",
-		"b = AppendInt32Field(b, int32(*m.StatusOpt), 2)",
-		"packed = AppendInt32Compact(packed, int32(item))",
+		"b = AppendInt32Compact(b, int32(*m.StatusOpt))",
+		"*packed = AppendInt32Compact(*packed, int32(item))",
 	}
 	for _, check := range encodeChecks {
 		if !strings.Contains(encode, check) {
@@ -917,6 +918,37 @@ func TestGoGeneratorClientServiceDropsOtherServiceTypes(t *testing.T) {
 	}
 }
 
+func TestGoGeneratorAddsNolintDirective(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Reply",
+			FullName: "example.Reply",
+			Fields:   []ir.Field{{Name: "value", Number: 1, Kind: ir.KindString, GoEncode: true}},
+		}},
+	}
+
+	outputs, err := Generator{}.Generate([]ir.File{file}, generate.Options{GoOut: "gen/go", GoNolint: "all"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, output := range outputs {
+		if !strings.Contains(string(output.Content), "\n//nolint:all\npackage example\n") {
+			t.Errorf("expected %s to carry //nolint:all above the package clause\n%s", output.Path, output.Content)
+		}
+	}
+
+	outputs, err = Generator{}.Generate([]ir.File{file}, generate.Options{GoOut: "gen/go"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, output := range outputs {
+		if strings.Contains(string(output.Content), "//nolint") {
+			t.Errorf("did not expect a nolint directive in %s without GoNolint", output.Path)
+		}
+	}
+}
+
 func generatedSection(t *testing.T, source string, start string, end string) string {
 	t.Helper()
 	startIdx := strings.Index(source, start)
@@ -929,3 +961,481 @@ func generatedSection(t *testing.T, source string, start string, end string) str
 	}
 	return source[startIdx : startIdx+endIdx+len(end)]
 }
+
+func TestGoGeneratorErrorsOnFlattenedTypeNameCollision(t *testing.T) {
+	// Nested enum Outer.Color and top-level message Outer_Color both flatten
+	// to OuterColor.
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "Outer", FullName: "example.Outer"},
+			{
+				Name:     "OuterColor",
+				FullName: "example.Outer_Color",
+				Fields:   []ir.Field{{Name: "value", Number: 1, Kind: ir.KindString, GoEncode: true}},
+			},
+		},
+		Enums: []ir.Enum{{
+			Name:     "OuterColor",
+			FullName: "example.Outer.Color",
+			Values:   []ir.EnumValue{{Name: "COLOR_UNSPECIFIED", Number: 0}},
+		}},
+	}
+
+	_, err := Generator{}.Generate([]ir.File{file}, generate.Options{GoOut: "gen/go"})
+	if err == nil {
+		t.Fatal("expected type name collision error")
+	}
+	want := "go type name OuterColor in package example is generated for both message example.Outer_Color and enum example.Outer.Color"
+	if err.Error() != want {
+		t.Fatalf("expected %q, got: %v", want, err)
+	}
+}
+
+func TestGoGeneratorPrefixesUtilIdentifiers(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Reply",
+			FullName: "example.Reply",
+			Fields:   []ir.Field{{Name: "count", Number: 1, Kind: ir.KindInt32, GoEncode: true}},
+		}},
+	}
+
+	outputs, err := Generator{}.Generate([]ir.File{file}, generate.Options{GoOut: "gen/go", GoUtilPrefix: "cp_"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	byName := make(map[string]string)
+	for _, output := range outputs {
+		byName[output.Path] = string(output.Content)
+	}
+	util, model := byName["gen/go/util.gen.go"], byName["gen/go/model.gen.go"]
+	if !strings.Contains(util, "func cp_AppendInt32Field(") || strings.Contains(util, "func AppendInt32Field(") {
+		t.Errorf("expected util.gen.go to declare only cp_AppendInt32Field\n%s", util)
+	}
+	if !strings.Contains(model, "cp_AppendInt32Field(") || strings.Contains(model, " AppendInt32Field(") {
+		t.Errorf("expected model.gen.go to call cp_AppendInt32Field\n%s", model)
+	}
+	if !strings.Contains(model, "func (m *Reply) Encode() []byte") {
+		t.Errorf("expected model API to keep its names\n%s", model)
+	}
+
+	if _, err := (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "gen/go", GoUtilPrefix: "cp-"}); err == nil {
+		t.Fatal("expected error for a prefix that is not a Go identifier")
+	}
+}
+
+func TestGoGeneratorRejectsGeneratedFuncNameConflicts(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Enums:     []ir.Enum{{Name: "Color", FullName: "example.Color", Values: []ir.EnumValue{{Name: "COLOR_UNSPECIFIED", Number: 0}}}},
+		Messages: []ir.Message{
+			{Name: "ColorValues", FullName: "example.ColorValues"},
+			{Name: "Report", FullName: "example.Report"},
+			{Name: "DiffReport", FullName: "example.DiffReport"},
+		},
+	}
+	if _, err := (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out"}); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	_, err := (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out", GoEnumValues: true})
+	if want := "go ColorValues function for enum example.Color conflicts with message example.ColorValues"; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+	_, err = (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out", GoDiff: true})
+	if want := "go DiffReport function for message example.Report conflicts with message example.DiffReport"; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
+
+func TestGoGeneratorRejectsSetterFieldConflict(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Foo",
+			FullName: "example.Foo",
+			Fields: []ir.Field{
+				{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+				{Name: "set_name", Number: 2, Kind: ir.KindString, GoEncode: true},
+			},
+		}},
+	}}
+	if _, err := (Generator{}).Generate(files, generate.Options{GoOut: "out"}); err != nil {
+		t.Fatalf("expected no conflict without GoSetters: %v", err)
+	}
+	_, err := (Generator{}).Generate(files, generate.Options{GoOut: "out", GoSetters: true})
+	if err == nil || !strings.Contains(err.Error(), "SetName") {
+		t.Fatalf("expected SetName conflict error, got %v", err)
+	}
+}
+
+func TestGoGeneratorRejectsDecodeFieldsConflict(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "Event", FullName: "example.Event"},
+			{Name: "EventFields", FullName: "example.EventFields"},
+		},
+	}}
+	if _, err := (Generator{}).Generate(files, generate.Options{GoOut: "out"}); err != nil {
+		t.Fatalf("expected no conflict without GoDecodeFields: %v", err)
+	}
+	_, err := (Generator{}).Generate(files, generate.Options{GoOut: "out", GoDecodeFields: true})
+	if err == nil || !strings.Contains(err.Error(), "DecodeEventFields") {
+		t.Fatalf("expected DecodeEventFields conflict error, got %v", err)
+	}
+}
+
+func TestGoGeneratorRejectsSortedRangeConflict(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Event",
+			FullName: "example.Event",
+			Fields: []ir.Field{
+				{Name: "tags", Number: 1, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindString, GoEncode: true},
+				{Name: "range_tags_sorted", Number: 2, Kind: ir.KindBool, GoEncode: true},
+			},
+		}},
+	}}
+	if _, err := (Generator{}).Generate(files, generate.Options{GoOut: "out"}); err != nil {
+		t.Fatalf("expected no conflict without GoSortedRange: %v", err)
+	}
+	_, err := (Generator{}).Generate(files, generate.Options{GoOut: "out", GoSortedRange: true})
+	if err == nil || !strings.Contains(err.Error(), "RangeTagsSorted") {
+		t.Fatalf("expected RangeTagsSorted conflict error, got %v", err)
+	}
+}
+
+func TestGoGeneratorRejectsImmutableGetterConflict(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:        "Imm",
+			FullName:    "example.Imm",
+			GoImmutable: true,
+			Fields:      []ir.Field{{Name: "hash", Number: 1, Kind: ir.KindString, GoEncode: true}},
+		}},
+	}}
+	if _, err := (Generator{}).Generate(files, generate.Options{GoOut: "out"}); err != nil {
+		t.Fatalf("expected no conflict without GoHash: %v", err)
+	}
+	_, err := (Generator{}).Generate(files, generate.Options{GoOut: "out", GoHash: true})
+	if want := "go Hash method conflicts with getter of message example.Imm"; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
+
+func TestGoGeneratorRejectsDecodeNConflict(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "Item", FullName: "example.Item"},
+			{Name: "ItemN", FullName: "example.ItemN"},
+		},
+	}}
+	_, err := (Generator{}).Generate(files, generate.Options{GoOut: "out"})
+	if want := "go DecodeItemN function for message example.ItemN conflicts with function for message example.Item"; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
+
+func TestGoGeneratorRejectsMessagesNamedAfterUtilTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		options generate.Options
+		want    string
+	}{
+		{"DecodeOptions", generate.Options{}, "type in util.gen.go"},
+		{"DynField", generate.Options{}, "type in util.gen.go"},
+		{"Arena", generate.Options{GoArena: true}, "type for -go.arena"},
+		{"Visitor", generate.Options{GoVisitor: true}, "type for -go.visitor"},
+		{"FieldDiff", generate.Options{GoDiff: true}, "type for -go.diff"},
+		{"MaskError", generate.Options{GoApplyMask: true}, "type for -go.applymask"},
+		{"UnknownEnum", generate.Options{GoUnknownEnums: true}, "type for -go.unknownenums"},
+		{"TextEncoder", generate.Options{GoTextFormat: true}, "type for -go.textformat"},
+		{"TextDecoder", generate.Options{GoTextFormat: true}, "type for -go.textformat"},
+		{"CanonicalEncodable", generate.Options{GoCanonical: true}, "type for -go.canonical"},
+		{"Decoder", generate.Options{GoDecoder: true}, "type for -go.decoder"},
+		{"JSONEncoder", generate.Options{GoJSONTags: "protojson"}, "type for JSON"},
+		{"JSONDecoder", generate.Options{GoJSONTags: "protojson"}, "type for JSON"},
+	}
+	for _, tt := range tests {
+		files := []ir.File{{
+			GoPackage: "example",
+			Messages:  []ir.Message{{Name: tt.name, FullName: "example." + tt.name}},
+		}}
+		tt.options.GoOut = "out"
+		_, err := (Generator{}).Generate(files, tt.options)
+		if want := "go " + tt.name + " " + tt.want + " conflicts with message example." + tt.name; err == nil || err.Error() != want {
+			t.Errorf("%s: expected %q, got %v", tt.name, want, err)
+		}
+		// -go.utilprefix renames the util type out of the way.
+		tt.options.GoUtilPrefix = "cp_"
+		if _, err := (Generator{}).Generate(files, tt.options); err != nil {
+			t.Errorf("%s: expected -go.utilprefix to avoid the conflict, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestGoGeneratorRejectsDecodeWithOptionsConflict(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "Item", FullName: "example.Item"},
+			{Name: "ItemWithOptions", FullName: "example.ItemWithOptions"},
+		},
+	}}
+	_, err := (Generator{}).Generate(files, generate.Options{GoOut: "out"})
+	if want := "go DecodeItemWithOptions function for message example.ItemWithOptions conflicts with function for message example.Item"; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
+
+func TestGoGeneratorRejectsSizeUpperBoundFieldConflict(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Item",
+			FullName: "example.Item",
+			Fields:   []ir.Field{{Name: "size_upper_bound", Number: 1, Kind: ir.KindInt32, GoEncode: true}},
+		}},
+	}}
+	_, err := (Generator{}).Generate(files, generate.Options{GoOut: "out"})
+	if want := "go SizeUpperBound method conflicts with field of message example.Item"; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
+
+func TestGoGeneratorRejectsBinaryMarshalerFieldConflict(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Item",
+			FullName: "example.Item",
+			Fields:   []ir.Field{{Name: "marshal_binary", Number: 1, Kind: ir.KindBytes, GoEncode: true}},
+		}},
+	}}
+	if _, err := (Generator{}).Generate(files, generate.Options{GoOut: "out"}); err != nil {
+		t.Fatalf("expected no conflict without GoBinaryMarshaler: %v", err)
+	}
+	_, err := (Generator{}).Generate(files, generate.Options{GoOut: "out", GoBinaryMarshaler: true})
+	if want := "go MarshalBinary method conflicts with field of message example.Item"; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
+
+func TestGoGeneratorRejectsLazyFieldWithVisitor(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "Payload", FullName: "example.Payload"},
+			{
+				Name:     "Envelope",
+				FullName: "example.Envelope",
+				Fields: []ir.Field{
+					{Name: "payload", ProtoName: "payload", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Payload", Lazy: true, GoEncode: true},
+				},
+			},
+		},
+	}}
+	if _, err := (Generator{}).Generate(files, generate.Options{GoOut: "out"}); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	_, err := (Generator{}).Generate(files, generate.Options{GoOut: "out", GoVisitor: true})
+	if want := "cp.lazy is not supported with -go.visitor: example.Envelope.payload"; err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
+
+func TestGoGeneratorEnumStringerWithoutMessageStringer(t *testing.T) {
+	file := minimalTestFile()
+	stringReceivers := func(options generate.Options) []string {
+		t.Helper()
+		outputs, err := (Generator{}).Generate([]ir.File{file}, options)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		var receivers []string
+		for _, out := range outputs {
+			f, err := parser.ParseFile(token.NewFileSet(), out.Path, out.Content, 0)
+			if err != nil {
+				t.Fatalf("parse %s: %v", out.Path, err)
+			}
+			for _, decl := range f.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv == nil || fn.Name.Name != "String" {
+					continue
+				}
+				recv := fn.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				receivers = append(receivers, recv.(*ast.Ident).Name)
+			}
+		}
+		return receivers
+	}
+
+	if got := strings.Join(stringReceivers(generate.Options{GoOut: "out", GoEnumStringer: true}), ","); got != "Level" {
+		t.Fatalf("expected String() only on the enum, got %q", got)
+	}
+	if got := strings.Join(stringReceivers(generate.Options{GoOut: "out", GoStringer: true}), ","); got != "Reading" {
+		t.Fatalf("expected String() only on the message, got %q", got)
+	}
+	if got := stringReceivers(generate.Options{GoOut: "out"}); len(got) != 0 {
+		t.Fatalf("expected no String() methods, got %v", got)
+	}
+}
+
+func minimalTestFile() ir.File {
+	return ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Level",
+			FullName: "example.Level",
+			Values:   []ir.EnumValue{{Name: "LEVEL_UNSPECIFIED", Number: 0}, {Name: "LEVEL_HIGH", Number: 1}},
+		}},
+		Messages: []ir.Message{
+			{
+				Name:     "Reading",
+				FullName: "example.Reading",
+				Fields: []ir.Field{
+					{Name: "id", ProtoName: "id", Number: 1, Kind: ir.KindString, GoEncode: true, Constraints: ir.FieldConstraints{Required: true}},
+					{Name: "level", Number: 2, Kind: ir.KindEnum, EnumFullName: "example.Level", GoEncode: true},
+					{Name: "at", Number: 3, Kind: ir.KindMessage, MessageFullName: "google.protobuf.Timestamp", IsTimestamp: true, GoEncode: true},
+					{Name: "took", Number: 4, Kind: ir.KindMessage, MessageFullName: "google.protobuf.Duration", IsDuration: true, GoEncode: true},
+					{Name: "seen", Number: 5, Kind: ir.KindInt64, GoType: "time.Time", GoEncode: true},
+					{Name: "values", Number: 6, Kind: ir.KindDouble, IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "labels", Number: 7, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindString, GoEncode: true},
+					{Name: "children", Number: 8, Kind: ir.KindMessage, MessageFullName: "example.Reading", IsRepeated: true, SortBy: "id", GoEncode: true},
+					{Name: "limit", Number: 9, Kind: ir.KindUint32, IsOptional: true, GoEncode: true},
+				},
+			},
+		},
+		Services: []ir.Service{{
+			Name:    "SensorService",
+			Methods: []ir.Method{{Name: "PostReadingV1", InputFullName: "example.Reading", OutputFullName: "example.Reading"}},
+		}},
+	}
+}
+
+func TestGoMinimalEmitsOnlyAllowedImports(t *testing.T) {
+	outputs, err := (Generator{}).Generate([]ir.File{minimalTestFile()}, generate.Options{GoOut: "out", GoServer: true, GoClient: true, GoEmitSamples: true, GoMinimal: true})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	var paths []string
+	for _, out := range outputs {
+		paths = append(paths, out.Path)
+		f, err := parser.ParseFile(token.NewFileSet(), out.Path, out.Content, parser.ImportsOnly)
+		if err != nil {
+			t.Fatalf("parse %s: %v", out.Path, err)
+		}
+		for _, imp := range f.Imports {
+			if path := strings.Trim(imp.Path.Value, `"`); !goMinimalImports[path] {
+				t.Errorf("%s imports %s, which -go.minimal does not allow", out.Path, path)
+			}
+		}
+	}
+	if got := strings.Join(paths, ","); got != "out/model.gen.go,out/util.gen.go" {
+		t.Fatalf("expected only models and util, got %s", got)
+	}
+}
+
+func TestGoMinimalRejectsUnsupportedFeatures(t *testing.T) {
+	file := minimalTestFile()
+	if _, err := (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out", GoMinimal: true, GoJSONTags: "protojson"}); err == nil || !strings.Contains(err.Error(), "protojson") {
+		t.Fatalf("expected protojson to be rejected, got %v", err)
+	}
+	file.Messages[0].Fields = append(file.Messages[0].Fields, ir.Field{Name: "ref", ProtoName: "ref", Number: 10, Kind: ir.KindBytes, GoType: "github.com/google/uuid.UUID", GoEncode: true})
+	if _, err := (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out", GoMinimal: true}); err == nil || !strings.Contains(err.Error(), "example.Reading.ref") {
+		t.Fatalf("expected a uuid field to be rejected, got %v", err)
+	}
+}
+
+func TestGoGeneratorUsesAnyNotEmptyInterface(t *testing.T) {
+	outputs, err := (Generator{}).Generate([]ir.File{minimalTestFile()}, generate.Options{
+		GoOut:             "out",
+		GoServer:          true,
+		GoClient:          true,
+		GoEmitSamples:     true,
+		GoJSONTags:        "protojson",
+		GoStringer:        true,
+		GoToMap:           true,
+		GoVisitor:         true,
+		GoDecodeFields:    true,
+		GoDecoder:         true,
+		GoUnpackAny:       true,
+		GoTextFormat:      true,
+		GoSortedRange:     true,
+		GoApplyMask:       true,
+		GoPopulatedFields: true,
+		GoArena:           true,
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, out := range outputs {
+		if strings.Contains(string(out.Content), "interface{}") {
+			t.Errorf("%s spells interface{} instead of any\n%s", out.Path, out.Content)
+		}
+	}
+}
+
+func TestGoJSONMessageOptionOverridesProtoJSONTags(t *testing.T) {
+	optOut := false
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "Shared", FullName: "example.Shared", Fields: []ir.Field{{Name: "name", ProtoName: "name", Number: 1, Kind: ir.KindString, GoEncode: true}}},
+			{Name: "Local", FullName: "example.Local", GoJSON: &optOut, Fields: []ir.Field{{Name: "name", ProtoName: "name", Number: 1, Kind: ir.KindString, GoEncode: true}}},
+		},
+	}
+	outputs, err := (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out", GoJSONTags: "protojson"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	model := string(outputs[0].Content)
+	if !strings.Contains(model, "func (m *Shared) MarshalJSON()") || !strings.Contains(model, "func (m *Shared) UnmarshalJSON(") {
+		t.Fatalf("expected Shared to keep MarshalJSON and UnmarshalJSON")
+	}
+	if strings.Contains(model, "func (m *Local) MarshalJSON()") || strings.Contains(model, "func (m *Local) UnmarshalJSON(") || strings.Contains(model, "func (m *Local) appendJSON(") {
+		t.Fatalf("expected cp.go_json = false to drop Local's JSON methods")
+	}
+	if !strings.Contains(model, "`json:\"name,omitempty\"`") {
+		t.Fatalf("expected protojson struct tags to stay")
+	}
+}
+
+func TestGoTagAppendsToJSONTag(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "User", FullName: "example.User", Fields: []ir.Field{
+				{Name: "user_name", ProtoName: "user_name", Number: 1, Kind: ir.KindString, GoEncode: true, GoTag: `db:"user_name" validate:"required"`},
+				{Name: "email", ProtoName: "email", Number: 2, Kind: ir.KindString, GoEncode: true, GoTag: `db:"email"`},
+			}},
+		},
+	}
+	outputs, err := (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out", GoJSONTags: "snake"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	model := string(outputs[0].Content)
+	if !strings.Contains(model, "UserName string `json:\"user_name,omitempty\" db:\"user_name\" validate:\"required\"`") {
+		t.Fatalf("expected json and cp.go_tag content in one tag:\n%s", model)
+	}
+	outputs, err = (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if model := string(outputs[0].Content); !strings.Contains(model, "Email string `db:\"email\"`") {
+		t.Fatalf("expected cp.go_tag alone without json tags:\n%s", model)
+	}
+	file.Messages[0].Fields[1].GoTag = `json:"mail"`
+	if _, err := (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out", GoJSONTags: "snake"}); err == nil || !strings.Contains(err.Error(), "example.User.email") {
+		t.Fatalf("expected a json key clashing with -go.jsontags to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package gogen
+
+import "strings"
+
+// useGoSortedMaps switches map encoding to AppendSortedMap for -go.hash, so
+// Encode, and therefore Hash, no longer depends on map iteration order.
+// WriteTo follows suit, so it writes what Encode returns.
+func useGoSortedMaps(data *goFileData) {
+	for i := range data.Messages {
+		for j, line := range data.Messages[i].EncodeLines {
+			data.Messages[i].EncodeLines[j] = strings.Replace(line, "AppendMap(", "AppendSortedMap(", 1)
+		}
+		for j, line := range data.Messages[i].WriteToLines {
+			data.Messages[i].WriteToLines[j] = strings.Replace(line, "AppendMap(", "AppendSortedMap(", 1)
+		}
+	}
+}
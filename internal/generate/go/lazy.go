@@ -0,0 +1,79 @@
+package gogen
+
+import (
+	"fmt"
+
+	"github.com/jptrs93/cleanproto/internal/generate"
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// goLazyField is a cp.lazy message field. Decode copies its bytes into the
+// unexported Raw field, and the Getter method decodes them into the
+// exported Field on first use.
+type goLazyField struct {
+	Field  string
+	Raw    string
+	Type   string
+	Getter string
+}
+
+// goLazyRawName returns the unexported struct field holding the undecoded
+// bytes of the cp.lazy field named name.
+func goLazyRawName(name string) string {
+	return "lazy" + name
+}
+
+func buildGoLazyFields(msg ir.Message, msgIndex map[string]ir.Message) []goLazyField {
+	var out []goLazyField
+	for _, field := range goVisibleFields(msg.Fields) {
+		if !field.Lazy {
+			continue
+		}
+		name := goStructFieldName(msg, field)
+		out = append(out, goLazyField{
+			Field:  name,
+			Raw:    goLazyRawName(name),
+			Type:   msgIndex[field.MessageFullName].Name,
+			Getter: "Get" + name,
+		})
+	}
+	return out
+}
+
+// checkGoLazyFields rejects cp.lazy fields that other generated code would
+// read as unset before their Get<Field> accessor decodes them.
+func checkGoLazyFields(files []ir.File, jsonMsgs, validateNeeds map[string]bool, options generate.Options) error {
+	for _, file := range files {
+		for _, msg := range file.Messages {
+			for _, field := range goVisibleFields(msg.Fields) {
+				if !field.Lazy {
+					continue
+				}
+				name := msg.FullName + "." + field.ProtoName
+				switch {
+				case msg.GoImmutable:
+					return fmt.Errorf("cp.lazy is not supported in cp.go_immutable messages: %s", name)
+				case jsonMsgs[msg.FullName]:
+					return fmt.Errorf("cp.lazy is not supported with protojson MarshalJSON: %s", name)
+				case options.GoStringer:
+					return fmt.Errorf("cp.lazy is not supported with -go.stringer: %s", name)
+				case options.GoToMap:
+					return fmt.Errorf("cp.lazy is not supported with -go.tomap: %s", name)
+				case options.GoApplyMask:
+					return fmt.Errorf("cp.lazy is not supported with -go.applymask: %s", name)
+				case options.GoTextFormat:
+					return fmt.Errorf("cp.lazy is not supported with -go.textformat: %s", name)
+				case options.GoCanonical:
+					return fmt.Errorf("cp.lazy is not supported with -go.canonical: %s", name)
+				case options.GoDiff:
+					return fmt.Errorf("cp.lazy is not supported with -go.diff: %s", name)
+				case options.GoVisitor:
+					return fmt.Errorf("cp.lazy is not supported with -go.visitor: %s", name)
+				case !options.GoMinimal && (!field.Constraints.IsEmpty() || validateNeeds[field.MessageFullName]):
+					return fmt.Errorf("cp.lazy is not supported on validated fields: %s", name)
+				}
+			}
+		}
+	}
+	return nil
+}
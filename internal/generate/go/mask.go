@@ -0,0 +1,134 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// buildGoMaskLines returns the bodies of a message's check<Msg>Mask function,
+// which rejects FieldMask paths ApplyMask cannot apply, and its applyMask
+// method, which zeroes the fields the grouped paths leave out. Paths may only
+// continue into singular message fields; other fields are kept or zeroed
+// whole.
+func buildGoMaskLines(msg ir.Message, fields []goField, visible []ir.Field, msgIndex map[string]ir.Message) (checkLines, applyLines []string) {
+	var leaves []string
+	var nested []string
+	applyLines = []string{"if m == nil {", "\treturn", "}"}
+	if len(visible) > 0 {
+		applyLines = append(applyLines, "var zero "+msg.Name)
+	}
+	for i, field := range visible {
+		name := fields[i].Name
+		key := field.ProtoName
+		if key == "" {
+			key = field.Name
+		}
+		child, ok := goMaskChild(field, msgIndex)
+		if !ok {
+			leaves = append(leaves, fmt.Sprintf("%q", key))
+			applyLines = append(applyLines,
+				fmt.Sprintf("if _, ok := keep[%q]; !ok {", key),
+				fmt.Sprintf("\tm.%s = zero.%s", name, name),
+				"}")
+			continue
+		}
+		nested = append(nested,
+			fmt.Sprintf("case %q:", key),
+			"\tif nested {",
+			fmt.Sprintf("\t\tif err := check%sMask(prefix+%q, []string{rest}); err != nil {", child.Name, key+"."),
+			"\t\t\treturn err",
+			"\t\t}",
+			"\t}")
+		applyLines = append(applyLines,
+			fmt.Sprintf("if sub, ok := keep[%q]; !ok {", key),
+			fmt.Sprintf("\tm.%s = zero.%s", name, name),
+			"} else if sub != nil {",
+			fmt.Sprintf("\tm.%s.applyMask(groupMaskPaths(sub))", name),
+			"}")
+	}
+
+	cut := "field, rest, nested := cutMaskPath(path)"
+	switch {
+	case len(leaves) == 0 && len(nested) == 0:
+		cut = "field, _, _ := cutMaskPath(path)"
+	case len(nested) == 0:
+		cut = "field, _, nested := cutMaskPath(path)"
+	}
+	checkLines = []string{"for _, path := range paths {", "\t" + cut, "\tswitch field {"}
+	if len(leaves) > 0 {
+		checkLines = append(checkLines,
+			"\tcase "+strings.Join(leaves, ", ")+":",
+			"\t\tif nested {",
+			`			return &MaskError{Path: prefix + path, Reason: "selects inside a field that is not a message"}`,
+			"\t\t}")
+	}
+	for _, line := range nested {
+		checkLines = append(checkLines, "\t"+line)
+	}
+	checkLines = append(checkLines,
+		"\tdefault:",
+		`		return &MaskError{Path: prefix + path, Reason: "no such field"}`,
+		"\t}",
+		"}",
+		"return nil")
+	return checkLines, applyLines
+}
+
+// goMaskChild returns the message a FieldMask path may continue into from
+// field: a singular message field held as a generated, mutable message.
+func goMaskChild(field ir.Field, msgIndex map[string]ir.Message) (ir.Message, bool) {
+	if field.Kind != ir.KindMessage || field.IsRepeated || field.IsMap || field.IsTimestamp || field.IsDuration || field.GoType != "" {
+		return ir.Message{}, false
+	}
+	child, ok := msgIndex[field.MessageFullName]
+	if !ok || child.GoImmutable {
+		return ir.Message{}, false
+	}
+	return child, true
+}
+
+const maskUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+// MaskError is returned by ApplyMask for a FieldMask path naming no field, or
+// continuing past a field that is not a singular message.
+type MaskError struct {
+	Path   string
+	Reason string
+}
+
+func (e *MaskError) Error() string {
+	return "field mask path " + e.Path + ": " + e.Reason
+}
+
+// cutMaskPath splits a FieldMask path at its first dot.
+func cutMaskPath(path string) (field, rest string, nested bool) {
+	for i := 0; i < len(path); i++ {
+		if path[i] == '.' {
+			return path[:i], path[i+1:], true
+		}
+	}
+	return path, "", false
+}
+
+// groupMaskPaths groups FieldMask paths by the field they start with, mapping
+// it to the rest of each path. A field named on its own maps to nil and is
+// kept whole, whatever deeper paths also name it.
+func groupMaskPaths(paths []string) map[string][]string {
+	groups := make(map[string][]string, len(paths))
+	for _, path := range paths {
+		field, rest, nested := cutMaskPath(path)
+		sub, seen := groups[field]
+		switch {
+		case !nested:
+			groups[field] = nil
+		case !seen || sub != nil:
+			groups[field] = append(sub, rest)
+		}
+	}
+	return groups
+}
+`
@@ -0,0 +1,142 @@
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strconv"
+
+	"github.com/jptrs93/cleanproto/internal/generate"
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// goMinimalImports is every import -go.minimal output may use: small
+// standard packages that TinyGo supports, with no reflect-based code such as
+// fmt, sort or encoding/json.
+var goMinimalImports = map[string]bool{
+	"bytes":        true,
+	"cmp":          true,
+	"errors":       true,
+	"io":           true,
+	"math":         true,
+	"slices":       true,
+	"strconv":      true,
+	"sync":         true,
+	"time":         true,
+	"unicode/utf8": true,
+}
+
+// goMinimalOptions applies the -go.minimal profile: only models and
+// util.gen.go are generated, dropping the net/http server and client stubs
+// and the sample test helpers. Validation is skipped separately.
+func goMinimalOptions(files []ir.File, options generate.Options) (generate.Options, error) {
+	if options.GoJSONTags == "protojson" {
+		return options, fmt.Errorf("-go.jsontags protojson is not supported with -go.minimal")
+	}
+	if options.GoStringer {
+		return options, fmt.Errorf("-go.stringer is not supported with -go.minimal")
+	}
+	if options.GoTextFormat {
+		return options, fmt.Errorf("-go.textformat is not supported with -go.minimal")
+	}
+	if options.GoReadDelimited {
+		return options, fmt.Errorf("-go.readdelimited is not supported with -go.minimal")
+	}
+	if options.GoUnpackAny {
+		return options, fmt.Errorf("-go.unpackany is not supported with -go.minimal")
+	}
+	for _, file := range files {
+		for _, msg := range file.Messages {
+			if msg.GoJSON != nil && *msg.GoJSON {
+				return options, fmt.Errorf("cp.go_json is not supported with -go.minimal: %s", msg.FullName)
+			}
+			for _, field := range msg.Fields {
+				if !field.GoIgnore && field.GoType == "github.com/google/uuid.UUID" {
+					return options, fmt.Errorf("cp.go_type uuid is not supported with -go.minimal: %s.%s", msg.FullName, field.ProtoName)
+				}
+			}
+		}
+	}
+	options.GoServer = false
+	options.GoClient = false
+	options.GoEmitSamples = false
+	return options, nil
+}
+
+// stripGoUtilUUID removes the uuid helpers and import from util.gen.go, so
+// -go.minimal output does not depend on github.com/google/uuid.
+func stripGoUtilUUID(content []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "util.gen.go", content, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var removed []ast.Decl
+	decls := file.Decls[:0]
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			specs := gen.Specs[:0]
+			for _, spec := range gen.Specs {
+				if path, _ := strconv.Unquote(spec.(*ast.ImportSpec).Path.Value); path != "github.com/google/uuid" {
+					specs = append(specs, spec)
+				}
+			}
+			gen.Specs = specs
+		} else if goDeclUsesPackage(decl, "uuid") {
+			removed = append(removed, decl)
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	file.Decls = decls
+	// Drop the comments of removed declarations, including their docs.
+	comments := file.Comments[:0]
+	for _, group := range file.Comments {
+		keep := true
+		for _, decl := range removed {
+			start := decl.Pos()
+			if doc := goDeclDoc(decl); doc != nil {
+				start = doc.Pos()
+			}
+			if group.Pos() >= start && group.End() <= decl.End() {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			comments = append(comments, group)
+		}
+	}
+	file.Comments = comments
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func goDeclUsesPackage(decl ast.Decl, pkg string) bool {
+	found := false
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Name == pkg {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+func goDeclDoc(decl ast.Decl) *ast.CommentGroup {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return d.Doc
+	case *ast.GenDecl:
+		return d.Doc
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package gogen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"slices"
+
+	"github.com/jptrs93/cleanproto/internal/generate"
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// goMessageFuncs returns the package-level functions generated for msg, which
+// share the package namespace with every generated type and function.
+func goMessageFuncs(msg ir.Message, options generate.Options) []string {
+	funcs := []string{"Decode" + msg.Name, "Decode" + msg.Name + "WithOptions", "Decode" + msg.Name + "N"}
+	add := func(ok bool, name string) {
+		if ok {
+			funcs = append(funcs, name)
+		}
+	}
+	add(msg.GoImmutable, "New"+msg.Name)
+	add(options.GoDiff, "Diff"+msg.Name)
+	add(options.GoTextFormat, "Parse"+msg.Name+"Text")
+	add(options.GoReadDelimited, "ReadDelimited"+msg.Name)
+	add(options.GoDecodeFields, "Decode"+msg.Name+"Fields")
+	add(options.GoArena, "Decode"+msg.Name+"WithArena")
+	return funcs
+}
+
+// goEnumFuncs returns the package-level functions generated for enum.
+func goEnumFuncs(enum ir.Enum, options generate.Options) []string {
+	if options.GoEnumValues {
+		return []string{enum.Name + "Values"}
+	}
+	return nil
+}
+
+// goUtilFile is a util file Generate adds to the util package, described by
+// the flag that adds it.
+type goUtilFile struct {
+	source string
+	desc   string
+}
+
+// goUtilFiles returns the util files Generate adds for options, each next to
+// the flag that adds it. jsonUtil reports whether json_util.gen.go is added,
+// which cp.go_json messages can do without a flag.
+func goUtilFiles(files []ir.File, keepMsgs map[string]bool, options generate.Options, jsonUtil bool) ([]goUtilFile, error) {
+	util, err := loadUtilSource("util")
+	if err != nil {
+		return nil, err
+	}
+	if options.GoMinimal {
+		if util, err = stripGoUtilUUID(util); err != nil {
+			return nil, err
+		}
+	}
+	utilFiles := []goUtilFile{{source: string(util), desc: "in util.gen.go"}}
+	add := func(ok bool, source, desc string) {
+		if ok {
+			utilFiles = append(utilFiles, goUtilFile{source: source, desc: desc})
+		}
+	}
+	var services bool
+	for _, file := range files {
+		services = services || len(file.Services) > 0
+	}
+	add(services && (options.GoServer || options.GoClient), muxUtilSource, "in mux_util.gen.go")
+	add(options.GoArena, arenaUtilSource, "for -go.arena")
+	add(options.GoApplyMask, maskUtilSource, "for -go.applymask")
+	add(options.GoUnknownEnums, unknownEnumsUtilSource, "for -go.unknownenums")
+	add(options.GoSortedRange, sortedRangeUtilSource, "for -go.sortedrange")
+	add(options.GoDecoder, decoderUtilSource, "for -go.decoder")
+	add(options.GoVisitor, visitorUtilSource, "for -go.visitor")
+	add(options.GoCanonical, canonicalUtilSource, "for -go.canonical")
+	add(options.GoDiff, diffUtilSource, "for -go.diff")
+	add(options.GoTextFormat, textFormatUtilSource, "for -go.textformat")
+	if options.GoUnpackAny {
+		registry, ok := buildGoAnyRegistry(files, keepMsgs, "util")
+		add(ok, registry, "for -go.unpackany")
+	}
+	add(options.GoReadDelimited, delimitedUtilSource, "for -go.readdelimited")
+	add(jsonUtil, jsonUtilSource, "for JSON")
+	return utilFiles, nil
+}
+
+// goUtilNames returns the package-level names source declares, each with
+// its kind.
+func goUtilNames(source string) (map[string]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "util.gen.go", source, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names[d.Name.Name] = "function"
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names[s.Name.Name] = "type"
+				case *ast.ValueSpec:
+					kind := "variable"
+					if d.Tok == token.CONST {
+						kind = "constant"
+					}
+					for _, name := range s.Names {
+						if name.Name != "_" {
+							names[name.Name] = kind
+						}
+					}
+				}
+			}
+		}
+	}
+	return names, nil
+}
+
+// checkGoPackageNames rejects a generated package-level function whose name
+// is taken by a message, an enum, a util declaration or a function generated
+// for another type in the same package, such as DecodeItemN for both message
+// Item and message ItemN, and a message or enum named after a util
+// declaration, such as Arena under -go.arena.
+func checkGoPackageNames(files []ir.File, keepMsgs, keepEnums map[string]bool, options generate.Options, jsonUtil bool) error {
+	names := make(map[string]map[string]string)
+	declare := func(pkg, name, desc string) error {
+		if names[pkg] == nil {
+			names[pkg] = make(map[string]string)
+		}
+		// A type in several files of a package is declared once per file.
+		if other, ok := names[pkg][name]; ok && other != desc {
+			return fmt.Errorf("go %s %s conflicts with %s", name, desc, other)
+		}
+		names[pkg][name] = desc
+		return nil
+	}
+	// Messages and enums sharing a name are left to
+	// checkGoTypeNameCollisions, so their errors are ignored here.
+	for _, file := range files {
+		for _, msg := range file.Messages {
+			if keepMsgs == nil || keepMsgs[msg.FullName] {
+				_ = declare(file.GoPackage, msg.Name, "message "+msg.FullName)
+			}
+		}
+		for _, enum := range file.Enums {
+			if keepEnums == nil || keepEnums[enum.FullName] {
+				_ = declare(file.GoPackage, enum.Name, "enum "+enum.FullName)
+			}
+		}
+	}
+	// The util files all go in the first file's package. -go.utilprefix
+	// renames everything they declare, so they cannot conflict.
+	if len(files) > 0 && options.GoUtilPrefix == "" {
+		utilFiles, err := goUtilFiles(files, keepMsgs, options, jsonUtil)
+		if err != nil {
+			return err
+		}
+		for _, util := range utilFiles {
+			utilNames, err := goUtilNames(util.source)
+			if err != nil {
+				return err
+			}
+			for _, name := range slices.Sorted(maps.Keys(utilNames)) {
+				if err := declare(files[0].GoPackage, name, utilNames[name]+" "+util.desc); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, file := range files {
+		for _, enum := range file.Enums {
+			if keepEnums != nil && !keepEnums[enum.FullName] {
+				continue
+			}
+			for _, name := range goEnumFuncs(enum, options) {
+				if err := declare(file.GoPackage, name, "function for enum "+enum.FullName); err != nil {
+					return err
+				}
+			}
+		}
+		for _, msg := range file.Messages {
+			if keepMsgs != nil && !keepMsgs[msg.FullName] {
+				continue
+			}
+			for _, name := range goMessageFuncs(msg, options) {
+				if err := declare(file.GoPackage, name, "function for message "+msg.FullName); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// goMessageMethods returns the methods generated on msg for data's options,
+// other than its getters, setters and Range<Field>Sorted methods. validate
+// and audit report whether msg gets Validate and ToAudit.
+func goMessageMethods(data goFileData, msg goMessage, validate, audit bool) []string {
+	methods := []string{"Encode", "SizeUpperBound"}
+	add := func(ok bool, names ...string) {
+		if ok {
+			methods = append(methods, names...)
+		}
+	}
+	add(msg.HasIsZero, "IsZero")
+	add(msg.EncodeErr, "EncodeErr")
+	add(data.Canonical, "CanonicalEncode")
+	add(data.Stringer, "String")
+	add(data.ToMap, "ToMap")
+	add(data.PopulatedFields, "PopulatedFields")
+	add(data.UnknownEnums, "UnknownEnums")
+	add(data.FieldNames, "FieldName")
+	add(data.Visitor, "VisitFields")
+	add(data.TextFormat, "TextString", "appendText", "parseText")
+	add(data.ApplyMask && !msg.Immutable, "ApplyMask")
+	add(data.Hash, "Hash")
+	add(data.BinaryMarshaler, "MarshalBinary", "UnmarshalBinary")
+	add(data.WriterTo, "WriteTo")
+	add(msg.MarshalJSON, "MarshalJSON", "UnmarshalJSON")
+	add(msg.AppendJSON, "appendJSON", "parseJSON")
+	add(validate, "Validate")
+	add(audit, "ToAudit")
+	return methods
+}
+
+// checkGoMessageNames rejects messages where a generated method, getter or
+// setter would share a name with a struct field or with another of them,
+// which Go does not allow. validate and audit hold the messages that get
+// Validate and ToAudit.
+func checkGoMessageNames(data goFileData, validate, audit map[string]bool) error {
+	for _, msg := range data.Messages {
+		names := make(map[string]string)
+		declare := func(name, desc string) error {
+			if other, ok := names[name]; ok {
+				return fmt.Errorf("go %s %s conflicts with %s of message %s", name, desc, other, msg.FullName)
+			}
+			names[name] = desc
+			return nil
+		}
+		for _, field := range msg.Fields {
+			if err := declare(field.Name, "field"); err != nil {
+				return err
+			}
+		}
+		for _, field := range msg.Fields {
+			if field.Getter == "" {
+				continue
+			}
+			if err := declare(field.Getter, "getter"); err != nil {
+				return err
+			}
+		}
+		for _, lazy := range msg.Lazy {
+			if err := declare(lazy.Getter, "getter"); err != nil {
+				return err
+			}
+		}
+		if data.Setters {
+			for _, setter := range msg.Setters {
+				if err := declare(setter.Name, "setter"); err != nil {
+					return err
+				}
+			}
+		}
+		if data.SortedRange {
+			for _, r := range msg.SortedRanges {
+				if err := declare(r.Name, "method"); err != nil {
+					return err
+				}
+			}
+		}
+		for _, method := range goMessageMethods(data, msg, validate[msg.FullName], audit[msg.FullName]) {
+			if err := declare(method, "method"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// buildGoPopulatedLines returns the body of a message's PopulatedFields
+// method. Each field runs its own Encode lines into a scratch buffer and
+// counts as populated when they write anything, so the result follows
+// Encode's presence rules exactly. Singular pointer messages, which Encode
+// writes whenever they are set, are checked without encoding them.
+func buildGoPopulatedLines(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum) ([]string, error) {
+	var lines []string
+	usesBuf := false
+	for _, field := range msg.Fields {
+		if field.GoIgnore || !field.GoEncode {
+			continue
+		}
+		if goPopulatedByPointer(field) {
+			name := "m." + goStructFieldName(msg, field)
+			cond := name + " != nil"
+			if field.Lazy {
+				cond += " || m." + goLazyRawName(goStructFieldName(msg, field)) + " != nil"
+			}
+			lines = append(lines,
+				"if "+cond+" {",
+				fmt.Sprintf("\tnums = append(nums, %d)", field.Number),
+				"}")
+			continue
+		}
+		usesBuf = true
+		single := msg
+		single.Fields = []ir.Field{field}
+		encodeLines, err := buildGoEncodeLines(single, msgIndex, enumIndex)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range encodeLines {
+			lines = append(lines, strings.ReplaceAll(line, "protowire.", ""))
+		}
+		lines = append(lines,
+			"if len(b) > 0 {",
+			fmt.Sprintf("\tnums = append(nums, %d)", field.Number),
+			"\tb = b[:0]",
+			"}")
+	}
+	if usesBuf {
+		lines = append([]string{"var b []byte"}, lines...)
+	}
+	return lines, nil
+}
+
+func goPopulatedByPointer(field ir.Field) bool {
+	return field.Kind == ir.KindMessage && !field.IsRepeated && !field.IsMap && !field.IsTimestamp && !field.IsDuration && !field.IsWrapper && field.GoType == "" && !field.GoValue
+}
@@ -0,0 +1,1099 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// goJSONTag returns the json struct tag for field in the given -go.jsontags
+// style, or "" when the style adds none.
+func goJSONTag(field ir.Field, style string) string {
+	if field.JSONIgnore {
+		return "-"
+	}
+	var tag string
+	switch style {
+	case "snake":
+		tag = toSnakeCase(field.Name)
+	case "protojson":
+		tag = goProtoJSONName(field)
+	default:
+		return ""
+	}
+	if goJSONTagOmitEmpty(field) {
+		tag += ",omitempty"
+	}
+	if style == "protojson" && goJSONQuotedInt(field) {
+		// Matches the quoted 64-bit integers protojson writes, for messages
+		// that leave out MarshalJSON with cp.go_json = false.
+		tag += ",string"
+	}
+	return tag
+}
+
+// goProtoJSONName returns the JSON name protojson uses for field: the
+// json_name option when set, otherwise the lowerCamelCase form protoc derives
+// from the proto field name.
+func goProtoJSONName(field ir.Field) string {
+	if field.JSONName != "" {
+		return field.JSONName
+	}
+	if field.ProtoName == "" {
+		return field.Name
+	}
+	var b []byte
+	var wasUnderscore bool
+	for i := 0; i < len(field.ProtoName); i++ {
+		c := field.ProtoName[i]
+		if c != '_' {
+			if wasUnderscore && 'a' <= c && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+		}
+		wasUnderscore = c == '_'
+	}
+	return string(b)
+}
+
+func goJSONQuotedInt(field ir.Field) bool {
+	if field.IsRepeated || field.IsMap || field.GoType != "" || field.IsTimestamp || field.IsDuration {
+		return false
+	}
+	return goJSONIs64BitKind(field.Kind)
+}
+
+func goJSONIs64BitKind(kind ir.Kind) bool {
+	switch kind {
+	case ir.KindInt64, ir.KindSint64, ir.KindSfixed64, ir.KindUint64, ir.KindFixed64:
+		return true
+	}
+	return false
+}
+
+// goMarshalsJSON reports whether msg gets a MarshalJSON method: its
+// cp.go_json option when set, otherwise whether -go.jsontags is protojson.
+func goMarshalsJSON(msg ir.Message, protoJSON bool) bool {
+	if msg.GoJSON != nil {
+		return *msg.GoJSON
+	}
+	return protoJSON
+}
+
+// computeGoJSONNeeds returns the messages and enums that need an appendJSON
+// method: those that get MarshalJSON and everything their JSON output writes
+// through nested message, enum and map value fields.
+func computeGoJSONNeeds(msgIndex map[string]ir.Message, protoJSON bool) (map[string]bool, map[string]bool) {
+	msgs := map[string]bool{}
+	enums := map[string]bool{}
+	var queue []string
+	add := func(fullName string) {
+		if fullName == "" || msgs[fullName] {
+			return
+		}
+		if _, ok := msgIndex[fullName]; !ok {
+			return
+		}
+		msgs[fullName] = true
+		queue = append(queue, fullName)
+	}
+	for fullName, msg := range msgIndex {
+		if goMarshalsJSON(msg, protoJSON) {
+			add(fullName)
+		}
+	}
+	for len(queue) > 0 {
+		msg := msgIndex[queue[0]]
+		queue = queue[1:]
+		for _, field := range goVisibleFields(msg.Fields) {
+			if field.JSONIgnore {
+				continue
+			}
+			add(field.MessageFullName)
+			add(field.MapValueMessage)
+			if field.EnumFullName != "" {
+				enums[field.EnumFullName] = true
+			}
+			if field.MapValueEnum != "" {
+				enums[field.MapValueEnum] = true
+			}
+		}
+	}
+	return msgs, enums
+}
+
+// buildGoJSONLines returns the body of a message's appendJSON method, which
+// writes the fields protojson.Marshal would emit by default, in declaration
+// order.
+func buildGoJSONLines(msg ir.Message) ([]string, error) {
+	var lines []string
+	for _, field := range goVisibleFields(msg.Fields) {
+		if field.JSONIgnore {
+			continue
+		}
+		fieldExpr := "m." + goStructFieldName(msg, field)
+		lines = append(lines, "if "+goJSONPresentCondition(fieldExpr, field)+" {")
+		lines = append(lines, fmt.Sprintf("\te.Name(%q)", goProtoJSONName(field)))
+		var valueLines []string
+		switch {
+		case field.IsMap:
+			nameLine, err := goJSONMapKeyLine(field.MapKeyKind)
+			if err != nil {
+				return nil, err
+			}
+			keysFunc := "SortedMapKeys"
+			if field.MapKeyKind == ir.KindBool {
+				keysFunc = "SortedBoolMapKeys"
+			}
+			valueLine, err := goJSONWriteKind(field.MapValueKind, fieldExpr+"[k]")
+			if err != nil {
+				return nil, err
+			}
+			valueLines = []string{
+				"e.BeginObject()",
+				"for _, k := range " + keysFunc + "(" + fieldExpr + ") {",
+				"\t" + nameLine,
+				"\t" + valueLine,
+				"}",
+				"e.EndObject()",
+			}
+		case field.IsRepeated && field.IsWrapper:
+			valueLine, err := goJSONWriteValue(field, "*v")
+			if err != nil {
+				return nil, err
+			}
+			zeroLine, err := goJSONWriteValue(field, goJSONZeroValue(field.Kind))
+			if err != nil {
+				return nil, err
+			}
+			// protojson writes a wrapper without a value as its zero value.
+			valueLines = []string{
+				"e.BeginArray()",
+				"for _, v := range " + fieldExpr + " {",
+				"\tif v != nil {",
+				"\t\t" + valueLine,
+				"\t} else {",
+				"\t\t" + zeroLine,
+				"\t}",
+				"}",
+				"e.EndArray()",
+			}
+		case field.IsRepeated:
+			valueLine, err := goJSONWriteValue(field, "v")
+			if err != nil {
+				return nil, err
+			}
+			valueLines = []string{
+				"e.BeginArray()",
+				"for _, v := range " + fieldExpr + " {",
+				"\t" + valueLine,
+				"}",
+				"e.EndArray()",
+			}
+		default:
+			expr := fieldExpr
+			if field.IsOptional {
+				expr = "*" + fieldExpr
+			}
+			valueLine, err := goJSONWriteValue(field, expr)
+			if err != nil {
+				return nil, err
+			}
+			valueLines = []string{valueLine}
+		}
+		for _, line := range valueLines {
+			lines = append(lines, "\t"+line)
+		}
+		lines = append(lines, "}")
+	}
+	return lines, nil
+}
+
+// buildGoJSONParseLines returns the body of a message's parseJSON method,
+// the inverse of appendJSON. Fields are read by their JSON name or their
+// proto field name, as protojson.Unmarshal does, and unknown names are an
+// error. Scalars are read with the expressions the text format parser uses,
+// since JSONDecoder has the same methods for them.
+func buildGoJSONParseLines(fields []goField, visible []ir.Field, enumIndex map[string]ir.Enum) ([]string, error) {
+	lines := []string{"for d.Next() {", "\tswitch d.Name() {"}
+	for i, field := range visible {
+		if field.JSONIgnore {
+			continue
+		}
+		caseLines, err := goJSONParseField(field, "m."+fields[i].Name, fields[i].Type, enumIndex)
+		if err != nil {
+			return nil, err
+		}
+		names := fmt.Sprintf("%q", goProtoJSONName(field))
+		if protoName := fieldProtoName(field); protoName != goProtoJSONName(field) {
+			names += fmt.Sprintf(", %q", protoName)
+		}
+		lines = append(lines, "\tcase "+names+":")
+		for _, line := range caseLines {
+			lines = append(lines, "\t\t"+line)
+		}
+	}
+	return append(lines, "\tdefault:", "\t\td.UnknownField()", "\t}", "}"), nil
+}
+
+// goJSONParseField returns the lines reading the current value, one of
+// field's, of Go type goType, into fieldExpr.
+func goJSONParseField(field ir.Field, fieldExpr, goType string, enumIndex map[string]ir.Enum) ([]string, error) {
+	elemType := strings.TrimPrefix(strings.TrimPrefix(goType, "[]"), "*")
+	var lines []string
+	switch {
+	case field.IsMap:
+		_, value, _ := strings.Cut(strings.TrimPrefix(goType, "map["), "]")
+		keyExpr, err := goJSONReadKey(field.MapKeyKind)
+		if err != nil {
+			return nil, err
+		}
+		var valueLines []string
+		if field.MapValueKind == ir.KindMessage {
+			valueLines = append([]string{goTextNewVar(value)}, goJSONParseMessage("v")...)
+		} else {
+			valueExpr, err := goTextReadKind(field.MapValueKind, value)
+			if err != nil {
+				return nil, err
+			}
+			valueLines = []string{"v := " + valueExpr}
+		}
+		lines = []string{
+			"if " + fieldExpr + " == nil {",
+			"\t" + fieldExpr + " = make(" + goType + ")",
+			"}",
+			"for d.Next() {",
+			"\tk := " + keyExpr,
+		}
+		for _, line := range valueLines {
+			lines = append(lines, "\t"+line)
+		}
+		lines = append(lines, "\t"+fieldExpr+"[k] = v", "}")
+		return goJSONInside("d.Object()", lines), nil
+	case field.Kind == ir.KindMessage && !field.IsTimestamp && !field.IsDuration && field.GoType == "":
+		if field.IsRepeated {
+			lines = append([]string{goTextNewVar(strings.TrimPrefix(goType, "[]"))}, goJSONParseMessage("v")...)
+			lines = append(lines, fieldExpr+" = append("+fieldExpr+", v)")
+			return goJSONInside("d.Array()", goJSONInside("d.Elem()", lines)), nil
+		}
+		if field.GoValue {
+			return goJSONParseMessage(fieldExpr), nil
+		}
+		return []string{
+			"if " + fieldExpr + " == nil {",
+			"\t" + fieldExpr + " = &" + elemType + "{}",
+			"}",
+			"if d.Object() {",
+			"\t" + fieldExpr + ".parseJSON(d)",
+			"}",
+		}, nil
+	}
+	valueExpr, err := goTextParseValue(field, elemType, enumIndex)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case field.IsRepeated && field.IsWrapper:
+		lines = []string{
+			"if d.Null() {",
+			"\t" + fieldExpr + " = append(" + fieldExpr + ", nil)",
+			"\tcontinue",
+			"}",
+			"v := " + valueExpr,
+			fieldExpr + " = append(" + fieldExpr + ", &v)",
+		}
+		return goJSONInside("d.Array()", goJSONInside("d.Elem()", lines)), nil
+	case field.IsRepeated:
+		lines = []string{fieldExpr + " = append(" + fieldExpr + ", " + valueExpr + ")"}
+		return goJSONInside("d.Array()", goJSONInside("d.Elem()", lines)), nil
+	case field.IsOptional || field.IsWrapper:
+		return []string{"v := " + valueExpr, fieldExpr + " = &v"}, nil
+	}
+	return []string{fieldExpr + " = " + valueExpr}, nil
+}
+
+// goJSONReadKey returns the JSONDecoder call reading a map key of kind from
+// the name of the current field.
+func goJSONReadKey(kind ir.Kind) (string, error) {
+	switch kind {
+	case ir.KindString:
+		return "d.Name()", nil
+	case ir.KindBool:
+		return "d.NameBool()", nil
+	case ir.KindInt32, ir.KindSint32, ir.KindSfixed32:
+		return "int32(d.NameInt(32))", nil
+	case ir.KindUint32, ir.KindFixed32:
+		return "uint32(d.NameUint(32))", nil
+	case ir.KindInt64, ir.KindSint64, ir.KindSfixed64:
+		return "d.NameInt(64)", nil
+	case ir.KindUint64, ir.KindFixed64:
+		return "d.NameUint(64)", nil
+	}
+	return "", fmt.Errorf("unsupported map key type: %v", kind)
+}
+
+func goJSONParseMessage(expr string) []string {
+	return []string{"if d.Object() {", "\t" + expr + ".parseJSON(d)", "}"}
+}
+
+// goJSONInside wraps lines in an if or for statement on cond: if for
+// d.Object() and d.Array(), which begin a value, and for for d.Elem().
+func goJSONInside(cond string, lines []string) []string {
+	keyword := "if "
+	if cond == "d.Elem()" {
+		keyword = "for "
+	}
+	out := []string{keyword + cond + " {"}
+	for _, line := range lines {
+		out = append(out, "\t"+line)
+	}
+	return append(out, "}")
+}
+
+// goJSONPresentCondition reports whether protojson would emit field: set
+// optional fields, non-empty lists and maps, and non-zero implicit-presence
+// values. It is the negation of goIsZeroCondition.
+func goJSONPresentCondition(fieldName string, field ir.Field) string {
+	if field.IsMap || field.IsRepeated {
+		return fmt.Sprintf("len(%s) > 0", fieldName)
+	}
+	if field.IsOptional {
+		return fieldName + " != nil"
+	}
+	if goEnumString(field) {
+		return fieldName + ` != ""`
+	}
+	switch field.GoType {
+	case "time.Time":
+		return "!" + fieldName + ".IsZero()"
+	case "time.Duration":
+		return fieldName + " != 0"
+	case "github.com/google/uuid.UUID":
+		return fieldName + " != uuid.Nil"
+	}
+	if field.IsTimestamp {
+		return "!" + fieldName + ".IsZero()"
+	}
+	if field.IsDuration {
+		return fieldName + " != 0"
+	}
+	switch field.Kind {
+	case ir.KindMessage:
+		if field.GoValue {
+			return "!" + fieldName + ".IsZero()"
+		}
+		return fieldName + " != nil"
+	case ir.KindBytes:
+		return fmt.Sprintf("len(%s) > 0", fieldName)
+	case ir.KindString:
+		return fieldName + ` != ""`
+	case ir.KindBool:
+		return fieldName
+	}
+	return fieldName + " != 0"
+}
+
+func goJSONMapKeyLine(kind ir.Kind) (string, error) {
+	switch kind {
+	case ir.KindString:
+		return "e.Name(k)", nil
+	case ir.KindBool:
+		return "e.NameBool(k)", nil
+	case ir.KindInt32, ir.KindSint32, ir.KindSfixed32, ir.KindInt64, ir.KindSint64, ir.KindSfixed64:
+		return "e.NameInt(int64(k))", nil
+	case ir.KindUint32, ir.KindFixed32, ir.KindUint64, ir.KindFixed64:
+		return "e.NameUint(uint64(k))", nil
+	}
+	return "", fmt.Errorf("unsupported map key type: %v", kind)
+}
+
+// goJSONWriteValue returns the JSONEncoder call writing one value of field,
+// converting cp.go_type values back to the proto value they encode as.
+func goJSONWriteValue(field ir.Field, expr string) (string, error) {
+	if goEnumString(field) {
+		return "e.String(" + expr + ")", nil
+	}
+	operand := expr
+	if strings.HasPrefix(expr, "*") {
+		operand = "(" + expr + ")"
+	}
+	switch field.GoType {
+	case "":
+	case "time.Time":
+		switch {
+		case field.IsTimestamp:
+			return "e.Timestamp(" + expr + ")", nil
+		case field.Kind == ir.KindInt32:
+			return "e.Int(" + operand + ".Unix())", nil
+		case field.Kind == ir.KindInt64:
+			return "e.Int64(" + operand + ".UnixMilli())", nil
+		}
+		return "", fmt.Errorf("unsupported cp.go_type conversion for field %s", field.Name)
+	case "time.Duration":
+		switch {
+		case field.IsDuration:
+			return "e.Duration(" + expr + ")", nil
+		case field.Kind == ir.KindInt32:
+			return "e.Int(int64(" + expr + " / time.Second))", nil
+		case field.Kind == ir.KindInt64:
+			return "e.Int64(int64(" + expr + " / time.Second))", nil
+		}
+		return "", fmt.Errorf("unsupported cp.go_type conversion for field %s", field.Name)
+	case "github.com/google/uuid.UUID":
+		if field.Kind == ir.KindBytes {
+			return "e.Bytes(" + operand + "[:])", nil
+		}
+		return "", fmt.Errorf("unsupported cp.go_type conversion for field %s", field.Name)
+	default:
+		raw, err := goCustomRawValueExpr(field, expr)
+		if err != nil {
+			return "", err
+		}
+		return goJSONWriteKind(field.Kind, raw)
+	}
+	if field.IsTimestamp {
+		return "e.Timestamp(" + expr + ")", nil
+	}
+	if field.IsDuration {
+		return "e.Duration(" + expr + ")", nil
+	}
+	return goJSONWriteKind(field.Kind, expr)
+}
+
+// goJSONZeroValue returns the zero value of a scalar kind as a Go literal.
+func goJSONZeroValue(kind ir.Kind) string {
+	switch kind {
+	case ir.KindBool:
+		return "false"
+	case ir.KindString:
+		return `""`
+	case ir.KindBytes:
+		return "nil"
+	}
+	return "0"
+}
+
+func goJSONWriteKind(kind ir.Kind, expr string) (string, error) {
+	switch kind {
+	case ir.KindMessage, ir.KindEnum:
+		if strings.HasPrefix(expr, "*") {
+			expr = "(" + expr + ")"
+		}
+		return expr + ".appendJSON(e)", nil
+	case ir.KindBool:
+		return "e.Bool(" + expr + ")", nil
+	case ir.KindString:
+		return "e.String(" + expr + ")", nil
+	case ir.KindBytes:
+		return "e.Bytes(" + expr + ")", nil
+	case ir.KindInt32, ir.KindSint32, ir.KindSfixed32:
+		return "e.Int(int64(" + expr + "))", nil
+	case ir.KindUint32, ir.KindFixed32:
+		return "e.Uint(uint64(" + expr + "))", nil
+	case ir.KindInt64, ir.KindSint64, ir.KindSfixed64:
+		return "e.Int64(" + expr + ")", nil
+	case ir.KindUint64, ir.KindFixed64:
+		return "e.Uint64(" + expr + ")", nil
+	case ir.KindFloat:
+		return "e.Float(float64(" + expr + "), 32)", nil
+	case ir.KindDouble:
+		return "e.Float(" + expr + ", 64)", nil
+	}
+	return "", fmt.Errorf("unsupported JSON kind: %v", kind)
+}
+
+const jsonUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// JSONEncoder builds the JSON that protojson.Marshal produces with default
+// options, minus its deliberately randomised whitespace. The first error
+// (invalid UTF-8, an out of range Timestamp) is reported by Result.
+type JSONEncoder struct {
+	buf []byte
+	err error
+}
+
+func (e *JSONEncoder) Result() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.buf, nil
+}
+
+// next writes the comma separating a value from the one before it.
+func (e *JSONEncoder) next() {
+	if n := len(e.buf); n > 0 {
+		switch e.buf[n-1] {
+		case '{', '[', ':':
+		default:
+			e.buf = append(e.buf, ',')
+		}
+	}
+}
+
+func (e *JSONEncoder) BeginObject() {
+	e.next()
+	e.buf = append(e.buf, '{')
+}
+
+func (e *JSONEncoder) EndObject() {
+	e.buf = append(e.buf, '}')
+}
+
+func (e *JSONEncoder) BeginArray() {
+	e.next()
+	e.buf = append(e.buf, '[')
+}
+
+func (e *JSONEncoder) EndArray() {
+	e.buf = append(e.buf, ']')
+}
+
+func (e *JSONEncoder) Name(name string) {
+	e.String(name)
+	e.buf = append(e.buf, ':')
+}
+
+func (e *JSONEncoder) NameInt(v int64) {
+	e.Name(strconv.FormatInt(v, 10))
+}
+
+func (e *JSONEncoder) NameUint(v uint64) {
+	e.Name(strconv.FormatUint(v, 10))
+}
+
+func (e *JSONEncoder) NameBool(v bool) {
+	e.Name(strconv.FormatBool(v))
+}
+
+func (e *JSONEncoder) Bool(v bool) {
+	e.next()
+	e.buf = strconv.AppendBool(e.buf, v)
+}
+
+func (e *JSONEncoder) Int(v int64) {
+	e.next()
+	e.buf = strconv.AppendInt(e.buf, v, 10)
+}
+
+func (e *JSONEncoder) Uint(v uint64) {
+	e.next()
+	e.buf = strconv.AppendUint(e.buf, v, 10)
+}
+
+// Int64 writes v as a quoted decimal, since JSON numbers lose precision
+// beyond 2^53.
+func (e *JSONEncoder) Int64(v int64) {
+	e.next()
+	e.buf = append(e.buf, '"')
+	e.buf = strconv.AppendInt(e.buf, v, 10)
+	e.buf = append(e.buf, '"')
+}
+
+func (e *JSONEncoder) Uint64(v uint64) {
+	e.next()
+	e.buf = append(e.buf, '"')
+	e.buf = strconv.AppendUint(e.buf, v, 10)
+	e.buf = append(e.buf, '"')
+}
+
+// Float writes v like encoding/json, with NaN and the infinities as the
+// strings protojson uses for them.
+func (e *JSONEncoder) Float(v float64, bitSize int) {
+	e.next()
+	switch {
+	case math.IsNaN(v):
+		e.buf = append(e.buf, ` + "`" + `"NaN"` + "`" + `...)
+		return
+	case math.IsInf(v, 1):
+		e.buf = append(e.buf, ` + "`" + `"Infinity"` + "`" + `...)
+		return
+	case math.IsInf(v, -1):
+		e.buf = append(e.buf, ` + "`" + `"-Infinity"` + "`" + `...)
+		return
+	}
+	format := byte('f')
+	if abs := math.Abs(v); abs != 0 {
+		if bitSize == 64 && (abs < 1e-6 || abs >= 1e21) ||
+			bitSize == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	e.buf = strconv.AppendFloat(e.buf, v, format, -1, bitSize)
+	if format == 'e' {
+		// Shorten e-09 to e-9, as encoding/json does.
+		n := len(e.buf)
+		if n >= 4 && e.buf[n-4] == 'e' && e.buf[n-3] == '-' && e.buf[n-2] == '0' {
+			e.buf[n-2] = e.buf[n-1]
+			e.buf = e.buf[:n-1]
+		}
+	}
+}
+
+// String writes s escaping only quotes, backslashes and control characters,
+// so unlike encoding/json it leaves <, > and & as they are.
+func (e *JSONEncoder) String(s string) {
+	e.next()
+	e.buf = append(e.buf, '"')
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		switch {
+		case r == utf8.RuneError && n == 1:
+			e.fail(errInvalidUTF8)
+			e.buf = append(e.buf, s[0])
+		case r == '"' || r == '\\':
+			e.buf = append(e.buf, '\\', byte(r))
+		case r < ' ':
+			e.buf = append(e.buf, '\\')
+			switch r {
+			case '\b':
+				e.buf = append(e.buf, 'b')
+			case '\f':
+				e.buf = append(e.buf, 'f')
+			case '\n':
+				e.buf = append(e.buf, 'n')
+			case '\r':
+				e.buf = append(e.buf, 'r')
+			case '\t':
+				e.buf = append(e.buf, 't')
+			default:
+				e.buf = append(e.buf, 'u')
+				e.buf = append(e.buf, "0000"[1+(bits.Len32(uint32(r))-1)/4:]...)
+				e.buf = strconv.AppendUint(e.buf, uint64(r), 16)
+			}
+		default:
+			e.buf = append(e.buf, s[:n]...)
+		}
+		s = s[n:]
+	}
+	e.buf = append(e.buf, '"')
+}
+
+func (e *JSONEncoder) Bytes(v []byte) {
+	e.next()
+	e.buf = append(e.buf, '"')
+	e.buf = base64.StdEncoding.AppendEncode(e.buf, v)
+	e.buf = append(e.buf, '"')
+}
+
+// Timestamp writes t in UTC as RFC 3339 with 0, 3, 6 or 9 fractional digits.
+func (e *JSONEncoder) Timestamp(t time.Time) {
+	if secs := t.Unix(); secs < -62135596800 || secs > 253402300799 {
+		e.fail(fmt.Errorf("timestamp out of range: %v", t))
+	}
+	e.next()
+	e.buf = append(e.buf, '"')
+	e.buf = t.UTC().AppendFormat(e.buf, "2006-01-02T15:04:05")
+	e.buf = appendJSONFraction(e.buf, uint64(t.Nanosecond()))
+	e.buf = append(e.buf, 'Z', '"')
+}
+
+// Duration writes d as seconds with 0, 3, 6 or 9 fractional digits and an s
+// suffix, e.g. "-1.500s".
+func (e *JSONEncoder) Duration(d time.Duration) {
+	e.next()
+	e.buf = append(e.buf, '"')
+	u := uint64(d)
+	if d < 0 {
+		e.buf = append(e.buf, '-')
+		u = -u
+	}
+	e.buf = strconv.AppendUint(e.buf, u/uint64(time.Second), 10)
+	e.buf = appendJSONFraction(e.buf, u%uint64(time.Second))
+	e.buf = append(e.buf, 's', '"')
+}
+
+func (e *JSONEncoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func appendJSONFraction(b []byte, nanos uint64) []byte {
+	if nanos == 0 {
+		return b
+	}
+	digits := 9
+	for nanos%1000 == 0 {
+		nanos /= 1000
+		digits -= 3
+	}
+	b = append(b, '.')
+	s := strconv.FormatUint(nanos, 10)
+	for i := len(s); i < digits; i++ {
+		b = append(b, '0')
+	}
+	return append(b, s...)
+}
+
+// SortedMapKeys returns the keys of m in the order protojson writes map
+// entries: numerically for integer keys and bytewise for strings.
+func SortedMapKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	return slices.Sorted(maps.Keys(m))
+}
+
+func SortedBoolMapKeys[V any](m map[bool]V) []bool {
+	keys := make([]bool, 0, 2)
+	for _, k := range []bool{false, true} {
+		if _, ok := m[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// JSONDecoder reads the JSON MarshalJSON writes, and the other forms
+// protojson.Unmarshal accepts for it, for the generated UnmarshalJSON
+// methods. Its methods each read the current value and record the first
+// error, after which they return zero values and Next reports false.
+type JSONDecoder struct {
+	dec   *json.Decoder
+	outer []jsonDecoderFrame
+	name  string
+	raw   json.RawMessage
+	err   error
+}
+
+type jsonDecoderFrame struct {
+	dec  *json.Decoder
+	name string
+}
+
+func (d *JSONDecoder) Err() error {
+	return d.err
+}
+
+// Object starts reading the current value as an object, whose fields Next
+// then reads. It reports false when the value is null.
+func (d *JSONDecoder) Object() bool {
+	return d.begin('{', "object")
+}
+
+// Array starts reading the current value as an array, whose elements Elem
+// then reads. It reports false when the value is null.
+func (d *JSONDecoder) Array() bool {
+	return d.begin('[', "array")
+}
+
+func (d *JSONDecoder) begin(delim json.Delim, kind string) bool {
+	if d.err != nil || d.Null() {
+		return false
+	}
+	dec := json.NewDecoder(bytes.NewReader(d.raw))
+	if tok, err := dec.Token(); err != nil || tok != delim {
+		d.fail("expected " + kind)
+		return false
+	}
+	d.outer = append(d.outer, jsonDecoderFrame{dec: d.dec, name: d.name})
+	d.dec = dec
+	return true
+}
+
+// Next reads the name and value of the next field of the current object,
+// skipping fields set to null, which protojson reads as unset. It reports
+// false at the end of the object.
+func (d *JSONDecoder) Next() bool {
+	for d.err == nil {
+		if !d.dec.More() {
+			d.end()
+			return false
+		}
+		tok, err := d.dec.Token()
+		if err != nil {
+			d.fail(err.Error())
+			return false
+		}
+		d.name, _ = tok.(string)
+		if err := d.dec.Decode(&d.raw); err != nil {
+			d.fail(err.Error())
+			return false
+		}
+		if !d.Null() {
+			return true
+		}
+	}
+	return false
+}
+
+// Elem reads the next element of the current array, reporting false at its
+// end.
+func (d *JSONDecoder) Elem() bool {
+	if d.err != nil {
+		return false
+	}
+	if !d.dec.More() {
+		d.end()
+		return false
+	}
+	if err := d.dec.Decode(&d.raw); err != nil {
+		d.fail(err.Error())
+		return false
+	}
+	return true
+}
+
+// end reads the closing delimiter of the current object or array and returns
+// to the one holding it.
+func (d *JSONDecoder) end() {
+	if _, err := d.dec.Token(); err != nil {
+		d.fail(err.Error())
+	}
+	frame := d.outer[len(d.outer)-1]
+	d.outer = d.outer[:len(d.outer)-1]
+	d.dec, d.name = frame.dec, frame.name
+}
+
+// Name returns the name of the field Next read, which for a map entry is its
+// key.
+func (d *JSONDecoder) Name() string {
+	return d.name
+}
+
+func (d *JSONDecoder) NameInt(bitSize int) int64 {
+	v, err := strconv.ParseInt(d.name, 10, bitSize)
+	if err != nil {
+		d.fail("invalid map key")
+	}
+	return v
+}
+
+func (d *JSONDecoder) NameUint(bitSize int) uint64 {
+	v, err := strconv.ParseUint(d.name, 10, bitSize)
+	if err != nil {
+		d.fail("invalid map key")
+	}
+	return v
+}
+
+func (d *JSONDecoder) NameBool() bool {
+	v, err := strconv.ParseBool(d.name)
+	if err != nil || d.name != "true" && d.name != "false" {
+		d.fail("invalid map key")
+	}
+	return v
+}
+
+func (d *JSONDecoder) UnknownField() {
+	d.fail("unknown field")
+}
+
+func (d *JSONDecoder) Null() bool {
+	return string(d.raw) == "null"
+}
+
+func (d *JSONDecoder) Bool() bool {
+	switch string(d.raw) {
+	case "true":
+		return true
+	case "false":
+	default:
+		d.fail("expected a bool, got " + string(d.raw))
+	}
+	return false
+}
+
+// Int reads an integer of bitSize bits, written as a number or, as protojson
+// writes 64-bit integers, a quoted number. Exponents are accepted when the
+// value is whole.
+func (d *JSONDecoder) Int(bitSize int) int64 {
+	s, _ := d.scalar("an integer")
+	v, err := strconv.ParseInt(s, 10, bitSize)
+	if err != nil {
+		if f, ferr := strconv.ParseFloat(s, 64); ferr == nil && f == math.Trunc(f) {
+			v, err = strconv.ParseInt(strconv.FormatFloat(f, 'f', -1, 64), 10, bitSize)
+		}
+	}
+	if err != nil {
+		d.fail("invalid integer " + strconv.Quote(s))
+	}
+	return v
+}
+
+func (d *JSONDecoder) Uint(bitSize int) uint64 {
+	s, _ := d.scalar("an integer")
+	v, err := strconv.ParseUint(s, 10, bitSize)
+	if err != nil {
+		if f, ferr := strconv.ParseFloat(s, 64); ferr == nil && f == math.Trunc(f) {
+			v, err = strconv.ParseUint(strconv.FormatFloat(f, 'f', -1, 64), 10, bitSize)
+		}
+	}
+	if err != nil {
+		d.fail("invalid integer " + strconv.Quote(s))
+	}
+	return v
+}
+
+// Float reads a number, a quoted number, or the strings Float writes for NaN
+// and the infinities.
+func (d *JSONDecoder) Float(bitSize int) float64 {
+	s, quoted := d.scalar("a number")
+	if quoted {
+		switch s {
+		case "NaN":
+			return math.NaN()
+		case "Infinity":
+			return math.Inf(1)
+		case "-Infinity":
+			return math.Inf(-1)
+		}
+	}
+	v, err := strconv.ParseFloat(s, bitSize)
+	if err != nil {
+		d.fail("invalid number " + strconv.Quote(s))
+	}
+	return v
+}
+
+// Enum reads an enum value by the name values gives it or by its number.
+func (d *JSONDecoder) Enum(values map[string]int32) int32 {
+	if len(d.raw) == 0 || d.raw[0] != '"' {
+		return int32(d.Int(32))
+	}
+	name := d.String()
+	v, ok := values[name]
+	if !ok {
+		d.fail("unknown enum value " + strconv.Quote(name))
+	}
+	return v
+}
+
+func (d *JSONDecoder) String() string {
+	s, quoted := d.scalar("a string")
+	if !quoted {
+		d.fail("expected a string, got " + string(d.raw))
+		return ""
+	}
+	if !utf8.Valid(d.raw) {
+		d.fail("invalid UTF-8 in string")
+		return ""
+	}
+	return s
+}
+
+// Bytes reads base64 in the standard or URL-safe alphabet, with or without
+// padding.
+func (d *JSONDecoder) Bytes() []byte {
+	s := d.String()
+	enc := base64.StdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.URLEncoding
+	}
+	if len(s)%4 != 0 {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	b, err := enc.DecodeString(s)
+	if err != nil {
+		d.fail("invalid base64 " + strconv.Quote(s))
+	}
+	return b
+}
+
+// UUID reads base64 of 16 bytes.
+func (d *JSONDecoder) UUID() [16]byte {
+	var v [16]byte
+	if b := d.Bytes(); len(b) == len(v) {
+		copy(v[:], b)
+	} else {
+		d.fail("invalid UUID length " + strconv.Itoa(len(b)))
+	}
+	return v
+}
+
+// Timestamp reads an RFC 3339 timestamp, in the local time zone like a
+// decoded one.
+func (d *JSONDecoder) Timestamp() time.Time {
+	s := d.String()
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		d.fail("invalid timestamp " + strconv.Quote(s))
+	}
+	return time.Unix(t.Unix(), int64(t.Nanosecond()))
+}
+
+// Duration reads seconds with an s suffix, e.g. "-1.500s".
+func (d *JSONDecoder) Duration() time.Duration {
+	s := d.String()
+	secs, ok := strings.CutSuffix(s, "s")
+	var v time.Duration
+	var err error
+	if ok && secs != "" && strings.Trim(secs, "-.0123456789") == "" {
+		v, err = time.ParseDuration(s)
+	}
+	if !ok || err != nil {
+		d.fail("invalid duration " + strconv.Quote(s))
+	}
+	return v
+}
+
+// scalar returns the text of the current value, a number or a string, and
+// whether it was quoted.
+func (d *JSONDecoder) scalar(want string) (string, bool) {
+	if d.err != nil {
+		return "", false
+	}
+	if len(d.raw) > 0 && d.raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(d.raw, &s); err != nil {
+			d.fail(err.Error())
+		}
+		return s, true
+	}
+	if len(d.raw) == 0 || d.raw[0] != '-' && (d.raw[0] < '0' || d.raw[0] > '9') {
+		d.fail("expected " + want + ", got " + string(d.raw))
+		return "", false
+	}
+	return string(d.raw), false
+}
+
+func (d *JSONDecoder) fail(msg string) {
+	if d.err != nil {
+		return
+	}
+	if d.name != "" {
+		msg = "field " + strconv.Quote(d.name) + ": " + msg
+	}
+	d.err = errors.New("json: " + msg)
+}
+
+// UnmarshalJSONEnum reads an enum value written either as its name or as its
+// number, both of which protojson accepts.
+func UnmarshalJSONEnum(b []byte, names map[int32]string) (int32, error) {
+	if len(b) > 0 && b[0] == '"' {
+		var name string
+		if err := json.Unmarshal(b, &name); err != nil {
+			return 0, err
+		}
+		for number, n := range names {
+			if n == name {
+				return number, nil
+			}
+		}
+		return 0, errors.New("unknown enum value " + strconv.Quote(name))
+	}
+	var number int32
+	if err := json.Unmarshal(b, &number); err != nil {
+		return 0, err
+	}
+	return number, nil
+}
+`
@@ -0,0 +1,6434 @@
+package gogen
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jptrs93/cleanproto/internal/generate"
+	jsg "github.com/jptrs93/cleanproto/internal/generate/js"
+	"github.com/jptrs93/cleanproto/internal/ir"
+	"github.com/jptrs93/cleanproto/internal/parser"
+
+	// The generated util.gen.go that runGeneratedTest compiles inside this
+	// module imports uuid, so the module must require it.
+	_ "github.com/google/uuid"
+)
+
+// runGeneratedTest generates Go code for files into a scratch package inside the
+// module, adds testSrc alongside it and runs go test there, so the generated
+// encode/decode paths are exercised against real buffers. Benchmarks in testSrc
+// run once to keep them compiling and working. env is appended to the go
+// test environment, e.g. GOARCH=386.
+func runGeneratedTest(t *testing.T, files []ir.File, options generate.Options, testSrc string, env ...string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("compiles generated code")
+	}
+	dir, err := os.MkdirTemp(".", "gentest")
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	options.GoOut = dir
+	outputs, err := Generator{}.Generate(files, options)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	outputs = append(outputs, generate.OutputFile{
+		Path:    filepath.Join(dir, "generated_test.go"),
+		Content: []byte(testSrc),
+	})
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	cmd := exec.Command("go", "test", "-count=1", "-bench=.", "-benchtime=1x", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go test generated package: %v\n%s", err, out)
+	}
+}
+
+func TestGeneratedDecodeAccumulatesInterleavedRepeatedFields(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Batch",
+			FullName: "example.Batch",
+			Fields: []ir.Field{
+				{Name: "names", Number: 1, Kind: ir.KindString, IsRepeated: true},
+				{Name: "title", Number: 2, Kind: ir.KindString},
+				{Name: "values", Number: 3, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true},
+				{Name: "counts", Number: 4, Kind: ir.KindUint64, IsRepeated: true, IsPacked: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+func packedVarints(vs ...uint64) []byte {
+	var packed []byte
+	for _, v := range vs {
+		packed = AppendVarint(packed, v)
+	}
+	return packed
+}
+
+func TestDecodeInterleaved(t *testing.T) {
+	var b []byte
+	b = AppendTag(b, 3, BytesType)
+	b = AppendBytes(b, packedVarints(1, 2))
+	b = AppendStringField(b, "a", 1)
+	b = AppendStringField(b, "first", 2)
+	b = AppendTag(b, 4, BytesType)
+	b = AppendBytes(b, packedVarints(7))
+	b = AppendStringField(b, "b", 1)
+	b = AppendTag(b, 3, BytesType)
+	b = AppendBytes(b, packedVarints(3))
+	b = AppendStringField(b, "last", 2)
+	b = AppendTag(b, 4, BytesType)
+	b = AppendBytes(b, packedVarints(8, 9))
+	b = AppendStringField(b, "c", 1)
+
+	m, err := DecodeBatch(b)
+	if err != nil {
+		t.Fatalf("DecodeBatch: %v", err)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(m.Names, want) {
+		t.Fatalf("names = %v, want %v", m.Names, want)
+	}
+	if want := []int32{1, 2, 3}; !reflect.DeepEqual(m.Values, want) {
+		t.Fatalf("values = %v, want %v", m.Values, want)
+	}
+	if want := []uint64{7, 8, 9}; !reflect.DeepEqual(m.Counts, want) {
+		t.Fatalf("counts = %v, want %v", m.Counts, want)
+	}
+	if m.Title != "last" {
+		t.Fatalf("title = %q, want last value to win", m.Title)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedDecodeNestedMessagesInPlace(t *testing.T) {
+	valueSlice := false
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Point",
+				FullName: "example.Point",
+				Fields: []ir.Field{
+					{Name: "x", Number: 1, Kind: ir.KindInt64, GoEncode: true},
+					{Name: "y", Number: 2, Kind: ir.KindInt64, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Path",
+				FullName: "example.Path",
+				Fields: []ir.Field{
+					{Name: "origin", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Point", GoValue: true, GoEncode: true},
+					{Name: "points", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Point", IsRepeated: true, GoSlicePtr: &valueSlice, GoEncode: true},
+					{Name: "end", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Point", GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import "testing"
+
+func samplePath() []byte {
+	m := Path{Origin: Point{X: 1, Y: 2}, End: &Point{X: 9, Y: 9}}
+	for i := 0; i < 64; i++ {
+		m.Points = append(m.Points, Point{X: int64(i), Y: int64(-i)})
+	}
+	return m.Encode()
+}
+
+func TestDecodePathRoundTrip(t *testing.T) {
+	m, err := DecodePath(samplePath())
+	if err != nil {
+		t.Fatalf("DecodePath: %v", err)
+	}
+	if m.Origin != (Point{X: 1, Y: 2}) || m.End == nil || *m.End != (Point{X: 9, Y: 9}) {
+		t.Fatalf("unexpected singular fields: %+v", m)
+	}
+	if len(m.Points) != 64 || m.Points[63] != (Point{X: 63, Y: -63}) {
+		t.Fatalf("unexpected points: %+v", m.Points)
+	}
+}
+
+func TestDecodePathAllocs(t *testing.T) {
+	b := samplePath()
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := DecodePath(b); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// One allocation per nested message would be at least 64; only the
+	// message itself, the End pointer and slice growth should remain.
+	if allocs > 16 {
+		t.Fatalf("DecodePath allocated %v times per run", allocs)
+	}
+}
+
+func BenchmarkDecodePath(b *testing.B) {
+	buf := samplePath()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodePath(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedImmutableMessage(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Badge",
+				FullName: "example.Badge",
+				Fields:   []ir.Field{{Name: "label", Number: 1, Kind: ir.KindString, GoEncode: true}},
+			},
+			{
+				Name:        "Account",
+				FullName:    "example.Account",
+				GoImmutable: true,
+				Fields: []ir.Field{
+					{Name: "id", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "type", Number: 2, Kind: ir.KindInt32, GoEncode: true},
+					{Name: "tags", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Badge", IsRepeated: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAccountFieldsAreUnexported(t *testing.T) {
+	typ := reflect.TypeOf(Account{})
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).IsExported() {
+			t.Fatalf("field %s is exported", typ.Field(i).Name)
+		}
+	}
+}
+
+func TestAccountRoundTrip(t *testing.T) {
+	in := NewAccount("acc-1", 3, []*Badge{{Label: "a"}, {Label: "b"}})
+	out, err := DecodeAccount(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeAccount: %v", err)
+	}
+	if out.ID() != "acc-1" || out.Type() != 3 {
+		t.Fatalf("unexpected getters: id=%q type=%d", out.ID(), out.Type())
+	}
+	if len(out.Tags()) != 2 || out.Tags()[1].Label != "b" {
+		t.Fatalf("unexpected tags: %+v", out.Tags())
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoJSONTags: "snake"}, testSrc)
+}
+
+func TestGeneratedEnumAliasUsesCanonicalName(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "State",
+			FullName: "example.State",
+			Values: []ir.EnumValue{
+				{Name: "STATE_UNSPECIFIED", Number: 0},
+				{Name: "STATE_RUNNING", Number: 1},
+				{Name: "STATE_STARTED", Number: 1},
+				{Name: "STATE_DONE", Number: 2},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import "testing"
+
+func TestStateAlias(t *testing.T) {
+	if State_STATE_RUNNING != State_STATE_STARTED {
+		t.Fatalf("expected aliased constants to share a value")
+	}
+	if got := State_STATE_STARTED.String(); got != "STATE_RUNNING" {
+		t.Fatalf("String() = %q, want first declared alias", got)
+	}
+	if got := State_STATE_DONE.String(); got != "STATE_DONE" {
+		t.Fatalf("String() = %q, want STATE_DONE", got)
+	}
+	if got := State(7).String(); got != "7" {
+		t.Fatalf("String() = %q, want numeric fallback", got)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoEnumStringer: true}, testSrc)
+}
+
+func TestGeneratedEnumValues(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "State",
+			FullName: "example.State",
+			Values: []ir.EnumValue{
+				{Name: "STATE_UNSPECIFIED", Number: 0},
+				{Name: "STATE_RUNNING", Number: 2},
+				{Name: "STATE_STARTED", Number: 2},
+				{Name: "STATE_DONE", Number: 1},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStateValues(t *testing.T) {
+	want := []State{State_STATE_UNSPECIFIED, State_STATE_RUNNING, State_STATE_DONE}
+	got := StateValues()
+	if !slices.Equal(got, want) {
+		t.Fatalf("StateValues() = %v, want %v", got, want)
+	}
+	got[0] = State_STATE_DONE
+	if StateValues()[0] != State_STATE_UNSPECIFIED {
+		t.Fatalf("expected StateValues to return a fresh slice")
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoEnumValues: true}, testSrc)
+}
+
+func TestGeneratedSamplesArePopulatedAndRoundTrip(t *testing.T) {
+	valueSlice := false
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Color",
+			FullName: "example.Color",
+			Values:   []ir.EnumValue{{Name: "COLOR_UNSPECIFIED", Number: 0}, {Name: "COLOR_RED", Number: 1}, {Name: "COLOR_BLUE", Number: 2}},
+		}},
+		Messages: []ir.Message{
+			{
+				Name:     "Leaf",
+				FullName: "example.Leaf",
+				Fields: []ir.Field{
+					{Name: "label", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "weight", Number: 2, Kind: ir.KindDouble, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Node",
+				FullName: "example.Node",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "children", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Node", IsRepeated: true, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Kitchen",
+				FullName: "example.Kitchen",
+				Fields: []ir.Field{
+					{Name: "flag", Number: 1, Kind: ir.KindBool, GoEncode: true},
+					{Name: "i32", Number: 2, Kind: ir.KindInt32, GoEncode: true},
+					{Name: "s64", Number: 3, Kind: ir.KindSint64, GoEncode: true},
+					{Name: "u32", Number: 4, Kind: ir.KindUint32, GoEncode: true},
+					{Name: "f64", Number: 5, Kind: ir.KindFixed64, GoEncode: true},
+					{Name: "ratio", Number: 6, Kind: ir.KindFloat, GoEncode: true},
+					{Name: "title", Number: 7, Kind: ir.KindString, GoEncode: true},
+					{Name: "blob", Number: 8, Kind: ir.KindBytes, GoEncode: true},
+					{Name: "color", Number: 9, Kind: ir.KindEnum, EnumFullName: "example.Color", GoEncode: true},
+					{Name: "maybe", Number: 10, Kind: ir.KindInt64, IsOptional: true, GoEncode: true},
+					{Name: "tags", Number: 11, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+					{Name: "scores", Number: 12, Kind: ir.KindSint32, IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "colors", Number: 13, Kind: ir.KindEnum, EnumFullName: "example.Color", IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "leaf", Number: 14, Kind: ir.KindMessage, MessageFullName: "example.Leaf", GoEncode: true},
+					{Name: "value_leaf", Number: 15, Kind: ir.KindMessage, MessageFullName: "example.Leaf", GoValue: true, GoEncode: true},
+					{Name: "leaves", Number: 16, Kind: ir.KindMessage, MessageFullName: "example.Leaf", IsRepeated: true, GoSlicePtr: &valueSlice, GoEncode: true},
+					{Name: "by_name", Number: 17, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindMessage, MapValueMessage: "example.Leaf", GoEncode: true},
+					{Name: "by_id", Number: 18, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindInt64, MapValueKind: ir.KindString, GoEncode: true},
+					{Name: "switches", Number: 19, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindBool, MapValueKind: ir.KindEnum, MapValueEnum: "example.Color", GoEncode: true},
+					{Name: "created_at", Number: 20, Kind: ir.KindMessage, MessageFullName: "google.protobuf.Timestamp", IsTimestamp: true, GoEncode: true},
+					{Name: "ttl", Number: 21, Kind: ir.KindMessage, MessageFullName: "google.protobuf.Duration", IsDuration: true, GoEncode: true},
+					{Name: "request_id", Number: 22, Kind: ir.KindBytes, GoType: "github.com/google/uuid.UUID", GoEncode: true},
+					{Name: "seen_at", Number: 23, Kind: ir.KindInt64, GoType: "time.Time", GoEncode: true},
+					{Name: "root", Number: 24, Kind: ir.KindMessage, MessageFullName: "example.Node", GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKitchenSample(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		in := sampleKitchen(seed)
+		v := reflect.ValueOf(in).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).IsZero() {
+				t.Fatalf("seed %d: field %s not populated", seed, v.Type().Field(i).Name)
+			}
+		}
+		if len(in.Root.Children) == 0 || len(in.Root.Children[0].Children) == 0 {
+			t.Fatalf("seed %d: expected nested nodes, got %+v", seed, in.Root)
+		}
+		out, err := DecodeKitchen(in.Encode())
+		if err != nil {
+			t.Fatalf("seed %d: DecodeKitchen: %v", seed, err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatalf("seed %d: round trip mismatch:\nin:  %+v\nout: %+v", seed, in, out)
+		}
+		if !reflect.DeepEqual(in, sampleKitchen(seed)) {
+			t.Fatalf("seed %d: sample is not deterministic", seed)
+		}
+	}
+	if reflect.DeepEqual(sampleKitchen(1), sampleKitchen(2)) {
+		t.Fatalf("expected different seeds to produce different samples")
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoEmitSamples: true}, testSrc)
+}
+
+func TestGeneratedSingleFileCompiles(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Reading",
+				FullName: "example.Reading",
+				Fields: []ir.Field{
+					{Name: "at", Number: 1, Kind: ir.KindMessage, MessageFullName: "google.protobuf.Timestamp", IsTimestamp: true, GoEncode: true},
+					{Name: "value", Number: 2, Kind: ir.KindDouble, GoEncode: true},
+					{Name: "samples", Number: 3, Kind: ir.KindFloat, IsRepeated: true, IsPacked: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	options := generate.Options{GoOut: "out", GoSingleFile: true}
+	outputs, err := Generator{}.Generate([]ir.File{file}, options)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if len(outputs) != 1 || outputs[0].Path != filepath.Join("out", "model.gen.go") {
+		var paths []string
+		for _, out := range outputs {
+			paths = append(paths, out.Path)
+		}
+		t.Fatalf("expected a single model.gen.go, got %v", paths)
+	}
+
+	testSrc := `package example
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadingRoundTrip(t *testing.T) {
+	in := &Reading{At: time.Unix(1700000000, 0), Value: 1.5, Samples: []float32{0.5, 2}}
+	out, err := DecodeReading(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeReading: %v", err)
+	}
+	if !out.At.Equal(in.At) || out.Value != in.Value || len(out.Samples) != 2 {
+		t.Fatalf("unexpected round trip: %+v", out)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, options, testSrc)
+}
+
+func TestGeneratedSplitModelCompiles(t *testing.T) {
+	file := minimalTestFile()
+	// Validation errors and services need the cleanproto options types,
+	// which this hand-built file does not declare.
+	file.Messages[0].Fields[0].Constraints = ir.FieldConstraints{}
+	file.Services = nil
+	options := generate.Options{GoOut: "out", GoSplitModel: true, GoBinaryMarshaler: true}
+	outputs, err := Generator{}.Generate([]ir.File{file}, options)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	contents := map[string]string{}
+	var paths []string
+	for _, out := range outputs {
+		contents[filepath.Base(out.Path)] = string(out.Content)
+		paths = append(paths, out.Path)
+	}
+	if _, ok := contents["model.gen.go"]; ok {
+		t.Fatalf("did not expect model.gen.go with GoSplitModel")
+	}
+	for name, want := range map[string]string{
+		"model_types.gen.go":  "type Reading struct",
+		"model_encode.gen.go": "func (m *Reading) Encode() []byte",
+		"model_decode.gen.go": "func DecodeReading(",
+	} {
+		content, ok := contents[name]
+		if !ok {
+			t.Fatalf("expected %s, got %v", name, paths)
+		}
+		if !strings.Contains(content, want) {
+			t.Fatalf("%s does not contain %q:\n%s", name, want, content)
+		}
+	}
+	if _, err := (Generator{}).Generate([]ir.File{file}, generate.Options{GoOut: "out", GoSplitModel: true, GoSingleFile: true}); err == nil {
+		t.Fatalf("expected -go.splitmodel with -go.singlefile to fail")
+	}
+
+	testSrc := `package example
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadingRoundTrip(t *testing.T) {
+	in := &Reading{
+		ID:     "r1",
+		Level:  Level_LEVEL_HIGH,
+		At:     time.UnixMilli(1700000000123).UTC(),
+		Took:   3 * time.Second,
+		Values: []float64{1.5, 0},
+		Labels: map[string]string{"k": "v"},
+	}
+	out, err := DecodeReading(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeReading: %v", err)
+	}
+	if !out.At.Equal(in.At) {
+		t.Fatalf("At = %v, want %v", out.At, in.At)
+	}
+	out.At, out.Seen = in.At, in.Seen
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch: %+v != %+v", in, out)
+	}
+	if b, err := in.MarshalBinary(); err != nil || !bytes.Equal(b, in.Encode()) {
+		t.Fatalf("MarshalBinary() = %x, %v, want Encode()", b, err)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, options, testSrc)
+}
+
+func TestGeneratedNegativeAndLargeEnumValuesRoundTrip(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Level",
+			FullName: "example.Level",
+			Values: []ir.EnumValue{
+				{Name: "LEVEL_UNSPECIFIED", Number: 0},
+				{Name: "LEVEL_NEGATIVE", Number: -2},
+				{Name: "LEVEL_MAX", Number: 2147483647},
+			},
+		}},
+		Messages: []ir.Message{{
+			Name:     "Gauge",
+			FullName: "example.Gauge",
+			Fields: []ir.Field{
+				{Name: "level", Number: 1, Kind: ir.KindEnum, EnumFullName: "example.Level", GoEncode: true},
+				{Name: "history", Number: 2, Kind: ir.KindEnum, EnumFullName: "example.Level", IsRepeated: true, IsPacked: true, GoEncode: true},
+				{Name: "offset", Number: 3, Kind: ir.KindInt32, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestGaugeEnumValues(t *testing.T) {
+	in := &Gauge{
+		Level:   Level_LEVEL_NEGATIVE,
+		History: []Level{Level_LEVEL_MAX, Level_LEVEL_NEGATIVE},
+		Offset:  -1,
+	}
+	b := in.Encode()
+	// Negative int32 and enum values are sign-extended to 10-byte varints.
+	var want []byte
+	want = AppendTag(want, 1, VarintType)
+	neg := int64(-2)
+	want = AppendVarint(want, uint64(neg))
+	if !bytes.HasPrefix(b, want) || len(want) != 11 {
+		t.Fatalf("expected 10-byte varint for negative enum, got % x", b)
+	}
+	out, err := DecodeGauge(b)
+	if err != nil {
+		t.Fatalf("DecodeGauge: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch: %+v vs %+v", in, out)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedWireEqualIgnoresFieldAndMapOrder(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Inventory",
+			FullName: "example.Inventory",
+			Fields: []ir.Field{
+				{Name: "owner", Number: 1, Kind: ir.KindString, GoEncode: true},
+				{Name: "counts", Number: 2, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindInt64, GoEncode: true},
+				{Name: "ids", Number: 3, Kind: ir.KindInt32, IsRepeated: true, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import "testing"
+
+func entry(key string, value int64) []byte {
+	var e []byte
+	e = AppendStringField(e, key, 1)
+	e = AppendInt64Field(e, value, 2)
+	var b []byte
+	b = AppendTag(b, 2, BytesType)
+	return AppendBytes(b, e)
+}
+
+func TestWireEqual(t *testing.T) {
+	var a []byte
+	a = AppendStringField(a, "alice", 1)
+	a = append(a, entry("apples", 3)...)
+	a = append(a, entry("pears", 5)...)
+	a = AppendInt32Field(a, 7, 3)
+	a = AppendInt32Field(a, 8, 3)
+
+	var b []byte
+	b = AppendInt32Field(b, 7, 3)
+	b = append(b, entry("pears", 5)...)
+	b = AppendInt32Field(b, 8, 3)
+	b = append(b, entry("apples", 3)...)
+	b = AppendStringField(b, "alice", 1)
+
+	if string(a) == string(b) {
+		t.Fatalf("expected test encodings to differ byte-wise")
+	}
+	if !WireEqual(a, b) {
+		t.Fatalf("expected reordered encodings to be wire-equal")
+	}
+	m, err := DecodeInventory(b)
+	if err != nil {
+		t.Fatalf("DecodeInventory: %v", err)
+	}
+	if !WireEqual(a, m.Encode()) {
+		t.Fatalf("expected re-encoded message to be wire-equal")
+	}
+
+	var swapped []byte
+	swapped = AppendStringField(swapped, "alice", 1)
+	swapped = append(swapped, entry("apples", 3)...)
+	swapped = append(swapped, entry("pears", 5)...)
+	swapped = AppendInt32Field(swapped, 8, 3)
+	swapped = AppendInt32Field(swapped, 7, 3)
+	if WireEqual(a, swapped) {
+		t.Fatalf("expected repeated element order to matter")
+	}
+	var changed []byte
+	changed = AppendStringField(changed, "alice", 1)
+	changed = append(changed, entry("apples", 4)...)
+	changed = append(changed, entry("pears", 5)...)
+	changed = AppendInt32Field(changed, 7, 3)
+	changed = AppendInt32Field(changed, 8, 3)
+	if WireEqual(a, changed) {
+		t.Fatalf("expected different map values to be unequal")
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedDecodeDynamicReadsFieldsWithoutSchema(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Bar",
+				FullName: "example.Bar",
+				Fields: []ir.Field{
+					{Name: "label", Number: 1, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Foo",
+				FullName: "example.Foo",
+				Fields: []ir.Field{
+					{Name: "id", Number: 1, Kind: ir.KindInt32, GoEncode: true},
+					{Name: "name", Number: 2, Kind: ir.KindString, GoEncode: true},
+					{Name: "child", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Bar", GoEncode: true},
+					{Name: "ratio", Number: 4, Kind: ir.KindDouble, GoEncode: true},
+					{Name: "scores", Number: 5, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDecodeDynamic(t *testing.T) {
+	b := (&Foo{ID: 150, Name: "hello", Child: &Bar{Label: "x"}, Ratio: 1.5, Scores: []int32{1, 2}}).Encode()
+	fields, err := DecodeDynamic(b)
+	if err != nil {
+		t.Fatalf("DecodeDynamic: %v", err)
+	}
+	want := []struct {
+		num   Number
+		typ   Type
+		value string
+	}{
+		{1, VarintType, "\x96\x01"},
+		{2, BytesType, "hello"},
+		{3, BytesType, "\x0a\x01x"},
+		{4, Fixed64Type, "\x00\x00\x00\x00\x00\x00\xf8\x3f"},
+		{5, BytesType, "\x01\x02"},
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("DecodeDynamic() = %+v, want %d fields", fields, len(want))
+	}
+	for i, w := range want {
+		f := fields[i]
+		if f.Number != w.num || f.Type != w.typ || string(f.Value) != w.value || f.Fields != nil {
+			t.Errorf("field %d = {%d %d %q %v}, want {%d %d %q []}", i, f.Number, f.Type, f.Value, f.Fields, w.num, w.typ, w.value)
+		}
+	}
+
+	nested, err := DecodeDynamicNested(b, 1)
+	if err != nil {
+		t.Fatalf("DecodeDynamicNested: %v", err)
+	}
+	child := nested[2].Fields
+	if len(child) != 1 || child[0].Number != 1 || child[0].Type != BytesType || string(child[0].Value) != "x" || child[0].Fields != nil {
+		t.Fatalf("child fields = %+v, want label \"x\" undecoded", child)
+	}
+
+	var group []byte
+	group = AppendTag(group, 6, StartGroupType)
+	group = AppendTag(group, 1, VarintType)
+	group = AppendVarint(group, 7)
+	group = AppendTag(group, 6, EndGroupType)
+	fields, err = DecodeDynamicNested(group, 1)
+	if err != nil {
+		t.Fatalf("DecodeDynamicNested(group): %v", err)
+	}
+	if len(fields) != 1 || fields[0].Type != StartGroupType || string(fields[0].Value) != "\x08\x07" || len(fields[0].Fields) != 1 || fields[0].Fields[0].Number != 1 {
+		t.Fatalf("group fields = %+v", fields)
+	}
+
+	if _, err := DecodeDynamic(b[:len(b)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("DecodeDynamic(truncated) error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedDecodeMapSizeHint(t *testing.T) {
+	mapField := func(hint int) ir.Field {
+		return ir.Field{Name: "totals", Number: 1, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindInt64, MapValueKind: ir.KindInt64, GoMapSizeHint: hint, GoEncode: true}
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "Sized", FullName: "example.Sized", Fields: []ir.Field{mapField(1024)}},
+			{Name: "Unsized", FullName: "example.Unsized", Fields: []ir.Field{mapField(0)}},
+		},
+	}
+	testSrc := `package example
+
+import "testing"
+
+func sampleTotals() map[int64]int64 {
+	totals := make(map[int64]int64, 1000)
+	for i := int64(0); i < 1000; i++ {
+		totals[i] = -i
+	}
+	return totals
+}
+
+func TestDecodeMapSizeHintRoundTrip(t *testing.T) {
+	buf := (&Sized{Totals: sampleTotals()}).Encode()
+	m, err := DecodeSized(buf)
+	if err != nil {
+		t.Fatalf("DecodeSized: %v", err)
+	}
+	if len(m.Totals) != 1000 || m.Totals[999] != -999 {
+		t.Fatalf("unexpected totals: %d entries", len(m.Totals))
+	}
+}
+
+func TestDecodeMapSizeHintAllocs(t *testing.T) {
+	sizedBuf := (&Sized{Totals: sampleTotals()}).Encode()
+	unsizedBuf := (&Unsized{Totals: sampleTotals()}).Encode()
+	sized := testing.AllocsPerRun(20, func() {
+		if _, err := DecodeSized(sizedBuf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	unsized := testing.AllocsPerRun(20, func() {
+		if _, err := DecodeUnsized(unsizedBuf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// Allocation count must not scale with the number of entries: the map is
+	// allocated once, and with a hint it never needs to grow.
+	if sized > 16 {
+		t.Fatalf("DecodeSized allocated %v times per run", sized)
+	}
+	if sized >= unsized {
+		t.Fatalf("expected size hint to reduce allocations: sized=%v unsized=%v", sized, unsized)
+	}
+}
+
+func BenchmarkDecodeMapWithSizeHint(b *testing.B) {
+	buf := (&Sized{Totals: sampleTotals()}).Encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeSized(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeMapWithoutSizeHint(b *testing.B) {
+	buf := (&Unsized{Totals: sampleTotals()}).Encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeUnsized(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func utf8TestFile() ir.File {
+	return ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Note",
+				FullName: "example.Note",
+				Fields: []ir.Field{
+					{Name: "id", Number: 1, Kind: ir.KindInt32, GoEncode: true},
+					{Name: "text", Number: 2, Kind: ir.KindString, GoEncode: true},
+					{Name: "label", Number: 4, Kind: ir.KindString, IsOptional: true, GoEncode: true},
+					{Name: "tags", Number: 5, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+					{Name: "attrs", Number: 6, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindString, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Thread",
+				FullName: "example.Thread",
+				Fields: []ir.Field{
+					{Name: "first", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Note", GoEncode: true},
+				},
+			},
+		},
+	}
+}
+
+const utf8TestHelpers = `
+func invalidNote() []byte {
+	var b []byte
+	b = AppendInt32Field(b, 7, 1)
+	b = AppendTag(b, 2, BytesType)
+	return AppendBytes(b, []byte{'o', 0xff, 'k'})
+}
+
+func invalidThread() []byte {
+	var b []byte
+	b = AppendTag(b, 3, BytesType)
+	return AppendBytes(b, invalidNote())
+}
+`
+
+func TestGeneratedDecodeRejectsInvalidUTF8(t *testing.T) {
+	testSrc := `package example
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+` + utf8TestHelpers + `
+func TestDecodeInvalidUTF8(t *testing.T) {
+	opts := DecodeOptions{ValidateUTF8: true}
+	_, err := DecodeNoteWithOptions(invalidNote(), opts)
+	if !errors.Is(err, errInvalidUTF8) {
+		t.Fatalf("expected invalid UTF-8 error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Note field 2") {
+		t.Fatalf("expected field context in %q", err)
+	}
+	_, err = DecodeThreadWithOptions(invalidThread(), opts)
+	if !errors.Is(err, errInvalidUTF8) || !strings.Contains(err.Error(), "Thread field 3: Note field 2") {
+		t.Fatalf("expected nested field context, got %v", err)
+	}
+	invalid := func(num Number) []byte {
+		return AppendBytes(AppendTag(nil, num, BytesType), []byte{'o', 0xff, 'k'})
+	}
+	// Optional, repeated and map key strings.
+	for _, b := range [][]byte{invalid(4), invalid(5), AppendBytes(AppendTag(nil, 6, BytesType), invalid(1))} {
+		if _, err := DecodeNoteWithOptions(b, opts); !errors.Is(err, errInvalidUTF8) {
+			t.Fatalf("expected invalid UTF-8 error for %x, got %v", b, err)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{utf8TestFile()}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedDecodeSkipsUTF8Validation(t *testing.T) {
+	testSrc := `package example
+
+import "testing"
+` + utf8TestHelpers + `
+func TestDecodeInvalidUTF8Accepted(t *testing.T) {
+	for _, opts := range []DecodeOptions{{}, {MaxSize: 1 << 20}} {
+		m, err := DecodeThreadWithOptions(invalidThread(), opts)
+		if err != nil {
+			t.Fatalf("DecodeThreadWithOptions(%+v): %v", opts, err)
+		}
+		if m.First == nil || m.First.ID != 7 || m.First.Text != "o\xffk" {
+			t.Fatalf("unexpected decode: %+v", m.First)
+		}
+	}
+	if _, err := DecodeThread(invalidThread()); err != nil {
+		t.Fatalf("DecodeThread: %v", err)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{utf8TestFile()}, generate.Options{}, testSrc)
+}
+
+// TestGeneratedExamplePassesVet generates the library example with every Go
+// output enabled and runs go vet over it, so unused variables or other vet
+// findings in generated code fail here rather than in users' CI.
+func TestGeneratedExamplePassesVet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles generated code")
+	}
+	p := parser.Parser{ImportPaths: []string{"../../..", "../../../example"}}
+	files, err := p.Parse(context.Background(), []string{"library.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	dir, err := os.MkdirTemp(".", "gentest")
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	outputs, err := Generator{}.Generate(files, generate.Options{
+		GoOut:         dir,
+		GoClient:      true,
+		GoServer:      true,
+		GoEmitSamples: true,
+		GoNolint:      "all",
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	// 386 checks the generated code also compiles where int is 32 bits.
+	for _, goarch := range []string{runtime.GOARCH, "386"} {
+		cmd := exec.Command("go", "vet", ".")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOARCH="+goarch)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("go vet generated package (GOARCH=%s): %v\n%s", goarch, err, out)
+		}
+	}
+}
+
+// TestGeneratedCodeRunsOn32BitPlatform round trips 64-bit values and sizes
+// through code built for GOARCH=386, where int is 32 bits.
+func TestGeneratedCodeRunsOn32BitPlatform(t *testing.T) {
+	if runtime.GOARCH != "amd64" || runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		t.Skip("needs a host that runs 386 binaries")
+	}
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "options.proto";
+
+option go_package = "example";
+
+message Wide {
+  int64 i64 = 1;
+  uint64 u64 = 2;
+  sint64 s64 = 3;
+  fixed64 f64 = 4;
+  sfixed64 sf64 = 5;
+  repeated uint32 u32s = 6;
+  map<uint64, int64> totals = 7;
+}
+
+service WideService {
+  rpc GetWideV1(Wide) returns (Wide);
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wide.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"wide.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestWideValuesRoundTrip(t *testing.T) {
+	in := &Wide{
+		I64:    math.MinInt64,
+		U64:    math.MaxUint64,
+		S64:    math.MinInt64,
+		F64:    math.MaxUint64,
+		Sf64:   math.MinInt64,
+		U32s:   []uint32{math.MaxUint32, 0, 1},
+		Totals: map[uint64]int64{math.MaxUint64: math.MaxInt64},
+	}
+	b := in.Encode()
+	if n := in.SizeUpperBound(); n < len(b) {
+		t.Fatalf("SizeUpperBound() = %d, below encoded size %d", n, len(b))
+	}
+	out, err := DecodeWide(b)
+	if err != nil {
+		t.Fatalf("DecodeWide: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("round trip = %+v, want %+v", out, in)
+	}
+}
+
+func TestStreamReaderRejectsFrameBeyondInt(t *testing.T) {
+	// A frame header claiming 2^32 bytes, more than a 32-bit int holds.
+	header := AppendVarint(nil, 1<<32)
+	_, ok, err := NewStreamReader(bytes.NewReader(header), 0).Next()
+	if ok || err == nil {
+		t.Fatalf("Next() = %v, %v, want an error", ok, err)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{GoServer: true}, testSrc, "GOARCH=386")
+}
+
+// TestGeneratedStreamReaderReusesFrameBuffer reads many frames of different
+// sizes through one StreamReader, checks every decoded message survives the
+// reader reusing its buffer, and that Next stops allocating once the buffer
+// has grown to the largest frame.
+func TestGeneratedStreamReaderReusesFrameBuffer(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+package example;
+option go_package = "example";
+
+message Chunk {
+  string name = 1;
+  bytes data = 2;
+  repeated string tags = 3;
+  map<string, bytes> blobs = 4;
+}
+
+service ChunkService {
+  rpc PostChunksV1(stream Chunk) returns (Chunk);
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "chunk.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"chunk.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func chunkFrames(n int) ([]*Chunk, []byte) {
+	var in []*Chunk
+	var stream []byte
+	for i := 0; i < n; i++ {
+		size := (i*37)%300 + 1
+		c := &Chunk{
+			Name: "chunk-" + strconv.Itoa(i),
+			Data: bytes.Repeat([]byte{byte(i)}, size),
+			Tags: []string{strings.Repeat("t", size%11)},
+			Blobs: map[string][]byte{"k" + strconv.Itoa(i): bytes.Repeat([]byte{byte(i + 1)}, size%17+1)},
+		}
+		in = append(in, c)
+		payload := c.Encode()
+		stream = append(AppendVarint(stream, uint64(len(payload))), payload...)
+	}
+	return in, stream
+}
+
+func TestStreamReaderDecodedFramesSurviveReuse(t *testing.T) {
+	in, stream := chunkFrames(200)
+	sr := NewStreamReader(bytes.NewReader(stream), 0)
+	var out []*Chunk
+	for {
+		payload, ok, err := sr.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if !ok {
+			break
+		}
+		c, err := DecodeChunk(payload)
+		if err != nil {
+			t.Fatalf("DecodeChunk: %v", err)
+		}
+		out = append(out, c)
+	}
+	if !reflect.DeepEqual(out, in) {
+		for i := range in {
+			if !reflect.DeepEqual(out[i], in[i]) {
+				t.Fatalf("chunk %d = %+v, want %+v", i, out[i], in[i])
+			}
+		}
+	}
+}
+
+func TestStreamReaderNextDoesNotAllocate(t *testing.T) {
+	payload := (&Chunk{Data: make([]byte, 256)}).Encode()
+	frame := append(AppendVarint(nil, uint64(len(payload))), payload...)
+	r := bytes.NewReader(nil)
+	sr := NewStreamReader(r, 0)
+	next := func() {
+		r.Reset(frame)
+		sr.r.Reset(r)
+		if _, ok, err := sr.Next(); !ok || err != nil {
+			t.Fatalf("Next() = %v, %v", ok, err)
+		}
+	}
+	next()
+	if allocs := testing.AllocsPerRun(100, next); allocs != 0 {
+		t.Fatalf("Next allocated %v times per frame, want 0", allocs)
+	}
+}
+
+func BenchmarkStreamReaderReusesBuffer(b *testing.B) {
+	_, stream := chunkFrames(1000)
+	b.SetBytes(int64(len(stream)))
+	b.ReportAllocs()
+	for b.Loop() {
+		sr := NewStreamReader(bytes.NewReader(stream), 0)
+		for {
+			payload, ok, err := sr.Next()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			_ = payload
+		}
+	}
+}
+
+// BenchmarkStreamReaderAllocatesPerFrame reads the same frames allocating a
+// new payload for each, as Next did before it kept its buffer.
+func BenchmarkStreamReaderAllocatesPerFrame(b *testing.B) {
+	_, stream := chunkFrames(1000)
+	b.SetBytes(int64(len(stream)))
+	b.ReportAllocs()
+	for b.Loop() {
+		r := bufio.NewReader(bytes.NewReader(stream))
+		for {
+			size, err := binary.ReadUvarint(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			payload := make([]byte, size)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{GoServer: true}, testSrc)
+}
+
+// TestGeneratedBoolMapKeysRoundTripWithJS encodes a map<bool, string> in Go,
+// decodes and re-encodes it with the generated JS and decodes the result in
+// Go again, so both sides agree on the varint key and the "true"/"false"
+// object keys.
+func TestGeneratedBoolMapKeysRoundTripWithJS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Flags",
+			FullName: "example.Flags",
+			Fields: []ir.Field{
+				{Name: "labels", Number: 1, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindBool, MapValueKind: ir.KindString, GoEncode: true, JsEncode: true},
+			},
+		}},
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate([]ir.File{file}, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "roundtrip.js"), Content: []byte(`import { decodeFlags, encodeFlags } from './model.js';
+
+const input = Uint8Array.from(Buffer.from(process.argv[2], 'hex'));
+const decoded = decodeFlags(input.buffer);
+if (decoded.labels["true"] !== "yes" || decoded.labels["false"] !== "no" || Object.keys(decoded.labels).length !== 2) {
+    throw new Error("unexpected labels: " + JSON.stringify(decoded.labels));
+}
+process.stdout.write(Buffer.from(encodeFlags({ labels: { "true": "oui", "false": "non" } })).toString('hex'));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"encoding/hex"
+	"os/exec"
+	"testing"
+)
+
+func TestBoolMapKeysWithJS(t *testing.T) {
+	in := (&Flags{Labels: map[bool]string{true: "yes", false: "no"}}).Encode()
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "roundtrip.js")) + `, hex.EncodeToString(in)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	b, err := hex.DecodeString(string(out))
+	if err != nil {
+		t.Fatalf("decode hex %q: %v", out, err)
+	}
+	m, err := DecodeFlags(b)
+	if err != nil {
+		t.Fatalf("DecodeFlags: %v", err)
+	}
+	if len(m.Labels) != 2 || m.Labels[true] != "oui" || m.Labels[false] != "non" {
+		t.Fatalf("unexpected labels from JS: %v", m.Labels)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+// TestGeneratedFixedWidthMapValuesRoundTripWithJS sends fixed64, sfixed32
+// and double map values from Go through the generated JS and back, so both
+// sides agree on the FIXED64/FIXED32 value wire types and on zero values,
+// which JS leaves off the wire.
+func TestGeneratedFixedWidthMapValuesRoundTripWithJS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Readings",
+			FullName: "example.Readings",
+			Fields: []ir.Field{
+				{Name: "counters", Number: 1, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindFixed64, GoEncode: true, JsEncode: true},
+				{Name: "offsets", Number: 2, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindInt32, MapValueKind: ir.KindSfixed32, GoEncode: true, JsEncode: true},
+				{Name: "ratios", Number: 3, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindDouble, GoEncode: true, JsEncode: true},
+			},
+		}},
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate([]ir.File{file}, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	// check.js checks every value Go sent, then re-encodes the message it
+	// decoded for Go to check in turn.
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "check.js"), Content: []byte(`import { decodeReadings, encodeReadings } from './model.js';
+
+const input = Uint8Array.from(Buffer.from(process.argv[2], 'hex'));
+const decoded = decodeReadings(input.buffer);
+const want = {
+    counters: { big: 9007199254740991, one: 1, zero: 0 },
+    offsets: { "-7": -2147483648, "7": 2147483647, "0": 0 },
+    ratios: { half: 0.5, neg: -1e300, zero: 0 },
+};
+for (const [name, values] of Object.entries(want)) {
+    const got = decoded[name];
+    if (Object.keys(got).length !== Object.keys(values).length) {
+        throw new Error(name + ": unexpected keys: " + JSON.stringify(got));
+    }
+    for (const [key, value] of Object.entries(values)) {
+        if (got[key] !== value) {
+            throw new Error(name + "[" + key + "] = " + got[key] + ", want " + value);
+        }
+    }
+}
+process.stdout.write(Buffer.from(encodeReadings(decoded)).toString('hex'));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"encoding/hex"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestFixedWidthMapValuesWithJS(t *testing.T) {
+	in := &Readings{
+		Counters: map[string]uint64{"big": 1<<53 - 1, "one": 1, "zero": 0},
+		Offsets:  map[int32]int32{-7: -1 << 31, 7: 1<<31 - 1, 0: 0},
+		Ratios:   map[string]float64{"half": 0.5, "neg": -1e300, "zero": 0},
+	}
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "check.js")) + `, hex.EncodeToString(in.Encode())).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	b, err := hex.DecodeString(string(out))
+	if err != nil {
+		t.Fatalf("decode hex %q: %v", out, err)
+	}
+	got, err := DecodeReadings(b)
+	if err != nil {
+		t.Fatalf("DecodeReadings: %v", err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Fatalf("round trip through JS = %+v, want %+v", got, in)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+// TestGeneratedNegativeVarintsRoundTripWithJS encodes int32 and int64 -1 in
+// Go, which protobuf writes as sign-extended 10-byte varints, and checks Go
+// and the generated JS decode the exact values, that JS writes the same
+// bytes back, and that both read the 5-byte form some encoders use for a
+// negative int32 as the same value.
+func TestGeneratedNegativeVarintsRoundTripWithJS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Signed",
+			FullName: "example.Signed",
+			Fields: []ir.Field{
+				{Name: "i32", Number: 1, Kind: ir.KindInt32, GoEncode: true, JsEncode: true},
+				{Name: "i64", Number: 2, Kind: ir.KindInt64, GoEncode: true, JsEncode: true},
+				{Name: "min32", Number: 3, Kind: ir.KindInt32, GoEncode: true, JsEncode: true},
+				{Name: "i32s", Number: 4, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true, JsEncode: true},
+			},
+		}},
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate([]ir.File{file}, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	// check.js checks the values Go sent and the 5-byte int32 in argv[3],
+	// then re-encodes the message it decoded for Go to compare.
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "check.js"), Content: []byte(`import { decodeSigned, encodeSigned } from './model.js';
+
+const input = Uint8Array.from(Buffer.from(process.argv[2], 'hex'));
+const decoded = decodeSigned(input.buffer);
+const want = { i32: -1, i64: -1, min32: -2147483648, i32s: [-1, -2147483648, 2147483647] };
+if (JSON.stringify(decoded) !== JSON.stringify(want)) {
+    throw new Error("decoded " + JSON.stringify(decoded) + ", want " + JSON.stringify(want));
+}
+const short = decodeSigned(Uint8Array.from(Buffer.from(process.argv[3], 'hex')).buffer);
+if (short.i32 !== -1) {
+    throw new Error("5-byte int32 decoded as " + short.i32);
+}
+process.stdout.write(Buffer.from(encodeSigned(decoded)).toString('hex'));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+// shortInt32 is field 1 holding -1 as the 5-byte varint of uint32(-1).
+var shortInt32 = []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0x0f}
+
+func TestNegativeVarintsWithJS(t *testing.T) {
+	in := &Signed{I32: -1, I64: -1, Min32: math.MinInt32, I32s: []int32{-1, math.MinInt32, math.MaxInt32}}
+	b := in.Encode()
+	if want := append([]byte{0x08}, bytes.Repeat([]byte{0xff}, 9)...); !bytes.HasPrefix(b, append(want, 0x01)) {
+		t.Fatalf("int32 -1 encoded as % x, want a 10-byte varint", b)
+	}
+	got, err := DecodeSigned(b)
+	if err != nil {
+		t.Fatalf("DecodeSigned: %v", err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Fatalf("Go round trip = %+v, want %+v", got, in)
+	}
+	short, err := DecodeSigned(shortInt32)
+	if err != nil {
+		t.Fatalf("DecodeSigned(5-byte int32): %v", err)
+	}
+	if short.I32 != -1 {
+		t.Fatalf("5-byte int32 decoded as %d, want -1", short.I32)
+	}
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "check.js")) + `, hex.EncodeToString(b), hex.EncodeToString(shortInt32)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	js, err := hex.DecodeString(string(out))
+	if err != nil {
+		t.Fatalf("decode hex %q: %v", out, err)
+	}
+	if !bytes.Equal(js, b) {
+		t.Fatalf("JS encoded % x, want Go's % x", js, b)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+// TestGeneratedAlwaysEmitFieldsEncodeAtZero checks cp.always_emit fields are
+// written by Go even at their zero value while their siblings are omitted,
+// and that the generated JS writes the same bytes back.
+func TestGeneratedAlwaysEmitFieldsEncodeAtZero(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Kind",
+			FullName: "example.Kind",
+			Values:   []ir.EnumValue{{Name: "KIND_UNSPECIFIED", Number: 0}, {Name: "KIND_FULL", Number: 1}},
+		}},
+		Messages: []ir.Message{{
+			Name:     "Header",
+			FullName: "example.Header",
+			Fields: []ir.Field{
+				{Name: "version", Number: 1, Kind: ir.KindInt32, AlwaysEmit: true, GoEncode: true, JsEncode: true},
+				{Name: "kind", Number: 2, Kind: ir.KindEnum, EnumFullName: "example.Kind", AlwaysEmit: true, GoEncode: true, JsEncode: true},
+				{Name: "label", Number: 3, Kind: ir.KindString, AlwaysEmit: true, GoEncode: true, JsEncode: true},
+				{Name: "ratio", Number: 4, Kind: ir.KindDouble, AlwaysEmit: true, GoEncode: true, JsEncode: true},
+				{Name: "count", Number: 5, Kind: ir.KindInt32, GoEncode: true, JsEncode: true},
+				{Name: "note", Number: 6, Kind: ir.KindString, GoEncode: true, JsEncode: true},
+			},
+		}},
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate([]ir.File{file}, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "reencode.js"), Content: []byte(`import { decodeHeader, encodeHeader } from './model.js';
+
+const input = Uint8Array.from(Buffer.from(process.argv[2], 'hex'));
+process.stdout.write(Buffer.from(encodeHeader(decodeHeader(input.buffer))).toString('hex'));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os/exec"
+	"testing"
+)
+
+func TestAlwaysEmitAtZero(t *testing.T) {
+	want := []byte{
+		0x08, 0x00, // version
+		0x10, 0x00, // kind
+		0x1a, 0x00, // label
+		0x21, 0, 0, 0, 0, 0, 0, 0, 0, // ratio
+	}
+	b := (&Header{}).Encode()
+	if !bytes.Equal(b, want) {
+		t.Fatalf("Encode() = % x, want % x", b, want)
+	}
+	if n := (&Header{}).SizeUpperBound(); n < len(b) {
+		t.Fatalf("SizeUpperBound() = %d, below encoded size %d", n, len(b))
+	}
+	got, err := DecodeHeader(b)
+	if err != nil {
+		t.Fatalf("DecodeHeader: %v", err)
+	}
+	if *got != (Header{}) {
+		t.Fatalf("DecodeHeader() = %+v, want the zero Header", got)
+	}
+	set := (&Header{Version: 2, Count: 3}).Encode()
+	if want := []byte{0x08, 0x02, 0x10, 0x00, 0x1a, 0x00, 0x21, 0, 0, 0, 0, 0, 0, 0, 0, 0x28, 0x03}; !bytes.Equal(set, want) {
+		t.Fatalf("Encode() = % x, want % x", set, want)
+	}
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "reencode.js")) + `, hex.EncodeToString(b)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	if string(out) != hex.EncodeToString(b) {
+		t.Fatalf("JS encoded %s, want Go's %x", out, b)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedMapNilMessageValuesEncodeEmpty(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Bar",
+				FullName: "example.Bar",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true, JsEncode: true},
+				},
+			},
+			{
+				Name:     "Foo",
+				FullName: "example.Foo",
+				Fields: []ir.Field{
+					{Name: "bars", Number: 1, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindMessage, MapValueMessage: "example.Bar", GoEncode: true, JsEncode: true},
+				},
+			},
+		},
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate([]ir.File{file}, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "encode.js"), Content: []byte(`import { encodeFoo } from './model.js';
+
+process.stdout.write(Buffer.from(encodeFoo({ bars: { gone: null } })).toString('hex'));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestNilMapValues(t *testing.T) {
+	withNil := &Foo{Bars: map[string]*Bar{"gone": nil}}
+	b := withNil.Encode()
+	want := (&Foo{Bars: map[string]*Bar{"gone": {}}}).Encode()
+	if !bytes.Equal(b, want) {
+		t.Fatalf("Encode() = % x, want the empty message encoding % x", b, want)
+	}
+	if n := withNil.SizeUpperBound(); n < len(b) {
+		t.Fatalf("SizeUpperBound() = %d, below encoded size %d", n, len(b))
+	}
+	if withNil.Hash() != (&Foo{Bars: map[string]*Bar{"gone": {}}}).Hash() {
+		t.Fatalf("Hash() differs between a nil and an empty map value")
+	}
+	got, err := DecodeFoo(b)
+	if err != nil {
+		t.Fatalf("DecodeFoo: %v", err)
+	}
+	if want := map[string]*Bar{"gone": {}}; !reflect.DeepEqual(got.Bars, want) {
+		t.Fatalf("DecodeFoo().Bars = %+v, want %+v", got.Bars, want)
+	}
+
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "encode.js")) + `).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	if goEmpty := (&Foo{Bars: map[string]*Bar{"gone": nil}}).Encode(); string(out) != hex.EncodeToString(goEmpty) {
+		t.Fatalf("JS encoded %s, want Go's %x", out, goEmpty)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoHash: true}, testSrc)
+}
+func TestGeneratedBinaryMarshaler(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Blob",
+			FullName: "example.Blob",
+			Fields: []ir.Field{
+				{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+				{Name: "data", Number: 2, Kind: ir.KindBytes, GoEncode: true},
+				{Name: "tags", Number: 3, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"encoding"
+	"reflect"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*Blob)(nil)
+	_ encoding.BinaryUnmarshaler = (*Blob)(nil)
+)
+
+func TestBlobBinaryRoundTrip(t *testing.T) {
+	in := &Blob{Name: "a", Data: []byte{1, 2, 3}, Tags: []string{"x", "y"}}
+	var marshaler encoding.BinaryMarshaler = in
+	b, err := marshaler.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	out := &Blob{Name: "stale", Tags: []string{"stale"}}
+	var unmarshaler encoding.BinaryUnmarshaler = out
+	if err := unmarshaler.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch: %+v != %+v", in, out)
+	}
+	for i := range b {
+		b[i] = 0
+	}
+	if out.Data[0] != 1 {
+		t.Fatalf("expected UnmarshalBinary not to retain its input")
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoBinaryMarshaler: true}, testSrc)
+}
+
+func TestGeneratedWriterTo(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Part",
+				FullName: "example.Part",
+				Fields: []ir.Field{
+					{Name: "label", Number: 1, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Blob",
+				FullName: "example.Blob",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "data", Number: 2, Kind: ir.KindBytes, GoEncode: true},
+					{Name: "parts", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Part", IsRepeated: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+var _ io.WriterTo = (*Blob)(nil)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 1, errors.New("closed")
+}
+
+func TestBlobWriteTo(t *testing.T) {
+	in := &Blob{Name: "a", Data: []byte{1, 2, 3}, Parts: []*Part{{Label: "x"}, {Label: "y"}}}
+	var buf bytes.Buffer
+	n, err := in.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if want := in.Encode(); !bytes.Equal(buf.Bytes(), want) || n != int64(len(want)) {
+		t.Fatalf("WriteTo wrote %d bytes %x, want %x", n, buf.Bytes(), want)
+	}
+	if n, err := in.WriteTo(failingWriter{}); err == nil || n != 1 {
+		t.Fatalf("expected the writer's count and error, got %d, %v", n, err)
+	}
+}
+
+type recordingWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.writes = append(w.writes, len(b))
+	return w.Buffer.Write(b)
+}
+
+func TestBlobWriteToStreams(t *testing.T) {
+	in := &Blob{Name: "big"}
+	for range 1000 {
+		in.Parts = append(in.Parts, &Part{Label: strings.Repeat("x", 100)})
+	}
+	var w recordingWriter
+	n, err := in.WriteTo(&w)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if want := in.Encode(); !bytes.Equal(w.Bytes(), want) || n != int64(len(want)) {
+		t.Fatalf("WriteTo wrote %d bytes, want %d", n, len(want))
+	}
+	if len(w.writes) < 2 {
+		t.Fatalf("expected the encoding in several writes, got %v", w.writes)
+	}
+	for _, size := range w.writes {
+		if size > writeToChunk+200 {
+			t.Fatalf("write of %d bytes, want at most a chunk and one part", size)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoWriterTo: true}, testSrc)
+}
+
+func TestGeneratedSettersChain(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Bar",
+				FullName: "example.Bar",
+				Fields: []ir.Field{
+					{Name: "id", Number: 1, Kind: ir.KindInt64, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Foo",
+				FullName: "example.Foo",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "items", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Bar", IsRepeated: true, GoEncode: true},
+					{Name: "limit", Number: 3, Kind: ir.KindInt32, IsOptional: true, GoEncode: true},
+					{Name: "child", Number: 4, Kind: ir.KindMessage, MessageFullName: "example.Bar", GoEncode: true},
+					{Name: "labels", Number: 5, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindString, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFooSetters(t *testing.T) {
+	a, b := &Bar{ID: 1}, &Bar{ID: 2}
+	m := new(Foo).
+		SetName("x").
+		AddItems(a).
+		AddItems(b).
+		SetLimit(0).
+		SetChild(a).
+		SetLabels(map[string]string{"k": "v"})
+	want := &Foo{Name: "x", Items: []*Bar{a, b}, Limit: new(int32), Child: a, Labels: map[string]string{"k": "v"}}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("got %+v, want %+v", m, want)
+	}
+	if m.SetItems(nil).Items != nil {
+		t.Fatalf("expected SetItems to replace the slice")
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoSetters: true}, testSrc)
+}
+
+func TestGeneratedSortByEncodesDeterministically(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Entry",
+				FullName: "example.Entry",
+				Fields: []ir.Field{
+					{Name: "id", ProtoName: "id", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "amount", ProtoName: "amount", Number: 2, Kind: ir.KindInt64, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Ledger",
+				FullName: "example.Ledger",
+				Fields: []ir.Field{
+					{Name: "entries", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Entry", IsRepeated: true, SortBy: "id", GoEncode: true},
+					{Name: "values", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Entry", IsRepeated: true, GoSlicePtr: new(bool), SortBy: "amount", GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestLedgerSortBy(t *testing.T) {
+	a, b, c := &Entry{ID: "a", Amount: 3}, &Entry{ID: "b", Amount: 1}, &Entry{ID: "c", Amount: 2}
+	x := &Ledger{Entries: []*Entry{c, nil, a, b}, Values: []Entry{*a, *b, *c}}
+	y := &Ledger{Entries: []*Entry{b, a, c}, Values: []Entry{*c, *b, *a}}
+	if !bytes.Equal(x.Encode(), y.Encode()) {
+		t.Fatalf("expected differently-ordered slices to encode identically")
+	}
+	if x.Entries[0] != c || x.Values[0].ID != "a" {
+		t.Fatalf("expected Encode to leave the slices unsorted")
+	}
+	decoded, err := DecodeLedger(x.Encode())
+	if err != nil {
+		t.Fatalf("DecodeLedger: %v", err)
+	}
+	var ids, amounts []string
+	for _, e := range decoded.Entries {
+		ids = append(ids, e.ID)
+	}
+	for _, e := range decoded.Values {
+		amounts = append(amounts, e.ID)
+	}
+	if got := fmt.Sprint(ids, amounts); got != "[a b c] [b c a]" {
+		t.Fatalf("unexpected encode order: %s", got)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedEmptyMessagePresence(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "Marker", FullName: "example.Marker"},
+			{
+				Name:     "Holder",
+				FullName: "example.Holder",
+				Fields: []ir.Field{
+					{Name: "marker", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Marker", GoEncode: true},
+					{Name: "markers", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Marker", IsRepeated: true, GoEncode: true},
+					{Name: "by_name", Number: 3, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindMessage, MapValueMessage: "example.Marker", GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEmptyMessageEncodesToNoBytes(t *testing.T) {
+	if b := (&Marker{}).Encode(); len(b) != 0 {
+		t.Fatalf("expected empty Marker to encode to no bytes, got %x", b)
+	}
+	if b := (&Holder{}).Encode(); len(b) != 0 {
+		t.Fatalf("expected empty Holder to encode to no bytes, got %x", b)
+	}
+	m, err := DecodeMarker(nil)
+	if err != nil || m == nil {
+		t.Fatalf("DecodeMarker(nil) = %v, %v", m, err)
+	}
+	h, err := DecodeHolder([]byte{})
+	if err != nil {
+		t.Fatalf("DecodeHolder: %v", err)
+	}
+	if !reflect.DeepEqual(h, &Holder{}) {
+		t.Fatalf("expected an empty Holder, got %+v", h)
+	}
+}
+
+func TestEmptyNestedMessageStaysPresent(t *testing.T) {
+	in := &Holder{Marker: &Marker{}, Markers: []*Marker{{}, {}}, ByName: map[string]*Marker{"x": {}}}
+	b := in.Encode()
+	want := []byte{0x0a, 0x00, 0x12, 0x00, 0x12, 0x00, 0x1a, 0x05, 0x0a, 0x01, 'x', 0x12, 0x00}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("encode = %x, want %x", b, want)
+	}
+	out, err := DecodeHolder(b)
+	if err != nil {
+		t.Fatalf("DecodeHolder: %v", err)
+	}
+	if out.Marker == nil {
+		t.Fatalf("expected a present empty nested message to decode as non-nil")
+	}
+	if len(out.Markers) != 2 || out.Markers[0] == nil || out.Markers[1] == nil {
+		t.Fatalf("expected two present empty repeated messages, got %+v", out.Markers)
+	}
+	if v, ok := out.ByName["x"]; !ok || v == nil {
+		t.Fatalf("expected a present empty map value, got %+v", out.ByName)
+	}
+	// A nil map value encodes as an empty message, as protobuf-go does.
+	nilValue, err := DecodeHolder((&Holder{ByName: map[string]*Marker{"y": nil}}).Encode())
+	if err != nil {
+		t.Fatalf("DecodeHolder: %v", err)
+	}
+	if v, ok := nilValue.ByName["y"]; !ok || v == nil {
+		t.Fatalf("expected a nil map value to decode as an empty message, got %+v", nilValue.ByName)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedNonNilSlices(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Label",
+				FullName: "example.Label",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Post",
+				FullName: "example.Post",
+				Fields: []ir.Field{
+					{Name: "title", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "tags", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Label", IsRepeated: true, GoEncode: true},
+					{Name: "scores", Number: 3, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "meta", Number: 4, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindString, GoEncode: true},
+					{Name: "body", Number: 5, Kind: ir.KindBytes, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPostDecodesNonNilCollections(t *testing.T) {
+	b := (&Post{Title: "x"}).Encode()
+	m, err := DecodePost(b)
+	if err != nil {
+		t.Fatalf("DecodePost: %v", err)
+	}
+	if m.Tags == nil || m.Scores == nil || m.Meta == nil {
+		t.Fatalf("expected non-nil empty collections, got %#v", m)
+	}
+	if len(m.Tags) != 0 || len(m.Scores) != 0 || len(m.Meta) != 0 {
+		t.Fatalf("expected empty collections, got %#v", m)
+	}
+	if m.Body != nil {
+		t.Fatalf("expected bytes fields to be left alone")
+	}
+	if !bytes.Equal(m.Encode(), b) {
+		t.Fatalf("expected empty collections to be omitted on encode")
+	}
+	full, err := DecodePost((&Post{Scores: []int32{1, 2}}).Encode())
+	if err != nil {
+		t.Fatalf("DecodePost: %v", err)
+	}
+	if len(full.Scores) != 2 {
+		t.Fatalf("expected decoded entries to be kept, got %v", full.Scores)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoNonNilSlices: true}, testSrc)
+}
+
+func TestGeneratedEmptyBytes(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Blob",
+				FullName: "example.Blob",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "data", Number: 2, Kind: ir.KindBytes, GoEncode: true},
+					{Name: "hash", Number: 3, Kind: ir.KindBytes, IsOptional: true, GoEncode: true},
+					{Name: "parts", Number: 4, Kind: ir.KindBytes, IsRepeated: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBlobDecodesEmptyBytes(t *testing.T) {
+	b := (&Blob{Name: "x"}).Encode()
+	m, err := DecodeBlob(b)
+	if err != nil {
+		t.Fatalf("DecodeBlob: %v", err)
+	}
+	if m.Data == nil || len(m.Data) != 0 {
+		t.Fatalf("expected absent bytes to decode as empty non-nil, got %#v", m.Data)
+	}
+	if m.Hash != nil || m.Parts != nil {
+		t.Fatalf("expected optional and repeated bytes to be left alone, got %#v", m)
+	}
+	if !bytes.Equal(m.Encode(), b) {
+		t.Fatalf("expected empty bytes to be omitted on encode")
+	}
+	m.Data = append(m.Data, 1)
+	other, err := DecodeBlob(b)
+	if err != nil {
+		t.Fatalf("DecodeBlob: %v", err)
+	}
+	if len(other.Data) != 0 {
+		t.Fatalf("expected appending to not affect other messages, got %v", other.Data)
+	}
+	full, err := DecodeBlob((&Blob{Data: []byte{7}}).Encode())
+	if err != nil {
+		t.Fatalf("DecodeBlob: %v", err)
+	}
+	if !bytes.Equal(full.Data, []byte{7}) {
+		t.Fatalf("expected decoded bytes to be kept, got %v", full.Data)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoEmptyBytes: true}, testSrc)
+}
+
+func TestGeneratedCordBytesAliasInput(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Blob",
+			FullName: "example.Blob",
+			Fields: []ir.Field{
+				{Name: "data", ProtoName: "data", Number: 1, Kind: ir.KindBytes, AliasBytes: true, GoEncode: true},
+				{Name: "extra", ProtoName: "extra", Number: 2, Kind: ir.KindBytes, IsOptional: true, AliasBytes: true, GoEncode: true},
+				{Name: "chunks", ProtoName: "chunks", Number: 3, Kind: ir.KindBytes, IsRepeated: true, AliasBytes: true, GoEncode: true},
+				{Name: "copied", ProtoName: "copied", Number: 4, Kind: ir.KindBytes, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import "testing"
+
+func TestCordAliases(t *testing.T) {
+	extra := []byte("e")
+	b := (&Blob{Data: []byte("d"), Extra: &extra, Chunks: [][]byte{[]byte("c")}, Copied: []byte("p")}).Encode()
+	m, err := DecodeBlob(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range b {
+		b[i] = 'x'
+	}
+	if string(m.Data) != "x" || string(*m.Extra) != "x" || string(m.Chunks[0]) != "x" {
+		t.Fatalf("expected ctype = CORD fields to alias the input, got %q %q %q", m.Data, *m.Extra, m.Chunks[0])
+	}
+	if string(m.Copied) != "p" {
+		t.Fatalf("expected other bytes fields to be copied, got %q", m.Copied)
+	}
+
+	empty := []byte{}
+	m, err = DecodeBlob((&Blob{Extra: &empty}).Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Extra == nil || *m.Extra == nil || len(*m.Extra) != 0 {
+		t.Fatalf("expected an empty optional field to stay set, got %#v", m.Extra)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+// TestGeneratedStreamHandlerCopiesCordFrames decodes two frames of a message
+// with a ctype = CORD field through a generated client-streaming handler and
+// checks the first message keeps its bytes after StreamReader reuses its
+// buffer for the second.
+func TestGeneratedStreamHandlerCopiesCordFrames(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+package example;
+option go_package = "example";
+
+message Blob {
+  bytes data = 1 [ctype = CORD];
+}
+
+service Blobs {
+  rpc PostBlobsV1(stream Blob) returns (Blob);
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "blob.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"blob.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type blobsHandler struct {
+	got []*Blob
+}
+
+func (h *blobsHandler) PostBlobsV1(_ context.Context, reqs iter.Seq2[*Blob, error]) (*Blob, error) {
+	for req, err := range reqs {
+		if err != nil {
+			return nil, err
+		}
+		h.got = append(h.got, req)
+	}
+	return &Blob{}, nil
+}
+
+func TestStreamHandlerCopiesCordFrames(t *testing.T) {
+	var body bytes.Buffer
+	for _, data := range []string{"first", "other"} {
+		if err := WriteStreamFrame(&body, (&Blob{Data: []byte(data)}).Encode()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &blobsHandler{}
+	w := httptest.NewRecorder()
+	CreateMux(h, nil).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/blobs", &body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var got []string
+	for _, m := range h.got {
+		got = append(got, string(m.Data))
+	}
+	if len(got) != 2 || got[0] != "first" || got[1] != "other" {
+		t.Fatalf("decoded frames = %q, want [first other]", got)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{GoServer: true}, testSrc)
+}
+
+func TestGeneratedDecodeLocalsAlwaysUsed(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			// Only scalars and bytes: no msgBytes local is needed. Zero
+			// elements of unpacked lists must still be encoded.
+			{
+				Name:     "Chunk",
+				FullName: "example.Chunk",
+				Fields: []ir.Field{
+					{Name: "data", Number: 1, Kind: ir.KindBytes, GoEncode: true},
+					{Name: "parts", Number: 2, Kind: ir.KindBytes, IsRepeated: true, GoEncode: true},
+					{Name: "sizes", Number: 3, Kind: ir.KindInt64, IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "names", Number: 4, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+					{Name: "flags", Number: 5, Kind: ir.KindBool, IsRepeated: true, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Batch",
+				FullName: "example.Batch",
+				Fields: []ir.Field{
+					{Name: "chunks", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Chunk", IsRepeated: true, GoEncode: true},
+					{Name: "ids", Number: 2, Kind: ir.KindUint32, IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "raw", Number: 3, Kind: ir.KindBytes, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	in := &Batch{
+		Chunks: []*Chunk{
+			{Data: []byte{1}, Parts: [][]byte{{2}, {3}}, Sizes: []int64{-1, 0, 5}, Names: []string{"a", ""}, Flags: []bool{true, false}},
+			{Data: []byte{4}},
+		},
+		Ids: []uint32{7, 0},
+		Raw: []byte{9},
+	}
+	out, err := DecodeBatch(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeBatch: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch: %+v != %+v", in, out)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedMinimalBuildsAndRoundTrips(t *testing.T) {
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadingRoundTrip(t *testing.T) {
+	limit := uint32(0)
+	in := &Reading{
+		ID:     "r1",
+		Level:  Level_LEVEL_HIGH,
+		At:     time.UnixMilli(1700000000123).UTC(),
+		Took:   3 * time.Second,
+		Values: []float64{1.5, 0},
+		Labels: map[string]string{"k": "v"},
+		Limit:  &limit,
+	}
+	out, err := DecodeReading(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeReading: %v", err)
+	}
+	if !out.At.Equal(in.At) {
+		t.Fatalf("At = %v, want %v", out.At, in.At)
+	}
+	out.At, out.Seen = in.At, in.Seen
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch: %+v != %+v", in, out)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{minimalTestFile()}, generate.Options{GoMinimal: true}, testSrc)
+}
+
+func TestGeneratedHashIgnoresMapOrder(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Bucket",
+				FullName: "example.Bucket",
+				Fields: []ir.Field{
+					{Name: "counts", Number: 1, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindInt32, MapValueKind: ir.KindInt64, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Index",
+				FullName: "example.Index",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "buckets", Number: 2, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindMessage, MapValueMessage: "example.Bucket", GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func buildIndex(order []int) *Index {
+	m := &Index{Name: "idx", Buckets: map[string]*Bucket{}}
+	for _, i := range order {
+		b := &Bucket{Counts: map[int32]int64{}}
+		for _, j := range order {
+			b.Counts[int32(j)] = int64(i * j)
+		}
+		m.Buckets[fmt.Sprint("k", i)] = b
+	}
+	return m
+}
+
+func TestIndexHashIsStable(t *testing.T) {
+	forward := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	backward := []int{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}
+	a, b := buildIndex(forward), buildIndex(backward)
+	if !bytes.Equal(a.Encode(), b.Encode()) {
+		t.Fatalf("expected deterministic encodings")
+	}
+	for i := 0; i < 20; i++ {
+		if a.Hash() != b.Hash() {
+			t.Fatalf("expected equal messages to hash equally")
+		}
+	}
+	b.Buckets["k3"].Counts[4] = 13
+	if a.Hash() == b.Hash() {
+		t.Fatalf("expected different messages to hash differently")
+	}
+	if (&Index{Name: "a"}).Hash() == (&Index{Name: "b"}).Hash() {
+		t.Fatalf("expected different names to hash differently")
+	}
+	decoded, err := DecodeIndex(a.Encode())
+	if err != nil {
+		t.Fatalf("DecodeIndex: %v", err)
+	}
+	if decoded.Hash() != a.Hash() {
+		t.Fatalf("expected a decoded copy to hash equally")
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoHash: true}, testSrc)
+}
+
+func TestGeneratedCanonicalEncodeOrdersFields(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+option go_package = "example";
+
+message Signer {
+  string key_id = 3;
+  map<string, int32> weights = 1;
+}
+
+message Payload {
+  string note = 9;
+  int64 amount = 2;
+  map<string, Signer> signers = 5;
+  Signer primary = 4;
+  repeated Signer others = 1;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "payload.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"payload.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func fieldNumbers(t *testing.T, b []byte) []Number {
+	t.Helper()
+	fields, err := DecodeDynamic(b)
+	if err != nil {
+		t.Fatalf("DecodeDynamic: %v", err)
+	}
+	var nums []Number
+	for _, f := range fields {
+		nums = append(nums, f.Number)
+	}
+	return nums
+}
+
+func ascending(nums []Number) bool {
+	for i := 1; i < len(nums); i++ {
+		if nums[i] < nums[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCanonicalEncode(t *testing.T) {
+	weights := map[string]int32{}
+	signers := map[string]*Signer{}
+	for i := 0; i < 16; i++ {
+		weights[fmt.Sprint("w", i)] = int32(i)
+		signers[fmt.Sprint("s", i)] = &Signer{KeyID: fmt.Sprint(i), Weights: map[string]int32{"a": 1, "b": 2, "c": 3}}
+	}
+	m := &Payload{
+		Note:    "n",
+		Amount:  7,
+		Signers: signers,
+		Primary: &Signer{KeyID: "p", Weights: weights},
+		Others:  []*Signer{{KeyID: "o", Weights: weights}},
+	}
+
+	canonical := m.CanonicalEncode()
+	for i := 0; i < 20; i++ {
+		if !bytes.Equal(m.CanonicalEncode(), canonical) {
+			t.Fatalf("expected CanonicalEncode to be stable")
+		}
+	}
+	if nums := fieldNumbers(t, canonical); !ascending(nums) {
+		t.Fatalf("expected fields in number order, got %v", nums)
+	}
+	if nums := fieldNumbers(t, m.Encode()); ascending(nums) {
+		t.Fatalf("expected Encode to keep declaration order, got %v", nums)
+	}
+	nested := m.Primary.CanonicalEncode()
+	if nums := fieldNumbers(t, nested); !ascending(nums) {
+		t.Fatalf("expected nested fields in number order, got %v", nums)
+	}
+	if !bytes.Contains(canonical, nested) {
+		t.Fatalf("expected the nested message to be canonically encoded in place")
+	}
+
+	decoded, err := DecodePayload(canonical)
+	if err != nil {
+		t.Fatalf("DecodePayload: %v", err)
+	}
+	if !bytes.Equal(decoded.CanonicalEncode(), canonical) {
+		t.Fatalf("expected a decoded copy to encode to the same canonical bytes")
+	}
+	if !WireEqual(canonical, m.Encode()) {
+		t.Fatalf("expected CanonicalEncode to encode the same message as Encode")
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{GoCanonical: true}, testSrc)
+}
+
+func TestGeneratedArenaDecode(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Leaf",
+				FullName: "example.Leaf",
+				Fields: []ir.Field{
+					{Name: "id", Number: 1, Kind: ir.KindInt64, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Node",
+				FullName: "example.Node",
+				Fields: []ir.Field{
+					{Name: "depth", Number: 1, Kind: ir.KindInt32, GoEncode: true},
+					{Name: "child", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Node", GoEncode: true},
+					{Name: "leaves", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Leaf", IsRepeated: true, GoEncode: true},
+					{Name: "by_id", Number: 4, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindInt64, MapValueKind: ir.KindMessage, MapValueMessage: "example.Leaf", GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+func deepNode(depth int32) *Node {
+	root := &Node{Depth: 0}
+	n := root
+	for i := int32(1); i < depth; i++ {
+		n.Leaves = []*Leaf{{ID: int64(i)}, {ID: -int64(i)}}
+		n.Child = &Node{Depth: i}
+		n = n.Child
+	}
+	n.ByID = map[int64]*Leaf{7: {ID: 7}}
+	return root
+}
+
+func TestDecodeWithArenaMatchesDecode(t *testing.T) {
+	buf := deepNode(50).Encode()
+	want, err := DecodeNode(buf)
+	if err != nil {
+		t.Fatalf("DecodeNode: %v", err)
+	}
+	var a Arena
+	for i := 0; i < 3; i++ {
+		a.Reset()
+		got, err := DecodeNodeWithArena(buf, &a)
+		if err != nil {
+			t.Fatalf("DecodeNodeWithArena: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round %d: arena decode differs from Decode", i)
+		}
+	}
+	a.Reset()
+	small, err := DecodeNodeWithArena((&Node{Depth: 1}).Encode(), &a)
+	if err != nil {
+		t.Fatalf("DecodeNodeWithArena: %v", err)
+	}
+	if small.Child != nil || small.Leaves != nil {
+		t.Fatalf("expected recycled messages to be zeroed, got %#v", small)
+	}
+}
+
+func TestDecodeWithArenaAllocatesLess(t *testing.T) {
+	buf := deepNode(50).Encode()
+	var a Arena
+	arena := testing.AllocsPerRun(20, func() {
+		a.Reset()
+		if _, err := DecodeNodeWithArena(buf, &a); err != nil {
+			t.Fatal(err)
+		}
+	})
+	plain := testing.AllocsPerRun(20, func() {
+		if _, err := DecodeNode(buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// Only the leaves slices and the map remain individual allocations.
+	if arena*2 > plain {
+		t.Fatalf("expected the arena to at least halve allocations: arena=%v plain=%v", arena, plain)
+	}
+}
+
+func BenchmarkDecodeDeepWithArena(b *testing.B) {
+	buf := deepNode(50).Encode()
+	var a Arena
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a.Reset()
+		if _, err := DecodeNodeWithArena(buf, &a); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeDeepWithoutArena(b *testing.B) {
+	buf := deepNode(50).Encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeNode(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoArena: true}, testSrc)
+}
+
+func TestGeneratedLazyFieldDecodesOnFirstGet(t *testing.T) {
+	payloadFields := []ir.Field{
+		{Name: "title", Number: 1, Kind: ir.KindString, GoEncode: true},
+		{Name: "tags", Number: 2, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+		{Name: "scores", Number: 3, Kind: ir.KindInt64, IsRepeated: true, IsPacked: true, GoEncode: true},
+		{Name: "attrs", Number: 4, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindString, GoEncode: true},
+	}
+	envelope := func(name string, lazy bool) ir.Message {
+		return ir.Message{Name: name, FullName: "example." + name, Fields: []ir.Field{
+			{Name: "id", Number: 1, Kind: ir.KindInt64, GoEncode: true},
+			{Name: "payload", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Payload", Lazy: lazy, GoEncode: true},
+		}}
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{Name: "Payload", FullName: "example.Payload", Fields: payloadFields},
+			envelope("Envelope", true),
+			envelope("EagerEnvelope", false),
+		},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func bigPayload() *Payload {
+	p := &Payload{Title: "report", Attrs: map[string]string{}}
+	for i := 0; i < 200; i++ {
+		p.Tags = append(p.Tags, fmt.Sprintf("tag-%d", i))
+		p.Scores = append(p.Scores, int64(i)*1000003)
+		p.Attrs[fmt.Sprintf("k%d", i)] = fmt.Sprintf("v%d", i)
+	}
+	return p
+}
+
+func TestLazyFieldDecodesOnFirstGet(t *testing.T) {
+	want := bigPayload()
+	buf := (&EagerEnvelope{ID: 7, Payload: want}).Encode()
+	m, err := DecodeEnvelope(buf)
+	if err != nil {
+		t.Fatalf("DecodeEnvelope: %v", err)
+	}
+	if m.ID != 7 || m.Payload != nil {
+		t.Fatalf("expected only the eager field decoded, got %+v", m)
+	}
+	if got := m.Encode(); !bytes.Equal(got, buf) {
+		t.Fatalf("expected undecoded bytes to encode unchanged")
+	}
+	if n := m.SizeUpperBound(); n < len(buf) {
+		t.Fatalf("SizeUpperBound %d below encoded size %d", n, len(buf))
+	}
+	got, err := m.GetPayload()
+	if err != nil {
+		t.Fatalf("GetPayload: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) || m.Payload != got {
+		t.Fatalf("expected GetPayload to decode and cache the payload")
+	}
+	if again, _ := m.GetPayload(); again != got {
+		t.Fatalf("expected the cached payload on the second call")
+	}
+	if round, err := DecodeEagerEnvelope(m.Encode()); err != nil || !reflect.DeepEqual(round.Payload, want) {
+		t.Fatalf("expected the decoded payload to round trip, got %v", err)
+	}
+}
+
+func TestLazyFieldKeepsPresenceAndMerges(t *testing.T) {
+	m, err := DecodeEnvelope((&EagerEnvelope{Payload: &Payload{}}).Encode())
+	if err != nil {
+		t.Fatalf("DecodeEnvelope: %v", err)
+	}
+	if got, err := m.GetPayload(); err != nil || got == nil {
+		t.Fatalf("expected an empty payload to stay set, got %v, %v", got, err)
+	}
+	first := (&EagerEnvelope{Payload: &Payload{Title: "a", Tags: []string{"x"}}}).Encode()
+	second := (&EagerEnvelope{Payload: &Payload{Tags: []string{"y"}}}).Encode()
+	m, err = DecodeEnvelope(append(first, second...))
+	if err != nil {
+		t.Fatalf("DecodeEnvelope: %v", err)
+	}
+	got, err := m.GetPayload()
+	if err != nil || got.Title != "a" || !reflect.DeepEqual(got.Tags, []string{"x", "y"}) {
+		t.Fatalf("expected repeated occurrences to merge, got %+v, %v", got, err)
+	}
+}
+
+func TestLazyFieldAssignmentReplacesBytes(t *testing.T) {
+	m, err := DecodeEnvelope((&EagerEnvelope{Payload: bigPayload()}).Encode())
+	if err != nil {
+		t.Fatalf("DecodeEnvelope: %v", err)
+	}
+	m.Payload = &Payload{Title: "new"}
+	decoded, err := DecodeEagerEnvelope(m.Encode())
+	if err != nil || decoded.Payload.Title != "new" || decoded.Payload.Tags != nil {
+		t.Fatalf("expected the assigned payload to replace the lazy bytes, got %+v, %v", decoded.Payload, err)
+	}
+	if got, _ := m.GetPayload(); got.Title != "new" {
+		t.Fatalf("expected GetPayload to return the assigned payload")
+	}
+}
+
+func TestLazyFieldReportsErrorOnGet(t *testing.T) {
+	// Field 2 holds a one-byte message with a truncated tag.
+	m, err := DecodeEnvelope([]byte{0x12, 0x01, 0x80})
+	if err != nil {
+		t.Fatalf("expected the malformed payload to be left undecoded, got %v", err)
+	}
+	if _, err := m.GetPayload(); err == nil {
+		t.Fatal("expected GetPayload to report the malformed payload")
+	}
+}
+
+func TestLazyFieldSkipsDecodeWork(t *testing.T) {
+	buf := (&EagerEnvelope{ID: 7, Payload: bigPayload()}).Encode()
+	lazy := testing.AllocsPerRun(20, func() {
+		if _, err := DecodeEnvelope(buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	eager := testing.AllocsPerRun(20, func() {
+		if _, err := DecodeEagerEnvelope(buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if lazy*10 > eager {
+		t.Fatalf("expected a lazy decode to allocate far less: lazy=%v eager=%v", lazy, eager)
+	}
+}
+
+func BenchmarkDecodeEnvelopeLazy(b *testing.B) {
+	buf := (&EagerEnvelope{ID: 7, Payload: bigPayload()}).Encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeEnvelope(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeEnvelopeEager(b *testing.B) {
+	buf := (&EagerEnvelope{ID: 7, Payload: bigPayload()}).Encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeEagerEnvelope(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func strictRepeatedTestFile() ir.File {
+	return ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "LineItem",
+				FullName: "example.LineItem",
+				Fields: []ir.Field{
+					{Name: "sku", Number: 1, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Order",
+				FullName: "example.Order",
+				Fields: []ir.Field{
+					{Name: "items", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.LineItem", IsRepeated: true, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Cart",
+				FullName: "example.Cart",
+				Fields: []ir.Field{
+					{Name: "order", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Order", GoEncode: true},
+				},
+			},
+		},
+	}
+}
+
+func TestGeneratedStrictRepeatedRejectsNilElements(t *testing.T) {
+	testSrc := `package example
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEncodeErrRejectsNilElement(t *testing.T) {
+	order := &Order{Items: []*LineItem{{Sku: "a"}, nil, {Sku: "b"}}}
+	if _, err := order.EncodeErr(); !errors.Is(err, ErrNilElement) {
+		t.Fatalf("expected ErrNilElement, got %v", err)
+	}
+	b, err := (&Cart{Order: order}).EncodeErr()
+	if !errors.Is(err, ErrNilElement) || b != nil {
+		t.Fatalf("expected ErrNilElement from a nested message, got %v", err)
+	}
+	if _, err := order.MarshalBinary(); !errors.Is(err, ErrNilElement) {
+		t.Fatalf("expected MarshalBinary to report ErrNilElement, got %v", err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected Encode to panic")
+			}
+		}()
+		order.Encode()
+	}()
+	order.Items[1] = &LineItem{}
+	b, err = order.EncodeErr()
+	if err != nil {
+		t.Fatalf("EncodeErr: %v", err)
+	}
+	decoded, err := DecodeOrder(b)
+	if err != nil || len(decoded.Items) != 3 {
+		t.Fatalf("expected 3 items, got %v, %v", decoded, err)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{strictRepeatedTestFile()}, generate.Options{GoStrictRepeated: true, GoBinaryMarshaler: true}, testSrc)
+}
+
+func TestGeneratedRepeatedSkipsNilElementsByDefault(t *testing.T) {
+	testSrc := `package example
+
+import "testing"
+
+func TestEncodeSkipsNilElement(t *testing.T) {
+	decoded, err := DecodeOrder((&Order{Items: []*LineItem{{Sku: "a"}, nil, {Sku: "b"}}}).Encode())
+	if err != nil {
+		t.Fatalf("DecodeOrder: %v", err)
+	}
+	if len(decoded.Items) != 2 {
+		t.Fatalf("expected the nil element to be skipped, got %d items", len(decoded.Items))
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{strictRepeatedTestFile()}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedEncodeErrReportsOutOfRangeValues(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Event",
+				FullName: "example.Event",
+				Fields: []ir.Field{
+					{Name: "at", Number: 1, Kind: ir.KindInt32, GoType: "time.Time", GoEncode: true},
+					{Name: "took", Number: 2, Kind: ir.KindInt32, GoType: "time.Duration", IsOptional: true, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Log",
+				FullName: "example.Log",
+				Fields: []ir.Field{
+					{Name: "events", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Event", IsRepeated: true, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Plain",
+				FullName: "example.Plain",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncodeErrSurfacesConversionErrors(t *testing.T) {
+	ok := &Event{At: time.Unix(1700000000, 0)}
+	if _, err := ok.EncodeErr(); err != nil {
+		t.Fatalf("EncodeErr: %v", err)
+	}
+	late := &Event{At: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if _, err := late.EncodeErr(); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected ErrOutOfRange, got %v", err)
+	}
+	took := 100 * 365 * 24 * time.Hour
+	if _, err := (&Event{Took: &took}).EncodeErr(); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected ErrOutOfRange for the duration, got %v", err)
+	}
+	if _, err := (&Log{Events: []*Event{ok, late}}).EncodeErr(); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected ErrOutOfRange from a nested message, got %v", err)
+	}
+	// Plain cannot fail to encode, so it gets no EncodeErr.
+	if _, has := any(&Plain{}).(interface{ EncodeErr() ([]byte, error) }); has {
+		t.Fatalf("expected no EncodeErr on Plain")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected Encode to panic")
+		}
+	}()
+	late.Encode()
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+// TestGeneratedMoneyRoundTripsWithJS generates google.type.Money from the
+// built-in money.proto and sends a message holding it from Go through the
+// generated JS and back.
+func TestGeneratedMoneyRoundTripsWithJS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "google/type/money.proto";
+
+option go_package = "example";
+
+message Order {
+  google.type.Money total = 1;
+  map<string, google.type.Money> lines = 2;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate(files, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "check.js"), Content: []byte(`import { decodeOrder, encodeOrder } from './model.js';
+
+const input = Uint8Array.from(Buffer.from(process.argv[2], 'hex'));
+const order = decodeOrder(input.buffer);
+const total = order.total;
+if (total.currencyCode !== "USD" || total.units !== -1 || total.nanos !== -750000000) {
+    throw new Error("unexpected total: " + JSON.stringify(total));
+}
+const fee = order.lines.fee;
+if (fee.currencyCode !== "EUR" || fee.units !== 3 || fee.nanos !== 0) {
+    throw new Error("unexpected fee: " + JSON.stringify(order.lines));
+}
+order.total.units = 2;
+order.lines.tip = { currencyCode: "GBP", units: 0, nanos: 500000000 };
+process.stdout.write(Buffer.from(encodeOrder(order)).toString('hex'));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"encoding/hex"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestMoneyWithJS(t *testing.T) {
+	in := &Order{
+		Total: &Money{CurrencyCode: "USD", Units: -1, Nanos: -750000000},
+		Lines: map[string]*Money{"fee": {CurrencyCode: "EUR", Units: 3}},
+	}
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "check.js")) + `, hex.EncodeToString(in.Encode())).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	b, err := hex.DecodeString(string(out))
+	if err != nil {
+		t.Fatalf("decode hex %q: %v", out, err)
+	}
+	got, err := DecodeOrder(b)
+	if err != nil {
+		t.Fatalf("DecodeOrder: %v", err)
+	}
+	want := &Order{
+		Total: &Money{CurrencyCode: "USD", Units: 2, Nanos: -750000000},
+		Lines: map[string]*Money{
+			"fee": {CurrencyCode: "EUR", Units: 3},
+			"tip": {CurrencyCode: "GBP", Nanos: 500000000},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("round trip through JS = %+v, want %+v", got, want)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+// TestGeneratedUnpackAnyDecodesByTypeURL packs a Foo into an Any, carries it
+// through an encoded Envelope and checks UnpackAny returns the same *Foo,
+// and that an unknown type URL is reported.
+func TestGeneratedUnpackAnyDecodesByTypeURL(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "google/protobuf/any.proto";
+
+option go_package = "example";
+
+message Foo {
+  string name = 1;
+  repeated int32 sizes = 2;
+}
+
+message Envelope {
+  google.protobuf.Any payload = 1;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUnpackAny(t *testing.T) {
+	foo := &Foo{Name: "widget", Sizes: []int32{3, 1, 2}}
+	env := &Envelope{Payload: &Any{TypeUrl: "type.googleapis.com/example.Foo", Value: foo.Encode()}}
+	decoded, err := DecodeEnvelope(env.Encode())
+	if err != nil {
+		t.Fatalf("DecodeEnvelope: %v", err)
+	}
+	got, err := UnpackAny(decoded.Payload)
+	if err != nil {
+		t.Fatalf("UnpackAny: %v", err)
+	}
+	gotFoo, ok := got.(*Foo)
+	if !ok {
+		t.Fatalf("UnpackAny() = %T, want *Foo", got)
+	}
+	if !reflect.DeepEqual(gotFoo, foo) {
+		t.Fatalf("UnpackAny() = %+v, want %+v", gotFoo, foo)
+	}
+	if got, err := UnpackAny(&Any{TypeUrl: "example.Foo", Value: foo.Encode()}); err != nil || !reflect.DeepEqual(got, foo) {
+		t.Fatalf("UnpackAny(bare name) = %+v, %v, want %+v", got, err, foo)
+	}
+	for _, a := range []*Any{nil, {TypeUrl: "type.googleapis.com/example.Bar"}, {TypeUrl: "type.googleapis.com/other.Foo"}} {
+		if got, err := UnpackAny(a); !errors.Is(err, ErrUnknownAnyType) || got != nil {
+			t.Fatalf("UnpackAny(%+v) = %v, %v, want ErrUnknownAnyType", a, got, err)
+		}
+	}
+	if _, err := UnpackAny(&Any{TypeUrl: "type.googleapis.com/example.Foo", Value: []byte{0x0a, 0x05}}); err == nil {
+		t.Fatalf("UnpackAny of a truncated Foo succeeded")
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{GoUnpackAny: true}, testSrc)
+}
+
+// TestGeneratedIgnoresUnrelatedFieldOptions gives fields standard options and
+// custom options of another package alongside cp.* options, and checks only
+// the cp.* options change the generated code.
+func TestGeneratedIgnoresUnrelatedFieldOptions(t *testing.T) {
+	const acmeSource = `syntax = "proto3";
+
+package acme;
+
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions {
+  string label = 60010 [retention = RETENTION_SOURCE, targets = TARGET_TYPE_FIELD];
+  int32 weight = 60011 [retention = RETENTION_RUNTIME, targets = TARGET_TYPE_FIELD];
+  bool audited = 60012;
+}
+`
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "acme.proto";
+import "options.proto";
+
+option go_package = "example";
+
+message Reading {
+  string name = 1 [deprecated = true, debug_redact = true, (acme.label) = "int64", (acme.weight) = 3];
+  double ratio = 2 [(cp.go_type) = "float32", (acme.audited) = true, retention = RETENTION_RUNTIME];
+  int64 stamp = 3 [jstype = JS_STRING, (acme.weight) = 7, (acme.label) = "bigint"];
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "acme.proto"), []byte(acmeSource), 0o644); err != nil {
+		t.Fatalf("write acme proto: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "reading.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"reading.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var fields []ir.Field
+	for _, file := range files {
+		for _, msg := range file.Messages {
+			if msg.Name == "Reading" {
+				fields = msg.Fields
+			}
+		}
+	}
+	if len(fields) != 3 {
+		t.Fatalf("Reading fields = %+v", fields)
+	}
+	if fields[0].GoType != "" || fields[0].JSType != "" {
+		t.Fatalf("name: GoType = %q, JSType = %q, want acme options ignored", fields[0].GoType, fields[0].JSType)
+	}
+	if fields[1].GoType != "float32" {
+		t.Fatalf("ratio: GoType = %q, want float32", fields[1].GoType)
+	}
+	if fields[2].GoType != "" || fields[2].JSType != "string" {
+		t.Fatalf("stamp: GoType = %q, JSType = %q, want jstype honoured", fields[2].GoType, fields[2].JSType)
+	}
+	testSrc := `package example
+
+import "testing"
+
+func TestUnrelatedOptions(t *testing.T) {
+	var name string = "gauge"
+	var ratio float32 = 0.5
+	var stamp int64 = -7
+	in := &Reading{Name: name, Ratio: ratio, Stamp: stamp}
+	out, err := DecodeReading(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeReading: %v", err)
+	}
+	if *out != *in {
+		t.Fatalf("round trip = %+v, want %+v", out, in)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+func TestGeneratedNullableFalseIsValueField(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "options.proto";
+
+option go_package = "example";
+
+message Point {
+  int64 x = 1;
+  int64 y = 2;
+}
+
+message Segment {
+  Point from = 1 [(cp.nullable) = false];
+  Point to = 2;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import "testing"
+
+func TestNullableFalseRoundTrip(t *testing.T) {
+	var m Segment
+	var from Point = m.From
+	var to *Point = m.To
+	_, _ = from, to
+
+	in := &Segment{From: Point{X: 1, Y: 2}, To: &Point{X: 3}}
+	out, err := DecodeSegment(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeSegment: %v", err)
+	}
+	if out.From != (Point{X: 1, Y: 2}) || out.To == nil || *out.To != (Point{X: 3}) {
+		t.Fatalf("unexpected round trip: %+v", out)
+	}
+	if len((&Segment{}).Encode()) != 0 {
+		t.Fatalf("expected a zero value field to be omitted")
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+func TestGeneratedMessageStringer(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Inner",
+				FullName: "example.Inner",
+				Fields:   []ir.Field{{Name: "count", Number: 1, Kind: ir.KindInt32, GoEncode: true}},
+			},
+			{
+				Name:     "Outer",
+				FullName: "example.Outer",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "inner", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Inner", GoEncode: true},
+					{Name: "limit", Number: 3, Kind: ir.KindInt32, IsOptional: true, GoEncode: true},
+					{Name: "alias", Number: 4, Kind: ir.KindString, IsWrapper: true, IsOptional: true, GoEncode: true},
+					{Name: "maybes", Number: 5, Kind: ir.KindInt32, IsWrapper: true, IsRepeated: true, GoEncode: true},
+					{Name: "items", Number: 6, Kind: ir.KindMessage, MessageFullName: "example.Inner", IsRepeated: true, GoEncode: true},
+					{Name: "data", Number: 7, Kind: ir.KindBytes, GoEncode: true},
+				},
+			},
+			{Name: "Empty", FullName: "example.Empty"},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	limit, zero := int32(7), int32(0)
+	m := &Outer{Name: "a", Inner: &Inner{Count: 3}, Limit: &limit, Maybes: []*int32{nil, &zero}, Items: []*Inner{{Count: 1}}, Data: []byte{1, 2}}
+	if got, want := m.String(), "Outer{Name:a Inner:Inner{Count:3} Limit:7 Alias:<nil> Maybes:[<nil> 0] Items:[Inner{Count:1}] Data:[1 2]}"; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+	if got, want := (&Outer{}).String(), "Outer{Name: Inner:<nil> Limit:<nil> Alias:<nil> Maybes:[] Items:[] Data:[]}"; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+	if got, want := (&Empty{}).String(), "Empty{}"; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+	if got := fmt.Sprint(m); got != m.String() {
+		t.Fatalf("fmt.Sprint = %q, want String()", got)
+	}
+	var empty *Outer
+	if got := empty.String(); got != "<nil>" {
+		t.Fatalf("nil String() = %q", got)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoStringer: true}, testSrc)
+}
+
+func TestGeneratedToMapNestsMessagesAndEnums(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Level",
+			FullName: "example.Level",
+			Values:   []ir.EnumValue{{Name: "LEVEL_UNSPECIFIED", Number: 0}, {Name: "LEVEL_HIGH", Number: 1}},
+		}},
+		Messages: []ir.Message{
+			{
+				Name:     "Item",
+				FullName: "example.Item",
+				Fields: []ir.Field{
+					{Name: "sku", ProtoName: "sku", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "level", ProtoName: "level", Number: 2, Kind: ir.KindEnum, EnumFullName: "example.Level", GoEncode: true},
+				},
+			},
+			{
+				Name:     "Order",
+				FullName: "example.Order",
+				Fields: []ir.Field{
+					{Name: "order_id", ProtoName: "order_id", Number: 1, Kind: ir.KindInt64, GoEncode: true},
+					{Name: "first", ProtoName: "first", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Item", GoEncode: true},
+					{Name: "items", ProtoName: "items", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Item", IsRepeated: true, GoEncode: true},
+					{Name: "levels", ProtoName: "levels", Number: 4, Kind: ir.KindEnum, EnumFullName: "example.Level", IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "tags", ProtoName: "tags", Number: 5, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+					{Name: "by_sku", ProtoName: "by_sku", Number: 6, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindMessage, MapValueMessage: "example.Item", GoEncode: true},
+					{Name: "note", ProtoName: "note", Number: 7, Kind: ir.KindString, IsOptional: true, GoEncode: true},
+					{Name: "last", ProtoName: "last", Number: 8, Kind: ir.KindMessage, MessageFullName: "example.Item", GoEncode: true},
+					{Name: "maybes", ProtoName: "maybes", Number: 9, Kind: ir.KindInt32, IsWrapper: true, IsRepeated: true, GoEncode: true},
+					{Name: "alias", ProtoName: "alias", Number: 10, Kind: ir.KindString, IsWrapper: true, IsOptional: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestToMap(t *testing.T) {
+	zero, alias := int32(0), "al"
+	m := &Order{
+		OrderID: 7,
+		First:   &Item{Sku: "a", Level: Level_LEVEL_HIGH},
+		Items:   []*Item{{Sku: "b"}, {Sku: "c", Level: Level(9)}},
+		Levels:  []Level{Level_LEVEL_HIGH},
+		Tags:    []string{"x"},
+		BySku:   map[string]*Item{"d": {Sku: "d"}},
+		Maybes:  []*int32{nil, &zero},
+		Alias:   &alias,
+	}
+	want := map[string]any{
+		"orderId": int64(7),
+		"first":   map[string]any{"sku": "a", "level": "LEVEL_HIGH"},
+		"items": []any{
+			map[string]any{"sku": "b", "level": "LEVEL_UNSPECIFIED"},
+			map[string]any{"sku": "c", "level": int32(9)},
+		},
+		"levels": []any{"LEVEL_HIGH"},
+		"tags":   []string{"x"},
+		"bySku":  map[string]any{"d": map[string]any{"sku": "d", "level": "LEVEL_UNSPECIFIED"}},
+		"note":   nil,
+		"last":   nil,
+		"maybes": []any{nil, int32(0)},
+		"alias":  "al",
+	}
+	if got := m.ToMap(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ToMap() = %#v\nwant %#v", got, want)
+	}
+	var empty *Order
+	if got := empty.ToMap(); got != nil {
+		t.Fatalf("nil ToMap() = %#v", got)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoToMap: true}, testSrc)
+}
+
+func TestGeneratedBytesValueKeepsEmptyDistinctFromAbsent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "google/protobuf/wrappers.proto";
+
+option go_package = "example";
+
+message Blob {
+  google.protobuf.BytesValue data = 1;
+  int32 id = 2;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "blob.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"blob.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate(files, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	// check.js decodes Go's "empty" and "absent" encodings, checks they come
+	// back as an empty Uint8Array and undefined, and re-encodes both.
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "check.js"), Content: []byte(`import { decodeBlob, encodeBlob } from './model.js';
+
+const hex = (b) => Buffer.from(b).toString('hex');
+const decodeHex = (s) => decodeBlob(Uint8Array.from(Buffer.from(s, 'hex')).buffer);
+
+const empty = decodeHex(process.argv[2]);
+if (!(empty.data instanceof Uint8Array) || empty.data.length !== 0) {
+    throw new Error("expected empty data, got " + JSON.stringify(empty));
+}
+const absent = decodeHex(process.argv[3]);
+if (absent.data !== undefined) {
+    throw new Error("expected absent data to be undefined, got " + JSON.stringify(absent));
+}
+process.stdout.write([hex(encodeBlob(empty)), hex(encodeBlob(absent))].join(","));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os/exec"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestBytesValueRoundTrip(t *testing.T) {
+	empty := []byte{}
+	full := []byte("hi")
+	for _, data := range []*[]byte{nil, &empty, &full} {
+		out, err := DecodeBlob((&Blob{Data: data, ID: 1}).Encode())
+		if err != nil {
+			t.Fatalf("DecodeBlob: %v", err)
+		}
+		if (data == nil) != (out.Data == nil) {
+			t.Fatalf("presence lost: sent %v, got %v", data, out.Data)
+		}
+		if data != nil && !bytes.Equal(*data, *out.Data) {
+			t.Fatalf("got %q, want %q", *out.Data, *data)
+		}
+	}
+
+	// The wrapper is an ordinary nested message on the wire.
+	wrapped, err := proto.Marshal(wrapperspb.Bytes(full))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := append([]byte{0x0a, byte(len(wrapped))}, wrapped...)
+	if got := (&Blob{Data: &full}).Encode(); !bytes.Equal(got, want) {
+		t.Fatalf("Encode() = %x, want %x", got, want)
+	}
+	if got := (&Blob{Data: &empty}).Encode(); !bytes.Equal(got, []byte{0x0a, 0x00}) {
+		t.Fatalf("empty Encode() = %x, want 0a00", got)
+	}
+}
+
+func TestBytesValueWithJS(t *testing.T) {
+	empty := []byte{}
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "check.js")) + `, hex.EncodeToString((&Blob{Data: &empty}).Encode()), "").CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	if got := string(out); got != "0a00," {
+		t.Fatalf("JS re-encoded %q, want %q", got, "0a00,")
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+func TestGeneratedRepeatedWrapperKeepsNullElements(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "google/protobuf/wrappers.proto";
+
+option go_package = "example";
+
+message Names {
+  repeated google.protobuf.StringValue names = 1;
+  google.protobuf.Int64Value count = 2;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "names.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"names.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate(files, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	// check.js decodes Go's encoding, checks the null and empty elements
+	// survive, and re-encodes it.
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "check.js"), Content: []byte(`import { decodeNames, encodeNames } from './model.js';
+
+const msg = decodeNames(Uint8Array.from(Buffer.from(process.argv[2], 'hex')).buffer);
+const got = JSON.stringify(msg);
+if (got !== JSON.stringify({ names: ["a", null, ""], count: 0 })) {
+    throw new Error("unexpected decode: " + got);
+}
+process.stdout.write(Buffer.from(encodeNames(msg)).toString('hex'));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func newNames() *Names {
+	a, empty := "a", ""
+	var zero int64
+	return &Names{Names: []*string{&a, nil, &empty}, Count: &zero}
+}
+
+func TestRepeatedWrapperRoundTrip(t *testing.T) {
+	in := newNames()
+	out, err := DecodeNames(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeNames: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("round trip = %+v, want %+v", out, in)
+	}
+
+	// A null element is an empty wrapper; a present empty string is
+	// written explicitly.
+	want := []byte{0x0a, 0x03, 0x0a, 0x01, 'a', 0x0a, 0x00, 0x0a, 0x02, 0x0a, 0x00, 0x12, 0x00}
+	if got := in.Encode(); !bytes.Equal(got, want) {
+		t.Fatalf("Encode() = %x, want %x", got, want)
+	}
+}
+
+func TestRepeatedWrapperWithJS(t *testing.T) {
+	in := newNames()
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "check.js")) + `, hex.EncodeToString(in.Encode())).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	encoded, err := hex.DecodeString(string(out))
+	if err != nil {
+		t.Fatalf("decode hex %q: %v", out, err)
+	}
+	back, err := DecodeNames(encoded)
+	if err != nil {
+		t.Fatalf("DecodeNames: %v", err)
+	}
+	if !reflect.DeepEqual(back, in) {
+		t.Fatalf("JS round trip = %+v, want %+v", back, in)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+// TestGeneratedMaxFieldNumberRoundTripsWithJS encodes fields numbered up to
+// 536870911 (2^29-1), whose tags take five bytes, in Go, and decodes and
+// re-encodes them with the generated JS.
+func TestGeneratedMaxFieldNumberRoundTripsWithJS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+option go_package = "example";
+
+message Sparse {
+  int32 low = 1;
+  string mid = 50000;
+  repeated int32 packed = 536870909;
+  map<string, int32> counts = 536870910;
+  sint64 high = 536870911;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sparse.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"sparse.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate(files, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "check.js"), Content: []byte(`import { decodeSparse, encodeSparse } from './model.js';
+
+const msg = decodeSparse(Uint8Array.from(Buffer.from(process.argv[2], 'hex')).buffer);
+const got = JSON.stringify(msg);
+if (got !== JSON.stringify({ low: 1, mid: "m", packed: [1, 2], counts: { a: 3 }, high: -7 })) {
+    throw new Error("unexpected decode: " + got);
+}
+process.stdout.write(Buffer.from(encodeSparse(msg)).toString('hex'));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestMaxFieldNumberTag(t *testing.T) {
+	// (2^29-1)<<3 is 0xfffffff8: a five-byte varint tag.
+	want := []byte{0xf8, 0xff, 0xff, 0xff, 0x0f, 0x0d}
+	m := &Sparse{High: -7}
+	if got := m.Encode(); !bytes.Equal(got, want) {
+		t.Fatalf("Encode() = %x, want %x", got, want)
+	}
+	if n := m.SizeUpperBound(); n < len(want) {
+		t.Fatalf("SizeUpperBound() = %d, below encoded size %d", n, len(want))
+	}
+	out, err := DecodeSparse(want)
+	if err != nil {
+		t.Fatalf("DecodeSparse: %v", err)
+	}
+	if out.High != -7 {
+		t.Fatalf("High = %d, want -7", out.High)
+	}
+}
+
+func TestMaxFieldNumberWithJS(t *testing.T) {
+	in := &Sparse{Low: 1, Mid: "m", Packed: []int32{1, 2}, Counts: map[string]int32{"a": 3}, High: -7}
+	b := in.Encode()
+	if n := in.SizeUpperBound(); n < len(b) {
+		t.Fatalf("SizeUpperBound() = %d, below encoded size %d", n, len(b))
+	}
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "check.js")) + `, hex.EncodeToString(b)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	if got := string(out); got != hex.EncodeToString(b) {
+		t.Fatalf("JS re-encoded %s, want %x", got, b)
+	}
+	back, err := DecodeSparse(b)
+	if err != nil {
+		t.Fatalf("DecodeSparse: %v", err)
+	}
+	if !reflect.DeepEqual(back, in) {
+		t.Fatalf("round trip = %+v, want %+v", back, in)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+func TestGeneratedSint64BoundsRoundTripWithJS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "options.proto";
+
+option go_package = "example";
+
+message Bounds {
+  sint64 num = 1;
+  sint64 big = 2 [(cp.js_type) = "bigint"];
+  repeated sint64 nums = 3 [(cp.js_type) = "number"];
+  repeated sint64 bigs = 4 [(cp.js_type) = "bigint"];
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bounds.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"bounds.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate(files, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "check.js"), Content: []byte(`import { decodeBounds, encodeBounds } from './model.js';
+
+const msg = decodeBounds(Uint8Array.from(Buffer.from(process.argv[2], 'hex')).buffer);
+// bigints print with an n suffix, so a number in their place shows.
+process.stdout.write(JSON.stringify(msg, (key, value) => typeof value === 'bigint' ? value + 'n' : value) + '\n');
+process.stdout.write(Buffer.from(encodeBounds(msg)).toString('hex'));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"encoding/hex"
+	"math"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSint64BoundsWithJS(t *testing.T) {
+	in := &Bounds{
+		Num:  math.MinInt64,
+		Big:  math.MinInt64,
+		Nums: []int64{math.MinInt64, math.MaxInt64, -1},
+		Bigs: []int64{math.MinInt64, math.MaxInt64, -1},
+	}
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "check.js")) + `, hex.EncodeToString(in.Encode())).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	decoded, encoded, _ := strings.Cut(string(out), "\n")
+
+	// bigint fields are exact. number fields are the nearest double:
+	// MinInt64 is -2^63 exactly, and MaxInt64 rounds up to 2^63.
+	want := ` + "`" + `{"num":-9223372036854776000,"big":"-9223372036854775808n","nums":[-9223372036854776000,9223372036854776000,-1],"bigs":["-9223372036854775808n","9223372036854775807n","-1n"]}` + "`" + `
+	if decoded != want {
+		t.Fatalf("JS decoded %s, want %s", decoded, want)
+	}
+
+	b, err := hex.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("JS encoding %q: %v", encoded, err)
+	}
+	back, err := DecodeBounds(b)
+	if err != nil {
+		t.Fatalf("DecodeBounds: %v", err)
+	}
+	// Writing 2^63 back wraps to MinInt64, as a Go int64 conversion would.
+	wantBack := &Bounds{
+		Num:  math.MinInt64,
+		Big:  math.MinInt64,
+		Nums: []int64{math.MinInt64, math.MinInt64, -1},
+		Bigs: []int64{math.MinInt64, math.MaxInt64, -1},
+	}
+	if !reflect.DeepEqual(back, wantBack) {
+		t.Fatalf("JS re-encoded %+v, want %+v", back, wantBack)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+func TestGeneratedApplyMaskZeroesUnmaskedFields(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Address",
+				FullName: "example.Address",
+				Fields: []ir.Field{
+					{Name: "city", ProtoName: "city", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "street", ProtoName: "street", Number: 2, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Profile",
+				FullName: "example.Profile",
+				Fields: []ir.Field{
+					{Name: "display_name", ProtoName: "display_name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "age", ProtoName: "age", Number: 2, Kind: ir.KindInt32, GoEncode: true},
+					{Name: "home", ProtoName: "home", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Address", GoEncode: true},
+					{Name: "work", ProtoName: "work", Number: 4, Kind: ir.KindMessage, MessageFullName: "example.Address", GoEncode: true},
+					{Name: "tags", ProtoName: "tags", Number: 5, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newProfile() *Profile {
+	return &Profile{
+		DisplayName: "ada",
+		Age:         36,
+		Home:        &Address{City: "London", Street: "Main"},
+		Work:        &Address{City: "Cambridge", Street: "High"},
+		Tags:        []string{"x"},
+	}
+}
+
+func TestApplyMask(t *testing.T) {
+	m := newProfile()
+	if err := m.ApplyMask([]string{"display_name", "home.city", "work"}); err != nil {
+		t.Fatalf("ApplyMask: %v", err)
+	}
+	want := &Profile{
+		DisplayName: "ada",
+		Home:        &Address{City: "London"},
+		Work:        &Address{City: "Cambridge", Street: "High"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("ApplyMask kept %+v, want %+v", m, want)
+	}
+
+	// A whole-field path wins over a deeper one naming the same field.
+	m = newProfile()
+	if err := m.ApplyMask([]string{"home.city", "home"}); err != nil {
+		t.Fatalf("ApplyMask: %v", err)
+	}
+	if !reflect.DeepEqual(m, &Profile{Home: &Address{City: "London", Street: "Main"}}) {
+		t.Fatalf("ApplyMask kept %+v", m)
+	}
+}
+
+func TestApplyMaskRejectsInvalidPaths(t *testing.T) {
+	for path, want := range map[string]string{
+		"nickname":     "field mask path nickname: no such field",
+		"home.zip":     "field mask path home.zip: no such field",
+		"age.value":    "field mask path age.value: selects inside a field that is not a message",
+		"tags.first":   "field mask path tags.first: selects inside a field that is not a message",
+		"home.city.id": "field mask path home.city.id: selects inside a field that is not a message",
+	} {
+		m := newProfile()
+		err := m.ApplyMask([]string{"age", path})
+		var maskErr *MaskError
+		if !errors.As(err, &maskErr) || err.Error() != want {
+			t.Errorf("ApplyMask(%q) = %v, want %q", path, err, want)
+		}
+		if !reflect.DeepEqual(m, newProfile()) {
+			t.Errorf("ApplyMask(%q) modified the message: %+v", path, m)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoApplyMask: true}, testSrc)
+}
+
+func TestGeneratedPopulatedFieldsMatchEncode(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Point",
+				FullName: "example.Point",
+				Fields: []ir.Field{
+					{Name: "x", Number: 1, Kind: ir.KindInt32, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Record",
+				FullName: "example.Record",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "count", Number: 2, Kind: ir.KindInt64, GoEncode: true},
+					{Name: "maybe", Number: 3, Kind: ir.KindInt32, IsOptional: true, GoEncode: true},
+					{Name: "tags", Number: 4, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+					{Name: "scores", Number: 5, Kind: ir.KindSint32, IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "points", Number: 6, Kind: ir.KindMessage, MessageFullName: "example.Point", IsRepeated: true, GoEncode: true},
+					{Name: "labels", Number: 7, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindString, GoEncode: true},
+					{Name: "at", Number: 8, Kind: ir.KindMessage, MessageFullName: "google.protobuf.Timestamp", IsTimestamp: true, GoEncode: true},
+					{Name: "origin", Number: 9, Kind: ir.KindMessage, MessageFullName: "example.Point", GoValue: true, GoEncode: true},
+					{Name: "center", Number: 10, Kind: ir.KindMessage, MessageFullName: "example.Point", GoEncode: true},
+					{Name: "raw", Number: 11, Kind: ir.KindBytes, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func encodedFieldNumbers(t *testing.T, b []byte) []int {
+	t.Helper()
+	var nums []int
+	for len(b) > 0 {
+		rest, num, typ, err := ConsumeTag(b)
+		if err != nil {
+			t.Fatalf("ConsumeTag: %v", err)
+		}
+		if b, err = SkipFieldValue(rest, num, typ); err != nil {
+			t.Fatalf("SkipFieldValue: %v", err)
+		}
+		if len(nums) == 0 || nums[len(nums)-1] != int(num) {
+			nums = append(nums, int(num))
+		}
+	}
+	return nums
+}
+
+func TestPopulatedFields(t *testing.T) {
+	zero := int32(0)
+	for _, m := range []*Record{
+		{},
+		{
+			Name:   "a",
+			Maybe:  &zero,
+			Tags:   []string{},
+			Scores: []int32{0, -1},
+			Points: []*Point{},
+			At:     time.Time{},
+			Center: &Point{},
+		},
+		{
+			Count:  7,
+			Tags:   []string{""},
+			Labels: map[string]string{"k": "v"},
+			At:     time.Unix(5, 0),
+			Origin: Point{X: 1},
+			Raw:    []byte{},
+		},
+	} {
+		got := m.PopulatedFields()
+		want := encodedFieldNumbers(t, m.Encode())
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PopulatedFields() = %v, want %v from %+v", got, want, m)
+		}
+	}
+	if nums := (*Record)(nil).PopulatedFields(); nums != nil {
+		t.Errorf("nil PopulatedFields() = %v, want nil", nums)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoPopulatedFields: true}, testSrc)
+}
+
+func TestGeneratedMergeEncodedDecodesAsProtoMerge(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "options.proto";
+
+option go_package = "example";
+
+message Inner {
+  int32 a = 1;
+  string b = 2;
+  repeated int32 c = 3;
+}
+
+message Foo {
+  string name = 1;
+  int64 count = 2;
+  optional int32 maybe = 3;
+  repeated string tags = 4;
+  repeated int32 scores = 5;
+  Inner inner = 6;
+  Inner value = 7 [(cp.nullable) = false];
+  map<string, int32> counts = 8;
+  bytes raw = 9;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"foo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMergeEncoded(t *testing.T) {
+	zero := int32(0)
+	first := &Foo{
+		Name:   "first",
+		Count:  1,
+		Tags:   []string{"a"},
+		Scores: []int32{1},
+		Inner:  &Inner{A: 1, C: []int32{1}},
+		Value:  Inner{A: 1, B: "x"},
+		Counts: map[string]int32{"kept": 1, "replaced": 1},
+		Raw:    []byte("first"),
+	}
+	second := &Foo{
+		Count:  2,
+		Maybe:  &zero,
+		Tags:   []string{"b"},
+		Scores: []int32{2, 3},
+		Inner:  &Inner{B: "second", C: []int32{2}},
+		Value:  Inner{B: "y"},
+		Counts: map[string]int32{"replaced": 2},
+	}
+	src := second.Encode()
+	srcCopy := bytes.Clone(src)
+	merged, err := DecodeFoo(MergeEncoded(first.Encode(), src))
+	if err != nil {
+		t.Fatalf("DecodeFoo: %v", err)
+	}
+	if !bytes.Equal(src, srcCopy) {
+		t.Fatalf("MergeEncoded modified src")
+	}
+
+	// Singular fields second sets win, fields it leaves at zero keep first's
+	// values, repeated fields append and nested messages merge.
+	want := &Foo{
+		Name:   "first",
+		Count:  2,
+		Maybe:  &zero,
+		Tags:   []string{"a", "b"},
+		Scores: []int32{1, 2, 3},
+		Inner:  &Inner{A: 1, B: "second", C: []int32{1, 2}},
+		Value:  Inner{A: 1, B: "y"},
+		Counts: map[string]int32{"kept": 1, "replaced": 2},
+		Raw:    []byte("first"),
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Fatalf("merged = %+v, want %+v", merged, want)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+func TestGeneratedUnknownEnumsReportsUndeclaredValues(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "options.proto";
+
+option go_package = "example";
+
+enum Level {
+  LEVEL_UNSPECIFIED = 0;
+  LEVEL_LOW = 1;
+  LEVEL_HIGH = 2;
+}
+
+message Event {
+  Level level = 1;
+  optional Level maybe = 2;
+  repeated Level history = 3;
+  map<string, Level> by_host = 4;
+  Level named = 5 [(cp.go_type) = "string"];
+  string note = 6;
+}
+
+message Plain {
+  string note = 1;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "event.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"event.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnknownEnums(t *testing.T) {
+	// A newer schema's Level values, encoded as numbers this one lacks.
+	maybe := Level(-3)
+	in := &Event{
+		Level:   Level(7),
+		Maybe:   &maybe,
+		History: []Level{Level_LEVEL_LOW, 9, Level_LEVEL_HIGH, 8},
+		ByHost:  map[string]Level{"a": 12, "b": Level_LEVEL_LOW, "c": 11},
+		Named:   "5",
+	}
+	m, err := DecodeEvent(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeEvent: %v", err)
+	}
+	want := []UnknownEnum{
+		{Field: 1, Value: 7},
+		{Field: 2, Value: -3},
+		{Field: 3, Value: 9},
+		{Field: 3, Value: 8},
+		{Field: 4, Value: 11},
+		{Field: 4, Value: 12},
+		{Field: 5, Value: 5},
+	}
+	if got := m.UnknownEnums(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("UnknownEnums() = %v, want %v", got, want)
+	}
+
+	known := &Event{Level: Level_LEVEL_HIGH, History: []Level{Level_LEVEL_LOW}, ByHost: map[string]Level{"a": Level_LEVEL_HIGH}, Named: "LEVEL_LOW"}
+	if got := known.UnknownEnums(); got != nil {
+		t.Fatalf("UnknownEnums() of known values = %v, want nil", got)
+	}
+	if got := (&Plain{Note: "x"}).UnknownEnums(); got != nil {
+		t.Fatalf("Plain.UnknownEnums() = %v, want nil", got)
+	}
+	var nilEvent *Event
+	if got := nilEvent.UnknownEnums(); got != nil {
+		t.Fatalf("nil UnknownEnums() = %v, want nil", got)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{GoUnknownEnums: true}, testSrc)
+}
+
+// TestGeneratedSortedRangeIteratesInKeyOrder checks Range<Field>Sorted visits
+// integer, string and bool keyed maps in ascending key order and stops when
+// fn returns false.
+func TestGeneratedSortedRangeIteratesInKeyOrder(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Index",
+			FullName: "example.Index",
+			Fields: []ir.Field{
+				{Name: "by_id", Number: 1, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindSint64, MapValueKind: ir.KindString, GoEncode: true},
+				{Name: "by_name", Number: 2, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindInt32, GoEncode: true},
+				{Name: "flags", Number: 3, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindBool, MapValueKind: ir.KindString, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortedRange(t *testing.T) {
+	in := &Index{
+		ByID:   map[int64]string{40: "d", -3: "a", 7: "c", 0: "b", 1 << 40: "e"},
+		ByName: map[string]int32{"pear": 3, "apple": 1, "fig": 2, "Zed": 0},
+		Flags:  map[bool]string{true: "yes", false: "no"},
+	}
+	var ids []int64
+	var idValues []string
+	in.RangeByIDSorted(func(k int64, v string) bool {
+		ids = append(ids, k)
+		idValues = append(idValues, v)
+		return true
+	})
+	if want := []int64{-3, 0, 7, 40, 1 << 40}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("RangeByIDSorted keys = %v, want %v", ids, want)
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(idValues, want) {
+		t.Fatalf("RangeByIDSorted values = %v, want %v", idValues, want)
+	}
+	var names []string
+	in.RangeByNameSorted(func(k string, v int32) bool {
+		names = append(names, k)
+		return true
+	})
+	if want := []string{"Zed", "apple", "fig", "pear"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("RangeByNameSorted keys = %v, want %v", names, want)
+	}
+	var flags []bool
+	in.RangeFlagsSorted(func(k bool, v string) bool {
+		flags = append(flags, k)
+		return true
+	})
+	if want := []bool{false, true}; !reflect.DeepEqual(flags, want) {
+		t.Fatalf("RangeFlagsSorted keys = %v, want %v", flags, want)
+	}
+	names = nil
+	in.RangeByNameSorted(func(k string, v int32) bool {
+		names = append(names, k)
+		return len(names) < 2
+	})
+	if want := []string{"Zed", "apple"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("stopped RangeByNameSorted keys = %v, want %v", names, want)
+	}
+	var nilIndex *Index
+	nilIndex.RangeByIDSorted(func(int64, string) bool {
+		t.Fatal("fn called for a nil message")
+		return false
+	})
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoSortedRange: true}, testSrc)
+}
+
+// TestGeneratedFieldNameReturnsProtoNames checks FieldName maps field
+// numbers to proto field names, and unknown numbers to "".
+func TestGeneratedFieldNameReturnsProtoNames(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Profile",
+			FullName: "example.Profile",
+			Fields: []ir.Field{
+				{Name: "user_id", ProtoName: "user_id", Number: 1, Kind: ir.KindString, GoEncode: true},
+				{Name: "display_name", ProtoName: "display_name", Number: 5, Kind: ir.KindString, GoEncode: true},
+				{Name: "scores", ProtoName: "scores", Number: 9, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true},
+				{Name: "internal_note", ProtoName: "internal_note", Number: 12, Kind: ir.KindString, GoIgnore: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import "testing"
+
+func TestFieldName(t *testing.T) {
+	m := &Profile{}
+	for number, want := range map[int]string{1: "user_id", 5: "display_name", 9: "scores", 12: "internal_note", 0: "", 2: "", 100: ""} {
+		if got := m.FieldName(number); got != want {
+			t.Errorf("FieldName(%d) = %q, want %q", number, got, want)
+		}
+	}
+	var nilProfile *Profile
+	if got := nilProfile.FieldName(5); got != "display_name" {
+		t.Errorf("nil FieldName(5) = %q, want display_name", got)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoFieldNames: true}, testSrc)
+}
+
+func TestGeneratedVisitorCountsNestedStringFields(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+option go_package = "example";
+
+message Leaf {
+  string label = 1;
+  int32 weight = 2;
+}
+
+message Branch {
+  string name = 1;
+  Leaf leaf = 2;
+  repeated Leaf leaves = 3;
+}
+
+message Tree {
+  string title = 1;
+  Branch trunk = 2;
+  repeated Branch branches = 3;
+  map<string, Leaf> tagged = 4;
+  repeated string notes = 5;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tree.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"tree.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+type stringCounter struct {
+	count int
+	names []string
+}
+
+func (c *stringCounter) VisitField(number int, name string, value any) {
+	switch value.(type) {
+	case string:
+		c.count++
+		c.names = append(c.names, name)
+	}
+}
+
+func TestVisitFields(t *testing.T) {
+	tree := &Tree{
+		Title: "oak",
+		Trunk: &Branch{Name: "trunk", Leaf: &Leaf{Label: "a", Weight: 1}},
+		Branches: []*Branch{
+			{Name: "left", Leaves: []*Leaf{{Label: "b"}, {Label: "c"}}},
+			{Name: "right"},
+		},
+		Tagged: map[string]*Leaf{"x": {Label: "d"}, "nil": nil},
+		Notes:  []string{"not", "counted"},
+	}
+	var c stringCounter
+	tree.VisitFields(&c)
+	// Tree.title, three Branch.name and four Leaf.label; notes is a []string.
+	if c.count != 8 {
+		t.Fatalf("visited %d string fields %v, want 8", c.count, c.names)
+	}
+
+	var order []int
+	(&Branch{Name: "solo"}).VisitFields(visitFunc(func(number int, name string, value any) {
+		order = append(order, number)
+	}))
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("Branch visit order = %v, want %v", order, want)
+	}
+
+	var nilTree *Tree
+	nilTree.VisitFields(&c)
+}
+
+type visitFunc func(number int, name string, value any)
+
+func (f visitFunc) VisitField(number int, name string, value any) { f(number, name, value) }
+`
+	runGeneratedTest(t, files, generate.Options{GoVisitor: true}, testSrc)
+}
+
+func TestGeneratedDiffReportsNestedPaths(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+option go_package = "example";
+
+import "google/protobuf/timestamp.proto";
+
+enum Status {
+  STATUS_UNSPECIFIED = 0;
+  STATUS_ACTIVE = 1;
+}
+
+message Address {
+  string city = 1;
+  string zip = 2;
+}
+
+message Person {
+  string name = 1;
+  int32 age = 2;
+  Address home = 3;
+  Address work = 4;
+  optional string nickname = 5;
+  bytes avatar = 6;
+  Status status = 7;
+  google.protobuf.Timestamp joined = 8;
+  repeated string tags = 9;
+  repeated Address previous = 10;
+  map<string, Address> by_label = 11;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "person.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"person.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	joined := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	old := &Person{
+		Name:     "ada",
+		Age:      36,
+		Home:     &Address{City: "London", Zip: "N1"},
+		Avatar:   []byte{1},
+		Status:   Status_STATUS_ACTIVE,
+		Joined:   joined,
+		Tags:     []string{"a"},
+		Previous: []*Address{{City: "Paris"}},
+		ByLabel:  map[string]*Address{"x": {City: "Rome"}},
+	}
+	same := &Person{
+		Name:     "ada",
+		Age:      36,
+		Home:     &Address{City: "London", Zip: "N1"},
+		Avatar:   []byte{1},
+		Status:   Status_STATUS_ACTIVE,
+		Joined:   joined.In(time.FixedZone("X", 3600)),
+		Tags:     []string{"a"},
+		Previous: []*Address{{City: "Paris"}},
+		ByLabel:  map[string]*Address{"x": {City: "Rome"}},
+	}
+	if diffs := DiffPerson(old, same); len(diffs) != 0 {
+		t.Fatalf("expected no diffs between equal messages, got %+v", diffs)
+	}
+
+	changed := *same
+	changed.Age = 37
+	changed.Home = &Address{City: "Leeds", Zip: "N1"}
+	nickname := "countess"
+	changed.Nickname = &nickname
+	changed.Previous = []*Address{{City: "Berlin"}}
+	changed.ByLabel = map[string]*Address{"x": {City: "Rome"}, "y": nil}
+	got := DiffPerson(old, &changed)
+	want := []FieldDiff{
+		{Path: "age", Old: int32(36), New: int32(37)},
+		{Path: "home.city", Old: "London", New: "Leeds"},
+		{Path: "nickname", Old: nil, New: "countess"},
+		{Path: "previous", Old: old.Previous, New: changed.Previous},
+		{Path: "by_label", Old: old.ByLabel, New: changed.ByLabel},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DiffPerson() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	work := &Address{City: "Oxford"}
+	got = DiffPerson(&Person{Work: work}, nil)
+	want = []FieldDiff{{Path: "work", Old: work, New: nil}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DiffPerson(set, nil) = %+v, want %+v", got, want)
+	}
+	if diffs := DiffPerson(nil, &Person{Tags: []string{}}); len(diffs) != 0 {
+		t.Fatalf("expected an empty list to equal an unset one, got %+v", diffs)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{GoDiff: true}, testSrc)
+}
+
+func TestGeneratedTextFormat(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Color",
+			FullName: "example.Color",
+			Values: []ir.EnumValue{
+				{Name: "COLOR_UNSPECIFIED", Number: 0},
+				{Name: "COLOR_RED", Number: 1},
+			},
+		}},
+		Messages: []ir.Message{
+			{
+				Name:     "Leaf",
+				FullName: "example.Leaf",
+				Fields: []ir.Field{
+					{Name: "label", Number: 1, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Item",
+				FullName: "example.Item",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "count", Number: 2, Kind: ir.KindInt32, GoEncode: true},
+					{Name: "total", Number: 3, Kind: ir.KindUint64, GoEncode: true},
+					{Name: "ratio", Number: 4, Kind: ir.KindDouble, GoEncode: true},
+					{Name: "enabled", Number: 5, Kind: ir.KindBool, GoEncode: true},
+					{Name: "data", Number: 6, Kind: ir.KindBytes, GoEncode: true},
+					{Name: "color", Number: 7, Kind: ir.KindEnum, EnumFullName: "example.Color", GoEncode: true},
+					{Name: "leaf", Number: 8, Kind: ir.KindMessage, MessageFullName: "example.Leaf", GoEncode: true},
+					{Name: "tags", Number: 9, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+					{Name: "leaves", Number: 10, Kind: ir.KindMessage, MessageFullName: "example.Leaf", IsRepeated: true, GoEncode: true},
+					{Name: "counts", Number: 11, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindInt32, GoEncode: true},
+					{Name: "by_id", Number: 12, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindInt64, MapValueKind: ir.KindMessage, MapValueMessage: "example.Leaf", GoEncode: true},
+					{Name: "note", Number: 13, Kind: ir.KindString, IsOptional: true, GoEncode: true},
+					{Name: "at", Number: 14, Kind: ir.KindMessage, MessageFullName: "google.protobuf.Timestamp", IsTimestamp: true, GoEncode: true},
+					{Name: "unset", Number: 15, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"testing"
+	"time"
+)
+
+func TestItemTextString(t *testing.T) {
+	m := &Item{
+		Name:    "x \"q\"\n",
+		Count:   -3,
+		Total:   7,
+		Ratio:   0.5,
+		Enabled: true,
+		Data:    []byte{0, 'a', 0xff},
+		Color:   Color_COLOR_RED,
+		Leaf:    &Leaf{Label: "in"},
+		Tags:    []string{"a", "b"},
+		Leaves:  []*Leaf{{Label: "l1"}, nil},
+		Counts:  map[string]int32{"b": 2, "a": 1},
+		ByID:    map[int64]*Leaf{2: {Label: "two"}},
+		At:      time.Unix(5, 1000).UTC(),
+	}
+	want := "name: \"x \\\"q\\\"\\n\"\n" +
+		"count: -3\n" +
+		"total: 7\n" +
+		"ratio: 0.5\n" +
+		"enabled: true\n" +
+		"data: \"\\x00a\\xff\"\n" +
+		"color: COLOR_RED\n" +
+		"leaf {\n" +
+		"  label: \"in\"\n" +
+		"}\n" +
+		"tags: \"a\"\n" +
+		"tags: \"b\"\n" +
+		"leaves {\n" +
+		"  label: \"l1\"\n" +
+		"}\n" +
+		"leaves {\n" +
+		"}\n" +
+		"counts {\n" +
+		"  key: \"a\"\n" +
+		"  value: 1\n" +
+		"}\n" +
+		"counts {\n" +
+		"  key: \"b\"\n" +
+		"  value: 2\n" +
+		"}\n" +
+		"by_id {\n" +
+		"  key: 2\n" +
+		"  value {\n" +
+		"    label: \"two\"\n" +
+		"  }\n" +
+		"}\n" +
+		"at {\n" +
+		"  seconds: 5\n" +
+		"  nanos: 1000\n" +
+		"}\n"
+	if got := m.TextString(); got != want {
+		t.Fatalf("TextString:\n%s\nwant:\n%s", got, want)
+	}
+	if got := (&Item{Color: 9}).TextString(); got != "color: 9\n" {
+		t.Fatalf("expected an unknown enum value as its number, got %q", got)
+	}
+	if got := (&Item{}).TextString(); got != "" {
+		t.Fatalf("expected an empty message to print nothing, got %q", got)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoTextFormat: true}, testSrc)
+}
+
+func TestGeneratedTextFormatParseRoundTrip(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Color",
+			FullName: "example.Color",
+			Values: []ir.EnumValue{
+				{Name: "COLOR_UNSPECIFIED", Number: 0},
+				{Name: "COLOR_RED", Number: 1},
+				{Name: "COLOR_BLUE", Number: 2},
+			},
+		}},
+		Messages: []ir.Message{
+			{
+				Name:     "Leaf",
+				FullName: "example.Leaf",
+				Fields: []ir.Field{
+					{Name: "label", Number: 1, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Item",
+				FullName: "example.Item",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "count", Number: 2, Kind: ir.KindSint32, GoEncode: true},
+					{Name: "total", Number: 3, Kind: ir.KindFixed64, GoEncode: true},
+					{Name: "weight", Number: 4, Kind: ir.KindFloat, GoEncode: true},
+					{Name: "enabled", Number: 5, Kind: ir.KindBool, GoEncode: true},
+					{Name: "data", Number: 6, Kind: ir.KindBytes, GoEncode: true},
+					{Name: "color", Number: 7, Kind: ir.KindEnum, EnumFullName: "example.Color", GoEncode: true},
+					{Name: "leaf", Number: 8, Kind: ir.KindMessage, MessageFullName: "example.Leaf", GoEncode: true},
+					{Name: "tags", Number: 9, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+					{Name: "leaves", Number: 10, Kind: ir.KindMessage, MessageFullName: "example.Leaf", IsRepeated: true, GoEncode: true},
+					{Name: "colors", Number: 11, Kind: ir.KindEnum, EnumFullName: "example.Color", IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "counts", Number: 12, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindInt32, GoEncode: true},
+					{Name: "by_id", Number: 13, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindInt64, MapValueKind: ir.KindMessage, MapValueMessage: "example.Leaf", GoEncode: true},
+					{Name: "flags", Number: 14, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindBool, MapValueKind: ir.KindEnum, MapValueEnum: "example.Color", GoEncode: true},
+					{Name: "note", Number: 15, Kind: ir.KindString, IsOptional: true, GoEncode: true},
+					{Name: "alias", Number: 16, Kind: ir.KindString, IsWrapper: true, IsOptional: true, GoEncode: true},
+					{Name: "at", Number: 17, Kind: ir.KindMessage, MessageFullName: "google.protobuf.Timestamp", IsTimestamp: true, GoEncode: true},
+					{Name: "took", Number: 18, Kind: ir.KindMessage, MessageFullName: "google.protobuf.Duration", IsDuration: true, GoEncode: true},
+					{Name: "maybes", Number: 19, Kind: ir.KindInt32, IsWrapper: true, IsRepeated: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseItemTextRoundTrip(t *testing.T) {
+	note, alias := "", "al"
+	zero, one := int32(0), int32(1)
+	m := &Item{
+		Name:    "x \"q\"\n\x01 é",
+		Count:   -3,
+		Total:   1 << 60,
+		Weight:  1.25,
+		Enabled: true,
+		Data:    []byte{0, 'a', 0xff},
+		Color:   Color_COLOR_BLUE,
+		Leaf:    &Leaf{Label: "in"},
+		Tags:    []string{"a", "", "b"},
+		Leaves:  []*Leaf{{Label: "l1"}, {}},
+		Colors:  []Color{Color_COLOR_RED, 7},
+		Counts:  map[string]int32{"b": 2, "a": 0},
+		ByID:    map[int64]*Leaf{-2: {Label: "two"}},
+		Flags:   map[bool]Color{true: Color_COLOR_RED},
+		Note:    &note,
+		Alias:   &alias,
+		At:      time.Unix(5, 1000),
+		Took:    -1500 * time.Millisecond,
+		Maybes:  []*int32{nil, &zero, &one},
+	}
+	if text := m.TextString(); !strings.Contains(text, "maybes {\n}\nmaybes {\n  value: 0\n}\nmaybes {\n  value: 1\n}\n") {
+		t.Fatalf("expected a nil element as an empty block and a zero one with its value, got:\n%s", text)
+	}
+	got, err := ParseItemText(m.TextString())
+	if err != nil {
+		t.Fatalf("ParseItemText: %v\n%s", err, m.TextString())
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Fatalf("round trip:\n%+v\nwant:\n%+v", got, m)
+	}
+}
+
+func TestParseItemTextFixture(t *testing.T) {
+	got, err := ParseItemText(` + "`" + `
+# A fixture.
+name: 'it' "em"  count: 0x10
+color: 2, colors: [COLOR_RED]
+` + "`" + `)
+	if err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Fatalf("expected list syntax to be rejected on line 4, got %v, %+v", err, got)
+	}
+	got, err = ParseItemText(` + "`" + `
+# A fixture.
+name: 'it' "em"  count: 0x10
+color: 2; data: "\101\x42\n"
+leaf { label: "a" }
+leaf: { }
+by_id { key: 1 }
+` + "`" + `)
+	if err != nil {
+		t.Fatalf("ParseItemText: %v", err)
+	}
+	want := &Item{Name: "item", Count: 16, Color: Color_COLOR_BLUE, Data: []byte("AB\n"), Leaf: &Leaf{Label: "a"}, ByID: map[int64]*Leaf{1: {}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for _, bad := range []string{"nope: 1", "leaf {", "count: x", "color: COLOR_GREEN", "name: \"\\xff\"", "}"} {
+		if _, err := ParseItemText(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoTextFormat: true}, testSrc)
+}
+
+func TestGeneratedStringEnumFieldRoundTrips(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "options.proto";
+
+option go_package = "example";
+
+enum Level {
+  LEVEL_UNSPECIFIED = 0;
+  LEVEL_LOW = 1;
+  LEVEL_HIGH = 2;
+}
+
+message Event {
+  Level level = 1 [(cp.go_type) = "string"];
+  repeated Level history = 2 [(cp.go_type) = "string"];
+  optional Level maybe = 3 [(cp.go_type) = "string"];
+  Level plain = 4;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "event.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"event.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStringEnum(t *testing.T) {
+	unspecified := "LEVEL_UNSPECIFIED"
+	in := &Event{
+		Level:   "LEVEL_HIGH",
+		History: []string{"LEVEL_LOW", "LEVEL_HIGH"},
+		Maybe:   &unspecified,
+		Plain:   Level_LEVEL_LOW,
+	}
+	var _ string = in.Level
+	// The wire form is the enum numbers, as for an enum-typed field.
+	want := []byte{0x08, 0x02, 0x12, 0x02, 0x01, 0x02, 0x18, 0x00, 0x20, 0x01}
+	b := in.Encode()
+	if !bytes.Equal(b, want) {
+		t.Fatalf("Encode() = %x, want %x", b, want)
+	}
+	out, err := DecodeEvent(b)
+	if err != nil {
+		t.Fatalf("DecodeEvent: %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("round trip = %+v, want %+v", out, in)
+	}
+
+	// A number without a name reads as its decimal form and encodes back.
+	out, err = DecodeEvent([]byte{0x08, 0x07})
+	if err != nil {
+		t.Fatalf("DecodeEvent: %v", err)
+	}
+	if out.Level != "7" {
+		t.Fatalf("Level = %q, want \"7\"", out.Level)
+	}
+	if got := out.Encode(); !bytes.Equal(got, []byte{0x08, 0x07}) {
+		t.Fatalf("Encode() = %x, want 0807", got)
+	}
+
+	// An unknown name, such as a typo, cannot be encoded.
+	for _, m := range []*Event{{Level: "LEVEL_HIGHH"}, {History: []string{"LEVEL_LOW", "low"}}} {
+		if b, err := m.EncodeErr(); !errors.Is(err, ErrUnknownEnumName) {
+			t.Fatalf("EncodeErr() = %x, %v, want ErrUnknownEnumName", b, err)
+		}
+	}
+	if _, err := (&Event{}).EncodeErr(); err != nil {
+		t.Fatalf("EncodeErr() of the zero value: %v", err)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+func TestGeneratedReadDelimitedStopsOnCancel(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Note",
+			FullName: "example.Note",
+			Fields: []ir.Field{
+				{Name: "text", Number: 1, Kind: ir.KindString, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func frame(m *Note) []byte {
+	b := m.Encode()
+	return append(AppendVarint(nil, uint64(len(b))), b...)
+}
+
+func TestReadDelimited(t *testing.T) {
+	stream := append(frame(&Note{Text: "a"}), frame(&Note{})...)
+	// io.MultiReader has no ReadByte, so lengths are read a byte at a time.
+	for _, r := range []io.Reader{bytes.NewReader(stream), io.MultiReader(bytes.NewReader(stream))} {
+		for _, want := range []string{"a", ""} {
+			m, err := ReadDelimitedNote(context.Background(), r)
+			if err != nil {
+				t.Fatalf("ReadDelimitedNote: %v", err)
+			}
+			if m.Text != want {
+				t.Fatalf("Text = %q, want %q", m.Text, want)
+			}
+		}
+		if _, err := ReadDelimitedNote(context.Background(), r); err != io.EOF {
+			t.Fatalf("ReadDelimitedNote at end = %v, want io.EOF", err)
+		}
+	}
+	truncated := frame(&Note{Text: "abc"})
+	if _, err := ReadDelimitedNote(context.Background(), bytes.NewReader(truncated[:len(truncated)-1])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadDelimitedNote truncated = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestReadDelimitedCancel(t *testing.T) {
+	// Nothing is ever written to pr, so every Read on it blocks.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	defer pr.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ReadDelimitedNote(ctx, pr)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("ReadDelimitedNote = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadDelimitedNote did not return after cancel")
+	}
+
+	// A context done before the call returns without reading.
+	if _, err := ReadDelimitedNote(ctx, bytes.NewReader(frame(&Note{Text: "a"}))); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadDelimitedNote = %v, want context.Canceled", err)
+	}
+}
+
+func TestReadDelimitedCancelDeadline(t *testing.T) {
+	// net.Pipe has SetReadDeadline, so a blocked Read is interrupted in
+	// place rather than left running on another goroutine.
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ReadDelimitedNote(ctx, client)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("ReadDelimitedNote = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadDelimitedNote did not return after cancel")
+	}
+
+	// The deadline is cleared again, so client reads the next frame.
+	go server.Write(frame(&Note{Text: "b"}))
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	m, err := ReadDelimitedNote(ctx, client)
+	if err != nil || m.Text != "b" {
+		t.Fatalf("ReadDelimitedNote after cancel = %v, %v, want b", m, err)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoReadDelimited: true}, testSrc)
+}
+
+func TestGeneratedDecodeFieldsSkipsOtherFields(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Entry",
+				FullName: "example.Entry",
+				Fields: []ir.Field{
+					{Name: "ts", Number: 1, Kind: ir.KindInt64, GoEncode: true},
+					{Name: "level", Number: 2, Kind: ir.KindString, GoEncode: true},
+					{Name: "lines", Number: 3, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+					{Name: "source", Number: 4, Kind: ir.KindMessage, MessageFullName: "example.Source", GoEncode: true},
+					{Name: "ids", Number: 5, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Source",
+				FullName: "example.Source",
+				Fields: []ir.Field{
+					{Name: "host", Number: 1, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+		},
+	}}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeFields(t *testing.T) {
+	in := &Entry{Ts: 42, Level: "warn", Lines: []string{"a", "b"}, Source: &Source{Host: "h"}, Ids: []int32{1, 2}}
+	b := in.Encode()
+	// Out-of-order and repeated occurrences of a kept field still merge.
+	b = append(b, (&Entry{Lines: []string{"c"}, Ts: 7}).Encode()...)
+
+	got, err := DecodeEntryFields(b, 1, 5)
+	if err != nil {
+		t.Fatalf("DecodeEntryFields: %v", err)
+	}
+	if want := (&Entry{Ts: 7, Ids: []int32{1, 2}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("DecodeEntryFields(1, 5) = %+v, want %+v", got, want)
+	}
+	got, err = DecodeEntryFields(b, 3, 4)
+	if err != nil {
+		t.Fatalf("DecodeEntryFields: %v", err)
+	}
+	if want := (&Entry{Lines: []string{"a", "b", "c"}, Source: &Source{Host: "h"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("DecodeEntryFields(3, 4) = %+v, want %+v", got, want)
+	}
+	got, err = DecodeEntryFields(b)
+	if err != nil {
+		t.Fatalf("DecodeEntryFields: %v", err)
+	}
+	if !reflect.DeepEqual(got, &Entry{}) {
+		t.Fatalf("DecodeEntryFields() = %+v, want an empty Entry", got)
+	}
+	if _, err := DecodeEntryFields(b[:len(b)-1], 1); err == nil {
+		t.Fatal("DecodeEntryFields accepted a truncated message")
+	}
+}
+
+func TestDecodeFieldsSkipsLargeRepeatedCheaply(t *testing.T) {
+	in := &Entry{Ts: 1, Level: "info", Lines: strings.Split(strings.Repeat("line,", 1000), ",")}
+	b := in.Encode()
+	full := testing.AllocsPerRun(20, func() {
+		if _, err := DecodeEntry(b); err != nil {
+			t.Fatal(err)
+		}
+	})
+	projected := testing.AllocsPerRun(20, func() {
+		m, err := DecodeEntryFields(b, 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Ts != 1 || m.Level != "info" || m.Lines != nil {
+			t.Fatalf("DecodeEntryFields(1, 2) = %+v", m)
+		}
+	})
+	if projected > 2 || projected >= full {
+		t.Fatalf("DecodeEntryFields allocated %v times, DecodeEntry %v times", projected, full)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{GoDecodeFields: true}, testSrc)
+}
+
+func TestGeneratedFloatWidthGoTypes(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Reading",
+			FullName: "example.Reading",
+			Fields: []ir.Field{
+				{Name: "wide", Number: 1, Kind: ir.KindFloat, GoType: "float64", GoEncode: true},
+				{Name: "narrow", Number: 2, Kind: ir.KindDouble, GoType: "float32", GoEncode: true},
+				{Name: "wide_list", Number: 3, Kind: ir.KindFloat, GoType: "float64", IsRepeated: true, IsPacked: true, GoEncode: true},
+				{Name: "narrow_list", Number: 4, Kind: ir.KindDouble, GoType: "float32", IsRepeated: true, IsPacked: true, GoEncode: true},
+				{Name: "wide_opt", Number: 5, Kind: ir.KindFloat, GoType: "float64", IsOptional: true, GoEncode: true},
+				{Name: "narrow_opt", Number: 6, Kind: ir.KindDouble, GoType: "float32", IsOptional: true, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestReadingFieldTypes(t *testing.T) {
+	var m Reading
+	var _ float64 = m.Wide
+	var _ float32 = m.Narrow
+	var _ []float64 = m.WideList
+	var _ []float32 = m.NarrowList
+	var _ *float64 = m.WideOpt
+	var _ *float32 = m.NarrowOpt
+}
+
+func TestReadingWireTypes(t *testing.T) {
+	b := (&Reading{Wide: 1.5, Narrow: 2.5}).Encode()
+	b, num, typ, err := ConsumeTag(b)
+	if err != nil || num != 1 || typ != Fixed32Type {
+		t.Fatalf("float field: num=%v typ=%v err=%v", num, typ, err)
+	}
+	b = b[4:]
+	_, num, typ, err = ConsumeTag(b)
+	if err != nil || num != 2 || typ != Fixed64Type {
+		t.Fatalf("double field: num=%v typ=%v err=%v", num, typ, err)
+	}
+}
+
+func TestReadingRoundTrip(t *testing.T) {
+	wide, narrow := 0.25, float32(-8.5)
+	in := &Reading{
+		Wide:       1.5,
+		Narrow:     2.5,
+		WideList:   []float64{0.5, -4},
+		NarrowList: []float32{3.25, 1e10},
+		WideOpt:    &wide,
+		NarrowOpt:  &narrow,
+	}
+	out, err := DecodeReading(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeReading: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch: %+v != %+v", in, out)
+	}
+}
+
+func TestReadingPrecision(t *testing.T) {
+	// float fields surfaced as float64 still carry float32 precision on the wire.
+	out, err := DecodeReading((&Reading{Wide: 0.1}).Encode())
+	if err != nil {
+		t.Fatalf("DecodeReading: %v", err)
+	}
+	if out.Wide != float64(float32(0.1)) || out.Wide == 0.1 {
+		t.Fatalf("expected float32 precision, got %v", out.Wide)
+	}
+	// double fields surfaced as float32 lose precision when set from Go, but
+	// any double on the wire narrows to the nearest float32.
+	var b []byte
+	b = AppendTag(b, 2, Fixed64Type)
+	b = AppendFixed64(b, math.Float64bits(math.Pi))
+	out, err = DecodeReading(b)
+	if err != nil {
+		t.Fatalf("DecodeReading: %v", err)
+	}
+	if out.Narrow != float32(math.Pi) {
+		t.Fatalf("expected pi narrowed to float32, got %v", out.Narrow)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedRepeatedScalarEncodeIsInlined(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Series",
+			FullName: "example.Series",
+			Fields: []ir.Field{
+				{Name: "values", Number: 1, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true},
+				{Name: "labels", Number: 2, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"testing"
+)
+
+func sampleSeries() *Series {
+	m := &Series{Labels: []string{"a", "b"}}
+	for i := int32(0); i < 256; i++ {
+		m.Values = append(m.Values, i*1000-50000)
+	}
+	return m
+}
+
+// encodeSeriesWithClosures is the decorator-based encoding generated code
+// used before repeated scalar loops were inlined.
+func encodeSeriesWithClosures(m *Series) []byte {
+	var b []byte
+	b = AppendRepeatedCompact(b, m.Values, 1, AppendCompactDecorator(AppendInt32Compact))
+	b = AppendRepeated(b, m.Labels, AppendFieldDecorator(AppendStringField, 2))
+	return b
+}
+
+func TestSeriesInlinedEncodingMatchesClosures(t *testing.T) {
+	m := sampleSeries()
+	if !bytes.Equal(m.Encode(), encodeSeriesWithClosures(m)) {
+		t.Fatalf("inlined encoding differs from decorator-based encoding")
+	}
+	out, err := DecodeSeries(m.Encode())
+	if err != nil {
+		t.Fatalf("DecodeSeries: %v", err)
+	}
+	if len(out.Values) != 256 || out.Values[255] != 205000 || len(out.Labels) != 2 {
+		t.Fatalf("unexpected round trip: %+v", out)
+	}
+}
+
+func TestSeriesInlinedEncodingAllocs(t *testing.T) {
+	m := sampleSeries()
+	inlined := testing.AllocsPerRun(100, func() { m.Encode() })
+	closures := testing.AllocsPerRun(100, func() { encodeSeriesWithClosures(m) })
+	if inlined > closures {
+		t.Fatalf("inlined encode allocated more than closures: %v > %v", inlined, closures)
+	}
+}
+
+func BenchmarkEncodeRepeatedInt32Inlined(b *testing.B) {
+	m := sampleSeries()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.Encode()
+	}
+}
+
+func BenchmarkEncodeRepeatedInt32Closures(b *testing.B) {
+	m := sampleSeries()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeSeriesWithClosures(m)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedDecodeMaxSize(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Node",
+			FullName: "example.Node",
+			Fields: []ir.Field{
+				{Name: "child", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Node", GoEncode: true},
+				{Name: "pad", Number: 2, Kind: ir.KindBytes, GoEncode: true},
+				{Name: "kids", Number: 3, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindMessage, MapValueMessage: "example.Node", GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"errors"
+	"testing"
+)
+
+// chain nests depth Nodes, each carrying 100 bytes of padding, so decoding
+// touches roughly depth*depth*50 bytes for an input of depth*100 bytes.
+func chain(depth int) *Node {
+	var n *Node
+	for i := 0; i < depth; i++ {
+		n = &Node{Child: n, Pad: make([]byte, 100)}
+	}
+	return n
+}
+
+func TestDecodeMaxSizeNestedChain(t *testing.T) {
+	b := chain(40).Encode()
+	if _, err := DecodeNode(b); err != nil {
+		t.Fatalf("DecodeNode: %v", err)
+	}
+	if _, err := DecodeNodeWithOptions(b, DecodeOptions{MaxSize: 1 << 20}); err != nil {
+		t.Fatalf("DecodeNodeWithOptions under cap: %v", err)
+	}
+	// The input is ~4KB, but nested levels sum to ~80KB.
+	if _, err := DecodeNodeWithOptions(b, DecodeOptions{MaxSize: 20000}); !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Fatalf("expected ErrMaxSizeExceeded, got %v", err)
+	}
+}
+
+func TestDecodeMaxSizeCountsMapValues(t *testing.T) {
+	m := &Node{Kids: map[string]*Node{}}
+	for _, k := range []string{"a", "b", "c", "d"} {
+		m.Kids[k] = chain(10)
+	}
+	b := m.Encode()
+	if _, err := DecodeNodeWithOptions(b, DecodeOptions{MaxSize: len(b) * 2}); !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Fatalf("expected map values to count toward the cap, got %v", err)
+	}
+}
+
+func TestDecodeMaxSizeCountsInput(t *testing.T) {
+	b := (&Node{Pad: make([]byte, 64)}).Encode()
+	if _, err := DecodeNodeWithOptions(b, DecodeOptions{MaxSize: len(b) - 1}); !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Fatalf("expected input larger than the cap to fail, got %v", err)
+	}
+	if _, err := DecodeNodeWithOptions(b, DecodeOptions{MaxSize: len(b)}); err != nil {
+		t.Fatalf("expected input equal to the cap to decode, got %v", err)
+	}
+}
+
+func TestDecodeOversizedNestedLengthClaim(t *testing.T) {
+	// Field 1 claims a 1GiB nested message backed by only a few bytes.
+	var b []byte
+	b = AppendTag(b, 1, BytesType)
+	b = AppendVarint(b, 1<<30)
+	b = append(b, 0x12, 0x00)
+	for _, opts := range []DecodeOptions{{}, {MaxSize: 1 << 20}} {
+		m, err := DecodeNodeWithOptions(b, opts)
+		if err == nil || m != nil {
+			t.Fatalf("expected oversized length claim to fail cleanly with %+v, got %+v, %v", opts, m, err)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedDecoderAppliesOptionsToEachDecode(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Node",
+				FullName: "example.Node",
+				Fields: []ir.Field{
+					{Name: "child", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Node", GoEncode: true},
+					{Name: "pad", Number: 2, Kind: ir.KindBytes, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Badge",
+				FullName: "example.Badge",
+				Fields: []ir.Field{
+					{Name: "label", Number: 1, Kind: ir.KindString, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	small := (&Node{Child: &Node{Pad: []byte{1, 2}}}).Encode()
+	large := (&Node{Child: &Node{Pad: make([]byte, 200)}}).Encode()
+	d := &Decoder{Options: DecodeOptions{MaxSize: 100}}
+	// Each decode gets a fresh budget, so small inputs keep decoding after
+	// the first.
+	for i := 0; i < 3; i++ {
+		m, err := d.DecodeNode(small)
+		if err != nil {
+			t.Fatalf("decode %d: %v", i, err)
+		}
+		if !bytes.Equal(m.Child.Pad, []byte{1, 2}) {
+			t.Fatalf("decode %d = %+v", i, m)
+		}
+		badge, err := d.DecodeBadge((&Badge{Label: "x"}).Encode())
+		if err != nil || badge.Label != "x" {
+			t.Fatalf("DecodeBadge %d = %+v, %v", i, badge, err)
+		}
+	}
+	if _, err := d.DecodeNode(large); !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Fatalf("DecodeNode(large) error = %v, want ErrMaxSizeExceeded", err)
+	}
+	if _, err := d.DecodeNode(small); err != nil {
+		t.Fatalf("DecodeNode(small) after a failed decode: %v", err)
+	}
+
+	var zero Decoder
+	var nilDecoder *Decoder
+	for _, dec := range []*Decoder{&zero, nilDecoder} {
+		m, err := dec.DecodeNode(large)
+		if err != nil || len(m.Child.Pad) != 200 {
+			t.Fatalf("unlimited DecodeNode = %+v, %v", m, err)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoDecoder: true}, testSrc)
+}
+
+func TestGoGeneratorRejectsDecoderConflict(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages:  []ir.Message{{Name: "Decoder", FullName: "example.Decoder"}},
+	}
+	_, err := Generator{}.Generate([]ir.File{file}, generate.Options{GoOut: t.TempDir(), GoDecoder: true})
+	if err == nil || !strings.Contains(err.Error(), "go Decoder type for -go.decoder conflicts with message example.Decoder") {
+		t.Fatalf("expected Decoder conflict error, got %v", err)
+	}
+}
+
+func TestGeneratedPackedEncodeReusesScratchBuffer(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Metrics",
+			FullName: "example.Metrics",
+			Fields: []ir.Field{
+				{Name: "counts", Number: 1, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true},
+				{Name: "totals", Number: 2, Kind: ir.KindInt64, IsRepeated: true, IsPacked: true, GoEncode: true},
+				{Name: "deltas", Number: 3, Kind: ir.KindSint32, IsRepeated: true, IsPacked: true, GoEncode: true},
+				{Name: "ratios", Number: 4, Kind: ir.KindDouble, IsRepeated: true, IsPacked: true, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"testing"
+)
+
+func sampleMetrics() []*Metrics {
+	out := make([]*Metrics, 64)
+	for i := range out {
+		m := &Metrics{}
+		for j := 0; j < 32; j++ {
+			m.Counts = append(m.Counts, int32(i*j))
+			m.Totals = append(m.Totals, int64(i*j)<<20)
+			m.Deltas = append(m.Deltas, int32(j-i))
+			m.Ratios = append(m.Ratios, float64(i)/float64(j+1))
+		}
+		out[i] = m
+	}
+	return out
+}
+
+// appendPackedFresh is how packed fields were encoded before the scratch
+// buffer was pooled: a new packed slice per field per message.
+func appendPackedFresh[T any](b []byte, num Number, values []T, appendValue func([]byte, T) []byte) []byte {
+	var packed []byte
+	for _, v := range values {
+		packed = appendValue(packed, v)
+	}
+	if len(packed) > 0 {
+		b = AppendTag(b, num, BytesType)
+		b = AppendBytes(b, packed)
+	}
+	return b
+}
+
+func encodeMetricsFresh(m *Metrics) []byte {
+	var b []byte
+	b = appendPackedFresh(b, 1, m.Counts, AppendInt32Compact)
+	b = appendPackedFresh(b, 2, m.Totals, AppendInt64Compact)
+	b = appendPackedFresh(b, 3, m.Deltas, AppendSint32Compact)
+	b = appendPackedFresh(b, 4, m.Ratios, AppendFloat64Compact)
+	return b
+}
+
+func encodeAll(msgs []*Metrics, encode func(*Metrics) []byte) {
+	for _, m := range msgs {
+		encode(m)
+	}
+}
+
+func TestMetricsPooledEncodingMatchesFresh(t *testing.T) {
+	for _, m := range sampleMetrics() {
+		if !bytes.Equal(m.Encode(), encodeMetricsFresh(m)) {
+			t.Fatalf("pooled packed encoding differs from fresh buffers")
+		}
+	}
+	out, err := DecodeMetrics(sampleMetrics()[3].Encode())
+	if err != nil {
+		t.Fatalf("DecodeMetrics: %v", err)
+	}
+	if len(out.Counts) != 32 || out.Counts[31] != 93 || out.Deltas[0] != -3 || out.Ratios[1] != 1.5 {
+		t.Fatalf("unexpected round trip: %+v", out)
+	}
+}
+
+func TestMetricsPooledEncodingAllocs(t *testing.T) {
+	msgs := sampleMetrics()
+	encodeAll(msgs, (*Metrics).Encode)
+	pooled := testing.AllocsPerRun(50, func() { encodeAll(msgs, (*Metrics).Encode) })
+	fresh := testing.AllocsPerRun(50, func() { encodeAll(msgs, encodeMetricsFresh) })
+	if pooled >= fresh {
+		t.Fatalf("expected pooled packed encoding to allocate less: pooled %v, fresh %v", pooled, fresh)
+	}
+}
+
+func BenchmarkEncodePackedPooled(b *testing.B) {
+	msgs := sampleMetrics()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeAll(msgs, (*Metrics).Encode)
+	}
+}
+
+func BenchmarkEncodePackedFresh(b *testing.B) {
+	msgs := sampleMetrics()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeAll(msgs, encodeMetricsFresh)
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedOptionalBytesRoundTripsNilEmptyAndSet(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Blob",
+			FullName: "example.Blob",
+			Fields: []ir.Field{
+				{Name: "data", Number: 1, Kind: ir.KindBytes, IsOptional: true, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOptionalBytes(t *testing.T) {
+	empty := []byte{}
+	set := []byte("x")
+	for _, tc := range []struct {
+		name string
+		data *[]byte
+		wire []byte
+	}{
+		{"nil", nil, nil},
+		{"empty", &empty, []byte{0x0a, 0x00}},
+		{"set", &set, []byte{0x0a, 0x01, 'x'}},
+	} {
+		b := (&Blob{Data: tc.data}).Encode()
+		if !bytes.Equal(b, tc.wire) {
+			t.Fatalf("%s: Encode() = %x, want %x", tc.name, b, tc.wire)
+		}
+		out, err := DecodeBlob(b)
+		if err != nil {
+			t.Fatalf("%s: DecodeBlob: %v", tc.name, err)
+		}
+		switch {
+		case tc.data == nil:
+			if out.Data != nil {
+				t.Fatalf("%s: decoded %q, want nil", tc.name, *out.Data)
+			}
+		case out.Data == nil || *out.Data == nil || !bytes.Equal(*out.Data, *tc.data):
+			t.Fatalf("%s: decoded %v, want non-nil %q", tc.name, out.Data, *tc.data)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+func TestGeneratedEmptyOptionalStringsAndBytesAllocateOnlyPointers(t *testing.T) {
+	var fields []ir.Field
+	for i := 1; i <= 8; i++ {
+		fields = append(fields,
+			ir.Field{Name: "s" + strconv.Itoa(i), Number: 2*i - 1, Kind: ir.KindString, IsOptional: true, GoEncode: true},
+			ir.Field{Name: "b" + strconv.Itoa(i), Number: 2 * i, Kind: ir.KindBytes, IsOptional: true, GoEncode: true})
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Messages:  []ir.Message{{Name: "Sparse", FullName: "example.Sparse", Fields: fields}},
+	}
+	testSrc := `package example
+
+import "testing"
+
+var sparseSink *Sparse
+
+// sampleSparse encodes every field present with an empty value.
+func sampleSparse() []byte {
+	s, b := "", []byte{}
+	return (&Sparse{
+		S1: &s, S2: &s, S3: &s, S4: &s, S5: &s, S6: &s, S7: &s, S8: &s,
+		B1: &b, B2: &b, B3: &b, B4: &b, B5: &b, B6: &b, B7: &b, B8: &b,
+	}).Encode()
+}
+
+func TestDecodeEmptyOptionalAllocs(t *testing.T) {
+	buf := sampleSparse()
+	m, err := DecodeSparse(buf)
+	if err != nil {
+		t.Fatalf("DecodeSparse: %v", err)
+	}
+	if m.S8 == nil || *m.S8 != "" || m.B8 == nil || *m.B8 == nil || len(*m.B8) != 0 {
+		t.Fatalf("unexpected fields: %+v", m)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		// Keeping the result stops the message being stack allocated.
+		if sparseSink, err = DecodeSparse(buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// The message and one pointer per field; empty values copy nothing.
+	if allocs != 17 {
+		t.Fatalf("DecodeSparse allocated %v times per run, want 17", allocs)
+	}
+}
+
+func BenchmarkDecodeEmptyOptional(b *testing.B) {
+	buf := sampleSparse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeSparse(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedOptionalPresenceAgreesWithJS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Level",
+			FullName: "example.Level",
+			Values:   []ir.EnumValue{{Name: "LEVEL_UNSPECIFIED", Number: 0}, {Name: "LEVEL_HIGH", Number: 1}},
+		}},
+		Messages: []ir.Message{{
+			Name:     "Presence",
+			FullName: "example.Presence",
+			Fields: []ir.Field{
+				{Name: "flag", Number: 1, Kind: ir.KindBool, IsOptional: true, GoEncode: true, JsEncode: true},
+				{Name: "blob", Number: 2, Kind: ir.KindBytes, IsOptional: true, GoEncode: true, JsEncode: true},
+				{Name: "level", Number: 3, Kind: ir.KindEnum, EnumFullName: "example.Level", IsOptional: true, GoEncode: true, JsEncode: true},
+				{Name: "count", Number: 4, Kind: ir.KindInt32, IsOptional: true, GoEncode: true, JsEncode: true},
+				{Name: "note", Number: 5, Kind: ir.KindString, IsOptional: true, GoEncode: true, JsEncode: true},
+			},
+		}},
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate([]ir.File{file}, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	// presence.js decodes Go's "set to zero" and "absent" encodings, checks
+	// set-but-zero fields come back as their zero value and absent ones as
+	// undefined, then re-encodes both alongside its own set-to-zero literal.
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "presence.js"), Content: []byte(`import { decodePresence, encodePresence } from './model.js';
+
+const hex = (b) => Buffer.from(b).toString('hex');
+const decodeHex = (s) => decodePresence(Uint8Array.from(Buffer.from(s, 'hex')).buffer);
+
+const set = decodeHex(process.argv[2]);
+if (set.flag !== false || !(set.blob instanceof Uint8Array) || set.blob.length !== 0 || set.level !== 0 || set.count !== 0 || set.note !== "") {
+    throw new Error("set fields not decoded as zero values: " + JSON.stringify(set));
+}
+const absent = decodeHex(process.argv[3]);
+for (const name of ["flag", "blob", "level", "count", "note"]) {
+    if (absent[name] !== undefined) {
+        throw new Error("expected absent " + name + " to be undefined, got " + JSON.stringify(absent[name]));
+    }
+}
+const literal = encodePresence({ flag: false, blob: new Uint8Array(0), level: 0, count: 0, note: "" });
+process.stdout.write([hex(encodePresence(set)), hex(encodePresence(absent)), hex(literal)].join(","));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestOptionalPresenceWithJS(t *testing.T) {
+	flag := false
+	blob := []byte{}
+	level := Level_LEVEL_UNSPECIFIED
+	count := int32(0)
+	note := ""
+	set := (&Presence{Flag: &flag, Blob: &blob, Level: &level, Count: &count, Note: &note}).Encode()
+	absent := (&Presence{}).Encode()
+	if len(set) == 0 || len(absent) != 0 {
+		t.Fatalf("unexpected Go encodings: set %x, absent %x", set, absent)
+	}
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "presence.js")) + `, hex.EncodeToString(set), hex.EncodeToString(absent)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	parts := strings.Split(string(out), ",")
+	if len(parts) != 3 {
+		t.Fatalf("unexpected node output: %q", out)
+	}
+	for i, name := range []string{"re-encoded set", "re-encoded absent", "JS literal"} {
+		b, err := hex.DecodeString(parts[i])
+		if err != nil {
+			t.Fatalf("%s: decode hex %q: %v", name, parts[i], err)
+		}
+		m, err := DecodePresence(b)
+		if err != nil {
+			t.Fatalf("%s: DecodePresence: %v", name, err)
+		}
+		if i == 1 {
+			if m.Flag != nil || m.Blob != nil || m.Level != nil || m.Count != nil || m.Note != nil {
+				t.Fatalf("%s: expected all fields absent, got %+v", name, m)
+			}
+			continue
+		}
+		if !bytes.Equal(b, set) {
+			t.Fatalf("%s: JS encoding %x differs from Go %x", name, b, set)
+		}
+		if m.Flag == nil || *m.Flag || m.Blob == nil || len(*m.Blob) != 0 || m.Level == nil || *m.Level != Level_LEVEL_UNSPECIFIED ||
+			m.Count == nil || *m.Count != 0 || m.Note == nil || *m.Note != "" {
+			t.Fatalf("%s: expected set-but-zero fields, got %+v", name, m)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedPackedFixedWidthAgreesWithJS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Samples",
+			FullName: "example.Samples",
+			Fields: []ir.Field{
+				{Name: "values", Number: 1, Kind: ir.KindDouble, IsRepeated: true, IsPacked: true, GoEncode: true, JsEncode: true},
+				{Name: "ratios", Number: 2, Kind: ir.KindFloat, IsRepeated: true, IsPacked: true, GoEncode: true, JsEncode: true},
+				{Name: "counts", Number: 3, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true, JsEncode: true},
+			},
+		}},
+	}
+	jsDir, err := filepath.Abs(t.TempDir())
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	jsOutputs, err := jsg.Generator{}.Generate([]ir.File{file}, generate.Options{JsOut: jsDir})
+	if err != nil {
+		t.Fatalf("generate js: %v", err)
+	}
+	// samples.js decodes Go's packed encoding, checks every element came back
+	// (a reader advancing the wrong width per element would shift or drop
+	// them), then re-encodes it alongside an encoding of its own literal.
+	jsOutputs = append(jsOutputs,
+		generate.OutputFile{Path: filepath.Join(jsDir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(jsDir, "samples.js"), Content: []byte(`import { decodeSamples, encodeSamples } from './model.js';
+
+const hex = (b) => Buffer.from(b).toString('hex');
+const want = { values: [1.5, -2.25, 0, 1e300, 3], ratios: [0.5, -3.25, 0, 1024], counts: [1, 300, -1] };
+
+const got = decodeSamples(Uint8Array.from(Buffer.from(process.argv[2], 'hex')).buffer);
+if (JSON.stringify(got) !== JSON.stringify(want)) {
+    throw new Error("unexpected samples: " + JSON.stringify(got));
+}
+process.stdout.write([hex(encodeSamples(got)), hex(encodeSamples(want))].join(","));
+`)},
+	)
+	if err := generate.WriteFiles(jsOutputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackedFixedWidthWithJS(t *testing.T) {
+	want := &Samples{
+		Values: []float64{1.5, -2.25, 0, 1e300, 3},
+		Ratios: []float32{0.5, -3.25, 0, 1024},
+		Counts: []int32{1, 300, -1},
+	}
+	b := want.Encode()
+	out, err := exec.Command("node", ` + strconv.Quote(filepath.Join(jsDir, "samples.js")) + `, hex.EncodeToString(b)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	parts := strings.Split(string(out), ",")
+	if len(parts) != 2 {
+		t.Fatalf("unexpected node output: %q", out)
+	}
+	for i, name := range []string{"re-encoded", "JS literal"} {
+		js, err := hex.DecodeString(parts[i])
+		if err != nil {
+			t.Fatalf("%s: decode hex %q: %v", name, parts[i], err)
+		}
+		if !bytes.Equal(js, b) {
+			t.Fatalf("%s: JS encoding %x differs from Go %x", name, js, b)
+		}
+		got, err := DecodeSamples(js)
+		if err != nil {
+			t.Fatalf("%s: DecodeSamples: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedDecodeNReportsBytesConsumed(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Record",
+			FullName: "example.Record",
+			Fields: []ir.Field{
+				{Name: "key", Number: 1, Kind: ir.KindString, GoEncode: true},
+				{Name: "body", Number: 2, Kind: ir.KindBytes, GoEncode: true},
+			},
+		}},
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func frame(m *Record) []byte {
+	return AppendBytes(nil, m.Encode())
+}
+
+func TestDecodeRecordNConsumesOneFrame(t *testing.T) {
+	in := &Record{Key: "k", Body: bytes.Repeat([]byte{7}, 200)}
+	enc := in.Encode()
+	out, n, err := DecodeRecordN(frame(in))
+	if err != nil {
+		t.Fatalf("DecodeRecordN: %v", err)
+	}
+	// 200+ bytes of payload need a two-byte uvarint prefix.
+	if n != len(enc)+2 {
+		t.Fatalf("expected %d bytes consumed (len(Encode()) plus prefix), got %d", len(enc)+2, n)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch: %+v != %+v", in, out)
+	}
+}
+
+func TestDecodeRecordNMidBuffer(t *testing.T) {
+	records := []*Record{{Key: "a"}, {Key: "b", Body: []byte{1, 2, 3}}, {}}
+	var buf []byte
+	for _, r := range records {
+		buf = append(buf, frame(r)...)
+	}
+	buf = append(buf, 0xff) // trailing bytes after the last frame
+	for i, want := range records {
+		got, n, err := DecodeRecordN(buf)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if n != len(frame(want)) {
+			t.Fatalf("frame %d: expected %d bytes consumed, got %d", i, len(frame(want)), n)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("frame %d: %+v != %+v", i, want, got)
+		}
+		buf = buf[n:]
+	}
+	if len(buf) != 1 {
+		t.Fatalf("expected only the trailing byte left, got %x", buf)
+	}
+	if _, _, err := DecodeRecordN(buf); err == nil {
+		t.Fatalf("expected an error for a truncated frame")
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{}, testSrc)
+}
+
+func TestGeneratedUtilPrefixAvoidsUserNameClashes(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Kind",
+			FullName: "example.Kind",
+			Values:   []ir.EnumValue{{Name: "KIND_UNSPECIFIED", Number: 0}, {Name: "KIND_A", Number: 1}},
+		}},
+		Messages: []ir.Message{
+			{
+				Name:     "Leaf",
+				FullName: "example.Leaf",
+				Fields:   []ir.Field{{Name: "note", Number: 1, Kind: ir.KindString, GoEncode: true}},
+			},
+			{
+				Name:     "Tree",
+				FullName: "example.Tree",
+				Fields: []ir.Field{
+					{Name: "count", Number: 1, Kind: ir.KindInt32, GoEncode: true},
+					{Name: "values", Number: 2, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "leaf", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Leaf", GoEncode: true},
+					{Name: "leaves", Number: 4, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindMessage, MapValueMessage: "example.Leaf", GoEncode: true},
+					{Name: "kind", Number: 5, Kind: ir.KindEnum, EnumFullName: "example.Kind", IsOptional: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The package's own declarations that would clash with unprefixed helpers.
+type Encodable struct{}
+
+func AppendInt32Field() {}
+
+var ConsumeTag = "user"
+
+func TestPrefixedUtilRoundTrip(t *testing.T) {
+	kind := Kind_KIND_A
+	in := &Tree{
+		Count:  3,
+		Values: []int32{1, -2, 3},
+		Leaf:   &Leaf{Note: "x"},
+		Leaves: map[string]*Leaf{"a": {Note: "y"}},
+		Kind:   &kind,
+	}
+	out, err := DecodeTree(in.Encode())
+	if err != nil {
+		t.Fatalf("DecodeTree: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch: %+v != %+v", in, out)
+	}
+	if _, err := DecodeTreeWithOptions(in.Encode(), cp_DecodeOptions{MaxSize: 1}); err == nil {
+		t.Fatalf("expected the prefixed DecodeOptions to apply")
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoUtilPrefix: "cp_", GoEmitSamples: true}, testSrc)
+}
+
+func TestGeneratedExampleWithUtilPrefixPassesVet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles generated code")
+	}
+	p := parser.Parser{ImportPaths: []string{"../../..", "../../../example"}}
+	files, err := p.Parse(context.Background(), []string{"library.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, singleFile := range []bool{false, true} {
+		dir, err := os.MkdirTemp(".", "gentest")
+		if err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		outputs, err := Generator{}.Generate(files, generate.Options{
+			GoOut:         dir,
+			GoClient:      true,
+			GoServer:      true,
+			GoEmitSamples: true,
+			GoSingleFile:  singleFile,
+			GoUtilPrefix:  "cp_",
+		})
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if err := generate.WriteFiles(outputs); err != nil {
+			t.Fatalf("WriteFiles: %v", err)
+		}
+		cmd := exec.Command("go", "vet", ".")
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go vet generated package (singlefile=%v): %v\n%s", singleFile, err, out)
+		}
+	}
+}
+
+func TestGeneratedProtoJSONMatchesProtobufGo(t *testing.T) {
+	p := parser.Parser{ImportPaths: []string{"testdata/protojsonpb"}}
+	files, err := p.Parse(context.Background(), []string{"protojson.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for i := range files {
+		files[i].GoPackage = "example"
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/jptrs93/cleanproto/internal/generate/go/testdata/protojsonpb"
+)
+
+func compareJSON(t *testing.T, name string, got *Record, want *pb.Record) {
+	t.Helper()
+	// Called directly: json.Marshal would HTML-escape the result.
+	gotJSON, err := got.MarshalJSON()
+	if err != nil {
+		t.Fatalf("%s: MarshalJSON: %v", name, err)
+	}
+	wantJSON, err := protojson.Marshal(want)
+	if err != nil {
+		t.Fatalf("%s: protojson.Marshal: %v", name, err)
+	}
+	// protojson randomises whitespace to discourage byte comparisons.
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, wantJSON); err != nil {
+		t.Fatalf("%s: compact: %v", name, err)
+	}
+	if !bytes.Equal(gotJSON, compact.Bytes()) {
+		t.Fatalf("%s: JSON mismatch\n got: %s\nwant: %s", name, gotJSON, compact.Bytes())
+	}
+}
+
+func TestProtoJSONParity(t *testing.T) {
+	created := time.Date(2024, 2, 29, 13, 4, 5, 120000000, time.FixedZone("CET", 3600))
+	zero := int32(0)
+	name := "set"
+	compareJSON(t, "populated", &Record{
+		DisplayName: "a <b> & \"c\"\n\x01\u2028é",
+		Count:       -7,
+		BigID:       math.MinInt64,
+		TotalBytes:  math.MaxUint64,
+		Delta:       -3,
+		Checksum:    1 << 60,
+		Flags:       math.MaxUint32,
+		Enabled:     true,
+		Ratio:       1e21,
+		Score:       0.1,
+		Payload:     []byte{0xfb, 0xff, 0x00},
+		Level:       Level_LEVEL_HIGH,
+		MaybeZero:   &zero,
+		MaybeName:   &name,
+		Origin:      &Point{X: 1},
+		Path:        []*Point{{X: 1, Y: 2}, {}},
+		Ids:         []int64{1, -1 << 62},
+		Tags:        []string{"x", ""},
+		Levels:      []Level{Level_LEVEL_LOW, Level(9)},
+		Samples:     []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1e-7, -0.5, 123456789},
+		Labels:      map[int32]string{10: "ten", 2: "two", -1: "minus"},
+		Points:      map[string]*Point{"b": {Y: 1}, "a": {X: 1}, "B": {}},
+		LevelsByID:  map[int64]Level{1 << 40: Level_LEVEL_LOW, 3: Level(-4)},
+		Switches:    map[bool]int32{true: 1, false: 0},
+		CreatedAt:   created,
+		Timeout:     -1500 * time.Millisecond,
+		Custom:      "renamed",
+	}, &pb.Record{
+		DisplayName: "a <b> & \"c\"\n\x01\u2028é",
+		Count:       -7,
+		BigId:       math.MinInt64,
+		TotalBytes:  math.MaxUint64,
+		Delta:       -3,
+		Checksum:    1 << 60,
+		Flags:       math.MaxUint32,
+		Enabled:     true,
+		Ratio:       1e21,
+		Score:       0.1,
+		Payload:     []byte{0xfb, 0xff, 0x00},
+		Level:       pb.Level_LEVEL_HIGH,
+		MaybeZero:   &zero,
+		MaybeName:   &name,
+		Origin:      &pb.Point{X: 1},
+		Path:        []*pb.Point{{X: 1, Y: 2}, {}},
+		Ids:         []int64{1, -1 << 62},
+		Tags:        []string{"x", ""},
+		Levels:      []pb.Level{pb.Level_LEVEL_LOW, pb.Level(9)},
+		Samples:     []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1e-7, -0.5, 123456789},
+		Labels:      map[int32]string{10: "ten", 2: "two", -1: "minus"},
+		Points:      map[string]*pb.Point{"b": {Y: 1}, "a": {X: 1}, "B": {}},
+		LevelsById:  map[int64]pb.Level{1 << 40: pb.Level_LEVEL_LOW, 3: pb.Level(-4)},
+		Switches:    map[bool]int32{true: 1, false: 0},
+		CreatedAt:   timestamppb.New(created),
+		Timeout:     durationpb.New(-1500 * time.Millisecond),
+		Custom:      "renamed",
+	})
+	compareJSON(t, "empty", &Record{}, &pb.Record{})
+	for _, d := range []time.Duration{time.Second, time.Nanosecond, -time.Microsecond, 90 * time.Minute, 1234567 * time.Microsecond} {
+		compareJSON(t, d.String(), &Record{Timeout: d}, &pb.Record{Timeout: durationpb.New(d)})
+	}
+	for _, ts := range []time.Time{time.Unix(0, 1), time.Unix(1700000000, 0), time.Unix(-1, 5000), time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.UTC)} {
+		compareJSON(t, ts.String(), &Record{CreatedAt: ts}, &pb.Record{CreatedAt: timestamppb.New(ts)})
+	}
+	for _, f := range []float32{1e-7, 3.4e38, -1.5, 16777216} {
+		compareJSON(t, "float", &Record{Score: f}, &pb.Record{Score: f})
+	}
+}
+
+func TestProtoJSONErrors(t *testing.T) {
+	if _, err := json.Marshal(&Record{DisplayName: "\xff"}); err == nil {
+		t.Fatal("expected invalid UTF-8 to fail like protojson")
+	}
+	if _, err := json.Marshal(&Record{CreatedAt: time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)}); err == nil {
+		t.Fatal("expected an out of range timestamp to fail like protojson")
+	}
+}
+
+func TestProtoJSONUnmarshalsProtobufGoOutput(t *testing.T) {
+	want := &Record{
+		DisplayName: "n",
+		BigID:       1 << 60,
+		Level:       Level_LEVEL_LOW,
+		Origin:      &Point{Y: 2},
+		Points:      map[string]*Point{"a": {X: 1}},
+		CreatedAt:   time.Unix(1700000000, 5).UTC(),
+		Custom:      "c",
+	}
+	in, err := protojson.Marshal(&pb.Record{
+		DisplayName: "n",
+		BigId:       1 << 60,
+		Level:       pb.Level_LEVEL_LOW,
+		Origin:      &pb.Point{Y: 2},
+		Points:      map[string]*pb.Point{"a": {X: 1}},
+		CreatedAt:   timestamppb.New(time.Unix(1700000000, 5)),
+		Custom:      "c",
+	})
+	if err != nil {
+		t.Fatalf("protojson.Marshal: %v", err)
+	}
+	var got Record
+	if err := json.Unmarshal(in, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", in, err)
+	}
+	gotJSON, _ := json.Marshal(&got)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Fatalf("unmarshal mismatch\n got: %s\nwant: %s", gotJSON, wantJSON)
+	}
+	var level Level
+	if err := json.Unmarshal([]byte("2"), &level); err != nil || level != Level_LEVEL_HIGH {
+		t.Fatalf("expected a numeric enum to unmarshal, got %v, %v", level, err)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{GoJSONTags: "protojson", GoUtilPrefix: "cp_"}, testSrc)
+}
+
+func TestGeneratedGoJSONOptionLimitsMarshalJSON(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package example;
+
+import "options.proto";
+
+option go_package = "example";
+
+enum Level {
+  LEVEL_UNSPECIFIED = 0;
+  LEVEL_HIGH = 1;
+}
+
+message Inner {
+  int64 id = 1;
+}
+
+message Public {
+  option (cp.go_json) = true;
+  string name = 1;
+  Level level = 2;
+  Inner inner = 3;
+}
+
+message Internal {
+  string secret = 1;
+}
+
+message Outer {
+  option (cp.go_json) = true;
+  int64 id = 1;
+  repeated sint64 zz = 2;
+  optional fixed64 mask = 3;
+  map<int64, uint64> totals = 4;
+  repeated Inner inners = 5;
+  Level level = 6;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "model.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir, "../../.."}}
+	files, err := p.Parse(context.Background(), []string{"model.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestOnlyOptedInMessagesMarshalJSON(t *testing.T) {
+	if _, ok := any(&Internal{}).(json.Marshaler); ok {
+		t.Fatal("expected Internal to have no MarshalJSON")
+	}
+	if _, ok := any(&Inner{}).(json.Marshaler); ok {
+		t.Fatal("expected Inner to have no MarshalJSON")
+	}
+	b, err := json.Marshal(&Public{Name: "n", Level: Level_LEVEL_HIGH, Inner: &Inner{ID: 7}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if want := ` + "`" + `{"name":"n","level":"LEVEL_HIGH","inner":{"id":"7"}}` + "`" + `; string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+	if _, ok := any(&Internal{}).(json.Unmarshaler); ok {
+		t.Fatal("expected Internal to have no UnmarshalJSON")
+	}
+}
+
+func TestOptedInMessageJSONRoundTrip(t *testing.T) {
+	mask := uint64(math.MaxUint64)
+	want := &Outer{
+		ID:     math.MinInt64,
+		Zz:     []int64{-1, 0, math.MaxInt64},
+		Mask:   &mask,
+		Totals: map[int64]uint64{-3: 1 << 63},
+		Inners: []*Inner{{ID: 1 << 60}, {}},
+		Level:  Level_LEVEL_HIGH,
+	}
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got Outer
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal(%s): %v", b, err)
+	}
+	if !reflect.DeepEqual(&got, want) {
+		t.Fatalf("round trip mismatch\n got: %+v\nwant: %+v", &got, want)
+	}
+
+	// protojson also accepts unquoted 64-bit integers, enum numbers and null
+	// for unset fields.
+	in := ` + "`" + `{"id":5,"zz":[-2,"3"],"mask":null,"totals":{"7":8},"level":1}` + "`" + `
+	got = Outer{ID: 9}
+	if err := json.Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unmarshal(%s): %v", in, err)
+	}
+	if want := (Outer{ID: 5, Zz: []int64{-2, 3}, Totals: map[int64]uint64{7: 8}, Level: Level_LEVEL_HIGH}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for _, in := range []string{` + "`" + `{"unknown":1}` + "`" + `, ` + "`" + `{"id":"1.5"}` + "`" + `, ` + "`" + `{"zz":{}}` + "`" + `, ` + "`" + `{"level":"LEVEL_NONE"}` + "`" + `} {
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Fatalf("expected %s to fail", in)
+		}
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+func TestGeneratedEditionsExplicitPresenceIsPointer(t *testing.T) {
+	const protoSource = `edition = "2023";
+
+package demo;
+
+option go_package = "example";
+option features.field_presence = IMPLICIT;
+
+message Reading {
+  int32 count = 1;
+  int32 limit = 2 [features.field_presence = EXPLICIT];
+  repeated int32 samples = 3;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := parser.Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	testSrc := `package example
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEditionsPresence(t *testing.T) {
+	var m Reading
+	var count int32 = m.Count
+	var limit *int32 = m.Limit
+	_, _ = count, limit
+
+	zero := int32(0)
+	in := &Reading{Limit: &zero, Samples: []int32{1, 2}}
+	// Limit is set to zero, so its tag is written; samples are packed.
+	want := []byte{0x10, 0x00, 0x1a, 0x02, 0x01, 0x02}
+	if got := in.Encode(); !bytes.Equal(got, want) {
+		t.Fatalf("Encode = %x, want %x", got, want)
+	}
+	out, err := DecodeReading(want)
+	if err != nil {
+		t.Fatalf("DecodeReading: %v", err)
+	}
+	if out.Limit == nil || *out.Limit != 0 {
+		t.Fatalf("expected limit presence to survive a round trip, got %v", out.Limit)
+	}
+}
+`
+	runGeneratedTest(t, files, generate.Options{}, testSrc)
+}
+
+func TestGeneratedSizeUpperBoundCoversEncode(t *testing.T) {
+	file := ir.File{
+		GoPackage: "example",
+		Enums: []ir.Enum{{
+			Name:     "Level",
+			FullName: "example.Level",
+			Values:   []ir.EnumValue{{Name: "LEVEL_UNSPECIFIED", Number: 0}, {Name: "LEVEL_HIGH", Number: 1}},
+		}},
+		Messages: []ir.Message{
+			{
+				Name:     "Leaf",
+				FullName: "example.Leaf",
+				Fields: []ir.Field{
+					{Name: "note", Number: 1, Kind: ir.KindString, GoEncode: true},
+					{Name: "weight", Number: 2, Kind: ir.KindDouble, GoEncode: true},
+				},
+			},
+			{
+				Name:     "Tree",
+				FullName: "example.Tree",
+				Fields: []ir.Field{
+					{Name: "id", Number: 1, Kind: ir.KindInt32, GoEncode: true},
+					{Name: "delta", Number: 2, Kind: ir.KindSint64, GoEncode: true},
+					{Name: "flags", Number: 3, Kind: ir.KindFixed32, GoEncode: true},
+					{Name: "ok", Number: 4, Kind: ir.KindBool, GoEncode: true},
+					{Name: "payload", Number: 5, Kind: ir.KindBytes, GoEncode: true},
+					{Name: "level", Number: 6, Kind: ir.KindEnum, EnumFullName: "example.Level", GoEncode: true},
+					{Name: "limit", Number: 7, Kind: ir.KindInt64, IsOptional: true, GoEncode: true},
+					{Name: "label", Number: 8, Kind: ir.KindString, IsOptional: true, GoEncode: true},
+					{Name: "values", Number: 9, Kind: ir.KindInt32, IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "names", Number: 10, Kind: ir.KindString, IsRepeated: true, GoEncode: true},
+					{Name: "levels", Number: 11, Kind: ir.KindEnum, EnumFullName: "example.Level", IsRepeated: true, IsPacked: true, GoEncode: true},
+					{Name: "leaf", Number: 12, Kind: ir.KindMessage, MessageFullName: "example.Leaf", GoEncode: true},
+					{Name: "leaves", Number: 13, Kind: ir.KindMessage, MessageFullName: "example.Leaf", IsRepeated: true, GoEncode: true},
+					{Name: "by_name", Number: 14, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindMessage, MapValueMessage: "example.Leaf", GoEncode: true},
+					{Name: "counts", Number: 15, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindInt64, MapValueKind: ir.KindUint32, GoEncode: true},
+					{Name: "created", Number: 16, Kind: ir.KindMessage, IsTimestamp: true, GoEncode: true},
+					{Name: "timeout", Number: 17, Kind: ir.KindMessage, IsDuration: true, GoEncode: true},
+					{Name: "children", Number: 2000, Kind: ir.KindMessage, MessageFullName: "example.Tree", IsRepeated: true, GoEncode: true},
+				},
+			},
+		},
+	}
+	testSrc := `package example
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSizeUpperBound(t *testing.T) {
+	limit := int64(math.MinInt64)
+	label := ""
+	trees := []*Tree{
+		{},
+		{ID: -1, Delta: math.MinInt64, Level: Level(-5), Levels: []Level{-1, -2, 1}},
+		{
+			ID:      math.MaxInt32,
+			Flags:   7,
+			Ok:      true,
+			Payload: make([]byte, 300),
+			Limit:   &limit,
+			Label:   &label,
+			Values:  []int32{-1, math.MinInt32, 0, 5},
+			Names:   []string{"", "abc", string(make([]byte, 200))},
+			Leaf:    &Leaf{Note: "n", Weight: 1.5},
+			Leaves:  []*Leaf{nil, {}, {Note: "x"}},
+			ByName:  map[string]*Leaf{"a": {Note: "y"}, "": {}},
+			Counts:  map[int64]uint32{-1: math.MaxUint32, 0: 0},
+			Created: time.Unix(-1, 999999999),
+			Timeout: -time.Hour,
+		},
+	}
+	trees = append(trees, &Tree{Children: []*Tree{trees[1], trees[2], {Children: trees[:3]}}})
+	for seed := int64(0); seed < 20; seed++ {
+		trees = append(trees, sampleTree(seed))
+	}
+	for i, tree := range trees {
+		size, bound := len(tree.Encode()), tree.SizeUpperBound()
+		if bound < size {
+			t.Errorf("tree %d: SizeUpperBound() = %d < encoded size %d", i, bound, size)
+		}
+	}
+	var nilTree *Tree
+	if got := nilTree.SizeUpperBound(); got != 0 {
+		t.Fatalf("nil SizeUpperBound() = %d, want 0", got)
+	}
+}
+
+func BenchmarkSizeUpperBound(b *testing.B) {
+	tree := sampleTree(1)
+	for b.Loop() {
+		_ = tree.SizeUpperBound()
+	}
+}
+`
+	runGeneratedTest(t, []ir.File{file}, generate.Options{GoEmitSamples: true}, testSrc)
+}
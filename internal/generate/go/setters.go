@@ -0,0 +1,45 @@
+package gogen
+
+import (
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// goSetter is one generated fluent setter: a method that assigns its
+// argument and returns the message so calls can be chained.
+type goSetter struct {
+	Name   string
+	Param  string
+	Assign string
+}
+
+// buildGoSetters returns the fluent setters for msg's visible fields: a
+// Set<Field> per field, plus an Add<Field> appending one element for repeated
+// fields. Optional scalars take the value and store a pointer to it.
+func buildGoSetters(fields []goField, visible []ir.Field) []goSetter {
+	var setters []goSetter
+	for i, field := range visible {
+		name := fields[i].Name
+		goType := fields[i].Type
+		switch {
+		case field.IsRepeated && !field.IsMap:
+			setters = append(setters,
+				goSetter{Name: "Set" + name, Param: goType, Assign: "m." + name + " = v"},
+				goSetter{Name: "Add" + name, Param: strings.TrimPrefix(goType, "[]"), Assign: "m." + name + " = append(m." + name + ", v)"},
+			)
+		case field.IsOptional && strings.HasPrefix(goType, "*") && goOptionalStoresPointer(field):
+			setters = append(setters, goSetter{Name: "Set" + name, Param: goType[1:], Assign: "m." + name + " = &v"})
+		default:
+			setters = append(setters, goSetter{Name: "Set" + name, Param: goType, Assign: "m." + name + " = v"})
+		}
+	}
+	return setters
+}
+
+// goOptionalStoresPointer reports whether an optional field's pointer type is
+// added for presence, rather than being how the type is always held (as for
+// nested messages).
+func goOptionalStoresPointer(field ir.Field) bool {
+	return field.Kind != ir.KindMessage || field.IsTimestamp || field.IsDuration || field.GoType != ""
+}
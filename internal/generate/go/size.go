@@ -0,0 +1,210 @@
+package gogen
+
+import (
+	"fmt"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// maxVarintLen is the widest varint encoding: a negative int64 takes 10
+// bytes. It also bounds every length prefix.
+const maxVarintLen = 10
+
+// maxTimeMessageLen bounds an encoded Timestamp or Duration: two one-byte tags
+// and two varints.
+const maxTimeMessageLen = 2 * (1 + maxVarintLen)
+
+// goSizeBound is an upper bound on an encoded size: a constant plus, when
+// expr is set, a value-dependent Go expression such as len(s).
+type goSizeBound struct {
+	constant int
+	expr     string
+}
+
+func (b goSizeBound) add(n int) goSizeBound {
+	b.constant += n
+	return b
+}
+
+func (b goSizeBound) String() string {
+	if b.expr == "" {
+		return fmt.Sprint(b.constant)
+	}
+	if b.constant == 0 {
+		return b.expr
+	}
+	return fmt.Sprintf("%d + %s", b.constant, b.expr)
+}
+
+// buildGoSizeBoundLines returns the body of a message's SizeUpperBound
+// method. Every number is costed at its widest encoding, so only strings,
+// bytes, nested messages and collection lengths are looked at, and optional
+// scalars count as set.
+func buildGoSizeBoundLines(msg ir.Message) ([]string, error) {
+	var lines []string
+	// fixed sums the value-independent fields into a single line.
+	fixed := 0
+	for _, field := range msg.Fields {
+		if field.GoIgnore || !field.GoEncode {
+			continue
+		}
+		fieldName := "m." + goStructFieldName(msg, field)
+		tag := goTagSize(field.Number)
+		switch {
+		case field.IsMap:
+			key, err := goSizeBoundKind(field.MapKeyKind, "k")
+			if err != nil {
+				return nil, err
+			}
+			var value goSizeBound
+			switch field.MapValueKind {
+			case ir.KindMessage:
+				value = goSizeBound{constant: maxVarintLen, expr: "v.SizeUpperBound()"}
+			case ir.KindEnum:
+				value = goSizeBound{constant: maxVarintLen}
+			default:
+				value, err = goSizeBoundKind(field.MapValueKind, "v")
+				if err != nil {
+					return nil, err
+				}
+			}
+			// Entry tag and length, then the key and value with their
+			// one-byte tags.
+			entry := goSizeBound{constant: tag + maxVarintLen + 1 + key.constant + 1 + value.constant}
+			switch {
+			case key.expr == "" && value.expr == "":
+				lines = append(lines, fmt.Sprintf("n += len(%s) * %d", fieldName, entry.constant))
+				continue
+			case key.expr == "":
+				entry.expr = value.expr
+				lines = append(lines, fmt.Sprintf("for _, v := range %s {", fieldName))
+			case value.expr == "":
+				entry.expr = key.expr
+				lines = append(lines, fmt.Sprintf("for k := range %s {", fieldName))
+			default:
+				entry.expr = key.expr + " + " + value.expr
+				lines = append(lines, fmt.Sprintf("for k, v := range %s {", fieldName))
+			}
+			lines = append(lines, "n += "+entry.String(), "}")
+		case field.IsRepeated:
+			item, err := goSizeBoundValue(field, fieldName+"[i]")
+			if err != nil {
+				return nil, err
+			}
+			if item.expr == "" {
+				// Covers both the packed form (one tag and length) and the
+				// expanded form (a tag per item).
+				lines = append(lines, fmt.Sprintf("n += %d + len(%s)*%d", tag+maxVarintLen, fieldName, tag+item.constant))
+				continue
+			}
+			lines = append(lines,
+				fmt.Sprintf("for i := range %s {", fieldName),
+				"n += "+item.add(tag).String(),
+				"}",
+			)
+		case field.IsOptional:
+			value, err := goSizeBoundValue(field, "*"+fieldName)
+			if err != nil {
+				return nil, err
+			}
+			if value.expr == "" {
+				fixed += tag + value.constant
+				continue
+			}
+			lines = append(lines,
+				fmt.Sprintf("if %s != nil {", fieldName),
+				"n += "+value.add(tag).String(),
+				"}",
+			)
+		default:
+			value, err := goSizeBoundValue(field, fieldName)
+			if err != nil {
+				return nil, err
+			}
+			if value.expr == "" {
+				fixed += tag + value.constant
+				continue
+			}
+			lines = append(lines, "n += "+value.add(tag).String())
+			if field.Lazy {
+				raw := "m." + goLazyRawName(goStructFieldName(msg, field))
+				lines = append(lines,
+					fmt.Sprintf("if %s != nil {", raw),
+					fmt.Sprintf("n += %d + len(%s)", tag+maxVarintLen, raw),
+					"}",
+				)
+			}
+		}
+	}
+	if fixed > 0 {
+		lines = append([]string{fmt.Sprintf("n += %d", fixed)}, lines...)
+	}
+	return lines, nil
+}
+
+// goSizeBoundValue bounds one value of field, excluding its tag.
+func goSizeBoundValue(field ir.Field, expr string) (goSizeBound, error) {
+	switch field.GoType {
+	case "":
+	case "time.Time", "time.Duration":
+		if field.IsTimestamp || field.IsDuration {
+			return goSizeBound{constant: maxVarintLen + maxTimeMessageLen}, nil
+		}
+		return goSizeBound{constant: maxVarintLen}, nil
+	case "github.com/google/uuid.UUID":
+		return goSizeBound{constant: maxVarintLen + 16}, nil
+	default:
+		raw, err := goCustomRawValueExpr(field, expr)
+		if err != nil {
+			return goSizeBound{}, err
+		}
+		return goSizeBoundKind(field.Kind, raw)
+	}
+	if field.IsTimestamp || field.IsDuration {
+		return goSizeBound{constant: maxVarintLen + maxTimeMessageLen}, nil
+	}
+	if field.IsWrapper {
+		// The wrapper's length, then the value with its one-byte tag.
+		value, err := goSizeBoundKind(field.Kind, expr)
+		if err != nil {
+			return goSizeBound{}, err
+		}
+		if field.IsRepeated && value.expr != "" {
+			// A repeated element may be nil.
+			value.expr = "wrapperLen(" + expr + ")"
+		}
+		return value.add(maxVarintLen + 1), nil
+	}
+	if field.Kind == ir.KindMessage {
+		return goSizeBound{constant: maxVarintLen, expr: expr + ".SizeUpperBound()"}, nil
+	}
+	return goSizeBoundKind(field.Kind, expr)
+}
+
+func goSizeBoundKind(kind ir.Kind, expr string) (goSizeBound, error) {
+	switch kind {
+	case ir.KindBool:
+		return goSizeBound{constant: 1}, nil
+	case ir.KindUint32, ir.KindSint32:
+		return goSizeBound{constant: 5}, nil
+	case ir.KindInt32, ir.KindInt64, ir.KindUint64, ir.KindSint64, ir.KindEnum:
+		// int32 and enum values sign-extend to 64 bits when negative.
+		return goSizeBound{constant: maxVarintLen}, nil
+	case ir.KindFixed32, ir.KindSfixed32, ir.KindFloat:
+		return goSizeBound{constant: 4}, nil
+	case ir.KindFixed64, ir.KindSfixed64, ir.KindDouble:
+		return goSizeBound{constant: 8}, nil
+	case ir.KindString, ir.KindBytes:
+		return goSizeBound{constant: maxVarintLen, expr: "len(" + expr + ")"}, nil
+	}
+	return goSizeBound{}, fmt.Errorf("unsupported size kind: %v", kind)
+}
+
+// goTagSize returns the encoded width of the tag for field number.
+func goTagSize(number int) int {
+	n := 1
+	for v := uint64(number) << 3; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
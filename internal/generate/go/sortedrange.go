@@ -0,0 +1,79 @@
+package gogen
+
+import (
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// goSortedRange is one generated Range<Field>Sorted method, which calls fn
+// for each entry of a map field in key order.
+type goSortedRange struct {
+	Name  string
+	Field string
+	Key   string
+	Value string
+	// Helper is RangeSorted, or RangeSortedBool for bool keys, which
+	// cmp.Ordered does not cover.
+	Helper string
+}
+
+// buildGoSortedRanges returns a Range<Field>Sorted method for each of msg's
+// visible map fields.
+func buildGoSortedRanges(fields []goField, visible []ir.Field) []goSortedRange {
+	var out []goSortedRange
+	for i, field := range visible {
+		if !field.IsMap {
+			continue
+		}
+		// Keys are always scalars, so the first ] closes the key type.
+		key, value, _ := strings.Cut(strings.TrimPrefix(fields[i].Type, "map["), "]")
+		helper := "RangeSorted"
+		if field.MapKeyKind == ir.KindBool {
+			helper = "RangeSortedBool"
+		}
+		out = append(out, goSortedRange{
+			Name:   "Range" + ir.GoName(field.Name) + "Sorted",
+			Field:  fields[i].Name,
+			Key:    key,
+			Value:  value,
+			Helper: helper,
+		})
+	}
+	return out
+}
+
+const sortedRangeUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+import (
+	"cmp"
+	"slices"
+)
+
+// RangeSorted calls fn for each entry of m in ascending key order, stopping
+// when fn returns false.
+func RangeSorted[K cmp.Ordered, V any](m map[K]V, fn func(K, V) bool) {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if !fn(k, m[k]) {
+			return
+		}
+	}
+}
+
+// RangeSortedBool is RangeSorted for bool keys, calling fn for false before
+// true.
+func RangeSortedBool[V any](m map[bool]V, fn func(bool, V) bool) {
+	for _, k := range [2]bool{false, true} {
+		if v, ok := m[k]; ok && !fn(k, v) {
+			return
+		}
+	}
+}
+`
@@ -0,0 +1,112 @@
+package gogen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/generate"
+)
+
+// goSplitDecode and goSplitEncode name the declarations -go.splitmodel moves
+// out of model_types.gen.go: the Decode<Msg> family and the methods writing
+// a message's wire form. Everything else, including enums and methods such
+// as String or Hash, stays with the types.
+func goSplitDecode(name string) bool {
+	return strings.HasPrefix(name, "Decode") || strings.HasPrefix(name, "decode") || strings.HasPrefix(name, "ReadDelimited") || name == "UnmarshalBinary"
+}
+
+func goSplitEncode(name string) bool {
+	return name == "Encode" || name == "EncodeErr" || name == "SizeUpperBound" || name == "MarshalBinary"
+}
+
+// splitGoModel splits the model.gen.go at outPath into model_types.gen.go,
+// model_encode.gen.go and model_decode.gen.go in the same directory, for
+// -go.splitmodel. Each declaration keeps the comments above it, and each file
+// imports only the packages its declarations use.
+func splitGoModel(outPath string, content []byte) ([]generate.OutputFile, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, outPath, content, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("split %s: %w", outPath, err)
+	}
+	parts := []struct {
+		name  string
+		decls []ast.Decl
+		body  strings.Builder
+	}{{name: "model_types.gen.go"}, {name: "model_encode.gen.go"}, {name: "model_decode.gen.go"}}
+	var imports []*ast.ImportSpec
+	prev := file.Name.End()
+	for _, decl := range file.Decls {
+		// Text between the previous declaration and this one, such as its
+		// doc comment, moves with it.
+		chunk := content[fset.Position(prev).Offset:fset.Position(decl.End()).Offset]
+		prev = decl.End()
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			for _, spec := range gen.Specs {
+				imports = append(imports, spec.(*ast.ImportSpec))
+			}
+			continue
+		}
+		part := 0
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			switch {
+			case goSplitEncode(fn.Name.Name) && fn.Recv != nil:
+				part = 1
+			case goSplitDecode(fn.Name.Name):
+				part = 2
+			}
+		}
+		parts[part].decls = append(parts[part].decls, decl)
+		parts[part].body.WriteString("\n")
+		parts[part].body.Write(chunk)
+		parts[part].body.WriteString("\n")
+	}
+	dir := filepath.Dir(outPath)
+	outputs := make([]generate.OutputFile, 0, len(parts))
+	for i := range parts {
+		var b strings.Builder
+		b.WriteString("// Code generated by cleanproto. DO NOT EDIT.\n\n")
+		b.WriteString("package ")
+		b.WriteString(file.Name.Name)
+		b.WriteString("\n")
+		var used []string
+		for _, imp := range imports {
+			importPath, _ := strconv.Unquote(imp.Path.Value)
+			name := path.Base(importPath)
+			spec := imp.Path.Value
+			if imp.Name != nil {
+				name = imp.Name.Name
+				spec = name + " " + spec
+			}
+			for _, decl := range parts[i].decls {
+				if name == "_" || goDeclUsesPackage(decl, name) {
+					used = append(used, spec)
+					break
+				}
+			}
+		}
+		if len(used) > 0 {
+			b.WriteString("\nimport (\n")
+			for _, spec := range used {
+				b.WriteString("\t")
+				b.WriteString(spec)
+				b.WriteString("\n")
+			}
+			b.WriteString(")\n")
+		}
+		b.WriteString(parts[i].body.String())
+		formatted, err := format.Source([]byte(b.String()))
+		if err != nil {
+			return nil, fmt.Errorf("split %s: %w", parts[i].name, err)
+		}
+		outputs = append(outputs, generate.OutputFile{Path: filepath.Join(dir, parts[i].name), Content: formatted})
+	}
+	return outputs, nil
+}
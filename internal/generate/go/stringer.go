@@ -0,0 +1,54 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// buildGoStringLines returns the lines of a message's String method that
+// write its visible fields to b as "Name:value", space separated, in the
+// form fmt's %+v gives a struct. Unlike %+v, pointer fields are written as
+// the value they point to, or <nil>, and nested messages through their own
+// String methods, so the output does not change from run to run.
+func buildGoStringLines(fields []goField, visible []ir.Field) []string {
+	var lines []string
+	for i, field := range visible {
+		expr := "m." + fields[i].Name
+		label := fields[i].Name + ":"
+		if i > 0 {
+			label = " " + label
+		}
+		message := field.Kind == ir.KindMessage && !field.IsTimestamp && !field.IsDuration && field.GoType == ""
+		switch {
+		case field.IsRepeated && !field.IsMap && strings.HasPrefix(fields[i].Type, "[]*") && !message:
+			lines = append(lines,
+				fmt.Sprintf("b.WriteString(%q)", label+"["),
+				"for i, v := range "+expr+" {",
+				"\tif i > 0 {",
+				"\t\tb.WriteByte(' ')",
+				"\t}",
+				"\tif v != nil {",
+				"\t\tfmt.Fprint(&b, *v)",
+				"\t} else {",
+				"\t\tb.WriteString(\"<nil>\")",
+				"\t}",
+				"}",
+				"b.WriteByte(']')")
+		case message && !field.IsRepeated && !field.IsMap && !strings.HasPrefix(fields[i].Type, "*"):
+			// A cp.go_value message has String only on its pointer.
+			lines = append(lines, fmt.Sprintf("fmt.Fprintf(&b, %q, &%s)", label+"%v", expr))
+		case strings.HasPrefix(fields[i].Type, "*") && !message:
+			lines = append(lines,
+				"if "+expr+" != nil {",
+				fmt.Sprintf("\tfmt.Fprintf(&b, %q, *%s)", label+"%v", expr),
+				"} else {",
+				fmt.Sprintf("\tb.WriteString(%q)", label+"<nil>"),
+				"}")
+		default:
+			lines = append(lines, fmt.Sprintf("fmt.Fprintf(&b, %q, %s)", label+"%v", expr))
+		}
+	}
+	return lines
+}
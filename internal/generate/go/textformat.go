@@ -0,0 +1,906 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// buildGoTextLines returns the body of a message's appendText method, which
+// writes the fields prototext.Format would, in declaration order: set
+// optional fields, non-empty lists and maps and non-zero values, lists as one
+// line per element and maps as one key/value block per entry in key order.
+func buildGoTextLines(msg ir.Message) ([]string, error) {
+	var lines []string
+	for _, field := range goVisibleFields(msg.Fields) {
+		fieldExpr := "m." + goStructFieldName(msg, field)
+		name := fieldProtoName(field)
+		var valueLines []string
+		switch {
+		case field.IsMap:
+			keysFunc := "sortedTextKeys"
+			if field.MapKeyKind == ir.KindBool {
+				keysFunc = "sortedTextBoolKeys"
+			}
+			keyLines, err := goTextWriteKind(field.MapKeyKind, "key", "k")
+			if err != nil {
+				return nil, err
+			}
+			entryLines, err := goTextWriteKind(field.MapValueKind, "value", "v")
+			if err != nil {
+				return nil, err
+			}
+			entryLines = append(keyLines, entryLines...)
+			valueLines = append(valueLines,
+				"for _, k := range "+keysFunc+"("+fieldExpr+") {",
+				"\tv := "+fieldExpr+"[k]",
+				fmt.Sprintf("\te.BeginMessage(%q)", name))
+			for _, line := range entryLines {
+				valueLines = append(valueLines, "\t"+line)
+			}
+			valueLines = append(valueLines, "\te.EndMessage()", "}")
+		case field.IsRepeated:
+			elemLines, err := goTextWriteValue(field, name, "v")
+			if err != nil {
+				return nil, err
+			}
+			valueLines = append(valueLines, "for _, v := range "+fieldExpr+" {")
+			for _, line := range elemLines {
+				valueLines = append(valueLines, "\t"+line)
+			}
+			valueLines = append(valueLines, "}")
+		default:
+			expr := fieldExpr
+			if field.IsOptional && !field.IsWrapper {
+				expr = "*" + fieldExpr
+			}
+			var err error
+			valueLines, err = goTextWriteValue(field, name, expr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		lines = append(lines, "if "+goJSONPresentCondition(fieldExpr, field)+" {")
+		for _, line := range valueLines {
+			lines = append(lines, "\t"+line)
+		}
+		lines = append(lines, "}")
+	}
+	return lines, nil
+}
+
+// goTextWriteValue returns the TextEncoder calls writing one value of field
+// named name. Timestamps, durations and wrappers are written as the messages
+// they are on the wire, and cp.go_type values as the proto value they encode
+// as. Wrappers are given as a pointer, with nil written as an empty message.
+// As on the wire, an element of a repeated wrapper that is not nil is written
+// with its value even when zero, so that it parses back distinct from nil.
+func goTextWriteValue(field ir.Field, name, expr string) ([]string, error) {
+	if goEnumString(field) {
+		return []string{fmt.Sprintf("e.Enum(%q, %s)", name, expr)}, nil
+	}
+	operand := expr
+	if strings.HasPrefix(expr, "*") {
+		operand = "(" + expr + ")"
+	}
+	switch field.GoType {
+	case "":
+	case "time.Time":
+		switch {
+		case field.IsTimestamp:
+			return []string{fmt.Sprintf("e.Timestamp(%q, %s)", name, expr)}, nil
+		case field.Kind == ir.KindInt32:
+			return []string{fmt.Sprintf("e.Int(%q, %s.Unix())", name, operand)}, nil
+		case field.Kind == ir.KindInt64:
+			return []string{fmt.Sprintf("e.Int(%q, %s.UnixMilli())", name, operand)}, nil
+		}
+		return nil, fmt.Errorf("unsupported cp.go_type conversion for field %s", field.Name)
+	case "time.Duration":
+		switch {
+		case field.IsDuration:
+			return []string{fmt.Sprintf("e.Duration(%q, %s)", name, expr)}, nil
+		case field.Kind == ir.KindInt32 || field.Kind == ir.KindInt64:
+			return []string{fmt.Sprintf("e.Int(%q, int64(%s / time.Second))", name, expr)}, nil
+		}
+		return nil, fmt.Errorf("unsupported cp.go_type conversion for field %s", field.Name)
+	case "github.com/google/uuid.UUID":
+		if field.Kind == ir.KindBytes {
+			return []string{fmt.Sprintf("e.Bytes(%q, %s[:])", name, operand)}, nil
+		}
+		return nil, fmt.Errorf("unsupported cp.go_type conversion for field %s", field.Name)
+	default:
+		raw, err := goCustomRawValueExpr(field, expr)
+		if err != nil {
+			return nil, err
+		}
+		return goTextWriteKind(field.Kind, name, raw)
+	}
+	switch {
+	case field.IsTimestamp:
+		return []string{fmt.Sprintf("e.Timestamp(%q, %s)", name, expr)}, nil
+	case field.IsDuration:
+		return []string{fmt.Sprintf("e.Duration(%q, %s)", name, expr)}, nil
+	case field.IsWrapper:
+		valueLines, err := goTextWriteKind(field.Kind, "value", "*"+expr)
+		if err != nil {
+			return nil, err
+		}
+		cond := expr + " != nil"
+		if !field.IsRepeated {
+			cond += " && " + goJSONPresentCondition("*"+expr, ir.Field{Kind: field.Kind})
+		}
+		lines := []string{fmt.Sprintf("e.BeginMessage(%q)", name), "if " + cond + " {"}
+		for _, line := range valueLines {
+			lines = append(lines, "\t"+line)
+		}
+		return append(lines, "}", "e.EndMessage()"), nil
+	}
+	return goTextWriteKind(field.Kind, name, expr)
+}
+
+func goTextWriteKind(kind ir.Kind, name, expr string) ([]string, error) {
+	var line string
+	switch kind {
+	case ir.KindMessage:
+		if strings.HasPrefix(expr, "*") {
+			expr = "(" + expr + ")"
+		}
+		return []string{
+			fmt.Sprintf("e.BeginMessage(%q)", name),
+			expr + ".appendText(e)",
+			"e.EndMessage()",
+		}, nil
+	case ir.KindEnum:
+		if strings.HasPrefix(expr, "*") {
+			expr = "(" + expr + ")"
+		}
+		line = fmt.Sprintf("%s.appendText(e, %q)", expr, name)
+	case ir.KindBool:
+		line = fmt.Sprintf("e.Bool(%q, %s)", name, expr)
+	case ir.KindString:
+		line = fmt.Sprintf("e.String(%q, %s)", name, expr)
+	case ir.KindBytes:
+		line = fmt.Sprintf("e.Bytes(%q, %s)", name, expr)
+	case ir.KindInt32, ir.KindSint32, ir.KindSfixed32:
+		line = fmt.Sprintf("e.Int(%q, int64(%s))", name, expr)
+	case ir.KindUint32, ir.KindFixed32:
+		line = fmt.Sprintf("e.Uint(%q, uint64(%s))", name, expr)
+	case ir.KindInt64, ir.KindSint64, ir.KindSfixed64:
+		line = fmt.Sprintf("e.Int(%q, %s)", name, expr)
+	case ir.KindUint64, ir.KindFixed64:
+		line = fmt.Sprintf("e.Uint(%q, %s)", name, expr)
+	case ir.KindFloat:
+		line = fmt.Sprintf("e.Float(%q, float64(%s), 32)", name, expr)
+	case ir.KindDouble:
+		line = fmt.Sprintf("e.Float(%q, %s, 64)", name, expr)
+	default:
+		return nil, fmt.Errorf("unsupported text format kind: %v", kind)
+	}
+	return []string{line}, nil
+}
+
+// buildGoTextParseLines returns the body of a message's parseText method,
+// which reads the fields of a text format message until its closing brace,
+// the inverse of appendText. Repeated fields append, map entries are added
+// and message fields merge, as Decode does; unknown field names are an error.
+func buildGoTextParseLines(fields []goField, visible []ir.Field, enumIndex map[string]ir.Enum) ([]string, error) {
+	lines := []string{"for d.Next() {", "\tswitch d.Name() {"}
+	for i, field := range visible {
+		caseLines, err := goTextParseField(field, "m."+fields[i].Name, fields[i].Type, enumIndex)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("\tcase %q:", fieldProtoName(field)))
+		for _, line := range caseLines {
+			lines = append(lines, "\t\t"+line)
+		}
+	}
+	return append(lines, "\tdefault:", "\t\td.UnknownField()", "\t}", "}"), nil
+}
+
+// goTextParseField returns the lines reading one value of field, of Go type
+// goType, into fieldExpr.
+func goTextParseField(field ir.Field, fieldExpr, goType string, enumIndex map[string]ir.Enum) ([]string, error) {
+	elemType := strings.TrimPrefix(strings.TrimPrefix(goType, "[]"), "*")
+	switch {
+	case field.IsMap:
+		key, value, _ := strings.Cut(strings.TrimPrefix(goType, "map["), "]")
+		keyExpr, err := goTextReadKind(field.MapKeyKind, key)
+		if err != nil {
+			return nil, err
+		}
+		var valueLines []string
+		if field.MapValueKind == ir.KindMessage {
+			valueLines = goTextParseMessage("v")
+		} else {
+			valueExpr, err := goTextReadKind(field.MapValueKind, value)
+			if err != nil {
+				return nil, err
+			}
+			valueLines = []string{"v = " + valueExpr}
+		}
+		lines := []string{"d.BeginMessage()", "var k " + key, goTextNewVar(value)}
+		lines = append(lines, goTextParseBlock("key", []string{"k = " + keyExpr}, "value", valueLines)...)
+		return append(lines,
+			"d.EndMessage()",
+			"if "+fieldExpr+" == nil {",
+			"\t"+fieldExpr+" = make("+goType+")",
+			"}",
+			fieldExpr+"[k] = v"), nil
+	case field.Kind == ir.KindMessage && !field.IsTimestamp && !field.IsDuration && field.GoType == "":
+		if field.IsRepeated {
+			lines := append([]string{goTextNewVar(strings.TrimPrefix(goType, "[]"))}, goTextParseMessage("v")...)
+			return append(lines, fieldExpr+" = append("+fieldExpr+", v)"), nil
+		}
+		if field.GoValue {
+			return goTextParseMessage(fieldExpr), nil
+		}
+		return []string{
+			"d.BeginMessage()",
+			"if " + fieldExpr + " == nil {",
+			"\t" + fieldExpr + " = &" + elemType + "{}",
+			"}",
+			fieldExpr + ".parseText(d)",
+			"d.EndMessage()",
+		}, nil
+	}
+	valueExpr, err := goTextParseValue(field, elemType, enumIndex)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case field.IsWrapper && field.IsRepeated:
+		// An element without a value is nil, as it decodes from the wire.
+		lines := []string{"d.BeginMessage()", "var v *" + elemType}
+		lines = append(lines, goTextParseBlock("value", []string{"value := " + valueExpr, "v = &value"}, "", nil)...)
+		return append(lines, "d.EndMessage()", fieldExpr+" = append("+fieldExpr+", v)"), nil
+	case field.IsWrapper:
+		lines := []string{"d.BeginMessage()", "var v " + elemType}
+		lines = append(lines, goTextParseBlock("value", []string{"v = " + valueExpr}, "", nil)...)
+		return append(lines, "d.EndMessage()", fieldExpr+" = &v"), nil
+	}
+	switch {
+	case field.IsRepeated:
+		return []string{fieldExpr + " = append(" + fieldExpr + ", " + valueExpr + ")"}, nil
+	case field.IsOptional:
+		return []string{"v := " + valueExpr, fieldExpr + " = &v"}, nil
+	}
+	return []string{fieldExpr + " = " + valueExpr}, nil
+}
+
+// goTextParseValue returns the expression reading one scalar value of field,
+// of Go type elemType, converting it to the field's cp.go_type.
+func goTextParseValue(field ir.Field, elemType string, enumIndex map[string]ir.Enum) (string, error) {
+	if goEnumString(field) {
+		enum := enumIndex[field.EnumFullName].Name
+		return fmt.Sprintf("EnumName(d.Enum(%s_value), %s_name)", enum, enum), nil
+	}
+	switch field.GoType {
+	case "":
+	case "time.Time":
+		switch {
+		case field.IsTimestamp:
+			return "d.Timestamp()", nil
+		case field.Kind == ir.KindInt32:
+			return "time.Unix(d.Int(32), 0)", nil
+		case field.Kind == ir.KindInt64:
+			return "time.UnixMilli(d.Int(64))", nil
+		}
+		return "", fmt.Errorf("unsupported cp.go_type conversion for field %s", field.Name)
+	case "time.Duration":
+		switch {
+		case field.IsDuration:
+			return "d.Duration()", nil
+		case field.Kind == ir.KindInt32:
+			return "time.Duration(d.Int(32)) * time.Second", nil
+		case field.Kind == ir.KindInt64:
+			return "time.Duration(d.Int(64)) * time.Second", nil
+		}
+		return "", fmt.Errorf("unsupported cp.go_type conversion for field %s", field.Name)
+	case "github.com/google/uuid.UUID":
+		if field.Kind == ir.KindBytes {
+			return "uuid.UUID(d.UUID())", nil
+		}
+		return "", fmt.Errorf("unsupported cp.go_type conversion for field %s", field.Name)
+	default:
+		rawType, err := goCustomRawTypeName(field)
+		if err != nil {
+			return "", err
+		}
+		raw, err := goTextReadKind(field.Kind, rawType)
+		if err != nil {
+			return "", err
+		}
+		return goCustomFromRawExpr(field, raw), nil
+	}
+	switch {
+	case field.IsTimestamp:
+		return "d.Timestamp()", nil
+	case field.IsDuration:
+		return "d.Duration()", nil
+	}
+	return goTextReadKind(field.Kind, elemType)
+}
+
+// goTextReadKind returns the TextDecoder call reading a scalar of kind as
+// goType, which for enums is the generated enum type.
+func goTextReadKind(kind ir.Kind, goType string) (string, error) {
+	switch kind {
+	case ir.KindEnum:
+		return fmt.Sprintf("%s(d.Enum(%s_value))", goType, goType), nil
+	case ir.KindBool:
+		return "d.Bool()", nil
+	case ir.KindString:
+		return "d.String()", nil
+	case ir.KindBytes:
+		return "d.Bytes()", nil
+	case ir.KindInt32, ir.KindSint32, ir.KindSfixed32:
+		return "int32(d.Int(32))", nil
+	case ir.KindUint32, ir.KindFixed32:
+		return "uint32(d.Uint(32))", nil
+	case ir.KindInt64, ir.KindSint64, ir.KindSfixed64:
+		return "d.Int(64)", nil
+	case ir.KindUint64, ir.KindFixed64:
+		return "d.Uint(64)", nil
+	case ir.KindFloat:
+		return "float32(d.Float(32))", nil
+	case ir.KindDouble:
+		return "d.Float(64)", nil
+	}
+	return "", fmt.Errorf("unsupported text format kind: %v", kind)
+}
+
+// goTextNewVar declares v as a new value of goType, allocating it when it
+// is a message pointer.
+func goTextNewVar(goType string) string {
+	if strings.HasPrefix(goType, "*") {
+		return "v := &" + goType[1:] + "{}"
+	}
+	return "var v " + goType
+}
+
+func goTextParseMessage(expr string) []string {
+	return []string{"d.BeginMessage()", expr + ".parseText(d)", "d.EndMessage()"}
+}
+
+// goTextParseBlock returns a loop reading the fields of a map entry or
+// wrapper block, running the given lines for each of its one or two names.
+func goTextParseBlock(name1 string, lines1 []string, name2 string, lines2 []string) []string {
+	lines := []string{"for d.Next() {", "\tswitch d.Name() {", fmt.Sprintf("\tcase %q:", name1)}
+	for _, line := range lines1 {
+		lines = append(lines, "\t\t"+line)
+	}
+	if name2 != "" {
+		lines = append(lines, fmt.Sprintf("\tcase %q:", name2))
+		for _, line := range lines2 {
+			lines = append(lines, "\t\t"+line)
+		}
+	}
+	return append(lines, "\tdefault:", "\t\td.UnknownField()", "\t}", "}")
+}
+
+const textFormatUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// TextEncoder builds the protobuf text format for TextString: one field per
+// line as "name: value", and messages as "name {" blocks with their fields
+// indented by two spaces.
+type TextEncoder struct {
+	buf    []byte
+	indent int
+}
+
+func (e *TextEncoder) Text() string {
+	return string(e.buf)
+}
+
+// name starts the line of a scalar field.
+func (e *TextEncoder) name(name string) {
+	e.buf = append(e.buf, strings.Repeat("  ", e.indent)...)
+	e.buf = append(e.buf, name...)
+	e.buf = append(e.buf, ':', ' ')
+}
+
+func (e *TextEncoder) BeginMessage(name string) {
+	e.buf = append(e.buf, strings.Repeat("  ", e.indent)...)
+	e.buf = append(e.buf, name...)
+	e.buf = append(e.buf, " {\n"...)
+	e.indent++
+}
+
+func (e *TextEncoder) EndMessage() {
+	e.indent--
+	e.buf = append(e.buf, strings.Repeat("  ", e.indent)...)
+	e.buf = append(e.buf, "}\n"...)
+}
+
+func (e *TextEncoder) Bool(name string, v bool) {
+	e.name(name)
+	e.buf = strconv.AppendBool(e.buf, v)
+	e.buf = append(e.buf, '\n')
+}
+
+func (e *TextEncoder) Int(name string, v int64) {
+	e.name(name)
+	e.buf = strconv.AppendInt(e.buf, v, 10)
+	e.buf = append(e.buf, '\n')
+}
+
+func (e *TextEncoder) Uint(name string, v uint64) {
+	e.name(name)
+	e.buf = strconv.AppendUint(e.buf, v, 10)
+	e.buf = append(e.buf, '\n')
+}
+
+// Float writes v in its shortest form, with the infinities and NaN as inf,
+// -inf and nan.
+func (e *TextEncoder) Float(name string, v float64, bitSize int) {
+	e.name(name)
+	switch {
+	case math.IsNaN(v):
+		e.buf = append(e.buf, "nan"...)
+	case math.IsInf(v, 1):
+		e.buf = append(e.buf, "inf"...)
+	case math.IsInf(v, -1):
+		e.buf = append(e.buf, "-inf"...)
+	default:
+		e.buf = strconv.AppendFloat(e.buf, v, 'g', -1, bitSize)
+	}
+	e.buf = append(e.buf, '\n')
+}
+
+// Enum writes an enum value by its name, unquoted.
+func (e *TextEncoder) Enum(name string, v string) {
+	e.name(name)
+	e.buf = append(e.buf, v...)
+	e.buf = append(e.buf, '\n')
+}
+
+// String writes s quoted, keeping valid UTF-8 as it is.
+func (e *TextEncoder) String(name string, s string) {
+	e.name(name)
+	e.quote(s, true)
+	e.buf = append(e.buf, '\n')
+}
+
+// Bytes writes v quoted, with non-ASCII bytes escaped.
+func (e *TextEncoder) Bytes(name string, v []byte) {
+	e.name(name)
+	e.quote(string(v), false)
+	e.buf = append(e.buf, '\n')
+}
+
+// Timestamp writes t as the google.protobuf.Timestamp message it encodes as.
+func (e *TextEncoder) Timestamp(name string, t time.Time) {
+	e.BeginMessage(name)
+	e.secondsNanos(t.Unix(), int64(t.Nanosecond()))
+	e.EndMessage()
+}
+
+// Duration writes d as the google.protobuf.Duration message it encodes as.
+func (e *TextEncoder) Duration(name string, d time.Duration) {
+	e.BeginMessage(name)
+	e.secondsNanos(int64(d/time.Second), int64(d%time.Second))
+	e.EndMessage()
+}
+
+func (e *TextEncoder) secondsNanos(seconds, nanos int64) {
+	if seconds != 0 {
+		e.Int("seconds", seconds)
+	}
+	if nanos != 0 {
+		e.Int("nanos", nanos)
+	}
+}
+
+// quote writes s as a double-quoted string, escaping quotes, backslashes,
+// newlines, carriage returns and tabs, and other control characters and
+// invalid UTF-8 as \xHH. Unless utf8OK, bytes from 0x80 are escaped too.
+func (e *TextEncoder) quote(s string, utf8OK bool) {
+	const hex = "0123456789abcdef"
+	e.buf = append(e.buf, '"')
+	for len(s) > 0 {
+		r, n := rune(s[0]), 1
+		if utf8OK && r >= utf8.RuneSelf {
+			r, n = utf8.DecodeRuneInString(s)
+		}
+		switch {
+		case r == '"' || r == '\\':
+			e.buf = append(e.buf, '\\', byte(r))
+		case r == '\n':
+			e.buf = append(e.buf, '\\', 'n')
+		case r == '\r':
+			e.buf = append(e.buf, '\\', 'r')
+		case r == '\t':
+			e.buf = append(e.buf, '\\', 't')
+		case r < ' ' || r == 0x7f || r >= utf8.RuneSelf && (!utf8OK || r == utf8.RuneError && n == 1):
+			e.buf = append(e.buf, '\\', 'x', hex[s[0]>>4], hex[s[0]&0xf])
+		default:
+			e.buf = append(e.buf, s[:n]...)
+		}
+		s = s[n:]
+	}
+	e.buf = append(e.buf, '"')
+}
+
+// TextDecoder reads the protobuf text format for the Parse<Msg>Text
+// functions. Its methods each read one token and record the first error,
+// after which they return zero values and Next reports false.
+type TextDecoder struct {
+	s     string
+	pos   int
+	depth int
+	name  string
+	err   error
+}
+
+// Err returns the first error, or one for input left after the message.
+func (d *TextDecoder) Err() error {
+	if d.err == nil {
+		d.skipSpace()
+		if d.pos < len(d.s) {
+			d.fail("unexpected " + strconv.QuoteRune(rune(d.s[d.pos])))
+		}
+	}
+	return d.err
+}
+
+// Next reads the name of the next field of the current message, and the
+// colon after it, reporting false at the closing brace or end of input.
+func (d *TextDecoder) Next() bool {
+	if d.err != nil {
+		return false
+	}
+	d.skipSpace()
+	if d.pos < len(d.s) && (d.s[d.pos] == ',' || d.s[d.pos] == ';') {
+		d.pos++
+		d.skipSpace()
+	}
+	switch {
+	case d.pos == len(d.s):
+		if d.depth > 0 {
+			d.fail("unexpected end of input")
+		}
+		return false
+	case d.s[d.pos] == '}':
+		return false
+	}
+	d.name = d.token()
+	if d.name == "" {
+		return false
+	}
+	d.skipSpace()
+	if d.pos < len(d.s) && d.s[d.pos] == ':' {
+		d.pos++
+	}
+	return true
+}
+
+// Name returns the field name Next read.
+func (d *TextDecoder) Name() string {
+	return d.name
+}
+
+func (d *TextDecoder) UnknownField() {
+	d.fail("unknown field " + strconv.Quote(d.name))
+}
+
+func (d *TextDecoder) BeginMessage() {
+	d.expect('{')
+	d.depth++
+}
+
+func (d *TextDecoder) EndMessage() {
+	d.expect('}')
+	d.depth--
+}
+
+func (d *TextDecoder) Bool() bool {
+	switch tok := d.token(); tok {
+	case "true", "True", "t", "1":
+		return true
+	case "false", "False", "f", "0", "":
+		return false
+	default:
+		d.fail("invalid bool " + strconv.Quote(tok))
+		return false
+	}
+}
+
+// Int reads a decimal, 0x hexadecimal or 0 octal integer of bitSize bits.
+func (d *TextDecoder) Int(bitSize int) int64 {
+	tok := d.token()
+	v, err := strconv.ParseInt(tok, 0, bitSize)
+	if err != nil && tok != "" {
+		d.fail("invalid integer " + strconv.Quote(tok))
+	}
+	return v
+}
+
+func (d *TextDecoder) Uint(bitSize int) uint64 {
+	tok := d.token()
+	v, err := strconv.ParseUint(tok, 0, bitSize)
+	if err != nil && tok != "" {
+		d.fail("invalid integer " + strconv.Quote(tok))
+	}
+	return v
+}
+
+// Float reads a number, optionally with an f suffix, or inf, -inf or nan.
+func (d *TextDecoder) Float(bitSize int) float64 {
+	tok := d.token()
+	v, err := strconv.ParseFloat(tok, bitSize)
+	if err != nil && (strings.HasSuffix(tok, "f") || strings.HasSuffix(tok, "F")) {
+		v, err = strconv.ParseFloat(tok[:len(tok)-1], bitSize)
+	}
+	if err != nil && tok != "" {
+		d.fail("invalid number " + strconv.Quote(tok))
+	}
+	return v
+}
+
+// Enum reads an enum value by the name values gives it or by its number.
+func (d *TextDecoder) Enum(values map[string]int32) int32 {
+	tok := d.token()
+	if tok == "" {
+		return 0
+	}
+	if c := tok[0]; c == '-' || '0' <= c && c <= '9' {
+		v, err := strconv.ParseInt(tok, 0, 32)
+		if err != nil {
+			d.fail("invalid enum value " + strconv.Quote(tok))
+		}
+		return int32(v)
+	}
+	v, ok := values[tok]
+	if !ok {
+		d.fail("unknown enum value " + strconv.Quote(tok))
+	}
+	return v
+}
+
+// String reads one or more adjacent quoted strings, which must be valid
+// UTF-8 once unescaped.
+func (d *TextDecoder) String() string {
+	b := d.quoted()
+	if !utf8.Valid(b) {
+		d.fail("invalid UTF-8 in string")
+		return ""
+	}
+	return string(b)
+}
+
+func (d *TextDecoder) Bytes() []byte {
+	return d.quoted()
+}
+
+// UUID reads a quoted string of 16 bytes.
+func (d *TextDecoder) UUID() [16]byte {
+	var v [16]byte
+	if b := d.quoted(); len(b) == len(v) {
+		copy(v[:], b)
+	} else if d.err == nil {
+		d.fail("invalid UUID length " + strconv.Itoa(len(b)))
+	}
+	return v
+}
+
+// Timestamp reads a google.protobuf.Timestamp message.
+func (d *TextDecoder) Timestamp() time.Time {
+	seconds, nanos := d.secondsNanos()
+	return time.Unix(seconds, nanos)
+}
+
+// Duration reads a google.protobuf.Duration message.
+func (d *TextDecoder) Duration() time.Duration {
+	seconds, nanos := d.secondsNanos()
+	return time.Duration(seconds)*time.Second + time.Duration(nanos)
+}
+
+func (d *TextDecoder) secondsNanos() (seconds, nanos int64) {
+	d.BeginMessage()
+	for d.Next() {
+		switch d.name {
+		case "seconds":
+			seconds = d.Int(64)
+		case "nanos":
+			nanos = d.Int(32)
+		default:
+			d.UnknownField()
+		}
+	}
+	d.EndMessage()
+	return seconds, nanos
+}
+
+func (d *TextDecoder) fail(msg string) {
+	if d.err == nil {
+		line := 1 + strings.Count(d.s[:d.pos], "\n")
+		d.err = fmt.Errorf("text format line %d: %s", line, msg)
+	}
+}
+
+// skipSpace skips whitespace and # comments.
+func (d *TextDecoder) skipSpace() {
+	for d.pos < len(d.s) {
+		switch d.s[d.pos] {
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+			d.pos++
+		case '#':
+			if i := strings.IndexByte(d.s[d.pos:], '\n'); i >= 0 {
+				d.pos += i + 1
+			} else {
+				d.pos = len(d.s)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (d *TextDecoder) expect(c byte) {
+	if d.err != nil {
+		return
+	}
+	d.skipSpace()
+	if d.pos == len(d.s) || d.s[d.pos] != c {
+		d.fail("expected " + strconv.QuoteRune(rune(c)))
+		return
+	}
+	d.pos++
+}
+
+// token reads a field name or unquoted value: a run of letters, digits and
+// _ . + - characters.
+func (d *TextDecoder) token() string {
+	if d.err != nil {
+		return ""
+	}
+	d.skipSpace()
+	start := d.pos
+	for d.pos < len(d.s) {
+		c := d.s[d.pos]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '.' || c == '+' || c == '-') {
+			break
+		}
+		d.pos++
+	}
+	if d.pos == start {
+		if d.pos == len(d.s) {
+			d.fail("unexpected end of input")
+		} else {
+			d.fail("unexpected " + strconv.QuoteRune(rune(d.s[d.pos])))
+		}
+	}
+	return d.s[start:d.pos]
+}
+
+// quoted reads one or more adjacent single or double quoted strings and
+// returns their concatenated, unescaped bytes.
+func (d *TextDecoder) quoted() []byte {
+	b := []byte{}
+	if d.err != nil {
+		return b
+	}
+	d.skipSpace()
+	if d.pos == len(d.s) || d.s[d.pos] != '"' && d.s[d.pos] != '\'' {
+		d.fail("expected a quoted string")
+		return b
+	}
+	for d.pos < len(d.s) && (d.s[d.pos] == '"' || d.s[d.pos] == '\'') {
+		quote := d.s[d.pos]
+		d.pos++
+		for {
+			if d.pos >= len(d.s) || d.s[d.pos] == '\n' {
+				d.fail("unterminated string")
+				return b
+			}
+			c := d.s[d.pos]
+			d.pos++
+			if c == quote {
+				break
+			}
+			if c != '\\' {
+				b = append(b, c)
+				continue
+			}
+			var ok bool
+			if b, ok = d.unescape(b); !ok {
+				d.fail("invalid escape in string")
+				return b
+			}
+		}
+		d.skipSpace()
+	}
+	return b
+}
+
+// unescape appends the value of the escape sequence after a backslash.
+func (d *TextDecoder) unescape(b []byte) ([]byte, bool) {
+	if d.pos == len(d.s) {
+		return b, false
+	}
+	c := d.s[d.pos]
+	d.pos++
+	switch c {
+	case 'n':
+		return append(b, '\n'), true
+	case 'r':
+		return append(b, '\r'), true
+	case 't':
+		return append(b, '\t'), true
+	case 'a':
+		return append(b, '\a'), true
+	case 'b':
+		return append(b, '\b'), true
+	case 'f':
+		return append(b, '\f'), true
+	case 'v':
+		return append(b, '\v'), true
+	case '"', '\'', '\\', '?':
+		return append(b, c), true
+	case 'x', 'X':
+		v, ok := d.digits(16, 1, 2)
+		return append(b, byte(v)), ok
+	case 'u':
+		v, ok := d.digits(16, 4, 4)
+		return utf8.AppendRune(b, rune(v)), ok
+	case 'U':
+		v, ok := d.digits(16, 8, 8)
+		return utf8.AppendRune(b, rune(v)), ok && v <= utf8.MaxRune
+	}
+	if '0' <= c && c <= '7' {
+		d.pos--
+		v, ok := d.digits(8, 1, 3)
+		return append(b, byte(v)), ok && v <= 0xff
+	}
+	return b, false
+}
+
+// digits reads between min and max digits of base.
+func (d *TextDecoder) digits(base, min, max int) (uint64, bool) {
+	start := d.pos
+	for d.pos < len(d.s) && d.pos-start < max {
+		c := d.s[d.pos]
+		if !('0' <= c && c <= '9' && int(c-'0') < base || base == 16 && ('a' <= c && c <= 'f' || 'A' <= c && c <= 'F')) {
+			break
+		}
+		d.pos++
+	}
+	if d.pos-start < min {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(d.s[start:d.pos], base, 32)
+	return v, err == nil
+}
+
+// sortedTextKeys returns the keys of m in the order TextString writes map
+// entries: numerically for integer keys and bytewise for strings.
+func sortedTextKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	return slices.Sorted(maps.Keys(m))
+}
+
+func sortedTextBoolKeys[V any](m map[bool]V) []bool {
+	keys := make([]bool, 0, 2)
+	for _, k := range []bool{false, true} {
+		if _, ok := m[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+`
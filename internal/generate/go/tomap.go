@@ -0,0 +1,105 @@
+package gogen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// buildGoToMapLines returns the body of a message's ToMap method, which sets
+// one entry of out per visible field, keyed by its protojson name. Nested
+// messages become maps and enums their value names, including as repeated
+// elements and map values; other values are stored as they are. Pointer
+// fields, and the elements of repeated wrappers, are stored as the value they
+// point to, or nil.
+func buildGoToMapLines(fields []goField, visible []ir.Field) []string {
+	var lines []string
+	for i, field := range visible {
+		expr := "m." + fields[i].Name
+		key := fmt.Sprintf("out[%q]", goProtoJSONName(field))
+		switch {
+		case field.IsMap:
+			convert := goToMapConvert(field.MapValueKind, false)
+			if convert == "" {
+				lines = append(lines, key+" = "+expr)
+				continue
+			}
+			lines = append(lines,
+				"if "+expr+" != nil {",
+				fmt.Sprintf("\titems := make(map[%s]any, len(%s))", mustGoMapKeyType(field.MapKeyKind), expr),
+				"\tfor k, v := range "+expr+" {",
+				"\t\titems[k] = v"+convert,
+				"\t}",
+				"\t"+key+" = items",
+				"} else {",
+				"\t"+key+" = nil",
+				"}")
+		case field.IsRepeated && field.IsWrapper:
+			lines = append(lines,
+				"if "+expr+" != nil {",
+				"\titems := make([]any, len("+expr+"))",
+				"\tfor i, v := range "+expr+" {",
+				"\t\tif v != nil {",
+				"\t\t\titems[i] = *v",
+				"\t\t}",
+				"\t}",
+				"\t"+key+" = items",
+				"} else {",
+				"\t"+key+" = nil",
+				"}")
+		case field.IsRepeated:
+			convert := goToMapConvert(field.Kind, field.IsTimestamp || field.IsDuration || field.GoType != "")
+			if convert == "" {
+				lines = append(lines, key+" = "+expr)
+				continue
+			}
+			lines = append(lines,
+				"if "+expr+" != nil {",
+				"\titems := make([]any, len("+expr+"))",
+				"\tfor i := range "+expr+" {",
+				"\t\titems[i] = "+expr+"[i]"+convert,
+				"\t}",
+				"\t"+key+" = items",
+				"} else {",
+				"\t"+key+" = nil",
+				"}")
+		default:
+			convert := goToMapConvert(field.Kind, field.IsTimestamp || field.IsDuration || field.GoType != "")
+			if !strings.HasPrefix(fields[i].Type, "*") {
+				lines = append(lines, key+" = "+expr+convert)
+				continue
+			}
+			value := "*" + expr
+			switch {
+			case convert == "":
+			case field.Kind == ir.KindMessage:
+				value = expr + convert
+			default:
+				value = "(" + value + ")" + convert
+			}
+			lines = append(lines,
+				"if "+expr+" != nil {",
+				"\t"+key+" = "+value,
+				"} else {",
+				"\t"+key+" = nil",
+				"}")
+		}
+	}
+	return lines
+}
+
+// goToMapConvert returns the method call converting a value of kind for
+// ToMap, or "" when the value is stored as it is. native is set for message
+// kinds held as a Go type (Timestamp, Duration, cp.go_type).
+func goToMapConvert(kind ir.Kind, native bool) string {
+	switch {
+	case native:
+		return ""
+	case kind == ir.KindMessage:
+		return ".ToMap()"
+	case kind == ir.KindEnum:
+		return ".mapValue()"
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package gogen
+
+import (
+	"fmt"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// buildGoUnknownEnumLines returns the body of a message's UnknownEnums
+// method, which appends an UnknownEnum to out for each value of the
+// message's enum fields, including map values, that its enum's _name map
+// does not list. Map entries are sorted by value, as their order is random.
+func buildGoUnknownEnumLines(msg ir.Message, enumIndex map[string]ir.Enum) ([]string, error) {
+	var lines []string
+	for _, field := range goVisibleFields(msg.Fields) {
+		enumName := field.EnumFullName
+		if field.IsMap {
+			enumName = field.MapValueEnum
+		}
+		if enumName == "" || (field.IsMap && field.MapValueKind != ir.KindEnum) || (!field.IsMap && field.Kind != ir.KindEnum) {
+			continue
+		}
+		enum, ok := enumIndex[enumName]
+		if !ok {
+			return nil, fmt.Errorf("unknown enum type: %s", enumName)
+		}
+		name := "m." + goStructFieldName(msg, field)
+		check := func(value string) string {
+			if goEnumString(field) {
+				value = fmt.Sprintf("EnumNumber(%s, %s_value)", value, enum.Name)
+			} else {
+				value = "int32(" + value + ")"
+			}
+			return fmt.Sprintf("out = appendUnknownEnum(out, %d, %s, %s_name)", field.Number, value, enum.Name)
+		}
+		switch {
+		case field.IsMap:
+			lines = append(lines,
+				"if len("+name+") > 0 {",
+				"\tstart := len(out)",
+				"\tfor _, v := range "+name+" {",
+				"\t\t"+check("v"),
+				"\t}",
+				"\tsortUnknownEnums(out[start:])",
+				"}")
+		case field.IsRepeated:
+			lines = append(lines,
+				"for _, v := range "+name+" {",
+				"\t"+check("v"),
+				"}")
+		case field.IsOptional:
+			lines = append(lines,
+				"if "+name+" != nil {",
+				"\t"+check("*"+name),
+				"}")
+		default:
+			lines = append(lines, check(name))
+		}
+	}
+	return lines, nil
+}
+
+const unknownEnumsUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+import (
+	"cmp"
+	"slices"
+)
+
+// UnknownEnum is an enum field value that its generated enum does not
+// declare, such as one added by a newer version of the schema.
+type UnknownEnum struct {
+	// Field is the number of the field holding the value.
+	Field int
+	Value int32
+}
+
+func appendUnknownEnum(out []UnknownEnum, field int, value int32, names map[int32]string) []UnknownEnum {
+	if _, ok := names[value]; ok {
+		return out
+	}
+	return append(out, UnknownEnum{Field: field, Value: value})
+}
+
+func sortUnknownEnums(unknown []UnknownEnum) {
+	slices.SortFunc(unknown, func(a, b UnknownEnum) int {
+		return cmp.Compare(a.Value, b.Value)
+	})
+}
+`
@@ -0,0 +1,144 @@
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+
+	"github.com/jptrs93/cleanproto/internal/generate"
+)
+
+var goUtilPrefixPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// applyGoUtilPrefix prepends prefix to every package-level identifier
+// declared in util.gen.go (and json_util.gen.go) and rewrites references to them in all generated Go
+// files, so the helpers cannot clash with a user's own declarations in the
+// same package. It works on the parsed syntax tree, so locals, fields, methods
+// and selectors that merely share a helper's name are left alone.
+func applyGoUtilPrefix(outputs []generate.OutputFile, prefix string) error {
+	if !goUtilPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("invalid go util prefix %q: must be a Go identifier prefix", prefix)
+	}
+	type parsedOutput struct {
+		index int
+		fset  *token.FileSet
+		file  *ast.File
+	}
+	var parsed []parsedOutput
+	names := make(map[string]bool)
+	for i, output := range outputs {
+		if filepath.Ext(output.Path) != ".go" {
+			continue
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, output.Path, output.Content, parser.ParseComments)
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", output.Path, err)
+		}
+		parsed = append(parsed, parsedOutput{index: i, fset: fset, file: file})
+		if base := filepath.Base(output.Path); base == "util.gen.go" || base == "json_util.gen.go" || base == "arena.gen.go" || base == "mask.gen.go" || base == "delimited.gen.go" || base == "unknownenums.gen.go" || base == "sortedrange.gen.go" || base == "visitor.gen.go" || base == "decoder.gen.go" || base == "textformat.gen.go" || base == "diff.gen.go" || base == "canonical.gen.go" {
+			for name := range goTopLevelNames(file) {
+				names[name] = true
+			}
+		}
+	}
+	for _, p := range parsed {
+		renameGoIdents(p.file, names, prefix)
+		var buf bytes.Buffer
+		if err := format.Node(&buf, p.fset, p.file); err != nil {
+			return fmt.Errorf("format %s: %w", outputs[p.index].Path, err)
+		}
+		outputs[p.index].Content = buf.Bytes()
+	}
+	return nil
+}
+
+// goTopLevelNames returns the package-level funcs, types, vars and consts
+// declared in file. Methods are excluded since they live in their type's
+// namespace.
+func goTopLevelNames(file *ast.File) map[string]bool {
+	names := make(map[string]bool)
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names[d.Name.Name] = true
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names[s.Name.Name] = true
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						if name.Name != "_" {
+							names[name.Name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+func renameGoIdents(file *ast.File, names map[string]bool, prefix string) {
+	topLevel := make(map[any]bool)
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				topLevel[d] = true
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				topLevel[spec] = true
+			}
+		}
+	}
+	skip := make(map[*ast.Ident]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			skip[x.Sel] = true
+		case *ast.FuncDecl:
+			if x.Recv != nil {
+				skip[x.Name] = true
+			}
+		case *ast.Field:
+			// Struct fields and parameters; parameters shadowing a helper
+			// resolve to the Field below anyway.
+			for _, name := range x.Names {
+				skip[name] = true
+			}
+		case *ast.CompositeLit:
+			if _, isMap := x.Type.(*ast.MapType); isMap {
+				return true
+			}
+			for _, elt := range x.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if key, ok := kv.Key.(*ast.Ident); ok {
+						skip[key] = true
+					}
+				}
+			}
+		case *ast.Ident:
+			if skip[x] || !names[x.Name] {
+				return true
+			}
+			// Unresolved identifiers refer to another file's package scope;
+			// resolved ones must point at a package-level declaration rather
+			// than a local that shadows the helper.
+			if x.Obj != nil && !topLevel[x.Obj.Decl] {
+				return true
+			}
+			x.Name = prefix + x.Name
+		}
+		return true
+	})
+}
@@ -223,7 +223,7 @@ func (g *validateGen) emitValidate(b *strings.Builder, msg ir.Message) error {
 		if field.Constraints.Ignore == ir.IgnoreAlways {
 			continue
 		}
-		if err := g.emitField(b, field); err != nil {
+		if err := g.emitField(b, msg, field); err != nil {
 			return err
 		}
 	}
@@ -232,9 +232,9 @@ func (g *validateGen) emitValidate(b *strings.Builder, msg ir.Message) error {
 	return nil
 }
 
-func (g *validateGen) emitField(b *strings.Builder, field ir.Field) error {
+func (g *validateGen) emitField(b *strings.Builder, msg ir.Message, field ir.Field) error {
 	pathExpr := strconv.Quote(fieldProtoName(field))
-	receiver := "m." + ir.GoName(field.Name)
+	receiver := "m." + goStructFieldName(msg, field)
 	switch {
 	case field.IsMap:
 		return g.emitMapField(b, field, receiver, pathExpr)
@@ -861,6 +861,9 @@ func (g *validateGen) emitRepeatedField(b *strings.Builder, field ir.Field, rece
 	if !hasItemConstraints && !needsRecurseMessage && !wantUnique {
 		return nil
 	}
+	if field.IsWrapper {
+		return fmt.Errorf("repeated item rules not supported for wrapper field %s", field.Name)
+	}
 
 	if wantUnique {
 		if err := g.emitUniqueCheck(b, field, receiver, pathExpr); err != nil {
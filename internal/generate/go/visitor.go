@@ -0,0 +1,54 @@
+package gogen
+
+import (
+	"fmt"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// buildGoVisitLines returns the body of a message's VisitFields method, which
+// passes each visible field to v in declaration order and then visits the
+// fields of the generated messages it holds, singly, as list elements or as
+// map values. Timestamps, durations and cp.go_type fields are passed as they
+// are and not descended into.
+func buildGoVisitLines(fields []goField, visible []ir.Field) []string {
+	var lines []string
+	for i, field := range visible {
+		expr := "m." + fields[i].Name
+		lines = append(lines, fmt.Sprintf("v.VisitField(%d, %q, %s)", field.Number, fieldProtoName(field), expr))
+		switch {
+		case field.IsMap:
+			if field.MapValueKind == ir.KindMessage {
+				lines = append(lines,
+					"for _, item := range "+expr+" {",
+					"\titem.VisitFields(v)",
+					"}")
+			}
+		case field.Kind != ir.KindMessage || field.IsTimestamp || field.IsDuration || field.GoType != "":
+		case field.IsRepeated:
+			// Indexing rather than ranging by value also reaches the
+			// elements of cp.go_slice_ptr = false value slices in place.
+			lines = append(lines,
+				"for i := range "+expr+" {",
+				"\t"+expr+"[i].VisitFields(v)",
+				"}")
+		default:
+			lines = append(lines, expr+".VisitFields(v)")
+		}
+	}
+	return lines
+}
+
+const visitorUtilSource = `// Code generated by cleanproto. DO NOT EDIT.
+
+package __PACKAGE__
+
+// Visitor receives the fields of a message from its VisitFields method, for
+// schema-agnostic traversal such as redaction.
+type Visitor interface {
+	// VisitField is called with a field's number, proto name and Go value,
+	// such as a string, an enum, a *Child, a []int32 or a map, for a type
+	// switch. Unset fields are passed as their zero value.
+	VisitField(number int, name string, value any)
+}
+`
@@ -0,0 +1,45 @@
+package gogen
+
+import (
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// goWriteToFlush hands b to w once it has grown past writeToChunk.
+var goWriteToFlush = []string{
+	"if b, err = flushWriteTo(w, b, &n, writeToChunk); err != nil {",
+	"\treturn n, err",
+	"}",
+}
+
+// buildGoWriteToLines returns the body of a message's WriteTo method: Encode's
+// lines a field at a time, each followed by a flush of the buffer to w, and
+// for repeated message fields a flush after every element. The buffer so
+// holds at most one chunk plus one top-level field or element, rather than
+// the whole encoding.
+func buildGoWriteToLines(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum) ([]string, error) {
+	var lines []string
+	for _, field := range msg.Fields {
+		if field.GoIgnore || !field.GoEncode {
+			continue
+		}
+		var encodeLines []string
+		var err error
+		if field.IsRepeated && !field.IsMap && field.Kind == ir.KindMessage && !field.IsTimestamp && !field.IsDuration && !field.IsWrapper && field.GoType == "" {
+			encodeLines, err = goEncodeRepeatedMessage("m."+goStructFieldName(msg, field), field, msgIndex, goWriteToFlush)
+		} else {
+			single := msg
+			single.Fields = []ir.Field{field}
+			encodeLines, err = buildGoEncodeLines(single, msgIndex, enumIndex)
+			encodeLines = append(encodeLines, goWriteToFlush...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range encodeLines {
+			lines = append(lines, strings.ReplaceAll(line, "protowire.", ""))
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,58 @@
+package generate
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// PrependHeader puts header, e.g. a license notice, as a comment block at the
+// top of every generated Go, JS and TS file, ahead of the do-not-edit banner.
+// Go files get // line comments so the result stays gofmt-clean; JS and TS
+// files get a /* ... */ block.
+func PrependHeader(outputs []OutputFile, header string) {
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(header, "\r\n", "\n"), "\n"), "\n")
+	for i, out := range outputs {
+		var comment string
+		switch filepath.Ext(out.Path) {
+		case ".go":
+			comment = goHeaderComment(lines)
+		case ".js", ".ts":
+			comment = jsHeaderComment(lines)
+		default:
+			continue
+		}
+		outputs[i].Content = append([]byte(comment+"\n"), out.Content...)
+	}
+}
+
+func goHeaderComment(lines []string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		if line == "" {
+			b.WriteString("//\n")
+			continue
+		}
+		b.WriteString("// ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func jsHeaderComment(lines []string) string {
+	var b strings.Builder
+	b.WriteString("/*\n")
+	for _, line := range lines {
+		// A literal */ would end the block early.
+		line = strings.ReplaceAll(line, "*/", "* /")
+		if line == "" {
+			b.WriteString(" *\n")
+			continue
+		}
+		b.WriteString(" * ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString(" */\n")
+	return b.String()
+}
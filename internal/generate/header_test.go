@@ -0,0 +1,72 @@
+package generate_test
+
+import (
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jptrs93/cleanproto/internal/generate"
+	gogen "github.com/jptrs93/cleanproto/internal/generate/go"
+	jsg "github.com/jptrs93/cleanproto/internal/generate/js"
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+func TestPrependHeaderToGoAndJSOutputs(t *testing.T) {
+	const header = "Copyright 2026 Example Corp.\n\nLicensed under the Apache License, Version 2.0.\n"
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Ping",
+			FullName: "example.Ping",
+			Fields:   []ir.Field{{Name: "id", Number: 1, Kind: ir.KindString, GoEncode: true, JsEncode: true}},
+		}},
+	}}
+	dir := t.TempDir()
+	options := generate.Options{GoOut: filepath.Join(dir, "go"), JsOut: filepath.Join(dir, "js")}
+	outputs, err := generate.Generate(files, options, gogen.Generator{}, jsg.Generator{})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	generate.PrependHeader(outputs, header)
+	// WriteFiles writes Go files as given, so the header must leave them
+	// gofmt'd.
+	for _, out := range outputs {
+		if !strings.HasSuffix(out.Path, ".go") {
+			continue
+		}
+		formatted, err := format.Source(out.Content)
+		if err != nil || string(formatted) != string(out.Content) {
+			t.Errorf("expected %s to stay gofmt'd with the header, got err %v", out.Path, err)
+		}
+	}
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+
+	wantGo := "// Copyright 2026 Example Corp.\n//\n// Licensed under the Apache License, Version 2.0.\n\n// Code generated by cleanproto. DO NOT EDIT.\n"
+	wantJS := "/*\n * Copyright 2026 Example Corp.\n *\n * Licensed under the Apache License, Version 2.0.\n */\n\n// Code generated by cleanproto. DO NOT EDIT.\n"
+	for _, path := range []string{"go/model.gen.go", "go/util.gen.go", "js/model.js", "js/runtime.js"} {
+		content, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		want := wantGo
+		if strings.HasSuffix(path, ".js") {
+			want = wantJS
+		}
+		if !strings.HasPrefix(string(content), want) {
+			t.Errorf("expected %s to start with header %q, got:\n%s", path, want, content[:min(len(content), 300)])
+		}
+	}
+}
+
+func TestPrependHeaderEscapesJSCommentTerminator(t *testing.T) {
+	outputs := []generate.OutputFile{{Path: "model.js", Content: []byte("export {};\n")}}
+	generate.PrependHeader(outputs, "see */ here")
+	got := string(outputs[0].Content)
+	if strings.Count(got, "*/") != 1 || !strings.Contains(got, " * see * / here\n") {
+		t.Fatalf("expected escaped comment terminator, got:\n%s", got)
+	}
+}
@@ -101,7 +101,44 @@ func (g Generator) Generate(files []ir.File, options generate.Options) ([]genera
 	if err != nil {
 		return nil, err
 	}
+	inlineRuntime := false
+	protobufJS := false
+	switch options.JsRuntime {
+	case "", "import":
+	case "inline":
+		inlineRuntime = true
+	case "protobufjs":
+		protobufJS = true
+	default:
+		return nil, fmt.Errorf("unsupported js runtime mode: %q", options.JsRuntime)
+	}
+	asMap := false
+	switch options.JsMap {
+	case "", "object":
+	case "map":
+		asMap = true
+	default:
+		return nil, fmt.Errorf("unsupported js map mode: %q", options.JsMap)
+	}
+	indent, err := jsIndentUnit(options.JsIndent)
+	if err != nil {
+		return nil, err
+	}
+	files, err = jsUseMessageNames(files)
+	if err != nil {
+		return nil, err
+	}
+	if options.JsJSONNames {
+		files, err = jsUseJSONNames(files)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if protobufJS {
+		files = jsUseLong(files)
+	}
 	msgIndex := indexMessages(files)
+	enumIndex := indexEnums(files)
 	var outputs []generate.OutputFile
 	jsEmitted := false
 	for _, file := range files {
@@ -110,34 +147,64 @@ func (g Generator) Generate(files []ir.File, options generate.Options) ([]genera
 			continue
 		}
 		jsEmitted = true
-		data, err := buildJSFileData(file, msgIndex)
+		data, err := buildJSFileData(file, msgIndex, enumIndex, asMap)
 		if err != nil {
 			return nil, err
 		}
+		if inlineRuntime {
+			data.InlineRuntime = jsInlineRuntimeSource()
+			if indent != "" {
+				// Brought to model.js indentation so one pass reindents both.
+				data.InlineRuntime = jsReindent(data.InlineRuntime, jsRuntimeIndent, strings.Repeat(" ", jsModelIndent))
+			}
+		}
+		data.ProtobufJS = protobufJS
+		if options.JsValidate {
+			for i, msg := range file.Messages {
+				msgForJS := msg
+				msgForJS.Fields = jsVisibleFields(msg.Fields)
+				data.Messages[i].ValidateFunc, err = buildJSValidateFunc(msgForJS, msgIndex, enumIndex, asMap)
+				if err != nil {
+					return nil, err
+				}
+			}
+			data.NeedsValidate = len(file.Messages) > 0
+		}
 		var buf bytes.Buffer
 		if err := tmpl.Execute(&buf, data); err != nil {
 			return nil, err
 		}
+		model := buf.String()
+		if indent != "" {
+			model = jsReindent(model, jsModelIndent, indent)
+		}
 		outPath := filepath.Join(jsOut, "model.js")
 		outputs = append(outputs, generate.OutputFile{
 			Path:    outPath,
-			Content: buf.Bytes(),
+			Content: []byte(model),
 		})
 		if len(file.Services) > 0 {
 			capi, err := buildJSCapiFile(file, msgIndex)
 			if err != nil {
 				return nil, err
 			}
+			if indent != "" {
+				capi = jsReindent(capi, jsCapiIndent, indent)
+			}
 			outputs = append(outputs, generate.OutputFile{
 				Path:    filepath.Join(jsOut, "capi.js"),
 				Content: []byte(capi),
 			})
 		}
 	}
-	if jsEmitted {
+	if jsEmitted && !inlineRuntime && !protobufJS {
+		runtime := templates.JSRuntimeSource
+		if indent != "" {
+			runtime = jsReindent(runtime, jsRuntimeIndent, indent)
+		}
 		outputs = append(outputs, generate.OutputFile{
 			Path:    filepath.Join(options.JsOut, "runtime.js"),
-			Content: []byte(templates.JSRuntimeSource),
+			Content: []byte(runtime),
 		})
 	}
 	return outputs, nil
@@ -554,8 +621,19 @@ func camelWords(s string) []string {
 	return out
 }
 
+// jsInlineRuntimeSource returns runtime.js adapted for embedding in model.js:
+// the generated-file header is dropped and Writer/Reader become module-private.
+func jsInlineRuntimeSource() string {
+	src := strings.TrimPrefix(templates.JSRuntimeSource, "// Code generated by cleanproto. DO NOT EDIT.\n//\n")
+	src = strings.ReplaceAll(src, "export class ", "class ")
+	return strings.TrimRight(src, "\n")
+}
+
 type jsFileData struct {
+	Enums                []string
 	Typedefs             []string
+	InlineRuntime        string
+	ProtobufJS           bool
 	Messages             []jsMessage
 	NeedsReadInt64       bool
 	NeedsReadInt64BigInt bool
@@ -563,6 +641,7 @@ type jsFileData struct {
 	NeedsDuration        bool
 	NeedsTimestampNative bool
 	NeedsDurationBigInt  bool
+	NeedsValidate        bool
 }
 
 type jsMessage struct {
@@ -570,21 +649,25 @@ type jsMessage struct {
 	EncodeFunc        string
 	DecodeMessageFunc string
 	DecodeFunc        string
+	ValidateFunc      string
 	NeedsTimestamp    bool
 	NeedsDuration     bool
 }
 
-func buildJSFileData(file ir.File, msgIndex map[string]ir.Message) (jsFileData, error) {
+func buildJSFileData(file ir.File, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, asMap bool) (jsFileData, error) {
 	var data jsFileData
+	for _, enum := range file.Enums {
+		data.Enums = append(data.Enums, buildJSEnum(enum))
+	}
 	for _, msg := range file.Messages {
 		msgForJS := msg
 		msgForJS.Fields = jsVisibleFields(msg.Fields)
-		typedef, err := buildJSTypedef(msgForJS, msgIndex)
+		typedef, err := buildJSTypedef(msgForJS, msgIndex, enumIndex, asMap)
 		if err != nil {
 			return jsFileData{}, err
 		}
 		data.Typedefs = append(data.Typedefs, typedef)
-		jsMsg, needsReadInt64, err := buildJSMessage(msgForJS, msgIndex)
+		jsMsg, needsReadInt64, err := buildJSMessage(msgForJS, msgIndex, enumIndex, asMap)
 		if err != nil {
 			return jsFileData{}, err
 		}
@@ -598,7 +681,7 @@ func buildJSFileData(file ir.File, msgIndex map[string]ir.Message) (jsFileData,
 			data.NeedsDuration = true
 		}
 		for _, field := range msgForJS.Fields {
-			if field.JSType == "bigint" && (field.Kind == ir.KindInt64 || field.IsTimestamp || field.IsDuration) {
+			if field.JSType == "bigint" && (jsIsSignedInt64(field.Kind) || field.IsTimestamp || field.IsDuration) {
 				data.NeedsReadInt64BigInt = true
 			}
 			if field.JSType != "" && field.IsTimestamp {
@@ -613,14 +696,27 @@ func buildJSFileData(file ir.File, msgIndex map[string]ir.Message) (jsFileData,
 	return data, nil
 }
 
-func buildJSTypedef(msg ir.Message, msgIndex map[string]ir.Message) (string, error) {
+// buildJSEnum renders an enum as a frozen object mapping value names to
+// numbers. The JSDoc @enum tag lets typedefs refer to it by name.
+func buildJSEnum(enum ir.Enum) string {
+	var b strings.Builder
+	b.WriteString("/**\n * @readonly\n * @enum {number}\n */\n")
+	fmt.Fprintf(&b, "export const %s = Object.freeze({\n", enum.Name)
+	for _, value := range enum.Values {
+		fmt.Fprintf(&b, "    %s: %d,\n", value.Name, value.Number)
+	}
+	b.WriteString("});")
+	return b.String()
+}
+
+func buildJSTypedef(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, asMap bool) (string, error) {
 	var b strings.Builder
 	b.WriteString("/**\n")
 	b.WriteString(" * @typedef {Object} ")
 	b.WriteString(msg.Name)
 	b.WriteString("\n")
 	for _, field := range msg.Fields {
-		jsType, err := jsDocType(field, msgIndex)
+		jsType, err := jsDocType(field, msgIndex, enumIndex, asMap)
 		if err != nil {
 			return "", err
 		}
@@ -634,13 +730,13 @@ func buildJSTypedef(msg ir.Message, msgIndex map[string]ir.Message) (string, err
 	return b.String(), nil
 }
 
-func buildJSMessage(msg ir.Message, msgIndex map[string]ir.Message) (jsMessage, bool, error) {
-	writeFunc, needsReadInt64, needsTimestampWrite, needsDurationWrite, err := buildWriteFunc(msg, msgIndex)
+func buildJSMessage(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, asMap bool) (jsMessage, bool, error) {
+	writeFunc, needsReadInt64, needsTimestampWrite, needsDurationWrite, err := buildWriteFunc(msg, msgIndex, asMap)
 	if err != nil {
 		return jsMessage{}, false, err
 	}
 	encodeFunc := buildEncodeFunc(msg)
-	decodeMessageFunc, needsReadInt64Decode, needsTimestampDecode, needsDurationDecode, err := buildDecodeMessageFunc(msg, msgIndex)
+	decodeMessageFunc, needsReadInt64Decode, needsTimestampDecode, needsDurationDecode, err := buildDecodeMessageFunc(msg, msgIndex, enumIndex, asMap)
 	if err != nil {
 		return jsMessage{}, false, err
 	}
@@ -655,7 +751,7 @@ func buildJSMessage(msg ir.Message, msgIndex map[string]ir.Message) (jsMessage,
 	}, needsReadInt64 || needsReadInt64Decode, nil
 }
 
-func buildWriteFunc(msg ir.Message, msgIndex map[string]ir.Message) (string, bool, bool, bool, error) {
+func buildWriteFunc(msg ir.Message, msgIndex map[string]ir.Message, asMap bool) (string, bool, bool, bool, error) {
 	var b strings.Builder
 	needsReadInt64 := false
 	needsTimestamp := false
@@ -674,17 +770,28 @@ func buildWriteFunc(msg ir.Message, msgIndex map[string]ir.Message) (string, boo
 			needsDuration = true
 		}
 		if field.IsMap {
-			b.WriteString("    if (message.")
-			b.WriteString(field.Name)
-			b.WriteString(" && Object.keys(message.")
-			b.WriteString(field.Name)
-			b.WriteString(").length > 0) {\n")
-			b.WriteString("        for (const [rawKey, value] of Object.entries(message.")
-			b.WriteString(field.Name)
-			b.WriteString(")) {\n")
-			b.WriteString("            const key = ")
-			b.WriteString(jsMapKeyCast(field.MapKeyKind))
-			b.WriteString(";\n")
+			if asMap {
+				b.WriteString("    if (message.")
+				b.WriteString(field.Name)
+				b.WriteString(" && message.")
+				b.WriteString(field.Name)
+				b.WriteString(".size > 0) {\n")
+				b.WriteString("        for (const [key, value] of message.")
+				b.WriteString(field.Name)
+				b.WriteString(".entries()) {\n")
+			} else {
+				b.WriteString("    if (message.")
+				b.WriteString(field.Name)
+				b.WriteString(" && Object.keys(message.")
+				b.WriteString(field.Name)
+				b.WriteString(").length > 0) {\n")
+				b.WriteString("        for (const [rawKey, value] of Object.entries(message.")
+				b.WriteString(field.Name)
+				b.WriteString(")) {\n")
+				b.WriteString("            const key = ")
+				b.WriteString(jsMapKeyCast(field.MapKeyKind))
+				b.WriteString(";\n")
+			}
 			b.WriteString("            writer.uint32(tag(")
 			b.WriteString(fmt.Sprintf("%d", field.Number))
 			b.WriteString(", WIRE.LDELIM)).fork();\n")
@@ -782,15 +889,15 @@ func buildEncodeFunc(msg ir.Message) string {
 
 func buildDecodeFunc(msg ir.Message) string {
 	var b strings.Builder
-	fmt.Fprintf(&b, "/**\n * @param {ArrayBuffer} buffer\n * @returns {%s}\n */\n", msg.Name)
+	fmt.Fprintf(&b, "/**\n * @param {ArrayBuffer | ArrayBufferView} buffer\n * @returns {%s}\n */\n", msg.Name)
 	fmt.Fprintf(&b, "export function decode%s(buffer) {\n", msg.Name)
-	b.WriteString("    const reader = Reader.create(new Uint8Array(buffer));\n")
+	b.WriteString("    const reader = Reader.create(buffer);\n")
 	fmt.Fprintf(&b, "    return decode%sMessage(reader);\n", msg.Name)
 	b.WriteString("}\n")
 	return b.String()
 }
 
-func buildDecodeMessageFunc(msg ir.Message, msgIndex map[string]ir.Message) (string, bool, bool, bool, error) {
+func buildDecodeMessageFunc(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, asMap bool) (string, bool, bool, bool, error) {
 	var b strings.Builder
 	needsReadInt64 := false
 	needsTimestamp := false
@@ -805,7 +912,7 @@ func buildDecodeMessageFunc(msg ir.Message, msgIndex map[string]ir.Message) (str
 		}
 		b.WriteString(field.Name)
 		b.WriteString(": ")
-		b.WriteString(jsDefaultValue(field, msgIndex))
+		b.WriteString(jsDefaultValue(field, msgIndex, asMap))
 	}
 	b.WriteString(" };\n")
 	b.WriteString("    while (reader.pos < end) {\n")
@@ -815,7 +922,7 @@ func buildDecodeMessageFunc(msg ir.Message, msgIndex map[string]ir.Message) (str
 		b.WriteString("            case ")
 		b.WriteString(fmt.Sprintf("%d", field.Number))
 		b.WriteString(": {\n")
-		lines, usesReadInt64, usesTimestamp, err := jsDecodeField(field, msgIndex, "message")
+		lines, usesReadInt64, usesTimestamp, err := jsDecodeField(field, msgIndex, enumIndex, "message", asMap)
 		if err != nil {
 			return "", false, false, false, err
 		}
@@ -841,12 +948,15 @@ func buildDecodeMessageFunc(msg ir.Message, msgIndex map[string]ir.Message) (str
 	return b.String(), needsReadInt64, needsTimestamp, needsDuration, nil
 }
 
-func jsDocType(field ir.Field, msgIndex map[string]ir.Message) (string, error) {
+func jsDocType(field ir.Field, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, asMap bool) (string, error) {
 	if field.IsMap {
-		valueType, err := jsMapValueType(field, msgIndex)
+		valueType, err := jsMapValueType(field, msgIndex, enumIndex)
 		if err != nil {
 			return "", err
 		}
+		if asMap {
+			return "Map.<" + jsMapKeyDocType(field.MapKeyKind) + ", " + valueType + ">", nil
+		}
 		return "Object.<string, " + valueType + ">", nil
 	}
 	t, err := jsBaseType(field, msgIndex)
@@ -854,13 +964,19 @@ func jsDocType(field ir.Field, msgIndex map[string]ir.Message) (string, error) {
 		return "", err
 	}
 	if field.IsRepeated {
+		if field.IsWrapper {
+			return "(" + t + "|null)[]", nil
+		}
 		return t + "[]", nil
 	}
 	return t, nil
 }
 
-func jsDefaultValue(field ir.Field, msgIndex map[string]ir.Message) string {
+func jsDefaultValue(field ir.Field, msgIndex map[string]ir.Message, asMap bool) string {
 	if field.IsMap {
+		if asMap {
+			return "new Map()"
+		}
 		return "{}"
 	}
 	if field.IsRepeated {
@@ -872,12 +988,24 @@ func jsDefaultValue(field ir.Field, msgIndex map[string]ir.Message) string {
 		}
 		return "0n"
 	}
+	if field.JSType == "Long" {
+		if field.IsOptional {
+			return "undefined"
+		}
+		return jsLongZero(field.Kind)
+	}
 	if field.JSType == "number" {
 		if field.IsOptional {
 			return "undefined"
 		}
 		return "0"
 	}
+	if field.JSType == "string" {
+		if field.IsOptional {
+			return "undefined"
+		}
+		return "\"0\""
+	}
 	if field.JSType == "Date" || field.JSType == "LocalDate" {
 		if field.IsOptional {
 			return "undefined"
@@ -945,15 +1073,21 @@ func jsBaseType(field ir.Field, msgIndex map[string]ir.Message) (string, error)
 }
 
 func jsPresenceCheck(field ir.Field, name string) string {
-	if field.IsOptional {
+	if field.IsOptional || field.AlwaysEmit {
 		return name + " !== undefined && " + name + " !== null"
 	}
 	if field.JSType == "bigint" {
 		return name + " !== undefined && " + name + " !== null && " + name + " !== 0n"
 	}
+	if field.JSType == "Long" {
+		return name + " !== undefined && " + name + " !== null && !Long.fromValue(" + name + ").isZero()"
+	}
 	if field.JSType == "number" {
 		return name + " !== undefined && " + name + " !== null && " + name + " !== 0"
 	}
+	if field.JSType == "string" {
+		return name + " !== undefined && " + name + " !== null && " + name + " !== \"\" && " + name + " !== \"0\""
+	}
 	if field.JSType == "Date" || field.JSType == "LocalDate" {
 		return name + " instanceof Date && " + name + ".getTime() !== 0"
 	}
@@ -988,6 +1122,20 @@ func jsEncodeField(field ir.Field, msgIndex map[string]ir.Message, name, indent
 		b.WriteString(lines)
 		return b.String(), nil
 	}
+	if field.IsWrapper {
+		// A singular wrapper leaves a zero value out; a repeated element
+		// writes it, since null is the element without a value.
+		cond := name + " !== null && " + name + " !== undefined"
+		if !field.IsRepeated {
+			cond = jsPresenceCheck(jsWrappedValue(field), name)
+		}
+		fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.LDELIM)).fork();\n", indent, field.Number)
+		fmt.Fprintf(&b, "%sif (%s) {\n", indent, cond)
+		fmt.Fprintf(&b, "%s    writer.uint32(tag(1, %s)).%s(%s);\n", indent, jsWireType(field.Kind), jsWriterMethod(field.Kind), name)
+		fmt.Fprintf(&b, "%s}\n", indent)
+		fmt.Fprintf(&b, "%swriter.ldelim();\n", indent)
+		return b.String(), nil
+	}
 	if field.IsTimestamp {
 		fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.LDELIM)).fork();\n", indent, field.Number)
 		fmt.Fprintf(&b, "%swriteTimestamp(%s, writer);\n", indent, name)
@@ -1016,7 +1164,7 @@ func jsEncodeField(field ir.Field, msgIndex map[string]ir.Message, name, indent
 	return b.String(), nil
 }
 
-func jsDecodeField(field ir.Field, msgIndex map[string]ir.Message, target string) (string, bool, bool, error) {
+func jsDecodeField(field ir.Field, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, target string, asMap bool) (string, bool, bool, error) {
 	var b strings.Builder
 	fieldName := target + "." + field.Name
 	if field.JSType != "" {
@@ -1028,7 +1176,7 @@ func jsDecodeField(field ir.Field, msgIndex map[string]ir.Message, target string
 		return b.String(), needsReadInt64, false, nil
 	}
 	if field.IsMap {
-		mapLines, needsReadInt64, err := jsDecodeMapField(fieldName, field, msgIndex)
+		mapLines, needsReadInt64, err := jsDecodeMapField(fieldName, field, msgIndex, enumIndex, asMap)
 		if err != nil {
 			return "", false, false, err
 		}
@@ -1046,6 +1194,9 @@ func jsDecodeField(field ir.Field, msgIndex map[string]ir.Message, target string
 			b.WriteString(lines)
 			return b.String(), needsReadInt64, false, nil
 		}
+		if field.IsWrapper {
+			return jsDecodeWrapper(fieldName, field), isJSReadInt64(field), false, nil
+		}
 		if field.Kind == ir.KindMessage {
 			msg, ok := msgIndex[field.MessageFullName]
 			if !ok {
@@ -1054,18 +1205,20 @@ func jsDecodeField(field ir.Field, msgIndex map[string]ir.Message, target string
 			fmt.Fprintf(&b, "                %s.push(decode%sMessage(reader, reader.uint32()));\n", fieldName, msg.Name)
 			return b.String(), false, false, nil
 		}
-		if field.IsPacked && jsIsPackable(field.Kind) {
-			packedLines, needsReadInt64 := jsDecodePackedField(fieldName, field)
-			b.WriteString(packedLines)
-			return b.String(), needsReadInt64, false, nil
-		}
-		if isJSReadInt64(field) {
-			fmt.Fprintf(&b, "                %s.push(readInt64(reader, \"%s\"));\n", fieldName, jsReaderMethod(field.Kind))
-			return b.String(), true, false, nil
+		if jsIsPackable(field.Kind) {
+			// Decoders must accept both wire forms whatever the declared
+			// packing, since encoders and schema versions may disagree.
+			if isJSReadInt64(field) {
+				return jsDecodeRepeatedScalar(fieldName, fmt.Sprintf("readInt64(reader, \"%s\")", jsReaderMethod(field.Kind))), true, false, nil
+			}
+			return jsDecodeRepeatedScalar(fieldName, "reader."+jsReaderMethod(field.Kind)+"()"), false, false, nil
 		}
 		fmt.Fprintf(&b, "                %s.push(reader.%s());\n", fieldName, jsReaderMethod(field.Kind))
 		return b.String(), false, false, nil
 	}
+	if field.IsWrapper {
+		return jsDecodeWrapper(fieldName, field), isJSReadInt64(field), false, nil
+	}
 	if field.IsTimestamp {
 		lines, needsReadInt64 := jsDecodeTimestampSingle(fieldName, field)
 		b.WriteString(lines)
@@ -1113,8 +1266,8 @@ func jsEncodeNativeField(field ir.Field, name, indent string) (string, error) {
 		case ir.KindInt32:
 			fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.VARINT)).int32(Math.trunc(%s));\n", indent, field.Number, name)
 			return b.String(), nil
-		case ir.KindInt64:
-			fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.VARINT)).int64(Math.trunc(%s));\n", indent, field.Number, name)
+		case ir.KindInt64, ir.KindSint64:
+			fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.VARINT)).%s(Math.trunc(%s));\n", indent, field.Number, jsWriterMethod(field.Kind), name)
 			return b.String(), nil
 		}
 	case "bigint":
@@ -1134,8 +1287,8 @@ func jsEncodeNativeField(field ir.Field, name, indent string) (string, error) {
 		case ir.KindInt32:
 			fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.VARINT)).int32(Number(%s));\n", indent, field.Number, name)
 			return b.String(), nil
-		case ir.KindInt64:
-			fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.VARINT)).int64(%s.toString());\n", indent, field.Number, name)
+		case ir.KindInt64, ir.KindSint64:
+			fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.VARINT)).%s(%s.toString());\n", indent, field.Number, jsWriterMethod(field.Kind), name)
 			return b.String(), nil
 		}
 	case "Date":
@@ -1153,6 +1306,15 @@ func jsEncodeNativeField(field ir.Field, name, indent string) (string, error) {
 			fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.VARINT)).int64(Math.trunc(%s.getTime()));\n", indent, field.Number, name)
 			return b.String(), nil
 		}
+	case "string":
+		if isJSReadInt64(field) {
+			fmt.Fprintf(&b, "%swriter.uint32(tag(%d, %s)).%s(%s);\n", indent, field.Number, jsWireType(field.Kind), jsWriterMethod(field.Kind), name)
+			return b.String(), nil
+		}
+	case "Long":
+		// protobuf.js writers take a Long, number or decimal string as it is.
+		fmt.Fprintf(&b, "%swriter.uint32(tag(%d, %s)).%s(%s);\n", indent, field.Number, jsWireType(field.Kind), jsWriterMethod(field.Kind), name)
+		return b.String(), nil
 	case "LocalDate":
 		if field.Kind == ir.KindInt32 {
 			fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.VARINT)).int32(Math.trunc(%s.getTime() / 86400000));\n", indent, field.Number, name)
@@ -1164,41 +1326,31 @@ func jsEncodeNativeField(field ir.Field, name, indent string) (string, error) {
 
 func jsDecodeNativeField(field ir.Field, fieldName string) (string, bool, error) {
 	var b strings.Builder
+	if field.JSType == "Long" {
+		readExpr := fmt.Sprintf("reader.%s()", jsReaderMethod(field.Kind))
+		if field.IsRepeated {
+			return jsDecodeRepeatedScalar(fieldName, readExpr), false, nil
+		}
+		return "                " + fieldName + " = " + readExpr + ";\n", false, nil
+	}
+	if field.JSType == "string" {
+		// The reader yields a bigint, which prints exactly.
+		readExpr := fmt.Sprintf("reader.%s().toString()", jsReaderMethod(field.Kind))
+		if field.IsRepeated {
+			return jsDecodeRepeatedScalar(fieldName, readExpr), false, nil
+		}
+		return "                " + fieldName + " = " + readExpr + ";\n", false, nil
+	}
 	if field.IsRepeated {
-		if field.Kind == ir.KindInt64 {
-			if field.IsPacked {
-				b.WriteString("                const end2 = reader.uint32() + reader.pos;\n")
-				b.WriteString("                while (reader.pos < end2) {\n")
-				if field.JSType == "bigint" {
-					b.WriteString("                    ")
-					b.WriteString(fieldName)
-					b.WriteString(".push(readInt64BigInt(reader, \"int64\"));\n")
-				} else if field.JSType == "Date" {
-					b.WriteString("                    ")
-					b.WriteString(fieldName)
-					b.WriteString(".push(new Date(readInt64(reader, \"int64\")));\n")
-				} else {
-					b.WriteString("                    ")
-					b.WriteString(fieldName)
-					b.WriteString(".push(readInt64(reader, \"int64\"));\n")
-				}
-				b.WriteString("                }\n")
-				return b.String(), true, nil
-			}
+		if jsIsSignedInt64(field.Kind) {
+			method := jsReaderMethod(field.Kind)
+			readExpr := "readInt64(reader, \"" + method + "\")"
 			if field.JSType == "bigint" {
-				b.WriteString("                ")
-				b.WriteString(fieldName)
-				b.WriteString(".push(readInt64BigInt(reader, \"int64\"));\n")
+				readExpr = "readInt64BigInt(reader, \"" + method + "\")"
 			} else if field.JSType == "Date" {
-				b.WriteString("                ")
-				b.WriteString(fieldName)
-				b.WriteString(".push(new Date(readInt64(reader, \"int64\")));\n")
-			} else {
-				b.WriteString("                ")
-				b.WriteString(fieldName)
-				b.WriteString(".push(readInt64(reader, \"int64\"));\n")
+				readExpr = "new Date(readInt64(reader, \"int64\"))"
 			}
-			return b.String(), true, nil
+			return jsDecodeRepeatedScalar(fieldName, readExpr), true, nil
 		}
 		if field.IsTimestamp {
 			b.WriteString("                ")
@@ -1225,18 +1377,15 @@ func jsDecodeNativeField(field ir.Field, fieldName string) (string, bool, error)
 			return b.String(), true, nil
 		}
 		if field.Kind == ir.KindInt32 {
-			b.WriteString("                ")
-			b.WriteString(fieldName)
+			readExpr := "reader.int32()"
 			if field.JSType == "bigint" {
-				b.WriteString(".push(BigInt(reader.int32()));\n")
+				readExpr = "BigInt(reader.int32())"
 			} else if field.JSType == "Date" {
-				b.WriteString(".push(new Date(reader.int32() * 1000));\n")
+				readExpr = "new Date(reader.int32() * 1000)"
 			} else if field.JSType == "LocalDate" {
-				b.WriteString(".push(new Date(reader.int32() * 86400000));\n")
-			} else {
-				b.WriteString(".push(reader.int32());\n")
+				readExpr = "new Date(reader.int32() * 86400000)"
 			}
-			return b.String(), false, nil
+			return jsDecodeRepeatedScalar(fieldName, readExpr), false, nil
 		}
 	}
 
@@ -1255,14 +1404,15 @@ func jsDecodeNativeField(field ir.Field, fieldName string) (string, bool, error)
 		}
 		return "                " + fieldName + " = decodeDurationMessage(reader, reader.uint32());\n", true, nil
 	}
-	if field.Kind == ir.KindInt64 {
+	if jsIsSignedInt64(field.Kind) {
+		method := jsReaderMethod(field.Kind)
 		if field.JSType == "bigint" {
-			return "                " + fieldName + " = readInt64BigInt(reader, \"int64\");\n", true, nil
+			return "                " + fieldName + " = readInt64BigInt(reader, \"" + method + "\");\n", true, nil
 		}
 		if field.JSType == "Date" {
-			return "                " + fieldName + " = new Date(readInt64(reader, \"int64\"));\n", true, nil
+			return "                " + fieldName + " = new Date(readInt64(reader, \"" + method + "\"));\n", true, nil
 		}
-		return "                " + fieldName + " = readInt64(reader, \"int64\");\n", true, nil
+		return "                " + fieldName + " = readInt64(reader, \"" + method + "\");\n", true, nil
 	}
 	if field.Kind == ir.KindInt32 {
 		if field.JSType == "bigint" {
@@ -1301,6 +1451,12 @@ func jsWireType(kind ir.Kind) string {
 	}
 }
 
+// jsIsSignedInt64 reports whether kind is a varint-encoded signed 64-bit
+// integer, the kinds cp.js_type "number" and "bigint" apply to.
+func jsIsSignedInt64(kind ir.Kind) bool {
+	return kind == ir.KindInt64 || kind == ir.KindSint64
+}
+
 func jsWriterMethod(kind ir.Kind) string {
 	switch kind {
 	case ir.KindBool:
@@ -1388,6 +1544,8 @@ func jsIsPackable(kind ir.Kind) bool {
 	}
 }
 
+// jsMapKeyCast converts an Object.entries key back to the wire key type. Decode
+// stores keys with String(key), so bool keys arrive as "true"/"false".
 func jsMapKeyCast(kind ir.Kind) string {
 	switch kind {
 	case ir.KindString:
@@ -1399,7 +1557,20 @@ func jsMapKeyCast(kind ir.Kind) string {
 	}
 }
 
-func jsMapValueType(field ir.Field, msgIndex map[string]ir.Message) (string, error) {
+// jsMapKeyDocType is the JSDoc key type of a map field decoded into a Map,
+// which keeps keys in their decoded form rather than stringifying them.
+func jsMapKeyDocType(kind ir.Kind) string {
+	switch kind {
+	case ir.KindString:
+		return "string"
+	case ir.KindBool:
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+func jsMapValueType(field ir.Field, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum) (string, error) {
 	switch field.MapValueKind {
 	case ir.KindMessage:
 		msg, ok := msgIndex[field.MapValueMessage]
@@ -1407,6 +1578,12 @@ func jsMapValueType(field ir.Field, msgIndex map[string]ir.Message) (string, err
 			return "", fmt.Errorf("unknown map value message: %s", field.MapValueMessage)
 		}
 		return msg.Name, nil
+	case ir.KindEnum:
+		enum, ok := enumIndex[field.MapValueEnum]
+		if !ok {
+			return "", fmt.Errorf("unknown map value enum: %s", field.MapValueEnum)
+		}
+		return enum.Name, nil
 	case ir.KindBytes:
 		return "Uint8Array", nil
 	case ir.KindBool:
@@ -1425,13 +1602,15 @@ func jsEncodeMapValue(field ir.Field, msgIndex map[string]ir.Message) (string, e
 		if !ok {
 			return "", fmt.Errorf("unknown map value message: %s", field.MapValueMessage)
 		}
-		b.WriteString("            if (value) {\n")
-		b.WriteString("                writer.uint32(tag(2, WIRE.LDELIM)).fork();\n")
+		// A null value is written as an empty message, as Go writes a nil
+		// one, rather than leaving the entry with only a key.
+		b.WriteString("            writer.uint32(tag(2, WIRE.LDELIM)).fork();\n")
+		b.WriteString("            if (value != null) {\n")
 		b.WriteString("                write")
 		b.WriteString(msg.Name)
 		b.WriteString("(value, writer);\n")
-		b.WriteString("                writer.ldelim();\n")
 		b.WriteString("            }\n")
+		b.WriteString("            writer.ldelim();\n")
 		return b.String(), nil
 	}
 	if field.MapValueKind == ir.KindBytes {
@@ -1473,7 +1652,7 @@ func jsMapValuePresence(kind ir.Kind) string {
 	}
 }
 
-func jsDecodeMapField(fieldName string, field ir.Field, msgIndex map[string]ir.Message) (string, bool, error) {
+func jsDecodeMapField(fieldName string, field ir.Field, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, asMap bool) (string, bool, error) {
 	var b strings.Builder
 	needsReadInt64 := false
 	b.WriteString("                const end2 = reader.uint32() + reader.pos;\n")
@@ -1481,7 +1660,11 @@ func jsDecodeMapField(fieldName string, field ir.Field, msgIndex map[string]ir.M
 	b.WriteString(jsMapKeyDefault(field.MapKeyKind))
 	b.WriteString(";\n")
 	b.WriteString("                let value = ")
-	b.WriteString(jsMapValueDefault(field, msgIndex))
+	valueDefault, err := jsMapValueDefault(field, enumIndex)
+	if err != nil {
+		return "", false, err
+	}
+	b.WriteString(valueDefault)
 	b.WriteString(";\n")
 	b.WriteString("                while (reader.pos < end2) {\n")
 	b.WriteString("                    const tag2 = reader.uint32();\n")
@@ -1507,6 +1690,19 @@ func jsDecodeMapField(fieldName string, field ir.Field, msgIndex map[string]ir.M
 	b.WriteString("                            reader.skipType(tag2 & 7);\n")
 	b.WriteString("                    }\n")
 	b.WriteString("                }\n")
+	if asMap {
+		// Map keeps wire order and native key types; a plain object would
+		// stringify keys and hoist integer-like ones into ascending order.
+		b.WriteString("                if (!")
+		b.WriteString(fieldName)
+		b.WriteString(") { ")
+		b.WriteString(fieldName)
+		b.WriteString(" = new Map(); }\n")
+		b.WriteString("                ")
+		b.WriteString(fieldName)
+		b.WriteString(".set(key, value);\n")
+		return b.String(), needsReadInt64, nil
+	}
 	b.WriteString("                if (!")
 	b.WriteString(fieldName)
 	b.WriteString(") { ")
@@ -1550,41 +1746,87 @@ func jsMapKeyDefault(kind ir.Kind) string {
 	}
 }
 
-func jsMapValueDefault(field ir.Field, msgIndex map[string]ir.Message) string {
+func jsMapValueDefault(field ir.Field, enumIndex map[string]ir.Enum) (string, error) {
 	switch field.MapValueKind {
 	case ir.KindBool:
-		return "false"
+		return "false", nil
 	case ir.KindString:
-		return "\"\""
+		return "\"\"", nil
 	case ir.KindBytes:
-		return "new Uint8Array(0)"
+		return "new Uint8Array(0)", nil
 	case ir.KindMessage:
-		return "undefined"
+		return "undefined", nil
+	case ir.KindEnum:
+		enum, ok := enumIndex[field.MapValueEnum]
+		if !ok {
+			return "", fmt.Errorf("unknown map value enum: %s", field.MapValueEnum)
+		}
+		for _, value := range enum.Values {
+			if value.Number == 0 {
+				return enum.Name + "." + value.Name, nil
+			}
+		}
+		return "0", nil
 	default:
-		return "0"
+		return "0", nil
 	}
 }
 
-func jsDecodePackedField(fieldName string, field ir.Field) (string, bool) {
+// jsDecodeRepeatedScalar pushes readExpr for a repeated scalar field,
+// reading a packed run when the value arrives length-delimited and a single
+// element otherwise.
+func jsDecodeRepeatedScalar(fieldName, readExpr string) string {
 	var b strings.Builder
-	needsReadInt64 := isJSReadInt64(field)
-	b.WriteString("                const end2 = reader.uint32() + reader.pos;\n")
-	b.WriteString("                while (reader.pos < end2) {\n")
-	if needsReadInt64 {
-		b.WriteString("                    ")
-		b.WriteString(fieldName)
-		b.WriteString(".push(readInt64(reader, \"")
-		b.WriteString(jsReaderMethod(field.Kind))
-		b.WriteString("\"));\n")
+	b.WriteString("                if ((tag & 7) === WIRE.LDELIM) {\n")
+	b.WriteString("                    const end2 = reader.uint32() + reader.pos;\n")
+	b.WriteString("                    while (reader.pos < end2) {\n")
+	fmt.Fprintf(&b, "                        %s.push(%s);\n", fieldName, readExpr)
+	b.WriteString("                    }\n")
+	b.WriteString("                } else {\n")
+	fmt.Fprintf(&b, "                    %s.push(%s);\n", fieldName, readExpr)
+	b.WriteString("                }\n")
+	return b.String()
+}
+
+// jsDecodeBytesValue reads a google.protobuf.BytesValue field inline. An empty
+// wrapper still sets the field, to an empty Uint8Array.
+// jsDecodeWrapper decodes a google.protobuf wrapper message into the value it
+// holds. A singular wrapper without a value decodes as the zero value, a
+// repeated element without one as null.
+func jsDecodeWrapper(fieldName string, field ir.Field) string {
+	var b strings.Builder
+	target := fieldName
+	b.WriteString("                const wrapperEnd = reader.uint32() + reader.pos;\n")
+	if field.IsRepeated {
+		target = "wrapped"
+		b.WriteString("                let wrapped = null;\n")
 	} else {
-		b.WriteString("                    ")
-		b.WriteString(fieldName)
-		b.WriteString(".push(reader.")
-		b.WriteString(jsReaderMethod(field.Kind))
-		b.WriteString("());\n")
+		fmt.Fprintf(&b, "                %s = %s;\n", fieldName, jsDefaultValue(jsWrappedValue(field), nil, false))
 	}
+	read := "reader." + jsReaderMethod(field.Kind) + "()"
+	if isJSReadInt64(field) {
+		read = fmt.Sprintf("readInt64(reader, \"%s\")", jsReaderMethod(field.Kind))
+	}
+	b.WriteString("                while (reader.pos < wrapperEnd) {\n")
+	b.WriteString("                    const wrapperTag = reader.uint32();\n")
+	b.WriteString("                    if (wrapperTag >>> 3 === 1) {\n")
+	fmt.Fprintf(&b, "                        %s = %s;\n", target, read)
+	b.WriteString("                    } else {\n")
+	b.WriteString("                        reader.skipType(wrapperTag & 7);\n")
+	b.WriteString("                    }\n")
 	b.WriteString("                }\n")
-	return b.String(), needsReadInt64
+	if field.IsRepeated {
+		fmt.Fprintf(&b, "                %s.push(wrapped);\n", fieldName)
+	}
+	return b.String()
+}
+
+// jsWrappedValue returns the plain scalar field a wrapper field holds.
+func jsWrappedValue(field ir.Field) ir.Field {
+	field.IsWrapper = false
+	field.IsOptional = false
+	field.IsRepeated = false
+	return field
 }
 
 func jsDecodeTimestampSingle(fieldName string, field ir.Field) (string, bool) {
@@ -1630,6 +1872,16 @@ func jsVisibleFields(fields []ir.Field) []ir.Field {
 	return visible
 }
 
+func indexEnums(files []ir.File) map[string]ir.Enum {
+	index := make(map[string]ir.Enum)
+	for _, file := range files {
+		for _, enum := range file.Enums {
+			index[enum.FullName] = enum
+		}
+	}
+	return index
+}
+
 func indexMessages(files []ir.File) map[string]ir.Message {
 	index := make(map[string]ir.Message)
 	for _, file := range files {
@@ -0,0 +1,690 @@
+package jsg
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jptrs93/cleanproto/internal/generate"
+	gogen "github.com/jptrs93/cleanproto/internal/generate/go"
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+func runtimeTestFiles() []ir.File {
+	return []ir.File{{
+		Messages: []ir.Message{{
+			Name:     "Item",
+			FullName: "example.Item",
+			Fields: []ir.Field{
+				{Name: "name", Number: 1, Kind: ir.KindString, JsEncode: true},
+				{Name: "count", Number: 2, Kind: ir.KindInt32, JsEncode: true},
+			},
+		}},
+	}}
+}
+
+func generateJSOutputs(t *testing.T, runtime string) map[string]string {
+	t.Helper()
+	outputs, err := Generator{}.Generate(runtimeTestFiles(), generate.Options{JsOut: "out", JsRuntime: runtime})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	byName := make(map[string]string, len(outputs))
+	for _, output := range outputs {
+		byName[output.Path] = string(output.Content)
+	}
+	return byName
+}
+
+func TestGenerateJSRuntimeImport(t *testing.T) {
+	for _, runtime := range []string{"", "import"} {
+		outputs := generateJSOutputs(t, runtime)
+		model, ok := outputs["out/model.js"]
+		if !ok {
+			t.Fatalf("runtime %q: model.js not generated", runtime)
+		}
+		if !strings.Contains(model, "import { Reader, Writer } from './runtime.js';") {
+			t.Fatalf("runtime %q: expected runtime import in model.js, got:\n%s", runtime, model)
+		}
+		if strings.Contains(model, "class Writer") {
+			t.Fatalf("runtime %q: expected Writer not to be inlined in model.js", runtime)
+		}
+		if _, ok := outputs["out/runtime.js"]; !ok {
+			t.Fatalf("runtime %q: expected runtime.js to be generated", runtime)
+		}
+	}
+}
+
+func TestGenerateJSRuntimeInline(t *testing.T) {
+	outputs := generateJSOutputs(t, "inline")
+	model, ok := outputs["out/model.js"]
+	if !ok {
+		t.Fatalf("model.js not generated")
+	}
+	if strings.Contains(model, "from './runtime.js'") {
+		t.Fatalf("expected no runtime import in inline mode, got:\n%s", model)
+	}
+	for _, want := range []string{"\nclass Writer {", "\nclass Reader {", "function writeItem("} {
+		if !strings.Contains(model, want) {
+			t.Fatalf("expected %q in inline model.js, got:\n%s", want, model)
+		}
+	}
+	if strings.Contains(model, "export class Writer") {
+		t.Fatalf("expected inlined Writer to stay module-private")
+	}
+	if strings.Count(model, "Code generated by cleanproto") != 1 {
+		t.Fatalf("expected a single generated-file header in inline model.js")
+	}
+	if _, ok := outputs["out/runtime.js"]; ok {
+		t.Fatalf("expected runtime.js not to be generated in inline mode")
+	}
+}
+
+func TestGenerateJSRuntimeRejectsUnknownMode(t *testing.T) {
+	_, err := Generator{}.Generate(runtimeTestFiles(), generate.Options{JsOut: "out", JsRuntime: "bundle"})
+	if err == nil {
+		t.Fatalf("expected error for unknown runtime mode")
+	}
+}
+
+func TestGenerateJSRuntimeProtobufJS(t *testing.T) {
+	files := []ir.File{{
+		Messages: []ir.Message{{
+			Name:     "Ledger",
+			FullName: "example.Ledger",
+			Fields: []ir.Field{
+				{Name: "id", Number: 1, Kind: ir.KindInt64, JsEncode: true},
+				{Name: "balances", Number: 2, Kind: ir.KindUint64, IsRepeated: true, IsPacked: true, JsEncode: true},
+				{Name: "totals", Number: 3, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindSint64, JsEncode: true},
+				{Name: "count", Number: 4, Kind: ir.KindInt32, JsEncode: true},
+			},
+		}},
+	}}
+	outputs, err := Generator{}.Generate(files, generate.Options{JsOut: "out", JsRuntime: "protobufjs"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(outputs) != 1 || outputs[0].Path != "out/model.js" {
+		t.Fatalf("expected only model.js, without runtime.js")
+	}
+	model := string(outputs[0].Content)
+	for _, want := range []string{
+		"import $protobuf from 'protobufjs/minimal.js';",
+		"const { Reader, Writer } = $protobuf;",
+		"const Long = $protobuf.util.Long;",
+		"@property {Long} id",
+		"@property {Long[]} balances",
+		"@property {Object.<string, number>} totals",
+		"id: Long.ZERO",
+		"!Long.fromValue(message.id).isZero()",
+		"writer.uint32(tag(1, WIRE.VARINT)).int64(message.id);",
+		"packedWriter.uint64(item);",
+		"message.id = reader.int64();",
+		"message.balances.push(reader.uint64());",
+		"message.count = reader.int32();",
+	} {
+		if !strings.Contains(model, want) {
+			t.Fatalf("expected %q in model.js, got:\n%s", want, model)
+		}
+	}
+	if strings.Contains(model, "runtime.js") {
+		t.Fatalf("expected no runtime.js import, got:\n%s", model)
+	}
+}
+
+func mapOrderTestFiles() []ir.File {
+	return []ir.File{{
+		Messages: []ir.Message{{
+			Name:     "Ranking",
+			FullName: "example.Ranking",
+			Fields: []ir.Field{
+				{Name: "scores", Number: 1, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindInt32, MapValueKind: ir.KindString, JsEncode: true},
+			},
+		}},
+	}}
+}
+
+func TestGenerateJSMapModeRejectsUnknownMode(t *testing.T) {
+	_, err := Generator{}.Generate(mapOrderTestFiles(), generate.Options{JsOut: "out", JsMap: "record"})
+	if err == nil {
+		t.Fatalf("expected error for unknown map mode")
+	}
+}
+
+func TestGenerateJSMapModePreservesIntegerKeyOrder(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	dir := t.TempDir()
+	outputs, err := Generator{}.Generate(mapOrderTestFiles(), generate.Options{JsOut: dir, JsMap: "map"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	outputs = append(outputs,
+		generate.OutputFile{Path: filepath.Join(dir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(dir, "order.js"), Content: []byte(`import { decodeRanking, encodeRanking } from './model.js';
+
+const scores = new Map([[30, "c"], [2, "b"], [10, "a"], [-1, "z"]]);
+const decoded = decodeRanking(encodeRanking({ scores }).slice().buffer);
+if (!(decoded.scores instanceof Map)) {
+    throw new Error("expected a Map, got " + Object.prototype.toString.call(decoded.scores));
+}
+const got = JSON.stringify([...decoded.scores.entries()]);
+if (got !== JSON.stringify([...scores.entries()])) {
+    throw new Error("map order not preserved: " + got);
+}
+const empty = decodeRanking(encodeRanking({ scores: new Map() }).slice().buffer);
+if (!(empty.scores instanceof Map) || empty.scores.size !== 0) {
+    throw new Error("expected an empty Map for an absent field");
+}
+`)},
+	)
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	if out, err := exec.Command("node", filepath.Join(dir, "order.js")).CombinedOutput(); err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+}
+
+func enumMapTestFiles() []ir.File {
+	return []ir.File{{
+		Enums: []ir.Enum{{
+			Name:     "Color",
+			FullName: "example.Color",
+			Values: []ir.EnumValue{
+				{Name: "COLOR_UNSPECIFIED", Number: 0},
+				{Name: "COLOR_RED", Number: 1},
+				{Name: "COLOR_BLUE", Number: -2},
+			},
+		}},
+		Messages: []ir.Message{{
+			Name:     "Palette",
+			FullName: "example.Palette",
+			Fields: []ir.Field{
+				{Name: "colors", Number: 1, Kind: ir.KindMessage, IsMap: true, MapKeyKind: ir.KindString, MapValueKind: ir.KindEnum, MapValueEnum: "example.Color", JsEncode: true},
+			},
+		}},
+	}}
+}
+
+func TestGenerateJSEnumMapValueUsesEnumType(t *testing.T) {
+	outputs, err := Generator{}.Generate(enumMapTestFiles(), generate.Options{JsOut: "out"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	var model string
+	for _, output := range outputs {
+		if output.Path == "out/model.js" {
+			model = string(output.Content)
+		}
+	}
+	for _, want := range []string{
+		"export const Color = Object.freeze({\n    COLOR_UNSPECIFIED: 0,\n    COLOR_RED: 1,\n    COLOR_BLUE: -2,\n});",
+		" * @property {Object.<string, Color>} colors\n",
+		"let value = Color.COLOR_UNSPECIFIED;",
+	} {
+		if !strings.Contains(model, want) {
+			t.Errorf("expected model.js to contain %q, got:\n%s", want, model)
+		}
+	}
+}
+
+func TestGenerateJSEnumMapValueRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	dir := t.TempDir()
+	outputs, err := Generator{}.Generate(enumMapTestFiles(), generate.Options{JsOut: dir})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	outputs = append(outputs,
+		generate.OutputFile{Path: filepath.Join(dir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(dir, "colors.js"), Content: []byte(`import { Color, decodePalette, encodePalette } from './model.js';
+
+const colors = { sky: Color.COLOR_BLUE, rose: Color.COLOR_RED };
+const decoded = decodePalette(encodePalette({ colors }).slice().buffer);
+if (JSON.stringify(decoded.colors) !== JSON.stringify(colors)) {
+    throw new Error("unexpected colors: " + JSON.stringify(decoded.colors));
+}
+// A map entry without a value field decodes to the enum's zero value.
+const keyOnly = Uint8Array.from([0x0a, 0x03, 0x0a, 0x01, 0x78]);
+const sparse = decodePalette(keyOnly.buffer);
+if (sparse.colors.x !== Color.COLOR_UNSPECIFIED) {
+    throw new Error("expected COLOR_UNSPECIFIED for a missing value, got " + sparse.colors.x);
+}
+if (!Object.isFrozen(Color)) {
+    throw new Error("expected Color to be frozen");
+}
+`)},
+	)
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	if out, err := exec.Command("node", filepath.Join(dir, "colors.js")).CombinedOutput(); err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+}
+
+func repeatedScalarTestFiles() []ir.File {
+	return []ir.File{{
+		Messages: []ir.Message{{
+			Name:     "Series",
+			FullName: "example.Series",
+			Fields: []ir.Field{
+				{Name: "values", Number: 1, Kind: ir.KindInt32, IsRepeated: true, JsEncode: true},
+				{Name: "weights", Number: 2, Kind: ir.KindDouble, IsRepeated: true, IsPacked: true, JsEncode: true},
+			},
+		}},
+	}}
+}
+
+func TestGenerateJSRepeatedScalarAcceptsBothWireForms(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	dir := t.TempDir()
+	outputs, err := Generator{}.Generate(repeatedScalarTestFiles(), generate.Options{JsOut: dir})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	outputs = append(outputs,
+		generate.OutputFile{Path: filepath.Join(dir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(dir, "wire.js"), Content: []byte(`import { decodeSeries } from './model.js';
+
+// values is declared unpacked: an unpacked element followed by a packed run.
+const values = decodeSeries(Uint8Array.from([0x08, 0x04, 0x0a, 0x03, 0x01, 0x02, 0x03]).buffer);
+if (JSON.stringify(values.values) !== "[4,1,2,3]") {
+    throw new Error("unexpected values: " + JSON.stringify(values.values));
+}
+// weights is declared packed: a single unpacked double (1.5).
+const weights = decodeSeries(Uint8Array.from([0x11, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f]).buffer);
+if (JSON.stringify(weights.weights) !== "[1.5]") {
+    throw new Error("unexpected weights: " + JSON.stringify(weights.weights));
+}
+`)},
+	)
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	if out, err := exec.Command("node", filepath.Join(dir, "wire.js")).CombinedOutput(); err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateJSDecodesBufferSlicedFromPool(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	files := []ir.File{{
+		Messages: []ir.Message{{
+			Name:     "Blob",
+			FullName: "example.Blob",
+			Fields: []ir.Field{
+				{Name: "name", Number: 1, Kind: ir.KindString, JsEncode: true},
+				{Name: "data", Number: 2, Kind: ir.KindBytes, JsEncode: true},
+				{Name: "crc", Number: 3, Kind: ir.KindFixed32, JsEncode: true},
+			},
+		}},
+	}}
+	dir := t.TempDir()
+	outputs, err := Generator{}.Generate(files, generate.Options{JsOut: dir})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	outputs = append(outputs,
+		generate.OutputFile{Path: filepath.Join(dir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(dir, "pool.js"), Content: []byte(`import { Buffer } from 'node:buffer';
+import { decodeBlob, encodeBlob } from './model.js';
+
+const encoded = encodeBlob({ name: "blob", data: Uint8Array.from([1, 2, 3]), crc: 0xdeadbeef });
+// A Buffer sliced from a larger pool, as Node hands out for small reads.
+const pool = Buffer.alloc(64, 0xee);
+pool.set(encoded, 7);
+const inputs = {
+    buffer: pool.subarray(7, 7 + encoded.length),
+    uint8array: new Uint8Array(pool.buffer, pool.byteOffset + 7, encoded.length),
+    dataview: new DataView(pool.buffer, pool.byteOffset + 7, encoded.length),
+    arraybuffer: encoded.slice().buffer,
+};
+for (const [kind, input] of Object.entries(inputs)) {
+    const blob = decodeBlob(input);
+    if (blob.name !== "blob" || blob.crc !== 0xdeadbeef || JSON.stringify(Array.from(blob.data)) !== "[1,2,3]") {
+        throw new Error(kind + ": unexpected " + JSON.stringify({ name: blob.name, crc: blob.crc, data: Array.from(blob.data) }));
+    }
+    if (Buffer.isBuffer(blob.data)) {
+        throw new Error(kind + ": bytes decoded as a Buffer");
+    }
+    pool.fill(0, 7, 7 + encoded.length);
+    if (blob.data[0] !== 1) {
+        throw new Error(kind + ": bytes share memory with the input");
+    }
+    pool.set(encoded, 7);
+}
+`)},
+	)
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	if out, err := exec.Command("node", filepath.Join(dir, "pool.js")).CombinedOutput(); err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+}
+
+func validateTestFiles() []ir.File {
+	return []ir.File{{
+		Messages: []ir.Message{
+			{
+				Name:     "Address",
+				FullName: "example.Address",
+				Fields: []ir.Field{
+					{Name: "city", Number: 1, Kind: ir.KindString, JsEncode: true, Constraints: ir.FieldConstraints{Required: true}},
+				},
+			},
+			{
+				Name:     "Person",
+				FullName: "example.Person",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, JsEncode: true, Constraints: ir.FieldConstraints{Required: true}},
+					{Name: "age", Number: 2, Kind: ir.KindInt32, JsEncode: true},
+					{Name: "address", Number: 3, Kind: ir.KindMessage, MessageFullName: "example.Address", JsEncode: true},
+					{Name: "tags", Number: 4, Kind: ir.KindString, IsRepeated: true, JsEncode: true},
+				},
+			},
+		},
+	}}
+}
+
+func TestGenerateJSValidate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	dir := t.TempDir()
+	outputs, err := Generator{}.Generate(validateTestFiles(), generate.Options{JsOut: dir, JsValidate: true})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	outputs = append(outputs,
+		generate.OutputFile{Path: filepath.Join(dir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(dir, "validate.js"), Content: []byte(`import { validatePerson } from './model.js';
+
+validatePerson({ name: "ada", age: 36, address: { city: "London" }, tags: ["x"] });
+validatePerson({ name: "ada" });
+
+const expectThrow = (message, want) => {
+    try {
+        validatePerson(message);
+    } catch (err) {
+        if (err.message !== want) {
+            throw new Error("expected " + JSON.stringify(want) + ", got " + JSON.stringify(err.message));
+        }
+        return;
+    }
+    throw new Error("expected " + JSON.stringify(want) + " to be thrown");
+};
+expectThrow({}, "name: is required");
+expectThrow({ name: "" }, "name: is required");
+expectThrow({ name: "ada", age: "36" }, "age: must be an integer");
+expectThrow({ name: "ada", address: {} }, "address.city: is required");
+expectThrow({ name: "ada", tags: ["x", 1] }, "tags[1]: must be a string");
+`)},
+	)
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	if out, err := exec.Command("node", filepath.Join(dir, "validate.js")).CombinedOutput(); err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateJSJSONNames(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	files := []ir.File{{
+		Messages: []ir.Message{{
+			Name:     "Account",
+			FullName: "example.Account",
+			Fields: []ir.Field{
+				{Name: "userId", ProtoName: "user_id", JSONName: "uid", Number: 1, Kind: ir.KindInt32, JsEncode: true},
+				{Name: "displayName", ProtoName: "display_name", JSONName: "displayName", Number: 2, Kind: ir.KindString, JsEncode: true},
+			},
+		}},
+	}}
+	dir := t.TempDir()
+	outputs, err := Generator{}.Generate(files, generate.Options{JsOut: dir, JsJSONNames: true})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	outputs = append(outputs,
+		generate.OutputFile{Path: filepath.Join(dir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(dir, "names.js"), Content: []byte(`import { decodeAccount, encodeAccount } from './model.js';
+
+const decoded = decodeAccount(encodeAccount({ uid: 7, displayName: "ada" }).slice().buffer);
+if (JSON.stringify(decoded) !== '{"uid":7,"displayName":"ada"}') {
+    throw new Error("unexpected account: " + JSON.stringify(decoded));
+}
+`)},
+	)
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	if out, err := exec.Command("node", filepath.Join(dir, "names.js")).CombinedOutput(); err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateJSMessageName(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{
+			{
+				Name:     "Item",
+				FullName: "example.Item",
+				JSName:   "LegacyItem",
+				Fields: []ir.Field{
+					{Name: "name", Number: 1, Kind: ir.KindString, GoEncode: true, JsEncode: true},
+				},
+			},
+			{
+				Name:     "Order",
+				FullName: "example.Order",
+				Fields: []ir.Field{
+					{Name: "item", Number: 1, Kind: ir.KindMessage, MessageFullName: "example.Item", GoEncode: true, JsEncode: true},
+					{Name: "items", Number: 2, Kind: ir.KindMessage, MessageFullName: "example.Item", IsRepeated: true, GoEncode: true, JsEncode: true},
+				},
+			},
+		},
+	}}
+	dir := t.TempDir()
+	outputs, err := Generator{}.Generate(files, generate.Options{JsOut: dir})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	var model string
+	for _, output := range outputs {
+		if filepath.Base(output.Path) == "model.js" {
+			model = string(output.Content)
+		}
+	}
+	for _, want := range []string{"@typedef {Object} LegacyItem", "export function writeLegacyItem(", "export function encodeLegacyItem(", "export function decodeLegacyItem(", "@property {LegacyItem} item"} {
+		if !strings.Contains(model, want) {
+			t.Errorf("model.js is missing %q", want)
+		}
+	}
+	if strings.Contains(model, "encodeItem(") || strings.Contains(model, "{Item}") {
+		t.Errorf("model.js still uses the default name Item:\n%s", model)
+	}
+	outputs = append(outputs,
+		generate.OutputFile{Path: filepath.Join(dir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(dir, "names.js"), Content: []byte(`import { decodeLegacyItem, encodeLegacyItem, decodeOrder, encodeOrder } from './model.js';
+
+const item = decodeLegacyItem(encodeLegacyItem({ name: "pen" }).slice().buffer);
+const order = decodeOrder(encodeOrder({ item, items: [item, { name: "ink" }] }).slice().buffer);
+if (JSON.stringify(order) !== '{"item":{"name":"pen"},"items":[{"name":"pen"},{"name":"ink"}]}') {
+    throw new Error("unexpected order: " + JSON.stringify(order));
+}
+`)},
+	)
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	if out, err := exec.Command("node", filepath.Join(dir, "names.js")).CombinedOutput(); err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+
+	goOutputs, err := gogen.Generator{}.Generate(files, generate.Options{GoOut: t.TempDir()})
+	if err != nil {
+		t.Fatalf("generate go: %v", err)
+	}
+	for _, output := range goOutputs {
+		if filepath.Base(output.Path) != "model.gen.go" {
+			continue
+		}
+		if content := string(output.Content); !strings.Contains(content, "type Item struct") || strings.Contains(content, "LegacyItem") {
+			t.Errorf("model.gen.go should keep the default name Item:\n%s", content)
+		}
+	}
+}
+
+func TestGenerateJSMessageNameRejectsCollision(t *testing.T) {
+	files := []ir.File{{
+		Messages: []ir.Message{
+			{Name: "Item", FullName: "example.Item", JSName: "Order"},
+			{Name: "Order", FullName: "example.Order"},
+		},
+	}}
+	_, err := Generator{}.Generate(files, generate.Options{JsOut: "out"})
+	if err == nil || !strings.Contains(err.Error(), `cp.js_name "Order" of message example.Item is already the JS name of example.Order`) {
+		t.Fatalf("expected a JS name collision error, got %v", err)
+	}
+	files[0].Messages[0].JSName = "not-a-name"
+	if _, err := (Generator{}).Generate(files, generate.Options{JsOut: "out"}); err == nil || !strings.Contains(err.Error(), "is not a valid JS identifier") {
+		t.Fatalf("expected an invalid identifier error, got %v", err)
+	}
+}
+
+func TestGenerateJSStringInt64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	files := []ir.File{{
+		Messages: []ir.Message{{
+			Name:     "Ids",
+			FullName: "example.Ids",
+			Fields: []ir.Field{
+				{Name: "id", Number: 1, Kind: ir.KindInt64, JSType: "string", JsEncode: true},
+				{Name: "hashes", Number: 2, Kind: ir.KindFixed64, JSType: "string", IsRepeated: true, IsPacked: true, JsEncode: true},
+				{Name: "delta", Number: 3, Kind: ir.KindSint64, JSType: "string", JsEncode: true},
+			},
+		}},
+	}}
+	dir := t.TempDir()
+	outputs, err := Generator{}.Generate(files, generate.Options{JsOut: dir})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if model := string(outputs[0].Content); !strings.Contains(model, "@property {string} id") {
+		t.Fatalf("expected id to be typed as a string:\n%s", model)
+	}
+	outputs = append(outputs,
+		generate.OutputFile{Path: filepath.Join(dir, "package.json"), Content: []byte(`{"type": "module"}`)},
+		generate.OutputFile{Path: filepath.Join(dir, "ids.js"), Content: []byte(`import { decodeIds, encodeIds } from './model.js';
+
+const ids = { id: "9007199254740993", hashes: ["18446744073709551615", "0"], delta: "-42" };
+const decoded = decodeIds(encodeIds(ids).slice().buffer);
+if (JSON.stringify(decoded) !== JSON.stringify(ids)) {
+    throw new Error("unexpected ids: " + JSON.stringify(decoded));
+}
+const empty = decodeIds(encodeIds({ id: "0", hashes: [], delta: "0" }).slice().buffer);
+if (empty.id !== "0" || empty.delta !== "0") {
+    throw new Error("expected zero ids to decode as \"0\": " + JSON.stringify(empty));
+}
+`)},
+	)
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	if out, err := exec.Command("node", filepath.Join(dir, "ids.js")).CombinedOutput(); err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateJSIndent(t *testing.T) {
+	files := runtimeTestFiles()
+	files[0].Services = []ir.Service{{
+		Name:    "Items",
+		Methods: []ir.Method{{Name: "GetItem", InputFullName: "example.Item", OutputFullName: "example.Item"}},
+	}}
+	generateFiles := func(runtime, indent string) map[string]string {
+		t.Helper()
+		outputs, err := Generator{}.Generate(files, generate.Options{JsOut: "out", JsRuntime: runtime, JsIndent: indent})
+		if err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+		byName := make(map[string]string, len(outputs))
+		for _, output := range outputs {
+			byName[output.Path] = string(output.Content)
+		}
+		return byName
+	}
+
+	if got, want := generateFiles("", "4")["out/model.js"], generateFiles("", "")["out/model.js"]; got != want {
+		t.Fatalf("expected -js.indent 4 to leave model.js unchanged, got:\n%s", got)
+	}
+	for _, runtime := range []string{"import", "inline"} {
+		for path, content := range generateFiles(runtime, "2") {
+			for i, line := range strings.Split(content, "\n") {
+				body := strings.TrimLeft(line, " ")
+				n := len(line) - len(body)
+				// JSDoc lines keep the space lining up their "*".
+				if strings.HasPrefix(body, "*") {
+					n--
+				}
+				if n%2 != 0 {
+					t.Fatalf("runtime %q: %s line %d is not indented by two spaces a level: %q", runtime, path, i+1, line)
+				}
+			}
+		}
+	}
+	tabbed := generateFiles("inline", "tab")
+	if model := tabbed["out/model.js"]; !strings.Contains(model, "\n\tconstructor() {\n") || !strings.Contains(model, "\n\t\twriter.uint32(tag(1, WIRE.LDELIM)).string(message.name);\n") {
+		t.Fatalf("expected the inline runtime and models to be indented with tabs, got:\n%s", model)
+	}
+	if capi := tabbed["out/capi.js"]; !strings.Contains(capi, "\n\t/**\n\t * @param {string} [baseURL='']\n") {
+		t.Fatalf("expected capi.js to be indented with tabs, got:\n%s", capi)
+	}
+
+	if _, err := (Generator{}).Generate(files, generate.Options{JsOut: "out", JsIndent: "9"}); err == nil {
+		t.Fatal("expected an error for an unsupported indent")
+	}
+}
@@ -0,0 +1,48 @@
+package jsg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Indentation the generated sources are written with: model.js indents by
+// four spaces, and capi.js and runtime.js by two.
+const (
+	jsModelIndent   = 4
+	jsRuntimeIndent = 2
+	jsCapiIndent    = 2
+)
+
+// jsIndentUnit returns the indentation for -js.indent: "tab" or a number of
+// spaces from 1 to 8. Empty keeps the generated indentation.
+func jsIndentUnit(mode string) (string, error) {
+	if mode == "" {
+		return "", nil
+	}
+	if mode == "tab" {
+		return "\t", nil
+	}
+	n, err := strconv.Atoi(mode)
+	if err != nil || n < 1 || n > 8 {
+		return "", fmt.Errorf("unsupported js indent: %q", mode)
+	}
+	return strings.Repeat(" ", n), nil
+}
+
+// jsReindent rewrites the leading spaces of each line of src, indented by
+// width spaces per level, to use unit per level instead. Spaces left over
+// past the last full level are kept, so JSDoc lines still line up their
+// " * ". Generated JS has no multi-line string literals for this to alter.
+func jsReindent(src string, width int, unit string) string {
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		body := strings.TrimLeft(line, " ")
+		if body == "" {
+			continue
+		}
+		n := len(line) - len(body)
+		lines[i] = strings.Repeat(unit, n/width) + strings.Repeat(" ", n%width) + body
+	}
+	return strings.Join(lines, "\n")
+}
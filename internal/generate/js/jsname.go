@@ -0,0 +1,44 @@
+package jsg
+
+import (
+	"fmt"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// jsUseMessageNames returns a copy of files whose messages are named by their
+// cp.js_name option where set, so the typedef and the write, encode and
+// decode functions of a message, and every reference to them, use that name.
+// The Go and TS generators keep ir.Message.Name.
+func jsUseMessageNames(files []ir.File) ([]ir.File, error) {
+	owners := make(map[string]string)
+	for _, file := range files {
+		for _, enum := range file.Enums {
+			owners[enum.Name] = enum.FullName
+		}
+		for _, msg := range file.Messages {
+			if msg.JSName == "" {
+				owners[msg.Name] = msg.FullName
+			}
+		}
+	}
+	out := make([]ir.File, len(files))
+	for i, file := range files {
+		file.Messages = append([]ir.Message(nil), file.Messages...)
+		for j, msg := range file.Messages {
+			if msg.JSName == "" {
+				continue
+			}
+			if !jsIdentifierPattern.MatchString(msg.JSName) {
+				return nil, fmt.Errorf("cp.js_name %q of message %s is not a valid JS identifier", msg.JSName, msg.FullName)
+			}
+			if owner, ok := owners[msg.JSName]; ok && owner != msg.FullName {
+				return nil, fmt.Errorf("cp.js_name %q of message %s is already the JS name of %s", msg.JSName, msg.FullName, owner)
+			}
+			owners[msg.JSName] = msg.FullName
+			file.Messages[j].Name = msg.JSName
+		}
+		out[i] = file
+	}
+	return out, nil
+}
@@ -0,0 +1,35 @@
+package jsg
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+var jsIdentifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// jsUseJSONNames returns a copy of files whose fields are named by their
+// proto3 JSON name (json_name, or its lowerCamelCase default), for
+// -js.jsonnames, so decoded objects use the keys a protojson peer emits.
+func jsUseJSONNames(files []ir.File) ([]ir.File, error) {
+	out := make([]ir.File, len(files))
+	for i, file := range files {
+		file.Messages = append([]ir.Message(nil), file.Messages...)
+		for j, msg := range file.Messages {
+			msg.Fields = append([]ir.Field(nil), msg.Fields...)
+			for k, field := range msg.Fields {
+				if field.JSONName == "" {
+					continue
+				}
+				if !jsIdentifierPattern.MatchString(field.JSONName) {
+					return nil, fmt.Errorf("json name %q of field %s.%s is not a valid JS identifier", field.JSONName, msg.FullName, field.ProtoName)
+				}
+				msg.Fields[k].Name = field.JSONName
+			}
+			file.Messages[j] = msg
+		}
+		out[i] = file
+	}
+	return out, nil
+}
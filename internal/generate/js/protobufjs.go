@@ -0,0 +1,36 @@
+package jsg
+
+import "github.com/jptrs93/cleanproto/internal/ir"
+
+// jsUseLong returns a copy of files whose singular and repeated 64-bit
+// integer fields without a cp.js_type are held as protobuf.js Long values,
+// for -js.runtime protobufjs. They are read with reader.int64() and friends
+// as they are and written back unchanged. Map values and wrappers keep
+// their number form.
+func jsUseLong(files []ir.File) []ir.File {
+	out := make([]ir.File, len(files))
+	for i, file := range files {
+		file.Messages = append([]ir.Message(nil), file.Messages...)
+		for j, msg := range file.Messages {
+			msg.Fields = append([]ir.Field(nil), msg.Fields...)
+			for k, field := range msg.Fields {
+				if field.JSType != "" || field.IsMap || field.IsWrapper || !isJSReadInt64(field) {
+					continue
+				}
+				msg.Fields[k].JSType = "Long"
+			}
+			file.Messages[j] = msg
+		}
+		out[i] = file
+	}
+	return out
+}
+
+// jsLongZero returns the zero Long for a 64-bit kind, unsigned for uint64
+// and fixed64 as protobuf.js reads them.
+func jsLongZero(kind ir.Kind) string {
+	if kind == ir.KindUint64 || kind == ir.KindFixed64 {
+		return "Long.UZERO"
+	}
+	return "Long.ZERO"
+}
@@ -0,0 +1,170 @@
+package jsg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+// buildJSValidateFunc renders validate<Msg>(message, prefix), which throws
+// when a required field (per its validation constraints) is unset or zero, or
+// when a field holds a value of the wrong type, so mistakes surface before
+// encoding silently drops or mangles them. Errors read "<path>: <reason>" like
+// the Go ValidationError.
+func buildJSValidateFunc(msg ir.Message, msgIndex map[string]ir.Message, enumIndex map[string]ir.Enum, asMap bool) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "/**\n * @param {%s} message\n * @param {string} [prefix]\n */\n", msg.Name)
+	fmt.Fprintf(&b, "export function validate%s(message, prefix = \"\") {\n", msg.Name)
+	b.WriteString("    if (typeof message !== \"object\" || message === null) {\n")
+	b.WriteString("        throw validationError(prefix.replace(/\\.$/, \"\") || \"message\", \"must be an object\");\n")
+	b.WriteString("    }\n")
+	for _, field := range msg.Fields {
+		if !field.JsEncode {
+			continue
+		}
+		required := field.Constraints.Required && field.Constraints.Ignore != ir.IgnoreAlways
+		b.WriteString("    {\n")
+		fmt.Fprintf(&b, "        const value = message.%s;\n", field.Name)
+		fmt.Fprintf(&b, "        const path = prefix + %q;\n", field.Name)
+		b.WriteString("        if (value === undefined || value === null) {\n")
+		if required {
+			b.WriteString("            throw validationError(path, \"is required\");\n")
+		}
+		b.WriteString("        } else {\n")
+		var err error
+		switch {
+		case field.IsMap:
+			err = jsValidateMap(&b, field, msgIndex, asMap, required)
+		case field.IsRepeated:
+			b.WriteString("            if (!Array.isArray(value)) {\n")
+			b.WriteString("                throw validationError(path, \"must be an array\");\n")
+			b.WriteString("            }\n")
+			if required {
+				b.WriteString("            if (value.length === 0) {\n")
+				b.WriteString("                throw validationError(path, \"is required\");\n")
+				b.WriteString("            }\n")
+			}
+			b.WriteString("            value.forEach((item, i) => {\n")
+			if field.IsWrapper {
+				// A null element is a wrapper without a value.
+				b.WriteString("                if (item !== null) {\n")
+				err = jsValidateValue(&b, field, msgIndex, "item", "path + \"[\" + i + \"]\"", "                    ")
+				b.WriteString("                }\n")
+			} else {
+				err = jsValidateValue(&b, field, msgIndex, "item", "path + \"[\" + i + \"]\"", "                ")
+			}
+			b.WriteString("            });\n")
+		default:
+			err = jsValidateValue(&b, field, msgIndex, "value", "path", "            ")
+			if required && !field.IsOptional {
+				if zero := jsZeroCondition(field, "value"); zero != "" {
+					fmt.Fprintf(&b, "            if (%s) {\n", zero)
+					b.WriteString("                throw validationError(path, \"is required\");\n")
+					b.WriteString("            }\n")
+				}
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		b.WriteString("        }\n")
+		b.WriteString("    }\n")
+	}
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+func jsValidateMap(b *strings.Builder, field ir.Field, msgIndex map[string]ir.Message, asMap, required bool) error {
+	entries := "Object.entries(value)"
+	if asMap {
+		b.WriteString("            if (!(value instanceof Map)) {\n")
+		b.WriteString("                throw validationError(path, \"must be a Map\");\n")
+		b.WriteString("            }\n")
+		entries = "value"
+	} else {
+		b.WriteString("            if (typeof value !== \"object\" || Array.isArray(value)) {\n")
+		b.WriteString("                throw validationError(path, \"must be an object\");\n")
+		b.WriteString("            }\n")
+	}
+	if required {
+		size := "Object.keys(value).length"
+		if asMap {
+			size = "value.size"
+		}
+		fmt.Fprintf(b, "            if (%s === 0) {\n", size)
+		b.WriteString("                throw validationError(path, \"is required\");\n")
+		b.WriteString("            }\n")
+	}
+	valueField := ir.Field{
+		Kind:            field.MapValueKind,
+		MessageFullName: field.MapValueMessage,
+		EnumFullName:    field.MapValueEnum,
+	}
+	fmt.Fprintf(b, "            for (const [key, item] of %s) {\n", entries)
+	if err := jsValidateValue(b, valueField, msgIndex, "item", "path + \"[\" + key + \"]\"", "                "); err != nil {
+		return err
+	}
+	b.WriteString("            }\n")
+	return nil
+}
+
+// jsValidateValue writes the type check for a single value of field.
+func jsValidateValue(b *strings.Builder, field ir.Field, msgIndex map[string]ir.Message, value, path, indent string) error {
+	jsType, err := jsBaseType(field, msgIndex)
+	if err != nil {
+		return err
+	}
+	var cond, reason string
+	switch {
+	case field.Kind == ir.KindMessage && !field.IsTimestamp && !field.IsDuration && field.JSType == "":
+		fmt.Fprintf(b, "%svalidate%s(%s, %s + \".\");\n", indent, jsType, value, path)
+		return nil
+	case jsType == "string":
+		cond, reason = "typeof "+value+" !== \"string\"", "must be a string"
+	case jsType == "boolean":
+		cond, reason = "typeof "+value+" !== \"boolean\"", "must be a boolean"
+	case jsType == "bigint":
+		cond, reason = "typeof "+value+" !== \"bigint\"", "must be a bigint"
+	case jsType == "Long":
+		cond, reason = "!Long.isLong("+value+")", "must be a Long"
+	case jsType == "Uint8Array":
+		cond, reason = "!("+value+" instanceof Uint8Array)", "must be a Uint8Array"
+	case jsType == "Date":
+		cond, reason = "!("+value+" instanceof Date)", "must be a Date"
+	case field.Kind == ir.KindFloat || field.Kind == ir.KindDouble || field.IsTimestamp || field.IsDuration:
+		cond, reason = "typeof "+value+" !== \"number\"", "must be a number"
+	default:
+		cond, reason = "!Number.isInteger("+value+")", "must be an integer"
+	}
+	fmt.Fprintf(b, "%sif (%s) {\n", indent, cond)
+	fmt.Fprintf(b, "%s    throw validationError(%s, %q);\n", indent, path, reason)
+	fmt.Fprintf(b, "%s}\n", indent)
+	return nil
+}
+
+// jsZeroCondition mirrors the Go validator's zero-value check for a required
+// singular field that is present.
+func jsZeroCondition(field ir.Field, value string) string {
+	switch {
+	case field.Kind == ir.KindMessage && !field.IsTimestamp && !field.IsDuration && field.JSType == "":
+		return ""
+	case field.JSType == "bigint":
+		return value + " === 0n"
+	case field.JSType == "Long":
+		return value + ".isZero()"
+	case field.JSType == "string":
+		return value + " === \"0\""
+	case field.JSType == "Date" || field.JSType == "LocalDate" || field.IsTimestamp && field.JSType == "":
+		return value + ".getTime() === 0"
+	}
+	switch field.Kind {
+	case ir.KindString:
+		return value + " === \"\""
+	case ir.KindBytes:
+		return value + ".length === 0"
+	case ir.KindBool:
+		return value + " === false"
+	}
+	return value + " === 0"
+}
@@ -513,7 +513,7 @@ func buildTSFileData(file ir.File, msgIndex map[string]ir.Message) (tsFileData,
 		}
 		for _, field := range msgForTS.Fields {
 			effType := tsEffectiveType(field)
-			if effType == "bigint" && (field.Kind == ir.KindInt64 || field.IsTimestamp || field.IsDuration) {
+			if effType == "bigint" && (field.Kind == ir.KindInt64 || field.IsTimestamp || field.IsDuration) || field.IsWrapper && isTSReadInt64(field) {
 				data.NeedsReadInt64BigInt = true
 			}
 			if effType != "" && field.IsTimestamp {
@@ -697,8 +697,8 @@ func buildEncodeFunc(msg ir.Message) string {
 
 func buildDecodeFunc(msg ir.Message) string {
 	var b strings.Builder
-	fmt.Fprintf(&b, "export function decode%s(buffer: ArrayBuffer): %s {\n", msg.Name, msg.Name)
-	b.WriteString("    const reader = Reader.create(new Uint8Array(buffer));\n")
+	fmt.Fprintf(&b, "export function decode%s(buffer: ArrayBuffer | ArrayBufferView): %s {\n", msg.Name, msg.Name)
+	b.WriteString("    const reader = Reader.create(buffer);\n")
 	fmt.Fprintf(&b, "    return decode%sMessage(reader);\n", msg.Name)
 	b.WriteString("}\n")
 	return b.String()
@@ -769,6 +769,9 @@ func tsTypeForDecl(field ir.Field, msgIndex map[string]ir.Message) (string, erro
 		return "", err
 	}
 	if field.IsRepeated {
+		if field.IsWrapper {
+			return "(" + t + " | null)[]", nil
+		}
 		return t + "[]", nil
 	}
 	return t, nil
@@ -876,7 +879,7 @@ func tsEffectiveType(field ir.Field) string {
 }
 
 func tsPresenceCheck(field ir.Field, name string) string {
-	if field.IsOptional {
+	if field.IsOptional || field.AlwaysEmit {
 		return name + " !== undefined && " + name + " !== null"
 	}
 	if field.TSType == "bigint" {
@@ -914,6 +917,24 @@ func tsPresenceCheck(field ir.Field, name string) string {
 
 func tsEncodeField(field ir.Field, msgIndex map[string]ir.Message, name, indent string) (string, error) {
 	var b strings.Builder
+	if field.IsWrapper {
+		// A singular wrapper leaves a zero value out; a repeated element
+		// writes it, since null is the element without a value.
+		cond := name + " !== null && " + name + " !== undefined"
+		if !field.IsRepeated {
+			cond = tsPresenceCheck(tsWrappedValue(field), name)
+		}
+		value := name
+		if isTSReadInt64(field) {
+			value = name + ".toString()"
+		}
+		fmt.Fprintf(&b, "%swriter.uint32(tag(%d, WIRE.LDELIM)).fork();\n", indent, field.Number)
+		fmt.Fprintf(&b, "%sif (%s) {\n", indent, cond)
+		fmt.Fprintf(&b, "%s    writer.uint32(tag(1, %s)).%s(%s);\n", indent, jsWireType(field.Kind), jsWriterMethod(field.Kind), value)
+		fmt.Fprintf(&b, "%s}\n", indent)
+		fmt.Fprintf(&b, "%swriter.ldelim();\n", indent)
+		return b.String(), nil
+	}
 	effType := tsEffectiveType(field)
 	if effType != "" {
 		nativeField := field
@@ -956,6 +977,9 @@ func tsEncodeField(field ir.Field, msgIndex map[string]ir.Message, name, indent
 func tsDecodeField(field ir.Field, msgIndex map[string]ir.Message, target string) (string, bool, bool, error) {
 	var b strings.Builder
 	fieldName := target + "." + field.Name
+	if field.IsWrapper {
+		return tsDecodeWrapper(fieldName, field), false, false, nil
+	}
 	effType := tsEffectiveType(field)
 	if effType != "" {
 		nativeField := field
@@ -994,14 +1018,13 @@ func tsDecodeField(field ir.Field, msgIndex map[string]ir.Message, target string
 			fmt.Fprintf(&b, "                %s.push(decode%sMessage(reader, reader.uint32()));\n", fieldName, msg.Name)
 			return b.String(), false, false, nil
 		}
-		if field.IsPacked && jsIsPackable(field.Kind) {
-			packedLines, needsReadInt64 := tsDecodePackedField(fieldName, field)
-			b.WriteString(packedLines)
-			return b.String(), needsReadInt64, false, nil
-		}
-		if isTSReadInt64(field) {
-			fmt.Fprintf(&b, "                %s.push(readInt64(reader, \"%s\"));\n", fieldName, jsReaderMethod(field.Kind))
-			return b.String(), true, false, nil
+		if jsIsPackable(field.Kind) {
+			// Decoders must accept both wire forms whatever the declared
+			// packing, since encoders and schema versions may disagree.
+			if isTSReadInt64(field) {
+				return tsDecodeRepeatedScalar(fieldName, fmt.Sprintf("readInt64(reader, \"%s\")", jsReaderMethod(field.Kind))), true, false, nil
+			}
+			return tsDecodeRepeatedScalar(fieldName, "reader."+jsReaderMethod(field.Kind)+"()"), false, false, nil
 		}
 		fmt.Fprintf(&b, "                %s.push(reader.%s());\n", fieldName, jsReaderMethod(field.Kind))
 		return b.String(), false, false, nil
@@ -1101,39 +1124,13 @@ func tsDecodeNativeField(field ir.Field, fieldName string) (string, bool, error)
 	var b strings.Builder
 	if field.IsRepeated {
 		if field.Kind == ir.KindInt64 {
-			if field.IsPacked {
-				b.WriteString("                const end2 = reader.uint32() + reader.pos;\n")
-				b.WriteString("                while (reader.pos < end2) {\n")
-				if field.TSType == "bigint" {
-					b.WriteString("                    ")
-					b.WriteString(fieldName)
-					b.WriteString(".push(readInt64BigInt(reader, \"int64\"));\n")
-				} else if field.TSType == "Date" {
-					b.WriteString("                    ")
-					b.WriteString(fieldName)
-					b.WriteString(".push(new Date(readInt64(reader, \"int64\")));\n")
-				} else {
-					b.WriteString("                    ")
-					b.WriteString(fieldName)
-					b.WriteString(".push(readInt64(reader, \"int64\"));\n")
-				}
-				b.WriteString("                }\n")
-				return b.String(), true, nil
-			}
+			readExpr := "readInt64(reader, \"int64\")"
 			if field.TSType == "bigint" {
-				b.WriteString("                ")
-				b.WriteString(fieldName)
-				b.WriteString(".push(readInt64BigInt(reader, \"int64\"));\n")
+				readExpr = "readInt64BigInt(reader, \"int64\")"
 			} else if field.TSType == "Date" {
-				b.WriteString("                ")
-				b.WriteString(fieldName)
-				b.WriteString(".push(new Date(readInt64(reader, \"int64\")));\n")
-			} else {
-				b.WriteString("                ")
-				b.WriteString(fieldName)
-				b.WriteString(".push(readInt64(reader, \"int64\"));\n")
+				readExpr = "new Date(readInt64(reader, \"int64\"))"
 			}
-			return b.String(), true, nil
+			return tsDecodeRepeatedScalar(fieldName, readExpr), true, nil
 		}
 		if field.IsTimestamp {
 			b.WriteString("                ")
@@ -1160,16 +1157,13 @@ func tsDecodeNativeField(field ir.Field, fieldName string) (string, bool, error)
 			return b.String(), true, nil
 		}
 		if field.Kind == ir.KindInt32 {
-			b.WriteString("                ")
-			b.WriteString(fieldName)
+			readExpr := "reader.int32()"
 			if field.TSType == "bigint" {
-				b.WriteString(".push(BigInt(reader.int32()));\n")
+				readExpr = "BigInt(reader.int32())"
 			} else if field.TSType == "Date" {
-				b.WriteString(".push(new Date(reader.int32() * 1000));\n")
-			} else {
-				b.WriteString(".push(reader.int32());\n")
+				readExpr = "new Date(reader.int32() * 1000)"
 			}
-			return b.String(), false, nil
+			return tsDecodeRepeatedScalar(fieldName, readExpr), false, nil
 		}
 	}
 
@@ -1357,13 +1351,15 @@ func jsEncodeMapValue(field ir.Field, msgIndex map[string]ir.Message) (string, e
 		if !ok {
 			return "", fmt.Errorf("unknown map value message: %s", field.MapValueMessage)
 		}
-		b.WriteString("            if (value) {\n")
-		b.WriteString("                writer.uint32(tag(2, WIRE.LDELIM)).fork();\n")
+		// A null value is written as an empty message, as Go writes a nil
+		// one, rather than leaving the entry with only a key.
+		b.WriteString("            writer.uint32(tag(2, WIRE.LDELIM)).fork();\n")
+		b.WriteString("            if (value != null) {\n")
 		b.WriteString("                write")
 		b.WriteString(msg.Name)
 		b.WriteString("(value, writer);\n")
-		b.WriteString("                writer.ldelim();\n")
 		b.WriteString("            }\n")
+		b.WriteString("            writer.ldelim();\n")
 		return b.String(), nil
 	}
 	if field.MapValueKind == ir.KindBytes {
@@ -1509,26 +1505,60 @@ func tsMapValueDefault(field ir.Field, msgIndex map[string]ir.Message) string {
 	}
 }
 
-func tsDecodePackedField(fieldName string, field ir.Field) (string, bool) {
+// tsDecodeRepeatedScalar pushes readExpr for a repeated scalar field,
+// reading a packed run when the value arrives length-delimited and a single
+// element otherwise.
+func tsDecodeRepeatedScalar(fieldName, readExpr string) string {
 	var b strings.Builder
-	needsReadInt64 := isTSReadInt64(field)
-	b.WriteString("                const end2 = reader.uint32() + reader.pos;\n")
-	b.WriteString("                while (reader.pos < end2) {\n")
-	if needsReadInt64 {
-		b.WriteString("                    ")
-		b.WriteString(fieldName)
-		b.WriteString(".push(readInt64(reader, \"")
-		b.WriteString(jsReaderMethod(field.Kind))
-		b.WriteString("\"));\n")
+	b.WriteString("                if ((tag & 7) === WIRE.LDELIM) {\n")
+	b.WriteString("                    const end2 = reader.uint32() + reader.pos;\n")
+	b.WriteString("                    while (reader.pos < end2) {\n")
+	fmt.Fprintf(&b, "                        %s.push(%s);\n", fieldName, readExpr)
+	b.WriteString("                    }\n")
+	b.WriteString("                } else {\n")
+	fmt.Fprintf(&b, "                    %s.push(%s);\n", fieldName, readExpr)
+	b.WriteString("                }\n")
+	return b.String()
+}
+
+// tsDecodeWrapper decodes a google.protobuf wrapper message into the value it
+// holds. A singular wrapper without a value decodes as the zero value, a
+// repeated element without one as null.
+func tsDecodeWrapper(fieldName string, field ir.Field) string {
+	var b strings.Builder
+	target := fieldName
+	b.WriteString("                const wrapperEnd = reader.uint32() + reader.pos;\n")
+	if field.IsRepeated {
+		t, _ := tsBaseType(tsWrappedValue(field), nil)
+		target = "wrapped"
+		fmt.Fprintf(&b, "                let wrapped: %s | null = null;\n", t)
 	} else {
-		b.WriteString("                    ")
-		b.WriteString(fieldName)
-		b.WriteString(".push(reader.")
-		b.WriteString(jsReaderMethod(field.Kind))
-		b.WriteString("());\n")
+		fmt.Fprintf(&b, "                %s = %s;\n", fieldName, tsDefaultValue(tsWrappedValue(field), nil))
 	}
+	read := "reader." + jsReaderMethod(field.Kind) + "()"
+	if isTSReadInt64(field) {
+		read = fmt.Sprintf("readInt64BigInt(reader, \"%s\")", jsReaderMethod(field.Kind))
+	}
+	b.WriteString("                while (reader.pos < wrapperEnd) {\n")
+	b.WriteString("                    const wrapperTag = reader.uint32();\n")
+	b.WriteString("                    if (wrapperTag >>> 3 === 1) {\n")
+	fmt.Fprintf(&b, "                        %s = %s;\n", target, read)
+	b.WriteString("                    } else {\n")
+	b.WriteString("                        reader.skipType(wrapperTag & 7);\n")
+	b.WriteString("                    }\n")
 	b.WriteString("                }\n")
-	return b.String(), needsReadInt64
+	if field.IsRepeated {
+		fmt.Fprintf(&b, "                %s.push(wrapped);\n", fieldName)
+	}
+	return b.String()
+}
+
+// tsWrappedValue returns the plain scalar field a wrapper field holds.
+func tsWrappedValue(field ir.Field) ir.Field {
+	field.IsWrapper = false
+	field.IsOptional = false
+	field.IsRepeated = false
+	return field
 }
 
 func tsDecodeTimestampSingle(fieldName string, field ir.Field) (string, bool) {
@@ -6,24 +6,94 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
 )
 
-func WriteFiles(outputs []OutputFile) error {
-	for _, file := range outputs {
-		if err := os.MkdirAll(filepath.Dir(file.Path), 0o755); err != nil {
-			return fmt.Errorf("create dir %s: %w", filepath.Dir(file.Path), err)
+// Generate runs generators over files and returns their combined outputs
+// without touching disk, so callers choose the sink: WriteFiles for the
+// filesystem, or anything else for in-memory tools. Go outputs come back
+// gofmt'd, and a Go output gofmt rejects fails the whole call, so nothing
+// is written.
+func Generate(files []ir.File, options Options, generators ...Generator) ([]OutputFile, error) {
+	var outputs []OutputFile
+	for _, gen := range generators {
+		out, err := gen.Generate(files, options)
+		if err != nil {
+			return nil, err
 		}
-		content := file.Content
-		if strings.HasSuffix(file.Path, ".go") {
-			formatted, err := format.Source(file.Content)
+		for _, file := range out {
+			content, err := formatOutput(file)
 			if err != nil {
-				return fmt.Errorf("gofmt %s: %w", file.Path, err)
+				return nil, err
 			}
-			content = formatted
+			outputs = append(outputs, OutputFile{Path: file.Path, Content: content})
+		}
+	}
+	return outputs, nil
+}
+
+// WriteFiles writes outputs to disk as given, all or nothing as far as the
+// filesystem allows: every file is staged in a temp file beside its target
+// before any target is replaced, so a write error leaves existing files
+// untouched. The staged files are then renamed into place. It does not
+// format Go files; Generate already has.
+func WriteFiles(outputs []OutputFile) error {
+	staged := make([]string, 0, len(outputs))
+	cleanup := func() {
+		for _, path := range staged {
+			os.Remove(path)
+		}
+	}
+	for _, file := range outputs {
+		tmp, err := stageFile(file.Path, file.Content)
+		if err != nil {
+			cleanup()
+			return err
 		}
-		if err := os.WriteFile(file.Path, content, 0o644); err != nil {
+		staged = append(staged, tmp)
+	}
+	for i, file := range outputs {
+		if err := os.Rename(staged[i], file.Path); err != nil {
+			cleanup()
 			return fmt.Errorf("write file %s: %w", file.Path, err)
 		}
 	}
 	return nil
 }
+
+// stageFile writes content to a new temp file in path's directory, creating
+// the directory if needed, and returns the temp file's path.
+func stageFile(path string, content []byte) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create dir %s: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("write file %s: %w", path, err)
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("write file %s: %w", path, err)
+	}
+	return f.Name(), nil
+}
+
+func formatOutput(file OutputFile) ([]byte, error) {
+	if !strings.HasSuffix(file.Path, ".go") {
+		return file.Content, nil
+	}
+	formatted, err := format.Source(file.Content)
+	if err != nil {
+		return nil, fmt.Errorf("gofmt %s: %w", file.Path, err)
+	}
+	return formatted, nil
+}
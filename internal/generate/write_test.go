@@ -0,0 +1,135 @@
+package generate_test
+
+import (
+	"go/format"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jptrs93/cleanproto/internal/generate"
+	gogen "github.com/jptrs93/cleanproto/internal/generate/go"
+	jsg "github.com/jptrs93/cleanproto/internal/generate/js"
+	tsg "github.com/jptrs93/cleanproto/internal/generate/ts"
+	"github.com/jptrs93/cleanproto/internal/ir"
+)
+
+func TestGenerateReturnsOutputsInMemory(t *testing.T) {
+	files := []ir.File{{
+		GoPackage: "example",
+		Messages: []ir.Message{{
+			Name:     "Ping",
+			FullName: "example.Ping",
+			Fields:   []ir.Field{{Name: "note", Number: 1, Kind: ir.KindString, GoEncode: true, JsEncode: true}},
+		}},
+	}}
+	// Output dirs are only path prefixes here; nothing is written under them.
+	options := generate.Options{GoOut: "mem/go", JsOut: "mem/js", TsOut: "mem/ts"}
+	outputs, err := generate.Generate(files, options, gogen.Generator{}, jsg.Generator{}, tsg.Generator{})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	byPath := make(map[string]string, len(outputs))
+	for _, out := range outputs {
+		byPath[out.Path] = string(out.Content)
+	}
+	want := map[string]string{
+		"mem/go/model.gen.go": "func (m *Ping) Encode() []byte {",
+		"mem/go/util.gen.go":  "func ConsumeTag(",
+		"mem/js/model.js":     "function writePing(",
+		"mem/ts/model.ts":     "export interface Ping {",
+	}
+	for path, snippet := range want {
+		content, ok := byPath[path]
+		if !ok {
+			t.Fatalf("expected output %s, got %v", path, slices.Sorted(maps.Keys(byPath)))
+		}
+		if !strings.Contains(content, snippet) {
+			t.Errorf("expected %s to contain %q, got:\n%s", path, snippet, content)
+		}
+	}
+	for path, content := range byPath {
+		if !strings.HasSuffix(path, ".go") {
+			continue
+		}
+		formatted, err := format.Source([]byte(content))
+		if err != nil {
+			t.Fatalf("gofmt %s: %v", path, err)
+		}
+		if string(formatted) != content {
+			t.Errorf("expected %s to be returned gofmt'd", path)
+		}
+	}
+}
+
+// badGoGenerator returns a Go file gofmt rejects after a valid one.
+type badGoGenerator struct{}
+
+func (badGoGenerator) Name() string { return "bad" }
+
+func (badGoGenerator) Generate([]ir.File, generate.Options) ([]generate.OutputFile, error) {
+	return []generate.OutputFile{
+		{Path: "go/model.gen.go", Content: []byte("package example\n")},
+		{Path: "go/util.gen.go", Content: []byte("package example\nfunc {\n")},
+	}, nil
+}
+
+func TestGenerateFailsOnFormatError(t *testing.T) {
+	outputs, err := generate.Generate(nil, generate.Options{}, badGoGenerator{})
+	if err == nil || !strings.Contains(err.Error(), "gofmt go/util.gen.go") {
+		t.Fatalf("expected a gofmt error, got %v", err)
+	}
+	if outputs != nil {
+		t.Fatalf("expected no outputs to write, got %v", outputs)
+	}
+}
+
+func TestWriteFilesLeavesTreeUntouchedOnWriteError(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "go", "model.gen.go")
+	if err := os.MkdirAll(filepath.Dir(existing), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(existing, []byte("package old\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// A file where the last output's directory should be makes it fail.
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	outputs := []generate.OutputFile{
+		{Path: existing, Content: []byte("package example\n")},
+		{Path: filepath.Join(dir, "js", "model.js"), Content: []byte("export {};\n")},
+		{Path: filepath.Join(blocker, "util.gen.go"), Content: []byte("package example\n")},
+	}
+	if err := generate.WriteFiles(outputs); err == nil {
+		t.Fatal("expected a write error")
+	}
+	if got, _ := os.ReadFile(existing); string(got) != "package old\n" {
+		t.Fatalf("expected %s to be left untouched, got %q", existing, got)
+	}
+	if _, err := os.Stat(outputs[1].Path); !os.IsNotExist(err) {
+		t.Fatalf("expected %s not to be written, stat err: %v", outputs[1].Path, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(existing))
+	if len(entries) != 1 {
+		t.Fatalf("expected no staged files left behind, got %v", entries)
+	}
+
+	outputs[2].Path = filepath.Join(dir, "go", "util.gen.go")
+	if err := generate.WriteFiles(outputs); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	for _, out := range outputs {
+		got, err := os.ReadFile(out.Path)
+		if err != nil || string(got) != string(out.Content) {
+			t.Fatalf("expected %s to contain %q, got %q (%v)", out.Path, out.Content, got, err)
+		}
+		if info, _ := os.Stat(out.Path); info.Mode().Perm() != 0o644 {
+			t.Fatalf("expected %s to be 0644, got %v", out.Path, info.Mode().Perm())
+		}
+	}
+}
@@ -41,29 +41,58 @@ type EnumValue struct {
 }
 
 type Message struct {
-	Name     string
-	FullName string
-	Fields   []Field
+	Name        string
+	FullName    string
+	Fields      []Field
+	GoImmutable bool
+	// GoJSON is the message's cp.go_json option, nil when unset. When set
+	// it decides whether the message gets MarshalJSON, whatever
+	// -go.jsontags says.
+	GoJSON *bool
+	// JSName is the message's cp.js_name option, the name the JS generator
+	// uses for it in place of Name. Empty when unset.
+	JSName string
 }
 
 type Field struct {
-	Name            string
-	ProtoName       string
-	Number          int
-	Kind            Kind
-	IsRepeated      bool
-	IsOptional      bool
-	IsPacked        bool
-	IsMap           bool
-	IsTimestamp     bool
-	IsDuration      bool
-	GoType          string
-	JSType          string
-	TSType          string
-	GoEncode        bool
-	GoIgnore        bool
-	GoSlicePtr      *bool
-	GoValue         bool
+	Name        string
+	ProtoName   string
+	JSONName    string
+	Number      int
+	Kind        Kind
+	IsRepeated  bool
+	IsOptional  bool
+	IsPacked    bool
+	IsMap       bool
+	IsTimestamp bool
+	IsDuration  bool
+	// IsWrapper marks a google.protobuf wrapper field (StringValue,
+	// Int32Value, ...). Kind is the wrapped value's kind and singular fields
+	// have IsOptional set; only the wire form wraps values in the message.
+	IsWrapper     bool
+	GoType        string
+	JSType        string
+	TSType        string
+	GoEncode      bool
+	GoIgnore      bool
+	GoSlicePtr    *bool
+	GoValue       bool
+	GoMapSizeHint int
+	// Lazy keeps a singular message field's bytes undecoded in Go until
+	// its Get<Field> accessor reads it (cp.lazy).
+	Lazy bool
+	// AlwaysEmit writes the field on encode even at its zero value
+	// (cp.always_emit).
+	AlwaysEmit bool
+	// SortBy is the proto name of the element field a repeated message
+	// field is sorted by when encoded (cp.sort_by).
+	SortBy string
+	// GoTag is raw struct tag content appended to the Go field's tag
+	// (cp.go_tag).
+	GoTag string
+	// AliasBytes makes Go decoding of a bytes field slice the input buffer
+	// rather than copy it (the standard ctype = CORD option).
+	AliasBytes      bool
 	JsEncode        bool
 	JsIgnore        bool
 	TsEncode        bool
@@ -1,8 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"go/token"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/jptrs93/cleanproto"
 	"google.golang.org/protobuf/encoding/protowire"
@@ -19,6 +23,10 @@ const validateProtoPath = cp.ValidateProtoPath
 
 var validateProtoSource = cp.ValidateProtoSource
 
+const moneyProtoPath = cp.MoneyProtoPath
+
+var moneyProtoSource = cp.MoneyProtoSource
+
 var E_GoType = cp.E_GoType
 var E_JsType = cp.E_JsType
 var E_GoEncode = cp.E_GoEncode
@@ -26,24 +34,64 @@ var E_JsEncode = cp.E_JsEncode
 var E_GoIgnore = cp.E_GoIgnore
 var E_GoSlicePtr = cp.E_GoSlicePtr
 var E_GoValue = cp.E_GoValue
+var E_GoMapSizeHint = cp.E_GoMapSizeHint
+var E_SortBy = cp.E_SortBy
+var E_Nullable = cp.E_Nullable
+var E_Lazy = cp.E_Lazy
+var E_AlwaysEmit = cp.E_AlwaysEmit
+var E_GoTag = cp.E_GoTag
 var E_JsIgnore = cp.E_JsIgnore
 var E_TsType = cp.E_TsType
 var E_TsEncode = cp.E_TsEncode
 var E_TsIgnore = cp.E_TsIgnore
 var E_JsonIgnore = cp.E_JsonIgnore
 var E_AuditIgnore = cp.E_AuditIgnore
+var E_GoImmutable = cp.E_GoImmutable
+var E_GoJson = cp.E_GoJson
+var E_JsName = cp.E_JsName
 var E_GoCustom = cp.E_GoCustom
 var E_OperationId = cp.E_OperationId
 var E_Audit = cp.E_Audit
 var E_Compression = cp.E_Compression
 var E_Url = cp.E_Url
 
+// foreignOption reports whether opts carries an extension of another package
+// under xt's field number. proto.GetExtension looks extensions up by number,
+// so without this check such an option would be read as xt, or panic when
+// its type differs.
+func foreignOption(opts proto.Message, xt protoreflect.ExtensionType) bool {
+	want := xt.TypeDescriptor()
+	foreign := false
+	opts.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if fd.IsExtension() && fd.Number() == want.Number() && fd.FullName() != want.FullName() {
+			foreign = true
+			return false
+		}
+		return true
+	})
+	return foreign
+}
+
+// hasOption and getOption are proto.HasExtension and proto.GetExtension for
+// cleanproto's own options, treating an option of another package with the
+// same field number as unset. Unrecognised options are otherwise ignored.
+func hasOption(opts proto.Message, xt protoreflect.ExtensionType) bool {
+	return !foreignOption(opts, xt) && proto.HasExtension(opts, xt)
+}
+
+func getOption(opts proto.Message, xt protoreflect.ExtensionType) any {
+	if foreignOption(opts, xt) {
+		return nil
+	}
+	return proto.GetExtension(opts, xt)
+}
+
 func goTypeFromFieldOptions(field protoreflect.FieldDescriptor) (string, error) {
 	opts, ok := field.Options().(*descriptorpb.FieldOptions)
 	if !ok || opts == nil {
 		return "", nil
 	}
-	val := proto.GetExtension(opts, E_GoType)
+	val := getOption(opts, E_GoType)
 	str, ok := val.(string)
 	if !ok || str == "" {
 		return "", nil
@@ -56,20 +104,54 @@ func jsTypeFromFieldOptions(field protoreflect.FieldDescriptor) (string, error)
 	if !ok || opts == nil {
 		return "", nil
 	}
-	val := proto.GetExtension(opts, E_JsType)
+	val := getOption(opts, E_JsType)
 	str, ok := val.(string)
 	if !ok || str == "" {
-		return "", nil
+		return jsTypeFromStandardJSType(field, opts.GetJstype()), nil
 	}
 	return str, nil
 }
 
+// jsTypeFromStandardJSType maps the standard jstype option of a 64-bit
+// integer field onto the equivalent cp.js_type, so schemas annotated for
+// other JS code generators keep their representation. cp.js_type wins when
+// both are set.
+func jsTypeFromStandardJSType(field protoreflect.FieldDescriptor, jstype descriptorpb.FieldOptions_JSType) string {
+	switch field.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Uint64Kind, protoreflect.Sint64Kind, protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind:
+	default:
+		return ""
+	}
+	switch jstype {
+	case descriptorpb.FieldOptions_JS_STRING:
+		return "string"
+	case descriptorpb.FieldOptions_JS_NUMBER:
+		// Only int64 has a non-number default representation.
+		if field.Kind() == protoreflect.Int64Kind {
+			return "number"
+		}
+	}
+	return ""
+}
+
+// aliasBytesFromFieldOptions reports whether the standard ctype option of a
+// bytes field is CORD, whose zero-copy intent Go decoding follows by aliasing
+// the input. STRING_PIECE, and ctype on string fields, change nothing since
+// Go strings are always copies.
+func aliasBytesFromFieldOptions(field protoreflect.FieldDescriptor) bool {
+	opts, ok := field.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return false
+	}
+	return field.Kind() == protoreflect.BytesKind && opts.GetCtype() == descriptorpb.FieldOptions_CORD
+}
+
 func tsTypeFromFieldOptions(field protoreflect.FieldDescriptor) (string, error) {
 	opts, ok := field.Options().(*descriptorpb.FieldOptions)
 	if !ok || opts == nil {
 		return "", nil
 	}
-	val := proto.GetExtension(opts, E_TsType)
+	val := getOption(opts, E_TsType)
 	str, ok := val.(string)
 	if !ok || str == "" {
 		return "", nil
@@ -82,10 +164,10 @@ func goEncodeFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error)
 	if !ok || opts == nil {
 		return true, nil
 	}
-	if !proto.HasExtension(opts, E_GoEncode) {
+	if !hasOption(opts, E_GoEncode) {
 		return true, nil
 	}
-	val := proto.GetExtension(opts, E_GoEncode)
+	val := getOption(opts, E_GoEncode)
 	b, ok := val.(bool)
 	if !ok {
 		return true, nil
@@ -98,10 +180,10 @@ func jsEncodeFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error)
 	if !ok || opts == nil {
 		return true, nil
 	}
-	if !proto.HasExtension(opts, E_JsEncode) {
+	if !hasOption(opts, E_JsEncode) {
 		return true, nil
 	}
-	val := proto.GetExtension(opts, E_JsEncode)
+	val := getOption(opts, E_JsEncode)
 	b, ok := val.(bool)
 	if !ok {
 		return true, nil
@@ -114,10 +196,10 @@ func tsEncodeFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error)
 	if !ok || opts == nil {
 		return true, nil
 	}
-	if !proto.HasExtension(opts, E_TsEncode) {
+	if !hasOption(opts, E_TsEncode) {
 		return true, nil
 	}
-	val := proto.GetExtension(opts, E_TsEncode)
+	val := getOption(opts, E_TsEncode)
 	b, ok := val.(bool)
 	if !ok {
 		return true, nil
@@ -130,7 +212,7 @@ func goIgnoreFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error)
 	if !ok || opts == nil {
 		return false, nil
 	}
-	val := proto.GetExtension(opts, E_GoIgnore)
+	val := getOption(opts, E_GoIgnore)
 	b, ok := val.(bool)
 	if !ok {
 		return false, nil
@@ -143,10 +225,10 @@ func goSlicePtrFromFieldOptions(field protoreflect.FieldDescriptor) (*bool, erro
 	if !ok || opts == nil {
 		return nil, nil
 	}
-	if !proto.HasExtension(opts, E_GoSlicePtr) {
+	if !hasOption(opts, E_GoSlicePtr) {
 		return nil, nil
 	}
-	val := proto.GetExtension(opts, E_GoSlicePtr)
+	val := getOption(opts, E_GoSlicePtr)
 	b, ok := val.(bool)
 	if !ok {
 		return nil, nil
@@ -159,10 +241,42 @@ func goValueFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error) {
 	if !ok || opts == nil {
 		return false, nil
 	}
-	if !proto.HasExtension(opts, E_GoValue) {
+	if !hasOption(opts, E_GoValue) {
+		return false, nil
+	}
+	val := getOption(opts, E_GoValue)
+	b, ok := val.(bool)
+	if !ok {
+		return false, nil
+	}
+	return b, nil
+}
+
+func lazyFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error) {
+	opts, ok := field.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return false, nil
+	}
+	if !hasOption(opts, E_Lazy) {
+		return false, nil
+	}
+	val := getOption(opts, E_Lazy)
+	b, ok := val.(bool)
+	if !ok {
+		return false, nil
+	}
+	return b, nil
+}
+
+func alwaysEmitFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error) {
+	opts, ok := field.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return false, nil
+	}
+	if !hasOption(opts, E_AlwaysEmit) {
 		return false, nil
 	}
-	val := proto.GetExtension(opts, E_GoValue)
+	val := getOption(opts, E_AlwaysEmit)
 	b, ok := val.(bool)
 	if !ok {
 		return false, nil
@@ -170,12 +284,154 @@ func goValueFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error) {
 	return b, nil
 }
 
+func nullableFromFieldOptions(field protoreflect.FieldDescriptor) (*bool, error) {
+	opts, ok := field.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return nil, nil
+	}
+	if !hasOption(opts, E_Nullable) {
+		return nil, nil
+	}
+	val := getOption(opts, E_Nullable)
+	b, ok := val.(bool)
+	if !ok {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+func goMapSizeHintFromFieldOptions(field protoreflect.FieldDescriptor) (int32, error) {
+	opts, ok := field.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return 0, nil
+	}
+	if !hasOption(opts, E_GoMapSizeHint) {
+		return 0, nil
+	}
+	val := getOption(opts, E_GoMapSizeHint)
+	n, ok := val.(int32)
+	if !ok {
+		return 0, nil
+	}
+	return n, nil
+}
+
+func sortByFromFieldOptions(field protoreflect.FieldDescriptor) (string, error) {
+	opts, ok := field.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return "", nil
+	}
+	val := getOption(opts, E_SortBy)
+	str, ok := val.(string)
+	if !ok || str == "" {
+		return "", nil
+	}
+	return str, nil
+}
+
+func goTagFromFieldOptions(field protoreflect.FieldDescriptor) (string, error) {
+	opts, ok := field.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return "", nil
+	}
+	val := getOption(opts, E_GoTag)
+	str, ok := val.(string)
+	if !ok {
+		return "", nil
+	}
+	str = strings.TrimSpace(str)
+	if err := validateGoTag(str); err != nil {
+		return "", fmt.Errorf("cp.go_tag %q is not a valid struct tag, %v: %s", str, err, field.FullName())
+	}
+	return str, nil
+}
+
+// validateGoTag checks that tag is a space-separated list of key:"value"
+// pairs, as reflect.StructTag reads them, with no key repeated and nothing
+// that would end the backtick-quoted tag it is written into.
+func validateGoTag(tag string) error {
+	if strings.ContainsAny(tag, "`\n") {
+		return errors.New("it contains a backtick or newline")
+	}
+	seen := make(map[string]bool)
+	for tag != "" {
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return errors.New(`expected key:"value"`)
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return fmt.Errorf("the %s value is not terminated", key)
+		}
+		if _, err := strconv.Unquote(tag[:i+1]); err != nil {
+			return fmt.Errorf("the %s value is not a valid quoted string", key)
+		}
+		if seen[key] {
+			return fmt.Errorf("it repeats the %s key", key)
+		}
+		seen[key] = true
+		tag = tag[i+1:]
+		if tag != "" && tag[0] != ' ' {
+			return errors.New("pairs must be separated by spaces")
+		}
+		tag = strings.TrimLeft(tag, " ")
+	}
+	return nil
+}
+
+// validateSortBy checks that cp.sort_by on field names a singular, ordered
+// field of its element message: a number, enum or string without presence or
+// a native Go type, so the generated encoder can compare it with <.
+func validateSortBy(field protoreflect.FieldDescriptor, sortBy string) error {
+	if !field.IsList() || field.Kind() != protoreflect.MessageKind {
+		return fmt.Errorf("cp.sort_by only applies to repeated message fields: %s", field.FullName())
+	}
+	switch field.Message().FullName() {
+	case "google.protobuf.Timestamp", "google.protobuf.Duration":
+		return fmt.Errorf("cp.sort_by only applies to repeated message fields: %s", field.FullName())
+	}
+	key := field.Message().Fields().ByName(protoreflect.Name(sortBy))
+	if key == nil {
+		return fmt.Errorf("cp.sort_by field %q not found in %s: %s", sortBy, field.Message().FullName(), field.FullName())
+	}
+	switch key.Kind() {
+	case protoreflect.BoolKind, protoreflect.BytesKind, protoreflect.MessageKind, protoreflect.GroupKind:
+		return fmt.Errorf("cp.sort_by field %q must be a number, enum or string: %s", sortBy, field.FullName())
+	}
+	if key.IsList() || key.IsMap() || key.HasPresence() {
+		return fmt.Errorf("cp.sort_by field %q must be singular without presence: %s", sortBy, field.FullName())
+	}
+	goType, err := goTypeFromFieldOptions(key)
+	if err != nil {
+		return err
+	}
+	goIgnore, err := goIgnoreFromFieldOptions(key)
+	if err != nil {
+		return err
+	}
+	if goType != "" || goIgnore {
+		return fmt.Errorf("cp.sort_by field %q must not set cp.go_type or cp.go_ignore: %s", sortBy, field.FullName())
+	}
+	return nil
+}
+
 func jsIgnoreFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error) {
 	opts, ok := field.Options().(*descriptorpb.FieldOptions)
 	if !ok || opts == nil {
 		return false, nil
 	}
-	val := proto.GetExtension(opts, E_JsIgnore)
+	val := getOption(opts, E_JsIgnore)
 	b, ok := val.(bool)
 	if !ok {
 		return false, nil
@@ -188,7 +444,7 @@ func tsIgnoreFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error)
 	if !ok || opts == nil {
 		return false, nil
 	}
-	val := proto.GetExtension(opts, E_TsIgnore)
+	val := getOption(opts, E_TsIgnore)
 	b, ok := val.(bool)
 	if !ok {
 		return false, nil
@@ -201,7 +457,23 @@ func jsonIgnoreFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error
 	if !ok || opts == nil {
 		return false, nil
 	}
-	val := proto.GetExtension(opts, E_JsonIgnore)
+	val := getOption(opts, E_JsonIgnore)
+	b, ok := val.(bool)
+	if !ok {
+		return false, nil
+	}
+	return b, nil
+}
+
+func goImmutableFromMessageOptions(msg protoreflect.MessageDescriptor) (bool, error) {
+	opts, ok := msg.Options().(*descriptorpb.MessageOptions)
+	if !ok || opts == nil {
+		return false, nil
+	}
+	if !hasOption(opts, E_GoImmutable) {
+		return false, nil
+	}
+	val := getOption(opts, E_GoImmutable)
 	b, ok := val.(bool)
 	if !ok {
 		return false, nil
@@ -209,15 +481,46 @@ func jsonIgnoreFromFieldOptions(field protoreflect.FieldDescriptor) (bool, error
 	return b, nil
 }
 
+// goJSONFromMessageOptions returns the message's cp.go_json value, or nil
+// when the option is not set.
+func goJSONFromMessageOptions(msg protoreflect.MessageDescriptor) (*bool, error) {
+	opts, ok := msg.Options().(*descriptorpb.MessageOptions)
+	if !ok || opts == nil {
+		return nil, nil
+	}
+	if !hasOption(opts, E_GoJson) {
+		return nil, nil
+	}
+	val := getOption(opts, E_GoJson)
+	b, ok := val.(bool)
+	if !ok {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+func jsNameFromMessageOptions(msg protoreflect.MessageDescriptor) (string, error) {
+	opts, ok := msg.Options().(*descriptorpb.MessageOptions)
+	if !ok || opts == nil {
+		return "", nil
+	}
+	val := getOption(opts, E_JsName)
+	str, ok := val.(string)
+	if !ok || str == "" {
+		return "", nil
+	}
+	return str, nil
+}
+
 func goCustomFromMethodOptions(method protoreflect.MethodDescriptor) (bool, error) {
 	opts, ok := method.Options().(*descriptorpb.MethodOptions)
 	if !ok || opts == nil {
 		return false, nil
 	}
-	if !proto.HasExtension(opts, E_GoCustom) {
+	if !hasOption(opts, E_GoCustom) {
 		return false, nil
 	}
-	val := proto.GetExtension(opts, E_GoCustom)
+	val := getOption(opts, E_GoCustom)
 	b, ok := val.(bool)
 	if !ok {
 		return false, nil
@@ -230,10 +533,10 @@ func operationIDFromMethodOptions(method protoreflect.MethodDescriptor) (string,
 	if !ok || opts == nil {
 		return "", nil
 	}
-	if !proto.HasExtension(opts, E_OperationId) {
+	if !hasOption(opts, E_OperationId) {
 		return "", nil
 	}
-	val := proto.GetExtension(opts, E_OperationId)
+	val := getOption(opts, E_OperationId)
 	str, ok := val.(string)
 	if !ok || str == "" {
 		return "", nil
@@ -246,10 +549,10 @@ func urlFromMethodOptions(method protoreflect.MethodDescriptor) (string, error)
 	if !ok || opts == nil {
 		return "", nil
 	}
-	if !proto.HasExtension(opts, E_Url) {
+	if !hasOption(opts, E_Url) {
 		return "", nil
 	}
-	val := proto.GetExtension(opts, E_Url)
+	val := getOption(opts, E_Url)
 	str, ok := val.(string)
 	if !ok || str == "" {
 		return "", nil
@@ -265,10 +568,10 @@ func auditFromMethodOptions(method protoreflect.MethodDescriptor) (bool, error)
 	if !ok || opts == nil {
 		return false, nil
 	}
-	if !proto.HasExtension(opts, E_Audit) {
+	if !hasOption(opts, E_Audit) {
 		return false, nil
 	}
-	val := proto.GetExtension(opts, E_Audit)
+	val := getOption(opts, E_Audit)
 	b, ok := val.(bool)
 	if !ok {
 		return false, nil
@@ -284,7 +587,7 @@ func compressionFromMethodOptions(method protoreflect.MethodDescriptor) (int32,
 	found := false
 	mode := int32(0)
 	opts.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
-		if !fd.IsExtension() || fd.Number() != 50033 {
+		if !fd.IsExtension() || fd.Number() != 50033 || fd.FullName() != "cp.compression" {
 			return true
 		}
 		found = true
@@ -331,7 +634,7 @@ func auditIgnoreFromFieldOptions(field protoreflect.FieldDescriptor) (bool, erro
 	if !ok || opts == nil {
 		return false, nil
 	}
-	val := proto.GetExtension(opts, E_AuditIgnore)
+	val := getOption(opts, E_AuditIgnore)
 	b, ok := val.(bool)
 	if !ok {
 		return false, nil
@@ -348,7 +651,7 @@ func policyFromMethodOptions(method protoreflect.MethodDescriptor) (int32, []str
 	var scopes []string
 	found := false
 	opts.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
-		if !fd.IsExtension() || fd.Number() != 50030 {
+		if !fd.IsExtension() || fd.Number() != 50030 || fd.FullName() != "cp.policy" {
 			return true
 		}
 		found = true
@@ -450,7 +753,45 @@ func goPackageFromOptions(file protoreflect.FileDescriptor) string {
 	}
 	goPkg = strings.TrimSuffix(goPkg, "/")
 	if idx := strings.LastIndex(goPkg, "/"); idx != -1 {
-		return goPkg[idx+1:]
+		return sanitizeGoPackageName(goPkg[idx+1:])
+	}
+	return sanitizeGoPackageName(goPkg)
+}
+
+// goPackageFromProtoPackage derives the Go package name used when go_package
+// is not set: the last segment of the dotted proto package, so my.api.v1
+// becomes v1. Files without a proto package yield "".
+func goPackageFromProtoPackage(file protoreflect.FileDescriptor) string {
+	pkg := string(file.Package())
+	if idx := strings.LastIndex(pkg, "."); idx != -1 {
+		pkg = pkg[idx+1:]
+	}
+	return sanitizeGoPackageName(pkg)
+}
+
+// sanitizeGoPackageName turns name into a valid Go identifier by replacing
+// invalid characters with underscores and suffixing Go keywords.
+func sanitizeGoPackageName(name string) string {
+	if name == "" {
+		return ""
+	}
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+			b.WriteRune(r)
+		case unicode.IsDigit(r):
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	out := b.String()
+	if token.IsKeyword(out) {
+		out += "_"
 	}
-	return goPkg
+	return out
 }
@@ -1,10 +1,12 @@
 package parser
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"go/token"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,23 +14,52 @@ import (
 	"github.com/jptrs93/cleanproto/internal/ir"
 
 	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/editionstesting"
 	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
+func init() {
+	// protocompile gates edition 2023 behind this opt-in until its support is
+	// declared complete. It must run before any compiler is used.
+	editionstesting.AllowEditions()
+}
+
+// StdinPath is the file path alias that resolves to Parser.Stdin.
+const StdinPath = "<stdin>"
+
 type Parser struct {
 	ImportPaths []string
+	// Stdin, when set, supplies the content of the StdinPath file. Imports
+	// from it are still resolved through ImportPaths.
+	Stdin io.Reader
+	// Logger receives warnings about skipped options; nil uses log.Default().
+	Logger *log.Logger
 }
 
 func (p *Parser) Parse(ctx context.Context, filePaths []string) ([]ir.File, error) {
+	var stdinSource []byte
+	if p.Stdin != nil {
+		var err error
+		stdinSource, err = io.ReadAll(p.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", StdinPath, err)
+		}
+	}
 	resolver := &protocompile.SourceResolver{
 		ImportPaths: p.ImportPaths,
 		Accessor: func(path string) (io.ReadCloser, error) {
+			if p.Stdin != nil && (path == StdinPath || strings.HasSuffix(path, string(filepath.Separator)+StdinPath)) {
+				return io.NopCloser(bytes.NewReader(stdinSource)), nil
+			}
 			if path == optionsProtoPath || strings.HasSuffix(path, string(filepath.Separator)+optionsProtoPath) {
 				return io.NopCloser(strings.NewReader(optionsProtoSource)), nil
 			}
 			if path == validateProtoPath || strings.HasSuffix(path, string(filepath.Separator)+validateProtoPath) {
 				return io.NopCloser(strings.NewReader(validateProtoSource)), nil
 			}
+			if path == moneyProtoPath || strings.HasSuffix(path, string(filepath.Separator)+moneyProtoPath) {
+				return io.NopCloser(strings.NewReader(moneyProtoSource)), nil
+			}
 			return os.Open(path)
 		},
 	}
@@ -39,7 +70,7 @@ func (p *Parser) Parse(ctx context.Context, filePaths []string) ([]ir.File, erro
 	if err != nil {
 		return nil, err
 	}
-	vc := newValidateContext()
+	vc := newValidateContext(p.Logger)
 	files, err := compiler.Compile(ctx, filePaths...)
 	if err != nil {
 		return nil, err
@@ -62,41 +93,54 @@ type builtinCatalog struct {
 	Enums    map[string]ir.Enum
 }
 
+// anyProtoPath is the standard import declaring google.protobuf.Any.
+const anyProtoPath = "google/protobuf/any.proto"
+
+// loadBuiltinCatalog compiles the built-in options.proto,
+// google/type/money.proto and google/protobuf/any.proto and indexes the
+// types they declare.
 func loadBuiltinCatalog(ctx context.Context, compiler protocompile.Compiler) (builtinCatalog, error) {
-	files, err := compiler.Compile(ctx, optionsProtoPath)
+	files, err := compiler.Compile(ctx, optionsProtoPath, moneyProtoPath, anyProtoPath)
 	if err != nil {
 		return builtinCatalog{}, err
 	}
+	catalog := builtinCatalog{
+		Messages: make(map[string]ir.Message),
+		Enums:    make(map[string]ir.Enum),
+	}
+	found := 0
 	for _, file := range files {
-		if file.Path() != optionsProtoPath {
+		if file.Path() != optionsProtoPath && file.Path() != moneyProtoPath && file.Path() != anyProtoPath {
 			continue
 		}
+		found++
 		irFile, err := fileToIR(file, nil)
 		if err != nil {
 			return builtinCatalog{}, err
 		}
-		catalog := builtinCatalog{
-			Messages: make(map[string]ir.Message, len(irFile.Messages)),
-			Enums:    make(map[string]ir.Enum, len(irFile.Enums)),
-		}
 		for _, msg := range irFile.Messages {
 			catalog.Messages[msg.FullName] = msg
 		}
 		for _, enum := range irFile.Enums {
 			catalog.Enums[enum.FullName] = enum
 		}
-		return catalog, nil
 	}
-	return builtinCatalog{}, fmt.Errorf("%s not found", optionsProtoPath)
+	if found != 3 {
+		return builtinCatalog{}, fmt.Errorf("%s, %s or %s not found", optionsProtoPath, moneyProtoPath, anyProtoPath)
+	}
+	return catalog, nil
 }
 
 func fileToIR(file protoreflect.FileDescriptor, vc *validateContext) (ir.File, error) {
-	if file.Syntax() != protoreflect.Proto3 {
-		return ir.File{}, fmt.Errorf("only proto3 is supported: %s", file.Path())
+	// Editions files are read through their resolved features: descriptors
+	// report field presence and packing the same way proto3 optional and
+	// packed repeated fields do, so they map onto the same IR flags.
+	if syntax := file.Syntax(); syntax != protoreflect.Proto3 && syntax != protoreflect.Editions {
+		return ir.File{}, fmt.Errorf("only proto3 and editions are supported: %s", file.Path())
 	}
 	goPkg := goPackageFromOptions(file)
 	if goPkg == "" {
-		goPkg = string(file.Package())
+		goPkg = goPackageFromProtoPackage(file)
 	}
 	out := ir.File{
 		Path:      file.Path(),
@@ -129,6 +173,34 @@ func fileToIR(file protoreflect.FileDescriptor, vc *validateContext) (ir.File, e
 func ensureGeneratedTypes(file *ir.File, builtins builtinCatalog) {
 	ensurePolicyTypes(file, builtins)
 	ensureApiErr(file, builtins)
+	ensureReferenced(file, builtins, "google.type.Money")
+	ensureReferenced(file, builtins, "google.protobuf.Any")
+}
+
+// ensureReferenced adds the built-in message fullName to files with fields
+// of that type, so google.type.Money and google.protobuf.Any are generated
+// as plain structs of their fields alongside them rather than needing
+// money.proto or any.proto among the generated files.
+func ensureReferenced(file *ir.File, builtins builtinCatalog, fullName string) {
+	uses := false
+	for _, msg := range file.Messages {
+		if msg.FullName == fullName {
+			return
+		}
+		for _, field := range msg.Fields {
+			if field.MessageFullName == fullName || field.MapValueMessage == fullName {
+				uses = true
+			}
+		}
+	}
+	if !uses {
+		return
+	}
+	msg, ok := builtins.Messages[fullName]
+	if !ok {
+		return
+	}
+	file.Messages = append(file.Messages, msg)
 }
 
 func ensureApiErr(file *ir.File, builtins builtinCatalog) {
@@ -142,8 +214,11 @@ func ensureApiErr(file *ir.File, builtins builtinCatalog) {
 	file.Messages = append(file.Messages, msg)
 }
 
+// ensurePolicyTypes adds AccessPolicy and AccessPolicyType to files with
+// services: the generated Go mux passes an AccessPolicy to verifyAuth for
+// every method, including those without a cp.policy.
 func ensurePolicyTypes(file *ir.File, builtins builtinCatalog) {
-	if !hasPolicyUsage(file.Services) {
+	if len(file.Services) == 0 {
 		return
 	}
 	if !hasEnumName(file.Enums, "AccessPolicyType") {
@@ -160,17 +235,6 @@ func ensurePolicyTypes(file *ir.File, builtins builtinCatalog) {
 	}
 }
 
-func hasPolicyUsage(services []ir.Service) bool {
-	for _, svc := range services {
-		for _, method := range svc.Methods {
-			if method.PolicyType != 0 || len(method.PolicyScopes) > 0 {
-				return true
-			}
-		}
-	}
-	return false
-}
-
 func hasMessageName(messages []ir.Message, name string) bool {
 	for _, msg := range messages {
 		if msg.Name == name {
@@ -258,6 +322,21 @@ func collectMessages(messages protoreflect.MessageDescriptors, prefix []string,
 		if err := vc.warnMessageOptions(msg); err != nil {
 			return nil, err
 		}
+		goImmutable, err := goImmutableFromMessageOptions(msg)
+		if err != nil {
+			return nil, err
+		}
+		irMsg.GoImmutable = goImmutable
+		goJSON, err := goJSONFromMessageOptions(msg)
+		if err != nil {
+			return nil, err
+		}
+		irMsg.GoJSON = goJSON
+		jsName, err := jsNameFromMessageOptions(msg)
+		if err != nil {
+			return nil, err
+		}
+		irMsg.JSName = jsName
 		fields, err := collectFields(msg.Fields(), vc)
 		if err != nil {
 			return nil, err
@@ -324,6 +403,12 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 		if oneof := field.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() {
 			return nil, fmt.Errorf("oneof is not supported: %s", field.FullName())
 		}
+		if field.Cardinality() == protoreflect.Required {
+			return nil, fmt.Errorf("required fields (features.field_presence = LEGACY_REQUIRED) are not supported: %s", field.FullName())
+		}
+		if field.Kind() == protoreflect.GroupKind {
+			return nil, fmt.Errorf("delimited message encoding (features.message_encoding = DELIMITED) is not supported: %s", field.FullName())
+		}
 		kind, err := kindFromField(field)
 		if err != nil {
 			return nil, err
@@ -337,6 +422,7 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 		var mapValueEnum string
 		var isTimestamp bool
 		var isDuration bool
+		var isWrapper bool
 		var goType string
 		var jsType string
 		var tsType string
@@ -346,6 +432,10 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 		var goIgnore bool
 		var goSlicePtr *bool
 		var goValue bool
+		var lazy bool
+		var alwaysEmit bool
+		var goMapSizeHint int32
+		var sortBy string
 		var jsIgnore bool
 		var tsIgnore bool
 		var jsonIgnore bool
@@ -364,6 +454,9 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 			mapValueKind = valKind
 			if valKind == ir.KindMessage {
 				mapValueMessage = string(field.MapValue().Message().FullName())
+				if _, ok := wrapperKinds[mapValueMessage]; ok {
+					return nil, fmt.Errorf("%s is not supported as a map value: %s", mapValueMessage, field.FullName())
+				}
 			}
 			if valKind == ir.KindEnum {
 				mapValueEnum = string(field.MapValue().Enum().FullName())
@@ -376,6 +469,7 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 			if msgName == "google.protobuf.Duration" {
 				isDuration = true
 			}
+			_, isWrapper = wrapperKinds[msgName]
 		} else if kind == ir.KindEnum {
 			enumName = string(field.Enum().FullName())
 		}
@@ -418,8 +512,59 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 		if err != nil {
 			return nil, err
 		}
-		if goValue && (field.IsList() || field.IsMap() || kind != ir.KindMessage || isTimestamp || isDuration || goType != "") {
-			return nil, fmt.Errorf("cp.go_value only applies to singular non-native message fields: %s", field.FullName())
+		nullable, err := nullableFromFieldOptions(field)
+		if err != nil {
+			return nil, err
+		}
+		valueOption := "cp.go_value"
+		if nullable != nil {
+			if *nullable && goValue {
+				return nil, fmt.Errorf("cp.nullable = true contradicts cp.go_value = true: %s", field.FullName())
+			}
+			if !*nullable {
+				goValue = true
+				valueOption = "cp.nullable = false"
+			}
+		}
+		if goValue && (field.IsList() || field.IsMap() || kind != ir.KindMessage || isTimestamp || isDuration || isWrapper || goType != "") {
+			return nil, fmt.Errorf("%s only applies to singular non-native message fields: %s", valueOption, field.FullName())
+		}
+		lazy, err = lazyFromFieldOptions(field)
+		if err != nil {
+			return nil, err
+		}
+		if lazy && (field.IsList() || field.IsMap() || kind != ir.KindMessage || isTimestamp || isDuration || isWrapper || goType != "" || goValue) {
+			return nil, fmt.Errorf("cp.lazy only applies to singular non-native pointer message fields: %s", field.FullName())
+		}
+		alwaysEmit, err = alwaysEmitFromFieldOptions(field)
+		if err != nil {
+			return nil, err
+		}
+		if alwaysEmit && (field.IsList() || field.IsMap() || field.HasPresence() || kind == ir.KindMessage || isWrapper || goType != "") {
+			return nil, fmt.Errorf("cp.always_emit only applies to singular non-optional scalar, enum, string and bytes fields without cp.go_type: %s", field.FullName())
+		}
+		goMapSizeHint, err = goMapSizeHintFromFieldOptions(field)
+		if err != nil {
+			return nil, err
+		}
+		if goMapSizeHint != 0 && !field.IsMap() {
+			return nil, fmt.Errorf("cp.go_map_size_hint only applies to map fields: %s", field.FullName())
+		}
+		if goMapSizeHint < 0 {
+			return nil, fmt.Errorf("cp.go_map_size_hint must not be negative: %s", field.FullName())
+		}
+		goTag, err := goTagFromFieldOptions(field)
+		if err != nil {
+			return nil, err
+		}
+		sortBy, err = sortByFromFieldOptions(field)
+		if err != nil {
+			return nil, err
+		}
+		if sortBy != "" {
+			if err := validateSortBy(field, sortBy); err != nil {
+				return nil, err
+			}
 		}
 		jsIgnore, err = jsIgnoreFromFieldOptions(field)
 		if err != nil {
@@ -441,13 +586,24 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 			return nil, err
 		}
 		isOptional := field.HasPresence() && !field.IsList() && !field.IsMap() && field.Kind() != protoreflect.MessageKind
+		if isWrapper {
+			// Held as the wrapped kind, optional when singular, so an absent
+			// wrapper stays distinct from one holding the zero value.
+			kind = wrapperKinds[msgName]
+			msgName = ""
+			isOptional = !field.IsList()
+		}
 		constraints, err := vc.parseFieldOptions(field)
 		if err != nil {
 			return nil, err
 		}
+		if kind == ir.KindEnum && goType == "string" && !constraints.IsEmpty() {
+			return nil, fmt.Errorf("validation rules are not supported on cp.go_type \"string\" enum fields: %s", field.FullName())
+		}
 		result = append(result, ir.Field{
 			Name:            ir.JsName(string(field.Name())),
 			ProtoName:       string(field.Name()),
+			JSONName:        field.JSONName(),
 			Number:          int(field.Number()),
 			Kind:            kind,
 			IsRepeated:      field.IsList(),
@@ -456,6 +612,7 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 			IsMap:           isMap,
 			IsTimestamp:     isTimestamp,
 			IsDuration:      isDuration,
+			IsWrapper:       isWrapper,
 			GoType:          goType,
 			JSType:          jsType,
 			TSType:          tsType,
@@ -463,6 +620,12 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 			GoIgnore:        goIgnore,
 			GoSlicePtr:      goSlicePtr,
 			GoValue:         goValue,
+			Lazy:            lazy,
+			AlwaysEmit:      alwaysEmit,
+			GoMapSizeHint:   int(goMapSizeHint),
+			SortBy:          sortBy,
+			GoTag:           goTag,
+			AliasBytes:      goType == "" && aliasBytesFromFieldOptions(field),
 			JsEncode:        jsEncode,
 			JsIgnore:        jsIgnore,
 			TsEncode:        tsEncode,
@@ -481,6 +644,20 @@ func collectFields(fields protoreflect.FieldDescriptors, vc *validateContext) ([
 	return result, nil
 }
 
+// wrapperKinds maps the google.protobuf wrapper messages to the kind of the
+// value they wrap.
+var wrapperKinds = map[string]ir.Kind{
+	"google.protobuf.DoubleValue": ir.KindDouble,
+	"google.protobuf.FloatValue":  ir.KindFloat,
+	"google.protobuf.Int64Value":  ir.KindInt64,
+	"google.protobuf.UInt64Value": ir.KindUint64,
+	"google.protobuf.Int32Value":  ir.KindInt32,
+	"google.protobuf.UInt32Value": ir.KindUint32,
+	"google.protobuf.BoolValue":   ir.KindBool,
+	"google.protobuf.StringValue": ir.KindString,
+	"google.protobuf.BytesValue":  ir.KindBytes,
+}
+
 func validateNativeTypes(fullName protoreflect.FullName, kind ir.Kind, msgName string, goType string, jsType string, tsType string, isMap bool) error {
 	if isMap && (goType != "" || jsType != "" || tsType != "") {
 		return fmt.Errorf("cp.go_type/cp.js_type/cp.ts_type not supported on map fields: %s", fullName)
@@ -530,6 +707,13 @@ func isSupportedGoType(kind ir.Kind, msgName string, goType string) bool {
 		return (kind == ir.KindMessage && msgName == "google.protobuf.Duration") || kind == ir.KindInt32 || kind == ir.KindInt64
 	case "github.com/google/uuid.UUID":
 		return kind == ir.KindBytes
+	case "string":
+		// An enum held as its value name.
+		return kind == ir.KindEnum
+	case "float32", "float64":
+		// Either width may surface either proto float type; the wire type
+		// stays the declared one and values convert on encode/decode.
+		return kind == ir.KindFloat || kind == ir.KindDouble
 	default:
 		return isSupportedLocalGoType(kind, goType)
 	}
@@ -548,7 +732,14 @@ func isSupportedLocalGoType(kind ir.Kind, goType string) bool {
 }
 
 func isSupportedJSType(kind ir.Kind, msgName string, jsType string) bool {
-	if jsType != "number" && jsType != "bigint" && jsType != "Date" && jsType != "LocalDate" {
+	if jsType != "number" && jsType != "bigint" && jsType != "Date" && jsType != "LocalDate" && jsType != "string" {
+		return false
+	}
+	if jsType == "string" {
+		switch kind {
+		case ir.KindInt64, ir.KindUint64, ir.KindSint64, ir.KindFixed64, ir.KindSfixed64:
+			return true
+		}
 		return false
 	}
 	if jsType == "Date" {
@@ -560,7 +751,7 @@ func isSupportedJSType(kind ir.Kind, msgName string, jsType string) bool {
 	if jsType == "LocalDate" {
 		return kind == ir.KindInt32
 	}
-	if kind == ir.KindInt32 || kind == ir.KindInt64 {
+	if kind == ir.KindInt32 || kind == ir.KindInt64 || kind == ir.KindSint64 {
 		return true
 	}
 	if kind == ir.KindMessage && (msgName == "google.protobuf.Timestamp" || msgName == "google.protobuf.Duration") {
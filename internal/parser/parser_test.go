@@ -6,25 +6,715 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/jptrs93/cleanproto/internal/ir"
 )
 
-func parseTestProto(t *testing.T, protoSource string) error {
-	t.Helper()
+func parseTestProto(t *testing.T, protoSource string) error {
+	t.Helper()
+	dir := t.TempDir()
+	protoPath := filepath.Join(dir, "demo.proto")
+	if err := os.WriteFile(protoPath, []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	optionsPath := filepath.Join(dir, "options.proto")
+	if err := os.WriteFile(optionsPath, []byte(optionsProtoSource), 0o644); err != nil {
+		t.Fatalf("write options proto: %v", err)
+	}
+	p := Parser{ImportPaths: []string{dir}}
+	_, err := p.Parse(context.Background(), []string{"demo.proto"})
+	return err
+}
+
+func TestParseGoValueFromFieldOptions(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Child {
+  int32 count = 1;
+}
+
+message Parent {
+  Child child = 1 [(cp.go_value) = true];
+}
+`
+
+	dir := t.TempDir()
+	protoPath := filepath.Join(dir, "demo.proto")
+	if err := os.WriteFile(protoPath, []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	optionsPath := filepath.Join(dir, "options.proto")
+	if err := os.WriteFile(optionsPath, []byte(optionsProtoSource), 0o644); err != nil {
+		t.Fatalf("write options proto: %v", err)
+	}
+
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	field := files[0].Messages[1].Fields[0]
+	if !field.GoValue {
+		t.Fatalf("expected cp.go_value to set ir.Field.GoValue")
+	}
+}
+
+func TestParseNullableFalseSetsGoValue(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Child {
+  int32 count = 1;
+}
+
+message Parent {
+  Child value = 1 [(cp.nullable) = false];
+  Child pointer = 2 [(cp.nullable) = true];
+}
+`
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "options.proto"), []byte(optionsProtoSource), 0o644); err != nil {
+		t.Fatalf("write options proto: %v", err)
+	}
+
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	fields := files[0].Messages[1].Fields
+	if !fields[0].GoValue || fields[1].GoValue {
+		t.Fatalf("expected only cp.nullable = false to set GoValue, got %v and %v", fields[0].GoValue, fields[1].GoValue)
+	}
+}
+
+func TestParseRejectsInvalidNullable(t *testing.T) {
+	for _, tc := range []struct {
+		field string
+		want  string
+	}{
+		{field: `repeated Child children = 1 [(cp.nullable) = false];`, want: "cp.nullable = false only applies to singular non-native message fields"},
+		{field: `int32 count = 1 [(cp.nullable) = false];`, want: "cp.nullable = false only applies to singular non-native message fields"},
+		{field: `Child child = 1 [(cp.nullable) = true, (cp.go_value) = true];`, want: "contradicts cp.go_value"},
+	} {
+		protoSource := `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Child {
+  int32 count = 1;
+}
+
+message Parent {
+  ` + tc.field + `
+}
+`
+		err := parseTestProto(t, protoSource)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected error containing %q, got %v", tc.field, tc.want, err)
+		}
+	}
+}
+
+func TestParseRejectsInvalidLazy(t *testing.T) {
+	for _, field := range []string{
+		`repeated Child children = 1 [(cp.lazy) = true];`,
+		`int32 count = 1 [(cp.lazy) = true];`,
+		`Child child = 1 [(cp.lazy) = true, (cp.go_value) = true];`,
+	} {
+		protoSource := `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Child {
+  int32 count = 1;
+}
+
+message Parent {
+  ` + field + `
+}
+`
+		err := parseTestProto(t, protoSource)
+		if err == nil || !strings.Contains(err.Error(), "cp.lazy only applies to singular non-native pointer message fields") {
+			t.Fatalf("%s: expected cp.lazy to be rejected, got %v", field, err)
+		}
+	}
+}
+
+func TestParseGoTypeStringOnlyOnEnums(t *testing.T) {
+	for field, wantErr := range map[string]bool{
+		`Level level = 1 [(cp.go_type) = "string"];`:           false,
+		`repeated Level levels = 1 [(cp.go_type) = "string"];`: false,
+		`int32 count = 1 [(cp.go_type) = "string"];`:           true,
+		`bytes raw = 1 [(cp.go_type) = "string"];`:             true,
+	} {
+		protoSource := `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+enum Level {
+  LEVEL_UNSPECIFIED = 0;
+}
+
+message Event {
+  ` + field + `
+}
+`
+		err := parseTestProto(t, protoSource)
+		if wantErr && (err == nil || !strings.Contains(err.Error(), `unsupported cp.go_type "string"`)) {
+			t.Fatalf("%s: expected cp.go_type \"string\" to be rejected, got %v", field, err)
+		}
+		if !wantErr && err != nil {
+			t.Fatalf("%s: Parse: %v", field, err)
+		}
+	}
+}
+
+func TestParseRejectsInvalidAlwaysEmit(t *testing.T) {
+	for _, field := range []string{
+		`repeated int32 counts = 1 [(cp.always_emit) = true];`,
+		`optional int32 count = 1 [(cp.always_emit) = true];`,
+		`Child child = 1 [(cp.always_emit) = true];`,
+		`map<string, int32> counts = 1 [(cp.always_emit) = true];`,
+		`int64 at = 1 [(cp.always_emit) = true, (cp.go_type) = "time.Time"];`,
+	} {
+		protoSource := `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Child {
+  int32 count = 1;
+}
+
+message Parent {
+  ` + field + `
+}
+`
+		err := parseTestProto(t, protoSource)
+		if err == nil || !strings.Contains(err.Error(), "cp.always_emit only applies to") {
+			t.Fatalf("%s: expected cp.always_emit to be rejected, got %v", field, err)
+		}
+	}
+}
+
+func TestParseGoMapSizeHintFromFieldOptions(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Ledger {
+  map<string, int64> totals = 1 [(cp.go_map_size_hint) = 512];
+}
+`
+
+	dir := t.TempDir()
+	protoPath := filepath.Join(dir, "demo.proto")
+	if err := os.WriteFile(protoPath, []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	optionsPath := filepath.Join(dir, "options.proto")
+	if err := os.WriteFile(optionsPath, []byte(optionsProtoSource), 0o644); err != nil {
+		t.Fatalf("write options proto: %v", err)
+	}
+
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	field := files[0].Messages[0].Fields[0]
+	if field.GoMapSizeHint != 512 {
+		t.Fatalf("GoMapSizeHint = %d, want 512", field.GoMapSizeHint)
+	}
+}
+
+func TestParseRejectsGoMapSizeHintOnNonMapField(t *testing.T) {
+	err := parseTestProto(t, `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Ledger {
+  repeated int64 totals = 1 [(cp.go_map_size_hint) = 512];
+}
+`)
+	if err == nil || !strings.Contains(err.Error(), "cp.go_map_size_hint only applies to map fields") {
+		t.Fatalf("expected non-map size hint error, got %v", err)
+	}
+}
+
+func TestParseSortByFromFieldOptions(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Entry {
+  string id = 1;
+}
+
+message Ledger {
+  repeated Entry entries = 1 [(cp.sort_by) = "id"];
+}
+`
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "options.proto"), []byte(optionsProtoSource), 0o644); err != nil {
+		t.Fatalf("write options proto: %v", err)
+	}
+
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := files[0].Messages[1].Fields[0].SortBy; got != "id" {
+		t.Fatalf("SortBy = %q, want id", got)
+	}
+}
+
+func TestParseRejectsInvalidSortBy(t *testing.T) {
+	for _, tc := range []struct {
+		field string
+		want  string
+	}{
+		{`repeated int64 totals = 2 [(cp.sort_by) = "id"];`, "only applies to repeated message fields"},
+		{`Entry entry = 2 [(cp.sort_by) = "id"];`, "only applies to repeated message fields"},
+		{`repeated Entry entries = 2 [(cp.sort_by) = "missing"];`, `"missing" not found in demo.Entry`},
+		{`repeated Entry entries = 2 [(cp.sort_by) = "data"];`, "must be a number, enum or string"},
+		{`repeated Entry entries = 2 [(cp.sort_by) = "rank"];`, "must be singular without presence"},
+		{`repeated Entry entries = 2 [(cp.sort_by) = "tags"];`, "must be singular without presence"},
+	} {
+		err := parseTestProto(t, `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Entry {
+  string id = 1;
+  bytes data = 2;
+  optional int32 rank = 3;
+  repeated string tags = 4;
+}
+
+message Ledger {
+  `+tc.field+`
+}
+`)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.field, tc.want, err)
+		}
+	}
+}
+
+func TestParseGoTagFromFieldOptions(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message User {
+  string user_name = 1 [(cp.go_tag) = " db:\"user_name\" validate:\"required,min=3\" "];
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "options.proto"), []byte(optionsProtoSource), 0o644); err != nil {
+		t.Fatalf("write options proto: %v", err)
+	}
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got, want := files[0].Messages[0].Fields[0].GoTag, `db:"user_name" validate:"required,min=3"`; got != want {
+		t.Fatalf("GoTag = %q, want %q", got, want)
+	}
+}
+
+func TestParseCtypeCordAliasesBytes(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Blob {
+  bytes data = 1 [ctype = CORD];
+  repeated bytes chunks = 2 [ctype = CORD];
+  bytes piece = 3 [ctype = STRING_PIECE];
+  string name = 4 [ctype = CORD];
+  bytes id = 5 [ctype = CORD, (cp.go_type) = "github.com/google/uuid.UUID"];
+  bytes plain = 6;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "options.proto"), []byte(optionsProtoSource), 0o644); err != nil {
+		t.Fatalf("write options proto: %v", err)
+	}
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := map[string]bool{"data": true, "chunks": true, "piece": false, "name": false, "id": false, "plain": false}
+	for _, field := range files[0].Messages[0].Fields {
+		if field.AliasBytes != want[field.ProtoName] {
+			t.Errorf("%s: AliasBytes = %v, want %v", field.ProtoName, field.AliasBytes, want[field.ProtoName])
+		}
+	}
+}
+
+func TestParseRejectsInvalidGoTag(t *testing.T) {
+	for _, tc := range []struct {
+		tag  string
+		want string
+	}{
+		{`db:\"a` + "`" + `\"`, "backtick"},
+		{`db:\"a\"\nx:\"b\"`, "newline"},
+		{`db`, `expected key:"value"`},
+		{`db:user_name`, `expected key:"value"`},
+		{`db:\"a`, "not terminated"},
+		{`db:\"a\\q\"`, "not a valid quoted string"},
+		{`db:\"a\"validate:\"b\"`, "separated by spaces"},
+		{`db:\"a\" db:\"b\"`, "repeats the db key"},
+	} {
+		err := parseTestProto(t, `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message User {
+  string name = 1 [(cp.go_tag) = "`+tc.tag+`"];
+}
+`)
+		if err == nil || !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), "demo.User.name") {
+			t.Errorf("%s: expected error containing %q, got %v", tc.tag, tc.want, err)
+		}
+	}
+}
+
+func TestParseIgnoresForeignOptionsReusingCPNumbers(t *testing.T) {
+	const acmeSource = `syntax = "proto3";
+
+package acme;
+
+import "google/protobuf/descriptor.proto";
+
+// These reuse the numbers of cp.go_type, cp.js_type and cp.go_ignore.
+extend google.protobuf.FieldOptions {
+  string label = 50010 [retention = RETENTION_SOURCE, targets = TARGET_TYPE_FIELD];
+  int32 weight = 50011 [retention = RETENTION_RUNTIME];
+  string owner = 50014;
+}
+`
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "acme.proto";
+
+option go_package = "demo";
+
+message Reading {
+  string name = 1 [deprecated = true, (acme.label) = "int64", (acme.weight) = 3, (acme.owner) = "ops"];
+}
+`
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "acme.proto"), []byte(acmeSource), 0o644); err != nil {
+		t.Fatalf("write acme proto: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	field := files[0].Messages[0].Fields[0]
+	if field.GoType != "" || field.JSType != "" || field.GoIgnore {
+		t.Fatalf("acme options read as cp options: GoType = %q, JSType = %q, GoIgnore = %v", field.GoType, field.JSType, field.GoIgnore)
+	}
+}
+
+func TestParseJSONName(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+option go_package = "demo";
+
+message Account {
+  int32 user_id = 1 [json_name = "uid"];
+  string display_name = 2;
+}
+`
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	fields := files[0].Messages[0].Fields
+	if fields[0].JSONName != "uid" || fields[0].Name != "userId" {
+		t.Fatalf("user_id: JSONName = %q, Name = %q", fields[0].JSONName, fields[0].Name)
+	}
+	if fields[1].JSONName != "displayName" {
+		t.Fatalf("display_name: JSONName = %q, want displayName", fields[1].JSONName)
+	}
+}
+
+func TestParseStandardJSType(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Ids {
+  int64 id = 1 [jstype = JS_STRING];
+  repeated fixed64 hashes = 2 [jstype = JS_STRING];
+  int64 count = 3 [jstype = JS_NUMBER];
+  uint64 total = 4 [jstype = JS_NUMBER];
+  int64 stamp = 5 [jstype = JS_STRING, (cp.js_type) = "bigint"];
+  int64 plain = 6;
+}
+`
+
 	dir := t.TempDir()
-	protoPath := filepath.Join(dir, "demo.proto")
-	if err := os.WriteFile(protoPath, []byte(protoSource), 0o644); err != nil {
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
 		t.Fatalf("write proto: %v", err)
 	}
-	optionsPath := filepath.Join(dir, "options.proto")
-	if err := os.WriteFile(optionsPath, []byte(optionsProtoSource), 0o644); err != nil {
+	if err := os.WriteFile(filepath.Join(dir, "options.proto"), []byte(optionsProtoSource), 0o644); err != nil {
 		t.Fatalf("write options proto: %v", err)
 	}
+
 	p := Parser{ImportPaths: []string{dir}}
-	_, err := p.Parse(context.Background(), []string{"demo.proto"})
-	return err
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := map[string]string{"id": "string", "hashes": "string", "count": "number", "total": "", "stamp": "bigint", "plain": ""}
+	for _, field := range files[0].Messages[0].Fields {
+		if field.JSType != want[field.ProtoName] {
+			t.Errorf("%s: JSType = %q, want %q", field.ProtoName, field.JSType, want[field.ProtoName])
+		}
+	}
 }
 
-func TestParseGoValueFromFieldOptions(t *testing.T) {
+func TestParseWrappersAsWrappedKind(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "google/protobuf/wrappers.proto";
+
+option go_package = "demo";
+
+message Blob {
+  google.protobuf.BytesValue data = 1;
+  repeated google.protobuf.StringValue names = 2;
+}
+`
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	field := files[0].Messages[0].Fields[0]
+	if !field.IsWrapper || field.Kind != ir.KindBytes || !field.IsOptional || field.MessageFullName != "" {
+		t.Fatalf("expected BytesValue to become optional bytes, got %+v", field)
+	}
+	field = files[0].Messages[0].Fields[1]
+	if !field.IsWrapper || field.Kind != ir.KindString || !field.IsRepeated || field.IsOptional || field.IsPacked {
+		t.Fatalf("expected repeated StringValue to become repeated string, got %+v", field)
+	}
+
+	err = parseTestProto(t, `syntax = "proto3";
+
+package demo;
+
+import "google/protobuf/wrappers.proto";
+
+message Blobs {
+  map<string, google.protobuf.BytesValue> data = 1;
+}
+`)
+	if err == nil || !strings.Contains(err.Error(), "not supported as a map value") {
+		t.Fatalf("expected BytesValue map value to be rejected, got %v", err)
+	}
+}
+
+func TestParseAddsBuiltinMoney(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "google/type/money.proto";
+
+option go_package = "demo";
+
+message Order {
+  google.type.Money total = 1;
+  map<string, google.type.Money> lines = 2;
+}
+
+message Note {
+  string text = 1;
+}
+`
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var money []ir.Message
+	for _, msg := range files[0].Messages {
+		if msg.FullName == "google.type.Money" {
+			money = append(money, msg)
+		}
+	}
+	if len(money) != 1 || money[0].Name != "Money" {
+		t.Fatalf("expected one google.type.Money message, got %+v", money)
+	}
+	want := []struct {
+		name   string
+		number int
+		kind   ir.Kind
+	}{
+		{"currency_code", 1, ir.KindString},
+		{"units", 2, ir.KindInt64},
+		{"nanos", 3, ir.KindInt32},
+	}
+	if len(money[0].Fields) != len(want) {
+		t.Fatalf("expected %d Money fields, got %+v", len(want), money[0].Fields)
+	}
+	for i, field := range money[0].Fields {
+		if field.ProtoName != want[i].name || field.Number != want[i].number || field.Kind != want[i].kind {
+			t.Fatalf("Money field %d = %+v, want %+v", i, field, want[i])
+		}
+	}
+}
+
+func TestParseAddsBuiltinAny(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "google/protobuf/any.proto";
+
+option go_package = "demo";
+
+message Envelope {
+  google.protobuf.Any payload = 1;
+  repeated google.protobuf.Any extras = 2;
+}
+`
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var anys []ir.Message
+	for _, msg := range files[0].Messages {
+		if msg.FullName == "google.protobuf.Any" {
+			anys = append(anys, msg)
+		}
+	}
+	if len(anys) != 1 || anys[0].Name != "Any" || len(anys[0].Fields) != 2 {
+		t.Fatalf("expected one google.protobuf.Any message, got %+v", anys)
+	}
+	if typeURL, value := anys[0].Fields[0], anys[0].Fields[1]; typeURL.ProtoName != "type_url" || typeURL.Kind != ir.KindString || value.ProtoName != "value" || value.Kind != ir.KindBytes {
+		t.Fatalf("unexpected Any fields: %+v", anys[0].Fields)
+	}
+}
+
+func TestParseGoImmutableFromMessageOptions(t *testing.T) {
 	const protoSource = `syntax = "proto3";
 
 package demo;
@@ -33,12 +723,13 @@ import "options.proto";
 
 option go_package = "demo";
 
-message Child {
+message Frozen {
+  option (cp.go_immutable) = true;
   int32 count = 1;
 }
 
-message Parent {
-  Child child = 1 [(cp.go_value) = true];
+message Plain {
+  int32 count = 1;
 }
 `
 
@@ -57,9 +748,142 @@ message Parent {
 	if err != nil {
 		t.Fatalf("Parse: %v", err)
 	}
-	field := files[0].Messages[1].Fields[0]
-	if !field.GoValue {
-		t.Fatalf("expected cp.go_value to set ir.Field.GoValue")
+	if !files[0].Messages[0].GoImmutable {
+		t.Fatalf("expected cp.go_immutable to set ir.Message.GoImmutable")
+	}
+	if files[0].Messages[1].GoImmutable {
+		t.Fatalf("expected messages without cp.go_immutable to stay mutable")
+	}
+}
+
+func TestParseJSNameFromMessageOptions(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Item {
+  option (cp.js_name) = "LegacyItem";
+  string name = 1;
+}
+`
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "options.proto"), []byte(optionsProtoSource), 0o644); err != nil {
+		t.Fatalf("write options proto: %v", err)
+	}
+
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	msg := files[0].Messages[0]
+	if msg.Name != "Item" || msg.JSName != "LegacyItem" {
+		t.Fatalf("Name = %q, JSName = %q, want Item and LegacyItem", msg.Name, msg.JSName)
+	}
+}
+
+func TestParseEnumAllowAlias(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+option go_package = "demo";
+
+enum State {
+  option allow_alias = true;
+  STATE_UNSPECIFIED = 0;
+  STATE_RUNNING = 1;
+  STATE_STARTED = 1;
+}
+
+message Job {
+  State state = 1;
+}
+`
+
+	if err := parseTestProto(t, protoSource); err != nil {
+		t.Fatalf("expected aliased enum to parse, got %v", err)
+	}
+}
+
+func TestParseDerivesGoPackageName(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "dotted proto package", header: "package my.api.v1;", want: "v1"},
+		{name: "go_package path", header: "package demo;\n\noption go_package = \"example.com/my-api\";", want: "my_api"},
+		{name: "go_package explicit name", header: "package demo;\n\noption go_package = \"example.com/api;apiv1\";", want: "apiv1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protoSource := "syntax = \"proto3\";\n\n" + tt.header + "\n\nmessage Ping {\n  string id = 1;\n}\n"
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+				t.Fatalf("write proto: %v", err)
+			}
+			p := Parser{ImportPaths: []string{dir}}
+			files, err := p.Parse(context.Background(), []string{"demo.proto"})
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if files[0].GoPackage != tt.want {
+				t.Fatalf("GoPackage = %q, want %q", files[0].GoPackage, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReadsStdinAlias(t *testing.T) {
+	const commonSource = `syntax = "proto3";
+
+package demo;
+
+message Meta {
+  string owner = 1;
+}
+`
+	const stdinSource = `syntax = "proto3";
+
+package demo;
+
+import "common.proto";
+
+message Ping {
+  string id = 1;
+  Meta meta = 2;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "common.proto"), []byte(commonSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := Parser{ImportPaths: []string{dir}, Stdin: strings.NewReader(stdinSource)}
+	files, err := p.Parse(context.Background(), []string{StdinPath})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+	if files[0].Path != StdinPath {
+		t.Fatalf("Path = %q, want %q", files[0].Path, StdinPath)
+	}
+	if len(files[0].Messages) == 0 || files[0].Messages[0].Name != "Ping" {
+		t.Fatalf("expected Ping message, got %+v", files[0].Messages)
+	}
+	fields := files[0].Messages[0].Fields
+	if len(fields) != 2 || fields[1].MessageFullName != "demo.Meta" {
+		t.Fatalf("expected meta field resolved via import path, got %+v", fields)
 	}
 }
 
@@ -98,6 +922,60 @@ message Parent {
 	}
 }
 
+func TestParseFloatWidthGoTypes(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Reading {
+  float wide = 1 [(cp.go_type) = "float64"];
+  double narrow = 2 [(cp.go_type) = "float32"];
+}
+`
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "options.proto"), []byte(optionsProtoSource), 0o644); err != nil {
+		t.Fatalf("write options proto: %v", err)
+	}
+
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	fields := files[0].Messages[0].Fields
+	if fields[0].GoType != "float64" || fields[1].GoType != "float32" {
+		t.Fatalf("unexpected go types: %q, %q", fields[0].GoType, fields[1].GoType)
+	}
+}
+
+func TestParseRejectsFloatGoTypeOnIntegerField(t *testing.T) {
+	const protoSource = `syntax = "proto3";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+
+message Reading {
+  int32 count = 1 [(cp.go_type) = "float64"];
+}
+`
+
+	err := parseTestProto(t, protoSource)
+	if err == nil || !strings.Contains(err.Error(), `unsupported cp.go_type "float64"`) {
+		t.Fatalf("expected float go_type validation error, got %v", err)
+	}
+}
+
 func TestParseRejectsQualifiedCustomGoType(t *testing.T) {
 	const protoSource = `syntax = "proto3";
 
@@ -247,3 +1125,80 @@ service DemoService {
 		t.Fatalf("expected URL override, got %q", methods[0].URL)
 	}
 }
+
+func TestParseEditionsMapsResolvedFeatures(t *testing.T) {
+	const protoSource = `edition = "2023";
+
+package demo;
+
+import "options.proto";
+
+option go_package = "demo";
+option features.field_presence = IMPLICIT;
+
+message Reading {
+  int32 count = 1;
+  int32 limit = 2 [features.field_presence = EXPLICIT];
+  string note = 3 [(cp.json_ignore) = true];
+  repeated int32 samples = 4;
+  repeated int32 raw = 5 [features.repeated_field_encoding = EXPANDED];
+  map<string, int32> totals = 6;
+}
+`
+	// Edition 2023 defaults to explicit presence.
+	const defaultsSource = `edition = "2023";
+
+package defaults;
+
+message Defaults {
+  int64 id = 1;
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(protoSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "defaults.proto"), []byte(defaultsSource), 0o644); err != nil {
+		t.Fatalf("write proto: %v", err)
+	}
+	p := Parser{ImportPaths: []string{dir}}
+	files, err := p.Parse(context.Background(), []string{"demo.proto", "defaults.proto"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	fields := files[0].Messages[0].Fields
+	if fields[0].IsOptional || !fields[1].IsOptional {
+		t.Fatalf("expected only limit to have explicit presence, got count=%v limit=%v", fields[0].IsOptional, fields[1].IsOptional)
+	}
+	if !fields[2].JSONIgnore {
+		t.Fatalf("expected cp options to apply in an editions file")
+	}
+	if !fields[3].IsPacked || fields[4].IsPacked {
+		t.Fatalf("expected samples packed and raw expanded, got %v and %v", fields[3].IsPacked, fields[4].IsPacked)
+	}
+	if !fields[5].IsMap || fields[5].IsOptional {
+		t.Fatalf("expected totals to be a plain map, got %+v", fields[5])
+	}
+	if id := files[1].Messages[0].Fields[0]; !id.IsOptional {
+		t.Fatalf("expected the edition 2023 default presence to make id optional")
+	}
+}
+
+func TestParseEditionsRejectsUnsupportedFeatures(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  string
+	}{
+		{name: "legacy required", field: "int32 count = 1 [features.field_presence = LEGACY_REQUIRED];", want: "required fields"},
+		{name: "delimited", field: "Reading inner = 1 [features.message_encoding = DELIMITED];", want: "delimited message encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseTestProto(t, "edition = \"2023\";\n\npackage demo;\n\nmessage Reading {\n  "+tt.field+"\n}\n")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -16,10 +16,14 @@ const bufValidateExtensionNumber = 1159
 type validateContext struct {
 	mu     sync.Mutex
 	warned map[string]struct{}
+	logger *log.Logger
 }
 
-func newValidateContext() *validateContext {
-	return &validateContext{warned: map[string]struct{}{}}
+func newValidateContext(logger *log.Logger) *validateContext {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &validateContext{warned: map[string]struct{}{}, logger: logger}
 }
 
 func (vc *validateContext) warn(scope, format string, args ...any) {
@@ -31,7 +35,7 @@ func (vc *validateContext) warn(scope, format string, args ...any) {
 	}
 	vc.warned[key] = struct{}{}
 	vc.mu.Unlock()
-	log.Printf("cleanproto: %s: "+format, append([]any{scope}, args...)...)
+	vc.logger.Printf("cleanproto: %s: "+format, append([]any{scope}, args...)...)
 }
 
 func (vc *validateContext) parseFieldOptions(field protoreflect.FieldDescriptor) (ir.FieldConstraints, error) {